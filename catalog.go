@@ -0,0 +1,197 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"strings"
+
+	"vqlite/column"
+	"vqlite/table"
+)
+
+// catalog holds the tables made by CREATE TABLE. Each is a named tree in the
+// index directory of one database file, next to the REPL's own table.
+type catalog struct {
+	primary *table.BTree
+	tables  map[string]*catalogTable
+}
+
+// catalogTable is one table of the catalog. Rows are keyed on the value of
+// column key, which is always an INT column. Selects on it go through cache,
+// which the tree's mutations invalidate.
+type catalogTable struct {
+	tree   *table.BTree
+	schema column.Schema
+	key    int
+	cache  *table.QueryCache
+}
+
+// queryCacheSize is how many select results each catalog table keeps.
+const queryCacheSize = 16
+
+// newCatalogTable returns the catalog table for tree, with an empty query
+// cache.
+func newCatalogTable(tree *table.BTree, schema column.Schema, key int) *catalogTable {
+	return &catalogTable{tree: tree, schema: schema, key: key, cache: table.NewQueryCache(tree, queryCacheSize)}
+}
+
+// replCatalog is the catalog statements run against; nil until a database
+// is opened with openCatalog.
+var replCatalog *catalog
+
+// openCatalog opens (or creates) the database file at path for CREATE TABLE
+// and reloads the tables created in earlier sessions from its index
+// directory, which records each table's schema and key column. Trees whose
+// names start with an underscore belong to the engine, not the catalog.
+func openCatalog(path string) (*catalog, error) {
+	_, bt, err := table.OpenTable(path, replSchema)
+	if err != nil {
+		return nil, fmt.Errorf("open catalog: %w", err)
+	}
+	trees, err := bt.Indexes()
+	if err != nil {
+		bt.Close()
+		return nil, fmt.Errorf("open catalog: %w", err)
+	}
+	c := &catalog{primary: bt, tables: make(map[string]*catalogTable)}
+	for name, tree := range trees {
+		if strings.HasPrefix(name, "_") {
+			continue
+		}
+		c.tables[name] = newCatalogTable(tree, tree.Schema(), tree.KeyColumn())
+	}
+	return c, nil
+}
+
+// Close closes the database file.
+func (c *catalog) Close() error {
+	return c.primary.Close()
+}
+
+// createTable allocates an empty tree for a table named name and registers
+// it, failing if the name is already taken in the file.
+func (c *catalog) createTable(name string, schema column.Schema, key int) error {
+	if _, ok := c.tables[name]; ok {
+		return fmt.Errorf("table %q already exists", name)
+	}
+	if strings.HasPrefix(name, "_") {
+		return fmt.Errorf("table name %q: names starting with _ are reserved", name)
+	}
+	tree, err := c.primary.CreateTable(name, schema, key)
+	if errors.Is(err, table.ErrIndexExists) {
+		return fmt.Errorf("table %q already exists", name)
+	} else if err != nil {
+		return fmt.Errorf("create table %q: %w", name, err)
+	}
+	c.tables[name] = newCatalogTable(tree, schema, key)
+	return nil
+}
+
+// dump writes the create table and insert statements that rebuild every
+// catalog table, in name order and each table's rows in key order, so that
+// .read of the output recreates the tables in an empty database.
+func (c *catalog) dump(w io.Writer) error {
+	for _, name := range slices.Sorted(maps.Keys(c.tables)) {
+		tbl := c.tables[name]
+		defs := make([]string, len(tbl.schema))
+		cols := make([]string, len(tbl.schema))
+		for i, col := range tbl.schema {
+			switch col.Type {
+			case column.ColumnTypeInt32:
+				defs[i] = col.Name + " int32"
+			case column.ColumnTypeText:
+				defs[i] = fmt.Sprintf("%s text(%d)", col.Name, col.MaxLength)
+			default:
+				defs[i] = col.Name + " int"
+			}
+			cols[i] = col.Name
+		}
+		defs[tbl.key] += " primary key"
+		fmt.Fprintf(w, "create table %s (%s);\n", name, strings.Join(defs, ", "))
+
+		cur, err := tbl.tree.NewCursor()
+		if err != nil {
+			return fmt.Errorf("dump %q: %w", name, err)
+		}
+		for cur.Valid() {
+			vals := make([]string, len(cur.Value()))
+			for i, v := range cur.Value() {
+				s, ok := v.(string)
+				if !ok {
+					vals[i] = fmt.Sprint(v)
+					continue
+				}
+				switch {
+				case !strings.Contains(s, "'"):
+					vals[i] = "'" + s + "'"
+				case !strings.Contains(s, `"`):
+					vals[i] = `"` + s + `"`
+				default:
+					return fmt.Errorf("dump %q: value %q holds both quote characters", name, s)
+				}
+			}
+			fmt.Fprintf(w, "insert into %s (%s) values (%s);\n", name, strings.Join(cols, ", "), strings.Join(vals, ", "))
+			if err := cur.Next(); err != nil {
+				return fmt.Errorf("dump %q: %w", name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// lookupTable returns the catalog table named name, or nil if there is no
+// catalog or no such table.
+func lookupTable(name string) *catalogTable {
+	if replCatalog == nil {
+		return nil
+	}
+	return replCatalog.tables[name]
+}
+
+// parseCreateTable parses the rest of a create table statement, such as
+// "t (id int primary key, name text(16))", into the table name, its schema
+// and the index of its key column. Without a primary key the first column
+// is the key; either way the key column must be INT.
+func parseCreateTable(input string) (string, column.Schema, int, error) {
+	name, defs, ok := strings.Cut(strings.TrimSpace(input), " ")
+	if !ok || name == "" {
+		return "", nil, 0, fmt.Errorf("create table %q: missing column list", input)
+	}
+	items, err := parseParenList(defs)
+	if err != nil {
+		return "", nil, 0, fmt.Errorf("create table column list: %w", err)
+	}
+	key := -1
+	for i, item := range items {
+		fields := strings.Fields(item)
+		n := len(fields)
+		if n >= 2 && strings.EqualFold(fields[n-2], "primary") && strings.EqualFold(fields[n-1], "key") {
+			if key >= 0 {
+				return "", nil, 0, fmt.Errorf("create table %q: more than one primary key", name)
+			}
+			key = i
+			items[i] = strings.Join(fields[:n-2], " ")
+		}
+	}
+	schema, err := table.ParseSchema(strings.Join(items, ","))
+	if err != nil {
+		return "", nil, 0, fmt.Errorf("create table %q: %w", name, err)
+	}
+	key = max(key, 0)
+	if schema[key].Type != column.ColumnTypeInt {
+		return "", nil, 0, fmt.Errorf("create table %q: key column %q must be INT", name, schema[key].Name)
+	}
+	return name, schema, key, nil
+}
+
+// formatRow renders row as "(v1, v2, ...)".
+func formatRow(row table.Row) string {
+	vals := make([]string, len(row))
+	for i, v := range row {
+		vals[i] = fmt.Sprint(v)
+	}
+	return "(" + strings.Join(vals, ", ") + ")"
+}
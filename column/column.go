@@ -5,6 +5,7 @@ type ColumnType int
 const (
 	ColumnTypeInt ColumnType = iota
 	ColumnTypeText
+	ColumnTypeInt32 // signed, stored as its two's-complement uint32
 )
 
 type Column struct {
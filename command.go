@@ -7,6 +7,7 @@ type MetaCommandResult int
 const (
 	MetaCommandSuccess MetaCommandResult = iota
 	MetaCommandUnrecognizedCommand
+	MetaCommandExit
 )
 
 type PrepareResult int
@@ -14,6 +15,7 @@ type PrepareResult int
 const (
 	PrepareSuccess PrepareResult = iota
 	PrepareUnrecognizedStatement
+	PrepareSyntaxError
 )
 
 const RowsPerPageGuess = 32
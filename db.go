@@ -0,0 +1,388 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	"vqlite/column"
+	"vqlite/table"
+)
+
+// DB is a database file opened for SQL, in the manner of database/sql: Exec
+// runs statements that change it and Query runs selects. It understands
+//
+//	create table t (id int primary key, name text(16), ...)
+//	insert into t (id, name) values (1, 'a')
+//	update t set name = 'b' where id = 1
+//	delete from t [where id = 1]
+//	select * from t [where id = 1] [order by age asc, name desc]
+//	select count(*) from t [where id between 2 and 7]
+//
+// where a where clause always compares the key column with a number.
+type DB struct {
+	cat *catalog
+}
+
+// OpenDB opens (or creates) the database file at path.
+func OpenDB(path string) (*DB, error) {
+	cat, err := openCatalog(path)
+	if err != nil {
+		return nil, err
+	}
+	return &DB{cat: cat}, nil
+}
+
+// Close closes the database file.
+func (db *DB) Close() error {
+	return db.cat.Close()
+}
+
+// Exec runs a create table, insert, update or delete and returns how many
+// rows it changed.
+func (db *DB) Exec(sql string) (int, error) {
+	sql = strings.TrimSpace(sql)
+	switch {
+	case strings.HasPrefix(sql, "create table "):
+		name, schema, key, err := parseCreateTable(strings.TrimPrefix(sql, "create table "))
+		if err != nil {
+			return 0, err
+		}
+		return 0, db.cat.createTable(name, schema, key)
+
+	case strings.HasPrefix(sql, "insert into "):
+		rest := strings.TrimPrefix(sql, "insert into ")
+		name, _, _ := strings.Cut(rest, " ")
+		tbl, err := db.table(name)
+		if err != nil {
+			return 0, err
+		}
+		row, err := parseInsertInto(rest, tbl.schema)
+		if err != nil {
+			return 0, err
+		}
+		if err := tbl.tree.Insert(row[tbl.key].(uint32), row); err != nil {
+			return 0, err
+		}
+		return 1, nil
+
+	case strings.HasPrefix(sql, "update "):
+		name, rest, _ := strings.Cut(strings.TrimPrefix(sql, "update "), " ")
+		tbl, err := db.table(name)
+		if err != nil {
+			return 0, err
+		}
+		set, where, ok := strings.Cut(rest, " where ")
+		set, found := strings.CutPrefix(set, "set ")
+		if !ok || !found {
+			return 0, fmt.Errorf("update %q: want set ... where", name)
+		}
+		key, err := tbl.parseWhere(where)
+		if err != nil {
+			return 0, err
+		}
+		row, found, err := tbl.tree.Search(key)
+		if err != nil || !found {
+			return 0, err
+		}
+		// assign into a copy, so a set clause failing halfway leaves the
+		// stored row alone
+		row = slices.Clone(row)
+		if err := tbl.parseSet(set, row); err != nil {
+			return 0, err
+		}
+		if err := tbl.tree.Insert(key, row); err != nil {
+			return 0, err
+		}
+		return 1, nil
+
+	case strings.HasPrefix(sql, "delete from "):
+		name, where, hasWhere := strings.Cut(strings.TrimPrefix(sql, "delete from "), " where ")
+		tbl, err := db.table(name)
+		if err != nil {
+			return 0, err
+		}
+		if !hasWhere {
+			n, err := tbl.tree.NumRows()
+			if err != nil {
+				return 0, err
+			}
+			return int(n), tbl.tree.Truncate(false)
+		}
+		key, err := tbl.parseWhere(where)
+		if err != nil {
+			return 0, err
+		}
+		found, err := tbl.tree.Delete(key)
+		if err != nil || !found {
+			return 0, err
+		}
+		return 1, nil
+	}
+	return 0, fmt.Errorf("exec: unrecognized statement %q", sql)
+}
+
+// Query runs a select * from a table, optionally with a where clause on the
+// key column, and returns its rows in key order. With an order by clause the
+// rows are sorted by its columns instead, ties left in key order. A select
+// count(*) returns a single count(*) column. Results are kept in the table's
+// query cache, so repeating a select before the table changes reads no
+// pages.
+func (db *DB) Query(sql string) (*Rows, error) {
+	sql = strings.TrimSpace(sql)
+	if rest, ok := strings.CutPrefix(sql, "select count(*) from "); ok {
+		return db.count(rest)
+	}
+	rest, ok := strings.CutPrefix(sql, "select * from ")
+	if !ok {
+		return nil, fmt.Errorf("query: unrecognized statement %q", sql)
+	}
+	rest, orderBy, hasOrder := strings.Cut(rest, " order by ")
+	name, where, hasWhere := strings.Cut(rest, " where ")
+	tbl, err := db.table(name)
+	if err != nil {
+		return nil, err
+	}
+	pairs, err := tbl.cache.Query(sql, func() ([]table.KeyRowPair, error) {
+		only, key := false, uint32(0)
+		if hasWhere {
+			if key, err = tbl.parseWhere(where); err != nil {
+				return nil, err
+			}
+			only = true
+		}
+		pairs, err := tbl.scan(only, key)
+		if err != nil || !hasOrder {
+			return pairs, err
+		}
+		return tbl.sort(pairs, orderBy)
+	})
+	if err != nil {
+		return nil, err
+	}
+	rows := &Rows{schema: tbl.schema, rows: make([]table.Row, len(pairs)), pos: -1}
+	for i, p := range pairs {
+		rows.rows[i] = p.Row
+	}
+	return rows, nil
+}
+
+// scan returns copies of the table's rows in key order, only those with key
+// k if only is set.
+func (tbl *catalogTable) scan(only bool, k uint32) ([]table.KeyRowPair, error) {
+	c, err := tbl.tree.NewCursor()
+	if err != nil {
+		return nil, err
+	}
+	if only {
+		if err := c.Seek(k); err != nil {
+			return nil, err
+		}
+	}
+	var pairs []table.KeyRowPair
+	for c.Valid() && (!only || c.Key() == k) {
+		pairs = append(pairs, table.KeyRowPair{Key: c.Key(), Row: slices.Clone(c.Value())})
+		if err := c.Next(); err != nil {
+			return nil, err
+		}
+	}
+	return pairs, nil
+}
+
+// sort sorts pairs by the columns of an order by clause.
+func (tbl *catalogTable) sort(pairs []table.KeyRowPair, clause string) ([]table.KeyRowPair, error) {
+	keys, err := table.ParseOrderBy(clause)
+	if err != nil {
+		return nil, err
+	}
+	rows := make([]table.Row, len(pairs))
+	for i, p := range pairs {
+		rows[i] = p.Row
+	}
+	if err := table.SortRows(tbl.schema, rows, keys); err != nil {
+		return nil, err
+	}
+	for i, row := range rows {
+		pairs[i] = table.KeyRowPair{Key: row[tbl.key].(uint32), Row: row}
+	}
+	return pairs, nil
+}
+
+// count runs a select count(*) after its "from ": it counts the keys its
+// where clause selects, or all of them without one, with CountRange, which
+// reads no rows.
+func (db *DB) count(rest string) (*Rows, error) {
+	name, where, hasWhere := strings.Cut(rest, " where ")
+	tbl, err := db.table(name)
+	if err != nil {
+		return nil, err
+	}
+	lo, hi := uint32(0), ^uint32(0)
+	if hasWhere {
+		if lo, hi, err = tbl.parseRange(where); err != nil {
+			return nil, err
+		}
+	}
+	n, err := tbl.tree.CountRange(lo, hi)
+	if err != nil {
+		return nil, err
+	}
+	schema := column.Schema{{Name: "count(*)", Type: column.ColumnTypeInt}}
+	return &Rows{schema: schema, rows: []table.Row{{uint32(n)}}, pos: -1}, nil
+}
+
+// table returns the catalog table named name.
+func (db *DB) table(name string) (*catalogTable, error) {
+	tbl := db.cat.tables[strings.TrimSpace(name)]
+	if tbl == nil {
+		return nil, fmt.Errorf("no such table %q", name)
+	}
+	return tbl, nil
+}
+
+// parseWhere parses a where clause such as "id = 5" on the table's key
+// column and returns the key.
+func (tbl *catalogTable) parseWhere(where string) (uint32, error) {
+	col, lit, ok := strings.Cut(where, "=")
+	col = strings.TrimSpace(col)
+	if !ok || col != tbl.schema[tbl.key].Name {
+		return 0, fmt.Errorf("where %q: want %s = <key>", where, tbl.schema[tbl.key].Name)
+	}
+	key, err := strconv.ParseUint(strings.TrimSpace(lit), 10, 32)
+	if err != nil {
+		return 0, fmt.Errorf("where %q: %w", where, err)
+	}
+	return uint32(key), nil
+}
+
+// parseRange parses a where clause on the key column selecting a range of
+// keys, such as "id between 2 and 7", or a single key as parseWhere does,
+// and returns the inclusive bounds.
+func (tbl *catalogTable) parseRange(where string) (lo, hi uint32, err error) {
+	col, bounds, ok := strings.Cut(where, " between ")
+	if !ok {
+		key, err := tbl.parseWhere(where)
+		return key, key, err
+	}
+	name := tbl.schema[tbl.key].Name
+	a, b, ok := strings.Cut(bounds, " and ")
+	if !ok || strings.TrimSpace(col) != name {
+		return 0, 0, fmt.Errorf("where %q: want %s between <key> and <key>", where, name)
+	}
+	var keys [2]uint32
+	for i, lit := range []string{a, b} {
+		k, err := strconv.ParseUint(strings.TrimSpace(lit), 10, 32)
+		if err != nil {
+			return 0, 0, fmt.Errorf("where %q: %w", where, err)
+		}
+		keys[i] = uint32(k)
+	}
+	return keys[0], keys[1], nil
+}
+
+// parseSet applies the assignments of a set clause such as
+// "name = 'b', age = 3" to row. The key column cannot be assigned.
+func (tbl *catalogTable) parseSet(set string, row table.Row) error {
+	items, err := parseParenList("(" + set + ")")
+	if err != nil {
+		return fmt.Errorf("set clause: %w", err)
+	}
+	for _, item := range items {
+		name, lit, ok := strings.Cut(item, "=")
+		name = strings.TrimSpace(name)
+		if !ok {
+			return fmt.Errorf("set %q: want column = value", item)
+		}
+		i := slices.IndexFunc(tbl.schema, func(c column.Column) bool { return c.Name == name })
+		if i < 0 {
+			return fmt.Errorf("set: unknown column %q", name)
+		}
+		if i == tbl.key {
+			return fmt.Errorf("set: cannot change key column %q", name)
+		}
+		v, err := parseValue(strings.TrimSpace(lit), tbl.schema[i].Type)
+		if err != nil {
+			return fmt.Errorf("set: column %q: %w", name, err)
+		}
+		row[i] = v
+	}
+	return nil
+}
+
+// Rows is the result of a Query. Call Next before each row, including the
+// first, and Scan to read it.
+type Rows struct {
+	schema column.Schema
+	rows   []table.Row // shared with the query cache, read-only
+	pos    int
+}
+
+// row returns the current row, or nil if there is none.
+func (r *Rows) row() table.Row {
+	if r.pos < 0 || r.pos >= len(r.rows) {
+		return nil
+	}
+	return r.rows[r.pos]
+}
+
+// Next moves to the next row, returning false when there are no more.
+func (r *Rows) Next() bool {
+	r.pos = min(r.pos+1, len(r.rows))
+	return r.pos < len(r.rows)
+}
+
+// Err returns the error that ended the scan, if any. Query reads every row
+// before it returns, and reports read errors itself, so it is always nil.
+func (r *Rows) Err() error { return nil }
+
+// Columns returns the names of the result columns.
+func (r *Rows) Columns() []string {
+	names := make([]string, len(r.schema))
+	for i, c := range r.schema {
+		names[i] = c.Name
+	}
+	return names
+}
+
+// Scan copies the current row into dest, one pointer per column: *uint32
+// or *int for INT, *int32 or *int for INT32, *string for TEXT, or *any for
+// any column.
+func (r *Rows) Scan(dest ...any) error {
+	row := r.row()
+	if row == nil {
+		return errors.New("scan: no current row")
+	}
+	if len(dest) != len(row) {
+		return fmt.Errorf("scan: %d destinations for %d columns", len(dest), len(row))
+	}
+	for i, d := range dest {
+		ok := true
+		switch p := d.(type) {
+		case *any:
+			*p = row[i]
+		case *uint32:
+			*p, ok = row[i].(uint32)
+		case *int32:
+			*p, ok = row[i].(int32)
+		case *string:
+			*p, ok = row[i].(string)
+		case *int:
+			switch v := row[i].(type) {
+			case uint32:
+				*p = int(v)
+			case int32:
+				*p = int(v)
+			default:
+				ok = false
+			}
+		default:
+			ok = false
+		}
+		if !ok {
+			return fmt.Errorf("scan: column %q holds %T, cannot scan into %T", r.schema[i].Name, row[i], d)
+		}
+	}
+	return nil
+}
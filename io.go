@@ -2,18 +2,104 @@ package main
 
 import (
 	"bufio"
+	"errors"
 	"fmt"
+	"io"
 	"strings"
+	"time"
 )
 
-func printPrompt() {
-	fmt.Print("db > ")
+func printPrompt(w io.Writer) {
+	fmt.Fprint(w, "db > ")
+}
+
+// runREPL prompts on w and reads statements from r until it ends or .exit is
+// entered. Lines starting with a dot go to doMetaCommand, everything else to
+// executeInput. Only a read error other than the end of r is returned.
+func runREPL(r io.Reader, w io.Writer) error {
+	reader := bufio.NewReader(r)
+	for {
+		printPrompt(w)
+		stmts, err := readStatements(reader)
+		if errors.Is(err, io.EOF) {
+			fmt.Fprintln(w)
+			return nil
+		} else if err != nil {
+			return err
+		}
+		for _, s := range stmts {
+			if !strings.HasPrefix(s, ".") {
+				start := time.Now()
+				executeInput(w, s)
+				if replTimer {
+					fmt.Fprintf(w, "Run Time: %s\n", time.Since(start))
+				}
+				continue
+			}
+			switch doMetaCommand(w, s) {
+			case MetaCommandExit:
+				return nil
+			case MetaCommandUnrecognizedCommand:
+				fmt.Fprintf(w, "Unrecognized command '%s'.\n", s)
+			}
+		}
+	}
 }
 
 func readInput(reader *bufio.Reader) (string, error) {
 	input, err := reader.ReadString('\n')
-	if err != nil {
+	if err != nil && (!errors.Is(err, io.EOF) || input == "") {
 		return "", err
 	}
 	return strings.TrimSpace(input), nil
 }
+
+// readStatements reads input up to the end of a statement list. A line
+// without semicolons is one statement, as with readInput; once a line holds a
+// semicolon, or leaves a quoted literal open, further lines are read until
+// the input ends with a semicolon outside quotes.
+func readStatements(reader *bufio.Reader) ([]string, error) {
+	input, err := readInput(reader)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		stmts, rest, open := splitStatements(input)
+		if !open && (rest == "" || len(stmts) == 0) {
+			if rest != "" {
+				stmts = append(stmts, rest)
+			}
+			return stmts, nil
+		}
+		line, err := readInput(reader)
+		if err != nil {
+			return nil, err
+		}
+		input += "\n" + line
+	}
+}
+
+// splitStatements splits input at semicolons that are not inside a single-
+// or double-quoted literal. It returns the complete statements, trimmed and
+// without empty ones, the text after the last semicolon, and whether that
+// text ends inside a quoted literal.
+func splitStatements(input string) (stmts []string, rest string, open bool) {
+	var quote rune
+	start := 0
+	for i, r := range input {
+		switch {
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			}
+		case r == '\'' || r == '"':
+			quote = r
+		case r == ';':
+			if s := strings.TrimSpace(input[start:i]); s != "" {
+				stmts = append(stmts, s)
+			}
+			start = i + 1
+		}
+	}
+	return stmts, strings.TrimSpace(input[start:]), quote != 0
+}
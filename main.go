@@ -1,22 +1,177 @@
 package main
 
 import (
+	"errors"
 	"fmt"
+	"io"
 	"os"
+	"slices"
+	"strconv"
 	"strings"
 	"vqlite/column"
-	"vqlite/pager"
 	"vqlite/table"
 )
 
-func doMetaCommand(input string) MetaCommandResult {
+// replTimer is set by .timer on: runREPL then reports how long each input
+// line took to run.
+var replTimer bool
+
+// doMetaCommand runs a command starting with a dot, writing its output to w.
+func doMetaCommand(w io.Writer, input string) MetaCommandResult {
 	if input == ".exit" {
-		os.Exit(0)
+		return MetaCommandExit
+	}
+	if arg, ok := strings.CutPrefix(input, ".timer "); ok {
+		switch strings.TrimSpace(arg) {
+		case "on":
+			replTimer = true
+		case "off":
+			replTimer = false
+		default:
+			return MetaCommandUnrecognizedCommand
+		}
+		return MetaCommandSuccess
+	}
+	if input == ".dump" {
+		if replCatalog == nil {
+			fmt.Fprintln(w, ".dump: no database open")
+		} else if err := replCatalog.dump(w); err != nil {
+			fmt.Fprintln(w, ".dump:", err)
+		}
+		return MetaCommandSuccess
+	}
+	if path, ok := strings.CutPrefix(input, ".read "); ok {
+		if err := executeScript(w, strings.TrimSpace(path)); err != nil {
+			fmt.Fprintln(w, ".read:", err)
+		}
+		return MetaCommandSuccess
 	}
 	return MetaCommandUnrecognizedCommand
 }
 
+// executeScript runs every statement in the file at path through
+// runStatement, the same path as executeInput, writing their output to w.
+// Statements are read as at the prompt: a line without semicolons is one
+// statement, and once a line holds a semicolon or leaves a quoted literal
+// open, the statement runs on to the line ending with a semicolon outside
+// quotes. It stops at the first statement that cannot be prepared or fails
+// to execute and reports the line it starts on.
+func executeScript(w io.Writer, path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read script: %w", err)
+	}
+	lines := strings.Split(string(data), "\n")
+	for i := 0; i < len(lines); i++ {
+		start := i + 1
+		input := lines[i]
+		for {
+			stmts, rest, open := splitStatements(input)
+			if i == len(lines)-1 || !open && (rest == "" || len(stmts) == 0) {
+				if rest != "" {
+					stmts = append(stmts, rest)
+				}
+				for _, s := range stmts {
+					res, err := runStatement(w, s)
+					switch {
+					case res == PrepareSyntaxError:
+						return fmt.Errorf("line %d: syntax error in %q", start, s)
+					case res != PrepareSuccess:
+						return fmt.Errorf("line %d: unrecognized statement %q", start, s)
+					case err != nil:
+						return fmt.Errorf("line %d: %q: %w", start, s, err)
+					}
+				}
+				break
+			}
+			i++
+			input += "\n" + lines[i]
+		}
+	}
+	return nil
+}
+
+// executeInput runs each semicolon-separated statement of input in order,
+// as typed at the prompt, writing results and errors to w. A statement that
+// cannot be prepared or fails is reported without stopping the rest. It
+// returns how many statements were executed.
+func executeInput(w io.Writer, input string) int {
+	stmts, rest, _ := splitStatements(input)
+	if rest != "" {
+		stmts = append(stmts, rest)
+	}
+	executed := 0
+	for _, s := range stmts {
+		res, err := runStatement(w, s)
+		switch res {
+		case PrepareSuccess:
+		case PrepareSyntaxError:
+			fmt.Fprintf(w, "Syntax error in '%s'.\n", s)
+			continue
+		default:
+			fmt.Fprintf(w, "Unrecognized keyword at start of '%s'.\n", s)
+			continue
+		}
+		if err != nil {
+			fmt.Fprintln(w, "Error:", err)
+		}
+		executed++
+	}
+	return executed
+}
+
+// runStatement runs one statement: one starting with explain through
+// explainSelect, anything else through prepareStatement and
+// executeStatement. It returns the result of preparing the statement and,
+// if it was run, the error it failed with.
+func runStatement(w io.Writer, s string) (PrepareResult, error) {
+	if sel, ok := strings.CutPrefix(s, "explain "); ok {
+		return PrepareSuccess, explainSelect(w, sel)
+	}
+	var stmt Statement
+	if res := prepareStatement(s, &stmt); res != PrepareSuccess {
+		return res, nil
+	}
+	return PrepareSuccess, executeStatement(w, &stmt)
+}
+
+// replSchema is the schema of the table the REPL works on:
+// id INT, username TEXT(32), email TEXT(64), age INT.
+var replSchema = column.Schema{
+	{Name: "id", Type: column.ColumnTypeInt},
+	{Name: "username", Type: column.ColumnTypeText, MaxLength: 32},
+	{Name: "email", Type: column.ColumnTypeText, MaxLength: 64},
+	{Name: "age", Type: column.ColumnTypeInt},
+}
+
 func prepareStatement(input string, stmt *Statement) PrepareResult {
+	if rest, ok := strings.CutPrefix(input, "create table "); ok {
+		name, schema, key, err := parseCreateTable(rest)
+		if err != nil {
+			return PrepareSyntaxError
+		}
+		stmt.Type = StatementCreateTable
+		stmt.TableName = name
+		stmt.Schema = schema
+		stmt.KeyColumn = key
+		return PrepareSuccess
+	}
+	if rest, ok := strings.CutPrefix(input, "insert into "); ok {
+		name, _, _ := strings.Cut(rest, " ")
+		stmt.Type = StatementInsert
+		stmt.TableName = name
+		tbl := lookupTable(name)
+		if tbl == nil {
+			// left for executeCatalogStatement to report
+			return PrepareSuccess
+		}
+		row, err := parseInsertInto(rest, tbl.schema)
+		if err != nil {
+			return PrepareSyntaxError
+		}
+		stmt.RowToInsert = row
+		return PrepareSuccess
+	}
 	if strings.HasPrefix(input, "insert") {
 		stmt.Type = StatementInsert
 		return PrepareSuccess
@@ -25,117 +180,314 @@ func prepareStatement(input string, stmt *Statement) PrepareResult {
 		stmt.Type = StatementSelect
 		return PrepareSuccess
 	}
+	if rest, ok := strings.CutPrefix(input, "select * from "); ok {
+		name, where, hasWhere := strings.Cut(strings.TrimSpace(rest), " where ")
+		tbl := lookupTable(name)
+		if tbl == nil {
+			return PrepareSyntaxError
+		}
+		if hasWhere {
+			list, ok := strings.CutPrefix(strings.TrimSpace(where), tbl.schema[tbl.key].Name+" in ")
+			keys, err := parseInList(list)
+			if !ok || err != nil {
+				return PrepareSyntaxError
+			}
+			stmt.WhereIn = keys
+		}
+		stmt.Type = StatementSelect
+		stmt.TableName = name
+		return PrepareSuccess
+	}
+	if list, ok := strings.CutPrefix(input, "select where id in "); ok {
+		keys, err := parseInList(list)
+		if err != nil {
+			return PrepareSyntaxError
+		}
+		stmt.Type = StatementSelect
+		stmt.WhereIn = keys
+		return PrepareSuccess
+	}
 	return PrepareUnrecognizedStatement
 }
 
-func executeStatement(stmt *Statement) {
-	switch stmt.Type {
-	case StatementInsert:
-		fmt.Println("This is where we would do an insert.")
-	case StatementSelect:
-		fmt.Println("This is where we would do a select.")
+// parseInsertInto parses the rest of an insert with an explicit column list,
+// such as "t (id, email) values (5, 'x@y.z')", into a full row in schema
+// order. Columns left out of the list get their type's zero value: 0 for
+// INT columns and "" for TEXT. The table name is not checked; callers pick
+// schema by it.
+func parseInsertInto(input string, schema column.Schema) (table.Row, error) {
+	_, rest, ok := strings.Cut(input, " ")
+	if !ok {
+		return nil, fmt.Errorf("insert %q: missing column list", input)
 	}
-}
-
-func main() {
-	// 1) Define your schema: id INT, username TEXT(32), email TEXT(64), age INT
-	schema := column.Schema{
-		{Name: "id", Type: column.ColumnTypeInt},
-		{Name: "username", Type: column.ColumnTypeText, MaxLength: 32},
-		{Name: "email", Type: column.ColumnTypeText, MaxLength: 64},
-		{Name: "age", Type: column.ColumnTypeInt},
+	cols, vals, ok := strings.Cut(rest, " values ")
+	if !ok {
+		return nil, fmt.Errorf("insert %q: missing values", input)
 	}
-
-	// Open pager & B-tree (will create new tree if file empty)
-	pg, err := pager.OpenPager("test.db")
+	names, err := parseParenList(cols)
 	if err != nil {
-		fmt.Println("open pager:", err)
-		return
+		return nil, fmt.Errorf("insert column list: %w", err)
 	}
-	meta, _ := table.BuildTableMeta(schema)
-	bt, err := table.NewBTree(pg, meta)
+	values, err := parseParenList(vals)
 	if err != nil {
-		fmt.Println("NewBTree:", err)
-		return
+		return nil, fmt.Errorf("insert values: %w", err)
+	}
+	if len(names) != len(values) {
+		return nil, fmt.Errorf("insert names %d columns but gives %d values", len(names), len(values))
 	}
 
-	// Insert rows
-	rows := []table.Row{
-		{uint32(1), "alice", "alice@example.com", uint32(30)},
-		{uint32(2), "bob", "bob@example.com", uint32(25)},
+	row := make(table.Row, len(schema))
+	for i, c := range schema {
+		switch c.Type {
+		case column.ColumnTypeInt:
+			row[i] = uint32(0)
+		case column.ColumnTypeInt32:
+			row[i] = int32(0)
+		case column.ColumnTypeText:
+			row[i] = ""
+		}
 	}
-	for _, r := range rows {
-		if err := bt.Insert(r[0].(uint32), r); err != nil {
-			fmt.Println("insert:", err)
-			return
+	seen := make(map[string]bool, len(names))
+	for j, name := range names {
+		i := slices.IndexFunc(schema, func(c column.Column) bool { return c.Name == name })
+		if i < 0 {
+			return nil, fmt.Errorf("insert: unknown column %q", name)
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("insert: column %q named twice", name)
+		}
+		seen[name] = true
+		v, err := parseValue(values[j], schema[i].Type)
+		if err != nil {
+			return nil, fmt.Errorf("insert: column %q: %w", name, err)
 		}
+		row[i] = v
 	}
+	return row, nil
+}
 
-	// Create cursor for lookups
-	cursor, err := bt.NewCursor()
-	if err != nil {
-		fmt.Println("create cursor:", err)
-		return
+// parseParenList splits a parenthesized, comma-separated list such as
+// "(id, 'a,b')" into its trimmed items, ignoring commas inside quotes.
+func parseParenList(list string) ([]string, error) {
+	list = strings.TrimSpace(list)
+	inner, ok := strings.CutPrefix(list, "(")
+	if !ok {
+		return nil, fmt.Errorf("list %q: missing (", list)
 	}
+	if inner, ok = strings.CutSuffix(inner, ")"); !ok {
+		return nil, fmt.Errorf("list %q: missing )", list)
+	}
+	var items []string
+	var quote rune
+	start := 0
+	for i, r := range inner {
+		switch {
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			}
+		case r == '\'' || r == '"':
+			quote = r
+		case r == ',':
+			items = append(items, strings.TrimSpace(inner[start:i]))
+			start = i + 1
+		}
+	}
+	if quote != 0 {
+		return nil, fmt.Errorf("list %q: unterminated quote", list)
+	}
+	return append(items, strings.TrimSpace(inner[start:])), nil
+}
 
-	// Fetch and print using cursor.Seek()
-	for _, r := range rows {
-		key := r[0].(uint32)
-		if err := cursor.Seek(key); err != nil {
-			fmt.Printf("seek error for key %d: %v\n", key, err)
-			continue
+// parseValue converts one literal of a values list to the Go type stored for
+// a column of type typ: a quoted string for TEXT, a number otherwise.
+func parseValue(lit string, typ column.ColumnType) (any, error) {
+	switch typ {
+	case column.ColumnTypeText:
+		if len(lit) < 2 || (lit[0] != '\'' && lit[0] != '"') || lit[len(lit)-1] != lit[0] {
+			return nil, fmt.Errorf("TEXT value %s is not quoted", lit)
 		}
-		if !cursor.Valid() || cursor.Key() != key {
-			fmt.Printf("Row key %d: not found\n", key)
-			continue
+		return lit[1 : len(lit)-1], nil
+	case column.ColumnTypeInt32:
+		v, err := strconv.ParseInt(lit, 10, 32)
+		if err != nil {
+			return nil, err
 		}
-		fmt.Printf("Row key %d: %v\n", key, cursor.Value())
+		return int32(v), nil
+	default:
+		v, err := strconv.ParseUint(lit, 10, 32)
+		if err != nil {
+			return nil, err
+		}
+		return uint32(v), nil
 	}
+}
 
-	// Demonstrate the power of cursor seeking with more examples
-	fmt.Println("\n--- Demonstrating Cursor Seeking Power ---")
+// parseInList parses the parenthesized key list of an in clause, such as
+// "(2, 5, 9)", into the keys to pass to BTree.LookupIn.
+func parseInList(list string) ([]uint32, error) {
+	list = strings.TrimSpace(list)
+	inner, ok := strings.CutPrefix(list, "(")
+	if !ok {
+		return nil, fmt.Errorf("in list %q: missing (", list)
+	}
+	if inner, ok = strings.CutSuffix(inner, ")"); !ok {
+		return nil, fmt.Errorf("in list %q: missing )", list)
+	}
+	var keys []uint32
+	for _, f := range strings.Split(inner, ",") {
+		k, err := strconv.ParseUint(strings.TrimSpace(f), 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("in list %q: %w", list, err)
+		}
+		keys = append(keys, uint32(k))
+	}
+	return keys, nil
+}
 
-	// Example 1: Exact key lookup
-	fmt.Println("1. Exact key lookup for key 2:")
-	if err := cursor.Seek(2); err != nil {
-		fmt.Println("   Seek error:", err)
-	} else if cursor.Valid() && cursor.Key() == 2 {
-		fmt.Printf("   Found: %v\n", cursor.Value())
+// explainSelect runs a select * from a catalog table, either a full scan or
+// with where <key> = N, and writes how it ran to w instead of its rows.
+func explainSelect(w io.Writer, input string) error {
+	rest, ok := strings.CutPrefix(input, "select * from ")
+	if !ok {
+		return fmt.Errorf("explain %q: want select * from <table> [where <key> = N]", input)
+	}
+	name, where, hasWhere := strings.Cut(strings.TrimSpace(rest), " where ")
+	tbl := lookupTable(name)
+	if tbl == nil {
+		return fmt.Errorf("no such table %q", name)
+	}
+	var stats table.QueryStats
+	var err error
+	if hasWhere {
+		var key uint32
+		if key, err = tbl.parseWhere(where); err != nil {
+			return err
+		}
+		stats, err = tbl.tree.ExplainLookup(key)
 	} else {
-		fmt.Println("   Key 2 not found")
+		stats, err = tbl.tree.ExplainScan(func(table.Row) bool { return true })
+	}
+	if err != nil {
+		return err
 	}
+	plan := "full scan"
+	if stats.Seek {
+		plan = "seek"
+	}
+	fmt.Fprintf(w, "plan: %s, nodes loaded: %d, pages read: %d, rows scanned: %d, rows returned: %d\n",
+		plan, stats.NodesLoaded, stats.PagesRead, stats.RowsScanned, stats.RowsReturned)
+	return nil
+}
 
-	// Example 2: Find first key >= target (range start)
-	fmt.Println("2. Find first key >= 1.5 (should position at key 2):")
-	if err := cursor.Seek(1); err != nil { // Note: seeking to 1, should find 2
-		fmt.Println("   Seek error:", err)
-	} else if cursor.Valid() {
-		fmt.Printf("   First key >= 1: %d with value %v\n", cursor.Key(), cursor.Value())
-	} else {
-		fmt.Println("   No keys >= 1")
+// executeStatement runs stmt, writing its output to w, and returns the
+// error it failed with.
+func executeStatement(w io.Writer, stmt *Statement) error {
+	if stmt.Type == StatementCreateTable || stmt.TableName != "" || stmt.WhereIn != nil {
+		return executeCatalogStatement(w, stmt)
+	}
+	switch stmt.Type {
+	case StatementInsert:
+		fmt.Fprintln(w, "This is where we would do an insert.")
+	case StatementSelect:
+		fmt.Fprintln(w, "This is where we would do a select.")
 	}
+	return nil
+}
 
-	// Example 3: Range iteration - all keys >= 1
-	fmt.Println("3. Range iteration: all users with id >= 1:")
-	if err := cursor.Seek(1); err != nil {
-		fmt.Println("   Seek error:", err)
-	} else {
-		count := 0
-		for cursor.Valid() {
-			fmt.Printf("   - User %d: %s <%s>\n", cursor.Key(), cursor.Value()[1], cursor.Value()[2])
-			count++
-			cursor.Next()
+// executeCatalogStatement runs a create table, an insert or select on a
+// catalog table, or a select with an in list, which without a table name
+// reads the REPL's own table.
+func executeCatalogStatement(w io.Writer, stmt *Statement) error {
+	if replCatalog == nil {
+		return errors.New("no database open")
+	}
+	switch stmt.Type {
+	case StatementCreateTable:
+		if err := replCatalog.createTable(stmt.TableName, stmt.Schema, stmt.KeyColumn); err != nil {
+			return err
+		}
+	case StatementInsert:
+		tbl := replCatalog.tables[stmt.TableName]
+		if tbl == nil {
+			return fmt.Errorf("no such table %q", stmt.TableName)
+		}
+		if err := tbl.tree.Insert(stmt.RowToInsert[tbl.key].(uint32), stmt.RowToInsert); err != nil {
+			return err
+		}
+	case StatementSelect:
+		if stmt.TableName != "" {
+			return selectCatalogTable(w, replCatalog.tables[stmt.TableName], stmt.WhereIn)
+		}
+		tree := replCatalog.primary
+		if stmt.WhereIn != nil {
+			pairs, err := tree.LookupIn(stmt.WhereIn)
+			if err != nil {
+				return err
+			}
+			for _, p := range pairs {
+				fmt.Fprintln(w, formatRow(p.Row))
+			}
+			break
+		}
+		c, err := tree.NewCursor()
+		if err != nil {
+			return err
+		}
+		for c.Valid() {
+			fmt.Fprintln(w, formatRow(c.Value()))
+			if err := c.Next(); err != nil {
+				return err
+			}
 		}
-		fmt.Printf("   Total: %d users\n", count)
 	}
+	fmt.Fprintln(w, "Executed.")
+	return nil
+}
 
-	// Example 4: Key not found - cursor positioning
-	fmt.Println("4. Seek to non-existent key 10 (should be invalid):")
-	if err := cursor.Seek(10); err != nil {
-		fmt.Println("   Seek error:", err)
-	} else if cursor.Valid() {
-		fmt.Printf("   Unexpected: found key %d\n", cursor.Key())
-	} else {
-		fmt.Println("   Correctly positioned: cursor invalid (key 10 > all existing keys)")
+// selectCatalogTable prints the rows of tbl in key order, only those with
+// the keys in in when it is not nil, reading them through the table's query
+// cache.
+func selectCatalogTable(w io.Writer, tbl *catalogTable, in []uint32) error {
+	query := "select *" // the cache belongs to tbl, so no name is needed
+	if in != nil {
+		query += fmt.Sprintf(" where in %v", in)
+	}
+	pairs, err := tbl.cache.Query(query, func() ([]table.KeyRowPair, error) {
+		if in != nil {
+			return tbl.tree.LookupIn(in)
+		}
+		return tbl.scan(false, 0)
+	})
+	if err != nil {
+		return err
+	}
+	for _, p := range pairs {
+		fmt.Fprintln(w, formatRow(p.Row))
+	}
+	fmt.Fprintln(w, "Executed.")
+	return nil
+}
+
+// main opens the database file named by the first argument, test.db by
+// default, and runs the REPL on standard input until it ends or .exit.
+func main() {
+	path := "test.db"
+	if len(os.Args) > 1 {
+		path = os.Args[1]
+	}
+	cat, err := openCatalog(path)
+	if err != nil {
+		fmt.Println("Error:", err)
+		os.Exit(1)
+	}
+	replCatalog = cat
+	err = runREPL(os.Stdin, os.Stdout)
+	if cerr := cat.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		fmt.Println("Error:", err)
+		os.Exit(1)
 	}
 }
@@ -0,0 +1,723 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"vqlite/table"
+)
+
+// TestExecuteInput_RunsEachStatement feeds two inserts and a select on one
+// line, one insert carrying a semicolon inside a quoted literal, and checks
+// all three are executed.
+func TestExecuteInput_RunsEachStatement(t *testing.T) {
+	if n := executeInput(io.Discard, `insert 1 alice a@x.com; insert 2 'bo;b' b@x.com; select`); n != 3 {
+		t.Errorf("executeInput executed %d statements; want 3", n)
+	}
+	if n := executeInput(io.Discard, "insert 1 a;; bogus; select;"); n != 2 {
+		t.Errorf("executeInput with an unrecognized statement executed %d; want 2", n)
+	}
+}
+
+// TestRunREPL_ExecutesUntilExit drives the REPL loop main runs with a
+// statement list continued on the next line, a meta-command it does not know
+// and .exit, and checks nothing after .exit runs.
+func TestRunREPL_ExecutesUntilExit(t *testing.T) {
+	cat, err := openCatalog(filepath.Join(t.TempDir(), "repl.db"))
+	if err != nil {
+		t.Fatalf("openCatalog: %v", err)
+	}
+	defer cat.Close()
+	replCatalog = cat
+	defer func() { replCatalog = nil }()
+
+	in := "create table t (id int primary key, n text(4)); insert into t (id, n) values (2, 'b\n" +
+		"');\nselect * from t\n.bogus\n.exit\nselect * from t\n"
+	var buf bytes.Buffer
+	if err := runREPL(strings.NewReader(in), &buf); err != nil {
+		t.Fatalf("runREPL: %v", err)
+	}
+	want := "db > Executed.\nExecuted.\ndb > (2, b\n)\nExecuted.\ndb > Unrecognized command '.bogus'.\ndb > "
+	if got := buf.String(); got != want {
+		t.Errorf("output = %q; want %q", got, want)
+	}
+
+	buf.Reset()
+	if err := runREPL(strings.NewReader("select * from t"), &buf); err != nil {
+		t.Fatalf("runREPL to end of input: %v", err)
+	}
+	if got, want := buf.String(), "db > (2, b\n)\nExecuted.\ndb > \n"; got != want {
+		t.Errorf("output without .exit = %q; want %q", got, want)
+	}
+}
+
+// TestRunREPL_TimerAndExplain checks explain reports a seek for a keyed
+// select that loads far fewer nodes than a full scan of the same table, and
+// that .timer on adds a run time after each line until .timer off.
+func TestRunREPL_TimerAndExplain(t *testing.T) {
+	cat, err := openCatalog(filepath.Join(t.TempDir(), "explain.db"))
+	if err != nil {
+		t.Fatalf("openCatalog: %v", err)
+	}
+	defer cat.Close()
+	replCatalog = cat
+	defer func() { replCatalog, replTimer = nil, false }()
+
+	var sql strings.Builder
+	sql.WriteString("create table t (id int primary key, n int);")
+	for i := 1; i <= 300; i++ {
+		fmt.Fprintf(&sql, "insert into t (id, n) values (%d, %d);", i, i)
+	}
+	executeInput(io.Discard, sql.String())
+
+	in := ".timer on\nexplain select * from t where id = 150\nexplain select * from t\n" +
+		".timer off\nexplain select * from t where id = 7\n.timer sometimes\n.exit\n"
+	var buf bytes.Buffer
+	if err := runREPL(strings.NewReader(in), &buf); err != nil {
+		t.Fatalf("runREPL: %v", err)
+	}
+	out := buf.String()
+	type plan struct {
+		kind                            string
+		nodes, pages, scanned, returned int
+	}
+	var plans []plan
+	for _, line := range strings.Split(out, "\n") {
+		_, line, ok := strings.Cut(line, "plan: ")
+		if !ok {
+			continue
+		}
+		var p plan
+		line = strings.Replace(line, "full scan", "scan", 1)
+		if _, err := fmt.Sscanf(line, "%s nodes loaded: %d, pages read: %d, rows scanned: %d, rows returned: %d",
+			&p.kind, &p.nodes, &p.pages, &p.scanned, &p.returned); err != nil {
+			t.Fatalf("parse %q: %v", line, err)
+		}
+		p.kind = strings.TrimSuffix(p.kind, ",")
+		plans = append(plans, p)
+	}
+	if len(plans) != 3 {
+		t.Fatalf("got %d explain lines; want 3 in %q", len(plans), out)
+	}
+	seek, scan := plans[0], plans[1]
+	if seek.kind != "seek" || seek.returned != 1 || scan.kind != "scan" || scan.scanned != 300 || scan.returned != 300 {
+		t.Errorf("plans = %+v; want a seek returning 1 row and a scan of all 300", plans[:2])
+	}
+	if scan.nodes < 5*seek.nodes {
+		t.Errorf("scan loaded %d nodes, seek %d; want the scan far higher", scan.nodes, seek.nodes)
+	}
+	if n := strings.Count(out, "Run Time: "); n != 2 {
+		t.Errorf("got %d run times; want 2 while the timer was on", n)
+	}
+	if !strings.Contains(out, "Unrecognized command '.timer sometimes'.") {
+		t.Errorf("bad .timer argument not reported in %q", out)
+	}
+}
+
+// TestReadStatements_ContinuesUntilSemicolon checks a statement list left
+// open at the end of a line is completed by the following lines.
+func TestReadStatements_ContinuesUntilSemicolon(t *testing.T) {
+	r := bufio.NewReader(strings.NewReader("insert 1 a; insert 2\n'x;\ny';\nselect\n"))
+	got, err := readStatements(r)
+	if err != nil {
+		t.Fatalf("readStatements: %v", err)
+	}
+	if want := []string{"insert 1 a", "insert 2\n'x;\ny'"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("first read = %q; want %q", got, want)
+	}
+	if got, err = readStatements(r); err != nil || !reflect.DeepEqual(got, []string{"select"}) {
+		t.Errorf("second read = %q, %v; want [select]", got, err)
+	}
+}
+
+// TestExecuteStatement_WritesToWriter runs a select and an unrecognized
+// statement into a buffer and checks the captured output.
+func TestExecuteStatement_WritesToWriter(t *testing.T) {
+	var buf bytes.Buffer
+	var stmt Statement
+	if prepareStatement("select", &stmt) != PrepareSuccess {
+		t.Fatal("prepareStatement(select) failed")
+	}
+	executeStatement(&buf, &stmt)
+	if got, want := buf.String(), "This is where we would do a select.\n"; got != want {
+		t.Errorf("select output = %q; want %q", got, want)
+	}
+
+	buf.Reset()
+	executeInput(&buf, "select; drop")
+	want := "This is where we would do a select.\nUnrecognized keyword at start of 'drop'.\n"
+	if got := buf.String(); got != want {
+		t.Errorf("executeInput output = %q; want %q", got, want)
+	}
+}
+
+// TestParseInsertInto_ColumnList inserts with a column list in a different
+// order than the schema and checks the row comes out in schema order with
+// zero values for the columns left out, that unknown columns fail, and that
+// an insert into a table that does not exist is reported, not dropped.
+func TestParseInsertInto_ColumnList(t *testing.T) {
+	cat, err := openCatalog(filepath.Join(t.TempDir(), "repl.db"))
+	if err != nil {
+		t.Fatalf("openCatalog: %v", err)
+	}
+	defer cat.Close()
+	replCatalog = cat
+	defer func() { replCatalog = nil }()
+	executeInput(io.Discard, "create table users (id int, username text(32), email text(64), age int)")
+
+	var stmt Statement
+	if r := prepareStatement("insert into users (email, id) values ('x@y.z', 5)", &stmt); r != PrepareSuccess {
+		t.Fatalf("prepareStatement = %v; want PrepareSuccess", r)
+	}
+	want := table.Row{uint32(5), "", "x@y.z", uint32(0)}
+	if stmt.Type != StatementInsert || !stmt.RowToInsert.Equal(want) {
+		t.Errorf("RowToInsert = %v; want %v", stmt.RowToInsert, want)
+	}
+
+	row, err := parseInsertInto("users (age, username) values (41, 'a, b')", replSchema)
+	if err != nil || !row.Equal(table.Row{uint32(0), "a, b", "", uint32(41)}) {
+		t.Errorf("parseInsertInto = %v, %v; want quoted comma kept", row, err)
+	}
+
+	_, err = parseInsertInto("users (id, nickname) values (1, 'x')", replSchema)
+	if err == nil || !strings.Contains(err.Error(), `unknown column "nickname"`) {
+		t.Errorf("unknown column err = %v; want unknown column \"nickname\"", err)
+	}
+	if r := prepareStatement("insert into users (id, nickname) values (1, 'x')", &stmt); r != PrepareSyntaxError {
+		t.Errorf("prepareStatement with unknown column = %v; want PrepareSyntaxError", r)
+	}
+
+	var buf bytes.Buffer
+	executeInput(&buf, "insert into nobody (id) values (1)")
+	if got, want := buf.String(), "Error: no such table \"nobody\"\n"; got != want {
+		t.Errorf("insert into a missing table output = %q; want %q", got, want)
+	}
+}
+
+// TestCreateTable_InsertSelect creates a table keyed on its second column,
+// inserts into it and selects it back in key order, and checks a second
+// create table of the same name is refused.
+func TestCreateTable_InsertSelect(t *testing.T) {
+	cat, err := openCatalog(filepath.Join(t.TempDir(), "repl.db"))
+	if err != nil {
+		t.Fatalf("openCatalog: %v", err)
+	}
+	defer cat.Close()
+	replCatalog = cat
+	defer func() { replCatalog = nil }()
+
+	var buf bytes.Buffer
+	executeInput(&buf, "create table pets (name text(16), id INT primary key, age int);"+
+		"insert into pets (id, name, age) values (7, 'rex', 3);"+
+		"insert into pets (name, id) values ('tom', 2);"+
+		"select * from pets")
+	want := "Executed.\nExecuted.\nExecuted.\n(tom, 2, 0)\n(rex, 7, 3)\nExecuted.\n"
+	if got := buf.String(); got != want {
+		t.Errorf("output = %q; want %q", got, want)
+	}
+
+	buf.Reset()
+	executeInput(&buf, "create table pets (id int)")
+	if got := buf.String(); got != "Error: table \"pets\" already exists\n" {
+		t.Errorf("duplicate create table output = %q", got)
+	}
+
+	for _, def := range []string{
+		"t",
+		"t (name text(8) primary key)",
+		"t (a int primary key, b int primary key)",
+		"t (a int, b blob)",
+	} {
+		if _, _, _, err := parseCreateTable(def); err == nil {
+			t.Errorf("parseCreateTable(%q) succeeded; want an error", def)
+		}
+	}
+}
+
+// TestSelectWhereIn_LooksUpKeys runs select ... where id in (...) on the
+// REPL's own table and on a catalog table, and checks only the listed keys
+// that exist are printed, in key order, and that a bad list is a syntax
+// error.
+func TestSelectWhereIn_LooksUpKeys(t *testing.T) {
+	cat, err := openCatalog(filepath.Join(t.TempDir(), "repl.db"))
+	if err != nil {
+		t.Fatalf("openCatalog: %v", err)
+	}
+	defer cat.Close()
+	replCatalog = cat
+	defer func() { replCatalog = nil }()
+	for _, id := range []uint32{1, 2, 3} {
+		if err := cat.primary.Insert(id, table.Row{id, fmt.Sprint("user", id), "u@x", id * 10}); err != nil {
+			t.Fatalf("insert %d: %v", id, err)
+		}
+	}
+
+	var buf bytes.Buffer
+	executeInput(&buf, "select where id in (3, 9, 1, 3)")
+	if got, want := buf.String(), "(1, user1, u@x, 10)\n(3, user3, u@x, 30)\nExecuted.\n"; got != want {
+		t.Errorf("REPL table output = %q; want %q", got, want)
+	}
+
+	buf.Reset()
+	executeInput(&buf, "create table pets (id int primary key, name text(8));"+
+		"insert into pets (id, name) values (7, 'rex');"+
+		"insert into pets (id, name) values (2, 'tom');"+
+		"select * from pets where id in (7, 5)")
+	if got, want := buf.String(), "Executed.\nExecuted.\nExecuted.\n(7, rex)\nExecuted.\n"; got != want {
+		t.Errorf("catalog table output = %q; want %q", got, want)
+	}
+
+	for _, input := range []string{"select where id in (1, x)", "select * from pets where name in (1)"} {
+		var stmt Statement
+		if got := prepareStatement(input, &stmt); got != PrepareSyntaxError {
+			t.Errorf("prepareStatement(%q) = %v; want PrepareSyntaxError", input, got)
+		}
+	}
+}
+
+// TestExecuteScript_RunsUntilBadLine runs a .read script that creates a
+// table, inserts, selects and then hits a statement it cannot prepare, and
+// checks the output of the good lines, that the line after the bad one never
+// runs, and that the error names the bad line. A second script checks that
+// explain runs as at the prompt and that a statement failing to execute
+// stops the script too.
+func TestExecuteScript_RunsUntilBadLine(t *testing.T) {
+	dir := t.TempDir()
+	cat, err := openCatalog(filepath.Join(dir, "repl.db"))
+	if err != nil {
+		t.Fatalf("openCatalog: %v", err)
+	}
+	defer cat.Close()
+	replCatalog = cat
+	defer func() { replCatalog = nil }()
+
+	script := filepath.Join(dir, "script.sql")
+	if err := os.WriteFile(script, []byte("create table pets (id int primary key, name text(8));\n"+
+		"insert into pets (id, name) values (1, 'rex'); insert into pets (id, name)\n"+
+		"  values (2, 'a;b');\n"+
+		"select * from pets\n"+
+		"\n"+
+		"drop table pets\n"+
+		"insert into pets (id, name) values (3, 'tom')\n"), 0o644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	var buf bytes.Buffer
+	err = executeScript(&buf, script)
+	if err == nil || !strings.Contains(err.Error(), "line 6") || !strings.Contains(err.Error(), "drop table pets") {
+		t.Errorf("executeScript error = %v; want one naming line 6 and its statement", err)
+	}
+	want := "Executed.\nExecuted.\nExecuted.\n(1, rex)\n(2, a;b)\nExecuted.\n"
+	if got := buf.String(); got != want {
+		t.Errorf("output = %q; want %q", got, want)
+	}
+	if _, found, _ := cat.tables["pets"].tree.Search(3); found {
+		t.Error("the line after the bad one was executed")
+	}
+
+	if err := executeScript(&buf, filepath.Join(dir, "missing.sql")); err == nil {
+		t.Error("executeScript of a missing file succeeded; want an error")
+	}
+
+	if err := os.WriteFile(script, []byte("explain select * from pets where id = 1\n"+
+		"create table pets (id int)\n"+
+		"insert into pets (id, name) values (4, 'tom')\n"), 0o644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	buf.Reset()
+	err = executeScript(&buf, script)
+	if err == nil || !strings.Contains(err.Error(), "line 2") {
+		t.Errorf("executeScript error = %v; want the failed create table on line 2", err)
+	}
+	if !strings.HasPrefix(buf.String(), "plan: seek") {
+		t.Errorf("output = %q; want the explain plan first", buf.String())
+	}
+	if _, found, _ := cat.tables["pets"].tree.Search(4); found {
+		t.Error("the line after the failed create table was executed")
+	}
+}
+
+// TestDumpRead_RoundTrip fills two tables, one keyed on a later column and
+// with text holding quotes and semicolons, .dumps them to a file, .reads the
+// file into a fresh database and checks that its dump matches.
+func TestDumpRead_RoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	cat, err := openCatalog(filepath.Join(dir, "a.db"))
+	if err != nil {
+		t.Fatalf("openCatalog: %v", err)
+	}
+	defer cat.Close()
+	replCatalog = cat
+	defer func() { replCatalog = nil }()
+
+	var buf bytes.Buffer
+	executeInput(&buf, "create table pets (name text(16), id int primary key, age int32);"+
+		"insert into pets (id, name, age) values (2, 'o\"neil', -3);"+
+		"insert into pets (id, name) values (1, \"it's; fine\");"+
+		"create table notes (id int, body text(32));"+
+		"insert into notes (id, body) values (9, 'hi')")
+	if strings.Contains(buf.String(), "Error") {
+		t.Fatalf("setup output = %q", buf.String())
+	}
+	buf.Reset()
+	if doMetaCommand(&buf, ".dump") != MetaCommandSuccess {
+		t.Fatal(".dump was not recognized")
+	}
+	dump := buf.String()
+	script := filepath.Join(dir, "dump.sql")
+	if err := os.WriteFile(script, []byte(dump), 0o644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+
+	fresh, err := openCatalog(filepath.Join(dir, "b.db"))
+	if err != nil {
+		t.Fatalf("openCatalog: %v", err)
+	}
+	defer fresh.Close()
+	replCatalog = fresh
+	if err := executeScript(io.Discard, script); err != nil {
+		t.Fatalf("executeScript: %v", err)
+	}
+	buf.Reset()
+	doMetaCommand(&buf, ".dump")
+	if got := buf.String(); got != dump {
+		t.Errorf("dump after .read =\n%s\nwant\n%s", got, dump)
+	}
+	row, found, err := fresh.tables["pets"].tree.Search(1)
+	if err != nil || !found || !reflect.DeepEqual(row, table.Row{"it's; fine", uint32(1), int32(0)}) {
+		t.Errorf("pets row 1 = %v, %v, %v; want [it's; fine 1 0]", row, found, err)
+	}
+}
+
+// TestDB_ExecAndQuery creates a table through DB.Exec, inserts, updates and
+// deletes rows, and reads them back with Rows.Next and Scan.
+func TestDB_ExecAndQuery(t *testing.T) {
+	db, err := OpenDB(filepath.Join(t.TempDir(), "db.db"))
+	if err != nil {
+		t.Fatalf("OpenDB: %v", err)
+	}
+	defer db.Close()
+
+	if _, err := db.Exec("create table users (id int primary key, name text(16), age int)"); err != nil {
+		t.Fatalf("create table: %v", err)
+	}
+	for _, sql := range []string{
+		"insert into users (id, name, age) values (3, 'carol', 41)",
+		"insert into users (id, name, age) values (1, 'alice', 30)",
+		"insert into users (id, name) values (2, 'bob')",
+		"insert into users (id, name) values (9, 'zed')",
+	} {
+		if n, err := db.Exec(sql); err != nil || n != 1 {
+			t.Fatalf("Exec(%q) = %d, %v; want 1, nil", sql, n, err)
+		}
+	}
+	if n, err := db.Exec("update users set age = 25, name = 'bobby' where id = 2"); err != nil || n != 1 {
+		t.Fatalf("update = %d, %v; want 1, nil", n, err)
+	}
+	if n, err := db.Exec("delete from users where id = 9"); err != nil || n != 1 {
+		t.Fatalf("delete = %d, %v; want 1, nil", n, err)
+	}
+	if n, err := db.Exec("delete from users where id = 9"); err != nil || n != 0 {
+		t.Errorf("delete of a missing key = %d, %v; want 0, nil", n, err)
+	}
+
+	rows, err := db.Query("select * from users")
+	if err != nil {
+		t.Fatalf("Query: %v", err)
+	}
+	var got []string
+	for rows.Next() {
+		var id uint32
+		var name string
+		var age int
+		if err := rows.Scan(&id, &name, &age); err != nil {
+			t.Fatalf("Scan: %v", err)
+		}
+		got = append(got, fmt.Sprintf("%d %s %d", id, name, age))
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatalf("Rows.Err: %v", err)
+	}
+	if want := []string{"1 alice 30", "2 bobby 25", "3 carol 41"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("rows = %q; want %q", got, want)
+	}
+
+	rows, err = db.Query("select * from users where id = 3")
+	if err != nil {
+		t.Fatalf("Query where: %v", err)
+	}
+	var name string
+	var id, age any
+	if !rows.Next() || rows.Scan(&id, &name, &age) != nil || name != "carol" || rows.Next() {
+		t.Errorf("where id = 3 did not return exactly carol's row")
+	}
+	if err := rows.Scan(&id, &age); err == nil {
+		t.Error("Scan after the last row succeeded; want an error")
+	}
+
+	for _, sql := range []string{
+		"create table users (id int)",
+		"insert into nobody (id) values (1)",
+		"update users set id = 4 where id = 1",
+		"delete from users where age = 30",
+		"drop table users",
+	} {
+		if _, err := db.Exec(sql); err == nil {
+			t.Errorf("Exec(%q) succeeded; want an error", sql)
+		}
+	}
+}
+
+// TestDB_QueryOrderBy checks a select with an order by over two columns
+// returns rows tied on the first column in the order of the second.
+func TestDB_QueryOrderBy(t *testing.T) {
+	db, err := OpenDB(filepath.Join(t.TempDir(), "db.db"))
+	if err != nil {
+		t.Fatalf("OpenDB: %v", err)
+	}
+	defer db.Close()
+
+	if _, err := db.Exec("create table users (id int primary key, age int, email text(16))"); err != nil {
+		t.Fatalf("create table: %v", err)
+	}
+	for _, sql := range []string{
+		"insert into users (id, age, email) values (1, 30, 'ann@x')",
+		"insert into users (id, age, email) values (2, 25, 'bob@x')",
+		"insert into users (id, age, email) values (3, 30, 'cat@x')",
+		"insert into users (id, age, email) values (4, 25, 'dan@x')",
+	} {
+		if _, err := db.Exec(sql); err != nil {
+			t.Fatalf("Exec(%q): %v", sql, err)
+		}
+	}
+	rows, err := db.Query("select * from users order by age asc, email desc")
+	if err != nil {
+		t.Fatalf("Query: %v", err)
+	}
+	var got []uint32
+	for rows.Next() {
+		var id, age uint32
+		var email string
+		if err := rows.Scan(&id, &age, &email); err != nil {
+			t.Fatalf("Scan: %v", err)
+		}
+		got = append(got, id)
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatalf("Rows.Err: %v", err)
+	}
+	if want := []uint32{4, 2, 3, 1}; !reflect.DeepEqual(got, want) {
+		t.Errorf("ids = %v; want %v", got, want)
+	}
+	if err := rows.Scan(new(any), new(any), new(any)); err == nil {
+		t.Error("Scan after the last row succeeded; want an error")
+	}
+	if _, err := db.Query("select * from users order by height"); err == nil {
+		t.Error("order by an unknown column succeeded; want an error")
+	}
+}
+
+// TestDB_QueryServesRepeatsFromCache repeats a select and checks it is kept
+// in the table's query cache, and that an insert, an update and a delete
+// each make the next select see the change.
+func TestDB_QueryServesRepeatsFromCache(t *testing.T) {
+	db, err := OpenDB(filepath.Join(t.TempDir(), "db.db"))
+	if err != nil {
+		t.Fatalf("OpenDB: %v", err)
+	}
+	defer db.Close()
+
+	if _, err := db.Exec("create table pets (id int primary key, name text(8))"); err != nil {
+		t.Fatalf("create table: %v", err)
+	}
+	names := func() []string {
+		rows, err := db.Query("select * from pets order by name asc")
+		if err != nil {
+			t.Fatalf("Query: %v", err)
+		}
+		var got []string
+		for rows.Next() {
+			var id int
+			var name string
+			if err := rows.Scan(&id, &name); err != nil {
+				t.Fatalf("Scan: %v", err)
+			}
+			got = append(got, name)
+		}
+		return got
+	}
+	cache := db.cat.tables["pets"].cache
+	for _, tc := range []struct {
+		sql  string
+		want []string
+	}{
+		{"insert into pets (id, name) values (1, 'rex')", []string{"rex"}},
+		{"insert into pets (id, name) values (2, 'ada')", []string{"ada", "rex"}},
+		{"update pets set name = 'zed' where id = 2", []string{"rex", "zed"}},
+		{"delete from pets where id = 1", []string{"zed"}},
+	} {
+		if _, err := db.Exec(tc.sql); err != nil {
+			t.Fatalf("Exec(%q): %v", tc.sql, err)
+		}
+		if got := names(); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("after %q: names = %q; want %q", tc.sql, got, tc.want)
+		}
+		if cache.Len() != 1 {
+			t.Errorf("after %q: cache holds %d results; want 1", tc.sql, cache.Len())
+		}
+		if got := names(); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("after %q: repeated names = %q; want %q", tc.sql, got, tc.want)
+		}
+	}
+}
+
+// TestDB_QueryCount checks select count(*) against the number of rows a
+// select * returns, for the whole table, a key range, an empty range and a
+// single key.
+func TestDB_QueryCount(t *testing.T) {
+	db, err := OpenDB(filepath.Join(t.TempDir(), "db.db"))
+	if err != nil {
+		t.Fatalf("OpenDB: %v", err)
+	}
+	defer db.Close()
+
+	if _, err := db.Exec("create table nums (id int primary key, v int)"); err != nil {
+		t.Fatalf("create table: %v", err)
+	}
+	for i := 1; i <= 60; i += 2 {
+		if _, err := db.Exec(fmt.Sprintf("insert into nums (id, v) values (%d, %d)", i, i*10)); err != nil {
+			t.Fatalf("insert %d: %v", i, err)
+		}
+	}
+	count := func(sql string) int {
+		rows, err := db.Query(sql)
+		if err != nil {
+			t.Fatalf("Query(%q): %v", sql, err)
+		}
+		if cols := rows.Columns(); !reflect.DeepEqual(cols, []string{"count(*)"}) {
+			t.Errorf("Columns = %q; want [count(*)]", cols)
+		}
+		var n int
+		if !rows.Next() || rows.Scan(&n) != nil || rows.Next() {
+			t.Fatalf("Query(%q) did not return exactly one count", sql)
+		}
+		return n
+	}
+	for _, tc := range []struct {
+		where string
+		want  int
+	}{
+		{"", 30},
+		{" where id between 10 and 20", 5},
+		{" where id between 0 and 4294967295", 30},
+		{" where id between 20 and 10", 0},
+		{" where id between 61 and 99", 0},
+		{" where id = 7", 1},
+		{" where id = 8", 0},
+	} {
+		if got := count("select count(*) from nums" + tc.where); got != tc.want {
+			t.Errorf("count(*)%s = %d; want %d", tc.where, got, tc.want)
+		}
+	}
+
+	rows, err := db.Query("select * from nums")
+	if err != nil {
+		t.Fatalf("Query: %v", err)
+	}
+	scanned := 0
+	for rows.Next() {
+		scanned++
+	}
+	if all := count("select count(*) from nums"); all != scanned {
+		t.Errorf("count(*) = %d; select * returned %d rows", all, scanned)
+	}
+
+	for _, sql := range []string{
+		"select count(*) from nobody",
+		"select count(*) from nums where v between 1 and 2",
+		"select count(*) from nums where id between 1",
+		"select count(*) from nums where id between a and 2",
+	} {
+		if _, err := db.Query(sql); err == nil {
+			t.Errorf("Query(%q) succeeded; want an error", sql)
+		}
+	}
+}
+
+// TestDB_FailedUpdateLeavesRow runs an update whose second assignment fails
+// and checks the first one did not reach the stored row.
+func TestDB_FailedUpdateLeavesRow(t *testing.T) {
+	db, err := OpenDB(filepath.Join(t.TempDir(), "db.db"))
+	if err != nil {
+		t.Fatalf("OpenDB: %v", err)
+	}
+	defer db.Close()
+	if _, err := db.Exec("create table users (id int primary key, age int, name text(8))"); err != nil {
+		t.Fatalf("create table: %v", err)
+	}
+	if _, err := db.Exec("insert into users (id, age, name) values (1, 30, 'ann')"); err != nil {
+		t.Fatalf("insert: %v", err)
+	}
+	if _, err := db.Exec("update users set age = 99, name = 5 where id = 1"); err == nil {
+		t.Fatal("update with an unquoted TEXT value succeeded")
+	}
+	rows, err := db.Query("select * from users where id = 1")
+	if err != nil {
+		t.Fatalf("Query: %v", err)
+	}
+	var id, age uint32
+	var name string
+	if !rows.Next() || rows.Scan(&id, &age, &name) != nil {
+		t.Fatal("row 1 missing after the failed update")
+	}
+	if age != 30 || name != "ann" {
+		t.Errorf("row after failed update = (%d, %d, %s); want (1, 30, ann)", id, age, name)
+	}
+}
+
+// TestCatalog_TablesSurviveReopen creates a table keyed on a column other
+// than the first, closes the database and checks the reopened catalog knows
+// the table and its key: inserts land in it and selects read them back.
+func TestCatalog_TablesSurviveReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "repl.db")
+	cat, err := openCatalog(path)
+	if err != nil {
+		t.Fatalf("openCatalog: %v", err)
+	}
+	replCatalog = cat
+	defer func() { replCatalog = nil }()
+	executeInput(io.Discard, "create table pets (name text(16), id int primary key);"+
+		"insert into pets (id, name) values (7, 'rex')")
+	if err := cat.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	if cat, err = openCatalog(path); err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	defer cat.Close()
+	replCatalog = cat
+	tbl := lookupTable("pets")
+	if tbl == nil {
+		t.Fatal("table pets not reloaded")
+	}
+	if tbl.key != 1 || tbl.schema[0].Name != "name" || tbl.schema[1].Name != "id" {
+		t.Errorf("reloaded pets has key %d and schema %v; want key 1 on (name, id)", tbl.key, tbl.schema)
+	}
+	var buf bytes.Buffer
+	executeInput(&buf, "insert into pets (id, name) values (2, 'tom'); select * from pets")
+	if got, want := buf.String(), "Executed.\n(tom, 2)\n(rex, 7)\nExecuted.\n"; got != want {
+		t.Errorf("output after reopen = %q; want %q", got, want)
+	}
+	buf.Reset()
+	executeInput(&buf, "create table pets (id int); create table _rowid (id int)")
+	if got := buf.String(); !strings.Contains(got, `table "pets" already exists`) || !strings.Contains(got, "reserved") {
+		t.Errorf("create table of taken or reserved names output = %q", got)
+	}
+}
@@ -1,6 +1,8 @@
 package pager
 
 import (
+	"crypto/cipher"
+	"errors"
 	"fmt"
 	"io"
 	"os"
@@ -11,6 +13,17 @@ const (
 	PageSize      = 4096
 )
 
+// PageUninitialized is the first byte of a page handed out by AllocatePage
+// while MarkNew is set, until its user writes real content over it.
+const PageUninitialized = 0xFF
+
+// ErrNoMorePages is returned by AllocatePage once MaxPages is reached.
+var ErrNoMorePages = errors.New("no more pages")
+
+// ErrDatabaseLocked is returned by OpenPager when another pager already has
+// the file open for writing.
+var ErrDatabaseLocked = errors.New("database is locked")
+
 type Page struct {
 	Data        [PageSize]byte
 	writeOffset uint32
@@ -23,6 +36,11 @@ type Pager struct {
 	File     *os.File
 	Pages    []*Page
 	NumPages int
+	MaxPages int  // capacity limit, TableMaxPages unless lowered by the caller
+	MarkNew  bool // start allocated pages with PageUninitialized instead of zero
+	Reads    int  // pages read from the file so far; cache hits are not counted
+
+	aead cipher.AEAD // set by OpenPagerWithKey; pages are stored encrypted
 }
 
 func (p *Pager) FileSize() (int64, error) {
@@ -34,23 +52,37 @@ func (p *Pager) FileSize() (int64, error) {
 }
 
 // OpenPager opens the file, computes how many pages it currently has,
-// and allocates the slice — _without_ reading every page.
+// and allocates the slice — _without_ reading every page. The file is locked
+// against other writers until Close; if one already holds it, OpenPager
+// returns ErrDatabaseLocked.
 func OpenPager(path string) (*Pager, error) {
+	return openPager(path, nil)
+}
+
+func openPager(path string, aead cipher.AEAD) (*Pager, error) {
 	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0600)
 	if err != nil {
 		return nil, err
 	}
+	if err := lockFile(f); err != nil {
+		f.Close()
+		return nil, err
+	}
 	fi, err := f.Stat()
 	if err != nil {
+		f.Close()
 		return nil, err
 	}
 	fileSize := fi.Size()
-	numPages := int((fileSize + PageSize - 1) / PageSize)
+	stride := int64(diskPageSize(aead))
+	numPages := int((fileSize + stride - 1) / stride)
 
 	p := &Pager{
 		File:     f,
 		Pages:    make([]*Page, numPages),
 		NumPages: numPages,
+		MaxPages: TableMaxPages,
+		aead:     aead,
 	}
 	return p, nil
 }
@@ -72,7 +104,7 @@ func (p *Pager) preloadAll() error {
 
 // loadPageFromDisk handles the raw seek+read and returns a fresh Page.
 func (p *Pager) loadPageFromDisk(pageNum uint32) (*Page, error) {
-	off := int64(pageNum) * PageSize
+	off := int64(pageNum) * int64(diskPageSize(p.aead))
 	if _, err := p.File.Seek(off, io.SeekStart); err != nil {
 		return nil, fmt.Errorf("seek page %d: %w", pageNum, err)
 	}
@@ -80,21 +112,30 @@ func (p *Pager) loadPageFromDisk(pageNum uint32) (*Page, error) {
 		Pager:   p,
 		PageNum: pageNum,
 	}
+	if p.aead != nil {
+		if err := p.readEncrypted(pg); err != nil {
+			return nil, err
+		}
+		p.Reads++
+		return pg, nil
+	}
 	n, err := io.ReadFull(p.File, pg.Data[:])
 	if err != nil && err != io.ErrUnexpectedEOF {
 		return nil, fmt.Errorf("read page %d: %w", pageNum, err)
 	}
 	pg.writeOffset = uint32(n)
+	p.Reads++
 	return pg, nil
 }
 
 func (p *Pager) GetPage(pageNum uint32) (*Page, error) {
-	if pageNum >= TableMaxPages {
-		return nil, fmt.Errorf("GetPage: page %d out of bounds (max %d)", pageNum, TableMaxPages)
+	if pageNum >= uint32(p.MaxPages) {
+		return nil, fmt.Errorf("GetPage: page %d out of bounds (max %d)", pageNum, p.MaxPages)
 	}
 	if pageNum >= uint32(p.NumPages) {
 		return nil, fmt.Errorf("GetPage: page %d beyond EOF (%d pages)", pageNum, p.NumPages)
 	}
+	p.syncCache()
 	// if not yet in cache, pull it in
 	if p.Pages[pageNum] == nil {
 		pg, err := p.loadPageFromDisk(pageNum)
@@ -107,15 +148,25 @@ func (p *Pager) GetPage(pageNum uint32) (*Page, error) {
 }
 
 func (p *Pager) FlushPage(pgNo uint32) error {
+	if pgNo >= uint32(len(p.Pages)) {
+		return fmt.Errorf("FlushPage: page %d out of bounds (max %d)", pgNo, len(p.Pages))
+	}
 	pg := p.Pages[pgNo]
 	if pg == nil || !pg.Dirty {
 		return nil
 	}
-	off := int64(pgNo) * PageSize
+	off := int64(pgNo) * int64(diskPageSize(p.aead))
 	if _, err := p.File.Seek(off, io.SeekStart); err != nil {
 		return err
 	}
-	if _, err := p.File.Write(pg.Data[:]); err != nil {
+	data := pg.Data[:]
+	if p.aead != nil {
+		var err error
+		if data, err = p.encrypt(pg); err != nil {
+			return err
+		}
+	}
+	if _, err := p.File.Write(data); err != nil {
 		return err
 	}
 	pg.Dirty = false
@@ -124,33 +175,148 @@ func (p *Pager) FlushPage(pgNo uint32) error {
 
 func (p *Pager) AllocatePage() (uint32, error) {
 	np := uint32(p.NumPages)
-	if np >= TableMaxPages {
-		return 0, fmt.Errorf("no more pages")
+	if np >= uint32(p.MaxPages) {
+		return 0, ErrNoMorePages
 	}
 	pg := &Page{
 		Pager:   p,
 		PageNum: np,
 		Dirty:   true, // mark for writing
 	}
-	p.Pages = append(p.Pages, pg)
+	if p.MarkNew {
+		// a page flushed before anything was written to it stays
+		// recognizable instead of reading as all zeros
+		pg.Data[0] = PageUninitialized
+	}
 	p.NumPages++
+	p.syncCache()
+	p.Pages[np] = pg
 	return np, nil
 }
 
-func (p *Pager) FlushAll() error {
+// Evict drops page pgNo from the cache if it is cached and clean, so the
+// next GetPage reads it from the file again. It reports whether the page was
+// dropped; dirty pages stay until they are flushed.
+func (p *Pager) Evict(pgNo uint32) bool {
+	if int(pgNo) >= len(p.Pages) {
+		return false
+	}
+	pg := p.Pages[pgNo]
+	if pg == nil || pg.Dirty {
+		return false
+	}
+	p.Pages[pgNo] = nil
+	return true
+}
+
+// CachedPages returns how many pages are currently held in the cache.
+func (p *Pager) CachedPages() int {
+	n := 0
+	for _, pg := range p.Pages {
+		if pg != nil {
+			n++
+		}
+	}
+	return n
+}
+
+// DirtyPages returns, in ascending order, the numbers of the cached pages
+// marked dirty: those changed since they were last written to the file. An
+// incremental backup copies these, then calls ClearDirty.
+func (p *Pager) DirtyPages() []uint32 {
+	var dirty []uint32
+	for i, pg := range p.Pages {
+		if pg != nil && pg.Dirty {
+			dirty = append(dirty, uint32(i))
+		}
+	}
+	return dirty
+}
+
+// ClearDirty marks the given pages clean once their changes are in the
+// file: each one still dirty is written out first, so clearing never drops
+// a write. Page numbers outside the cache are ignored.
+func (p *Pager) ClearDirty(pages []uint32) error {
+	for _, pgNo := range pages {
+		if int(pgNo) >= len(p.Pages) {
+			continue
+		}
+		if err := p.FlushPage(pgNo); err != nil {
+			return fmt.Errorf("ClearDirty: page %d: %w", pgNo, err)
+		}
+	}
+	return nil
+}
+
+// CopyPage overwrites page dst with the contents of page src and marks dst
+// dirty. Both pages must already exist.
+func (p *Pager) CopyPage(src, dst uint32) error {
+	from, err := p.GetPage(src)
+	if err != nil {
+		return fmt.Errorf("CopyPage: source: %w", err)
+	}
+	to, err := p.GetPage(dst)
+	if err != nil {
+		return fmt.Errorf("CopyPage: destination: %w", err)
+	}
+	to.Data = from.Data
+	to.Dirty = true
+	return nil
+}
+
+// syncCache makes the Pages slice exactly NumPages long, keeping the pages
+// already cached, so indexing it by any valid page number cannot panic.
+func (p *Pager) syncCache() {
+	if n := p.NumPages; len(p.Pages) < n {
+		p.Pages = append(p.Pages, make([]*Page, n-len(p.Pages))...)
+	} else {
+		p.Pages = p.Pages[:n]
+	}
+}
+
+// Truncate drops every page at or beyond numPages, both from the cache and
+// from the file on disk.
+func (p *Pager) Truncate(numPages int) error {
+	if numPages < 0 || numPages > p.NumPages {
+		return fmt.Errorf("Truncate: %d pages out of range (have %d)", numPages, p.NumPages)
+	}
+	if err := p.File.Truncate(int64(numPages) * int64(diskPageSize(p.aead))); err != nil {
+		return err
+	}
+	p.Pages = p.Pages[:numPages]
+	p.NumPages = numPages
+	return nil
+}
+
+// WriteDirty writes every dirty page back to the file without fsyncing it.
+// Use FlushAll or Sync when the data must be durable.
+func (p *Pager) WriteDirty() error {
 	for i, pg := range p.Pages {
 		if pg != nil && pg.Dirty {
 			if err := p.FlushPage(uint32(i)); err != nil {
 				return err
 			}
-			pg.Dirty = false
 		}
 	}
+	return nil
+}
+
+// FlushAll writes every dirty page back to the file and fsyncs it.
+func (p *Pager) FlushAll() error {
+	if err := p.WriteDirty(); err != nil {
+		return err
+	}
 	return p.File.Sync()
 }
 
+// Sync makes every change so far durable, leaving the pager open for further
+// use. It is equivalent to FlushAll.
+func (p *Pager) Sync() error {
+	return p.FlushAll()
+}
+
 func (p *Pager) Close() error {
-	if err := p.FlushAll(); err != nil {
+	if err := p.Sync(); err != nil {
 		return err
 	}
 	return p.File.Close()
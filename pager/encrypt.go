@@ -0,0 +1,84 @@
+package pager
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Encrypted page layout on disk, one per page in place of the plain
+// PageSize bytes:
+//
+//	[ nonce (12) | AES-GCM ciphertext of the page (PageSize) | tag (16) ]
+//
+// Each write draws a fresh random nonce, and the page number is bound in as
+// additional data, so a page copied to another slot fails to authenticate.
+const encryptedPageSize = 12 + PageSize + 16
+
+// ErrPageAuth is returned when an encrypted page fails authentication: the
+// key is wrong or the page was changed on disk.
+var ErrPageAuth = errors.New("page failed authentication")
+
+// OpenPagerWithKey opens the file at path like OpenPager, with every page
+// encrypted with AES-GCM under key, which must be 16, 24 or 32 bytes long.
+// Pages are encrypted as they are flushed and decrypted as they are read; a
+// file written under another key, or without encryption, fails with
+// ErrPageAuth on its first read.
+func OpenPagerWithKey(path string, key []byte) (*Pager, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("OpenPagerWithKey: %w", err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("OpenPagerWithKey: %w", err)
+	}
+	return openPager(path, aead)
+}
+
+// diskPageSize returns how many bytes one page takes in the file.
+func diskPageSize(aead cipher.AEAD) int {
+	if aead == nil {
+		return PageSize
+	}
+	return encryptedPageSize
+}
+
+// pageAD returns the additional data authenticated with page pgNo.
+func pageAD(pgNo uint32) []byte {
+	return binary.LittleEndian.AppendUint32(nil, pgNo)
+}
+
+// encrypt returns the on-disk form of pg.
+func (p *Pager) encrypt(pg *Page) ([]byte, error) {
+	nonce := make([]byte, p.aead.NonceSize(), encryptedPageSize)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("encrypt page %d: %w", pg.PageNum, err)
+	}
+	return p.aead.Seal(nonce, nonce, pg.Data[:], pageAD(pg.PageNum)), nil
+}
+
+// readEncrypted reads and decrypts page pg.PageNum from the current file
+// offset into pg. Nothing at all at the offset reads as a zero page, as it
+// does unencrypted.
+func (p *Pager) readEncrypted(pg *Page) error {
+	buf := make([]byte, encryptedPageSize)
+	n, err := io.ReadFull(p.File, buf)
+	if n == 0 && (err == io.EOF || err == io.ErrUnexpectedEOF) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("read page %d: %w", pg.PageNum, err)
+	}
+	ns := p.aead.NonceSize()
+	plain, err := p.aead.Open(pg.Data[:0], buf[:ns], buf[ns:], pageAD(pg.PageNum))
+	if err != nil {
+		return fmt.Errorf("read page %d: %w", pg.PageNum, ErrPageAuth)
+	}
+	pg.writeOffset = uint32(len(plain))
+	return nil
+}
@@ -1,8 +1,11 @@
 package pager
 
 import (
+	"bytes"
+	"errors"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 )
 
@@ -56,6 +59,37 @@ func TestGetPageOutOfBounds(t *testing.T) {
 	}
 }
 
+// Test that FlushPage reports page numbers past the cache instead of
+// panicking, and treats an uncached page as nothing to flush.
+func TestFlushPageOutOfBounds(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "flush_oob.db")
+	p, err := OpenPager(path)
+	if err != nil {
+		t.Fatalf("OpenPager: %v", err)
+	}
+	defer p.Close()
+
+	for _, pgNo := range []uint32{uint32(len(p.Pages)), uint32(len(p.Pages)) + 7, ^uint32(0)} {
+		if err := p.FlushPage(pgNo); err == nil || !strings.Contains(err.Error(), "out of bounds") {
+			t.Errorf("FlushPage(%d) err = %v; want out of bounds", pgNo, err)
+		}
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := p.AllocatePage(); err != nil {
+			t.Fatalf("AllocatePage: %v", err)
+		}
+	}
+	if err := p.FlushPage(1); err != nil {
+		t.Fatalf("FlushPage(1): %v", err)
+	}
+	if !p.Evict(1) {
+		t.Fatal("Evict(1) = false; want the clean page dropped")
+	}
+	if err := p.FlushPage(1); err != nil {
+		t.Errorf("FlushPage of an uncached page = %v; want nil", err)
+	}
+}
+
 // Test AllocatePage, modifying, flushing, and verifying on-disk content.
 func TestAllocateAndFlushPage(t *testing.T) {
 	tmp, err := os.CreateTemp("", "pager_test_alloc_*.db")
@@ -245,3 +279,413 @@ func TestGetPageAfterAllocate(t *testing.T) {
 		t.Errorf("GetPage returned a different page instance")
 	}
 }
+
+// Test that Truncate drops trailing pages from the cache and the file.
+func TestTruncate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "truncate.db")
+
+	p, err := OpenPager(path)
+	if err != nil {
+		t.Fatalf("OpenPager: %v", err)
+	}
+	defer p.Close()
+
+	for i := 0; i < 3; i++ {
+		if _, err := p.AllocatePage(); err != nil {
+			t.Fatalf("AllocatePage: %v", err)
+		}
+	}
+	if err := p.FlushAll(); err != nil {
+		t.Fatalf("FlushAll: %v", err)
+	}
+
+	if err := p.Truncate(1); err != nil {
+		t.Fatalf("Truncate: %v", err)
+	}
+	if p.NumPages != 1 || len(p.Pages) != 1 {
+		t.Errorf("NumPages=%d len(Pages)=%d; want 1", p.NumPages, len(p.Pages))
+	}
+	size, _ := p.FileSize()
+	if size != PageSize {
+		t.Errorf("file size = %d; want %d", size, PageSize)
+	}
+
+	if err := p.Truncate(5); err == nil {
+		t.Errorf("expected error truncating beyond NumPages")
+	}
+}
+
+// Test that AllocatePage stops at MaxPages with ErrNoMorePages.
+func TestAllocatePageMaxPages(t *testing.T) {
+	p, err := OpenPager(filepath.Join(t.TempDir(), "max.db"))
+	if err != nil {
+		t.Fatalf("OpenPager: %v", err)
+	}
+	defer p.Close()
+
+	p.MaxPages = 2
+	for i := 0; i < 2; i++ {
+		if _, err := p.AllocatePage(); err != nil {
+			t.Fatalf("AllocatePage %d: %v", i, err)
+		}
+	}
+	if _, err := p.AllocatePage(); err != ErrNoMorePages {
+		t.Errorf("AllocatePage beyond MaxPages err = %v; want ErrNoMorePages", err)
+	}
+}
+
+// Test that Sync makes data visible to a second handle while the pager
+// stays usable.
+func TestSync(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sync.db")
+
+	p, err := OpenPager(path)
+	if err != nil {
+		t.Fatalf("OpenPager: %v", err)
+	}
+	defer p.Close()
+
+	pgNum, _ := p.AllocatePage()
+	p.Pages[pgNum].Data[0] = 0x42
+	if err := p.Sync(); err != nil {
+		t.Fatalf("Sync: %v", err)
+	}
+	if p.Pages[pgNum].Dirty {
+		t.Errorf("page still dirty after Sync")
+	}
+
+	ro, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("Open read-only: %v", err)
+	}
+	defer ro.Close()
+	buf := make([]byte, PageSize)
+	if _, err := ro.ReadAt(buf, 0); err != nil {
+		t.Fatalf("ReadAt: %v", err)
+	}
+	if buf[0] != 0x42 {
+		t.Errorf("second handle read 0x%X; want 0x42", buf[0])
+	}
+
+	// the pager keeps working after Sync
+	pgNum, err = p.AllocatePage()
+	if err != nil {
+		t.Fatalf("AllocatePage after Sync: %v", err)
+	}
+	p.Pages[pgNum].Data[0] = 0x43
+	if err := p.Sync(); err != nil {
+		t.Fatalf("second Sync: %v", err)
+	}
+	if _, err := ro.ReadAt(buf, PageSize); err != nil {
+		t.Fatalf("ReadAt page 1: %v", err)
+	}
+	if buf[0] != 0x43 {
+		t.Errorf("second handle read page 1 as 0x%X; want 0x43", buf[0])
+	}
+}
+
+// Test that WriteDirty writes dirty pages out and marks them clean, so the
+// write-through path sees the data without paying for an fsync.
+func TestWriteDirty(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "writedirty.db")
+
+	p, err := OpenPager(path)
+	if err != nil {
+		t.Fatalf("OpenPager: %v", err)
+	}
+	defer p.Close()
+
+	pgNum, _ := p.AllocatePage()
+	p.Pages[pgNum].Data[0] = 0x5A
+	if err := p.WriteDirty(); err != nil {
+		t.Fatalf("WriteDirty: %v", err)
+	}
+	if d := p.DirtyPages(); len(d) != 0 {
+		t.Errorf("DirtyPages after WriteDirty = %v; want none", d)
+	}
+
+	buf := make([]byte, PageSize)
+	if _, err := p.File.ReadAt(buf, 0); err != nil {
+		t.Fatalf("ReadAt: %v", err)
+	}
+	if buf[0] != 0x5A {
+		t.Errorf("file holds 0x%X; want 0x5A", buf[0])
+	}
+}
+
+// TestOpenPagerLocked checks that a second read-write open of the same file
+// fails until the first pager is closed.
+func TestOpenPagerLocked(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "locked.db")
+
+	p, err := OpenPager(path)
+	if err != nil {
+		t.Fatalf("OpenPager: %v", err)
+	}
+	if _, err := OpenPager(path); !errors.Is(err, ErrDatabaseLocked) {
+		t.Fatalf("second OpenPager err = %v; want ErrDatabaseLocked", err)
+	}
+	if err := p.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	p2, err := OpenPager(path)
+	if err != nil {
+		t.Fatalf("OpenPager after Close: %v", err)
+	}
+	p2.Close()
+}
+
+// TestRecoverDatabase_TruncatesPartialPage appends half a page to a file of
+// two pages and checks recovery cuts it off without touching the whole pages.
+func TestRecoverDatabase_TruncatesPartialPage(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "crash.db")
+	p, err := OpenPager(path)
+	if err != nil {
+		t.Fatalf("OpenPager: %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		pgno, _ := p.AllocatePage()
+		p.Pages[pgno].Data[0] = byte(i + 1)
+	}
+	if err := p.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	f, _ := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0600)
+	f.Write(make([]byte, PageSize/2))
+	f.Close()
+
+	report, err := RecoverDatabase(path)
+	if err != nil {
+		t.Fatalf("RecoverDatabase: %v", err)
+	}
+	if report.TruncatedBytes != PageSize/2 {
+		t.Errorf("TruncatedBytes = %d; want %d", report.TruncatedBytes, PageSize/2)
+	}
+	fi, _ := os.Stat(path)
+	if fi.Size() != 2*PageSize {
+		t.Fatalf("size after recovery = %d; want %d", fi.Size(), 2*PageSize)
+	}
+
+	p, err = OpenPager(path)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	defer p.Close()
+	for i := uint32(0); i < 2; i++ {
+		pg, err := p.GetPage(i)
+		if err != nil {
+			t.Fatalf("GetPage(%d): %v", i, err)
+		}
+		if pg.Data[0] != byte(i+1) {
+			t.Errorf("page %d first byte = %d; want %d", i, pg.Data[0], i+1)
+		}
+	}
+}
+
+// Test GetPage right at and just past the end of a reopened file, including
+// after the Pages slice has fallen out of step with NumPages.
+func TestGetPageAtEndOfReopenedFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "reopen.db")
+	p, err := OpenPager(path)
+	if err != nil {
+		t.Fatalf("OpenPager: %v", err)
+	}
+	for i := 0; i < 3; i++ {
+		n, err := p.AllocatePage()
+		if err != nil {
+			t.Fatalf("AllocatePage: %v", err)
+		}
+		p.Pages[n].Data[0] = byte(0xA0 + n)
+	}
+	if err := p.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	p, err = OpenPager(path)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	defer p.Close()
+	last := uint32(p.NumPages - 1)
+	pg, err := p.GetPage(last)
+	if err != nil {
+		t.Fatalf("GetPage(%d): %v", last, err)
+	}
+	if pg.Data[0] != 0xA2 {
+		t.Errorf("GetPage(%d) first byte = 0x%X; want 0xA2", last, pg.Data[0])
+	}
+	if _, err := p.GetPage(last + 1); err == nil {
+		t.Errorf("GetPage(%d) past EOF succeeded", last+1)
+	}
+
+	// a Pages slice shorter than NumPages is grown rather than indexed past
+	p.Pages = p.Pages[:1]
+	pg, err = p.GetPage(last)
+	if err != nil || pg.Data[0] != 0xA2 {
+		t.Fatalf("GetPage(%d) with short cache = %v; want page 0xA2", last, err)
+	}
+	n, err := p.AllocatePage()
+	if err != nil || n != last+1 || p.Pages[n] == nil {
+		t.Errorf("AllocatePage = %d, %v; want %d with a cached page", n, err, last+1)
+	}
+}
+
+// Test that CopyPage duplicates a written page into a fresh one, that the
+// copy survives a flush and reopen, and that out-of-range pages are rejected.
+func TestCopyPage(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "copy.db")
+	p, err := OpenPager(path)
+	if err != nil {
+		t.Fatalf("OpenPager: %v", err)
+	}
+	src, _ := p.AllocatePage()
+	pg, _ := p.GetPage(src)
+	for i := range pg.Data {
+		pg.Data[i] = byte(i * 7)
+	}
+	if err := p.FlushAll(); err != nil {
+		t.Fatalf("FlushAll: %v", err)
+	}
+	dst, _ := p.AllocatePage()
+	if err := p.CopyPage(src, dst); err != nil {
+		t.Fatalf("CopyPage: %v", err)
+	}
+	if err := p.CopyPage(src, 5); err == nil {
+		t.Error("CopyPage to a page beyond EOF succeeded")
+	}
+	if err := p.CopyPage(9, dst); err == nil {
+		t.Error("CopyPage from a page beyond EOF succeeded")
+	}
+	if err := p.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	p, err = OpenPager(path)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	defer p.Close()
+	a, _ := p.GetPage(src)
+	b, err := p.GetPage(dst)
+	if err != nil {
+		t.Fatalf("GetPage(%d): %v", dst, err)
+	}
+	if a.Data != b.Data {
+		t.Error("copied page differs from its source after reload")
+	}
+}
+
+// Test that DirtyPages lists exactly the pages changed since the last flush
+// and that ClearDirty cleans them without losing their contents.
+func TestDirtyPages(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dirty.db")
+	p, err := OpenPager(path)
+	if err != nil {
+		t.Fatalf("OpenPager: %v", err)
+	}
+	for i := 0; i < 5; i++ {
+		if _, err := p.AllocatePage(); err != nil {
+			t.Fatalf("AllocatePage: %v", err)
+		}
+	}
+	if err := p.FlushAll(); err != nil {
+		t.Fatalf("FlushAll: %v", err)
+	}
+	if d := p.DirtyPages(); len(d) != 0 {
+		t.Fatalf("DirtyPages after flush = %v; want none", d)
+	}
+
+	for _, n := range []uint32{3, 1} {
+		pg, _ := p.GetPage(n)
+		pg.Data[0] = byte(40 + n)
+		pg.Dirty = true
+	}
+	dirty := p.DirtyPages()
+	if len(dirty) != 2 || dirty[0] != 1 || dirty[1] != 3 {
+		t.Fatalf("DirtyPages = %v; want [1 3]", dirty)
+	}
+	if err := p.ClearDirty(dirty); err != nil {
+		t.Fatalf("ClearDirty: %v", err)
+	}
+	if d := p.DirtyPages(); len(d) != 0 {
+		t.Errorf("DirtyPages after ClearDirty = %v; want none", d)
+	}
+	// drop the cache so the pages are read back from the file
+	p.Pages = make([]*Page, p.NumPages)
+	for _, n := range []uint32{1, 3} {
+		pg, _ := p.GetPage(n)
+		if pg.Data[0] != byte(40+n) {
+			t.Errorf("page %d byte 0 = %d after ClearDirty; want %d", n, pg.Data[0], 40+n)
+		}
+	}
+	p.Close()
+}
+
+// Test that pages written under a key read back under the same key, that
+// the file does not hold them in the clear, and that a wrong key or no key
+// fails with ErrPageAuth instead of returning garbage.
+func TestEncryptedPages(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "enc.db")
+	key := bytes.Repeat([]byte{7}, 32)
+	secret := []byte("attack at dawn")
+
+	p, err := OpenPagerWithKey(path, key)
+	if err != nil {
+		t.Fatalf("OpenPagerWithKey: %v", err)
+	}
+	for i := 0; i < 3; i++ {
+		n, err := p.AllocatePage()
+		if err != nil {
+			t.Fatalf("AllocatePage: %v", err)
+		}
+		pg, _ := p.GetPage(n)
+		copy(pg.Data[:], secret)
+		pg.Data[PageSize-1] = byte(n)
+	}
+	if err := p.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("ReadFile: %v", err)
+	}
+	if len(raw) != 3*encryptedPageSize {
+		t.Errorf("file is %d bytes; want %d", len(raw), 3*encryptedPageSize)
+	}
+	if bytes.Contains(raw, secret) {
+		t.Error("file holds page contents in the clear")
+	}
+
+	p, err = OpenPagerWithKey(path, key)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	if p.NumPages != 3 {
+		t.Errorf("NumPages = %d; want 3", p.NumPages)
+	}
+	for n := uint32(0); n < 3; n++ {
+		pg, err := p.GetPage(n)
+		if err != nil {
+			t.Fatalf("GetPage(%d): %v", n, err)
+		}
+		if !bytes.HasPrefix(pg.Data[:], secret) || pg.Data[PageSize-1] != byte(n) {
+			t.Errorf("page %d did not decrypt to what was written", n)
+		}
+	}
+	p.Close()
+
+	wrong, err := OpenPagerWithKey(path, bytes.Repeat([]byte{8}, 32))
+	if err != nil {
+		t.Fatalf("OpenPagerWithKey with another key: %v", err)
+	}
+	if _, err := wrong.GetPage(0); !errors.Is(err, ErrPageAuth) {
+		t.Errorf("GetPage under the wrong key err = %v; want ErrPageAuth", err)
+	}
+	wrong.Close()
+
+	if _, err := OpenPagerWithKey(path, []byte("short")); err == nil {
+		t.Error("OpenPagerWithKey with a 5-byte key succeeded; want an error")
+	}
+}
@@ -0,0 +1,51 @@
+package pager
+
+import (
+	"fmt"
+	"os"
+)
+
+// RecoveryReport describes what RecoverDatabase changed in the file.
+type RecoveryReport struct {
+	// TruncatedBytes is the size of the trailing partial page cut off, or 0
+	// if the file ended on a page boundary.
+	TruncatedBytes int64
+}
+
+// RecoverDatabase repairs the file at path after a crash by cutting off a
+// trailing partial page, which an interrupted write can leave behind. Whole
+// pages are kept as they are and their contents are not checked; see
+// table.RecoverDatabase for a repair that also validates the tree. The file
+// must not be open by another pager.
+func RecoverDatabase(path string) (RecoveryReport, error) {
+	return recoverDatabase(path, PageSize)
+}
+
+// RecoverEncryptedDatabase is RecoverDatabase for a file written through
+// OpenPagerWithKey, whose pages are larger on disk. No key is needed.
+func RecoverEncryptedDatabase(path string) (RecoveryReport, error) {
+	return recoverDatabase(path, encryptedPageSize)
+}
+
+func recoverDatabase(path string, pageSize int64) (RecoveryReport, error) {
+	var report RecoveryReport
+	f, err := os.OpenFile(path, os.O_RDWR, 0600)
+	if err != nil {
+		return report, err
+	}
+	defer f.Close()
+	if err := lockFile(f); err != nil {
+		return report, err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		return report, err
+	}
+	if partial := fi.Size() % pageSize; partial != 0 {
+		if err := f.Truncate(fi.Size() - partial); err != nil {
+			return report, fmt.Errorf("RecoverDatabase: truncate partial page: %w", err)
+		}
+		report.TruncatedBytes = partial
+	}
+	return report, f.Sync()
+}
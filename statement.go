@@ -1,6 +1,7 @@
 package main
 
 import (
+	"vqlite/column"
 	"vqlite/table"
 )
 
@@ -9,9 +10,17 @@ type StatementType int
 const (
 	StatementInsert StatementType = iota
 	StatementSelect
+	StatementCreateTable
 )
 
 type Statement struct {
 	Type        StatementType
 	RowToInsert table.Row
+	WhereIn     []uint32 // keys of a select ... where <key> in (...), nil for none
+
+	// TableName names the catalog table of a create table, an insert into
+	// or a select * from; empty for statements on the REPL's own table.
+	TableName string
+	Schema    column.Schema // of a create table
+	KeyColumn int           // of a create table
 }
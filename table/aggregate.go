@@ -0,0 +1,28 @@
+package table
+
+import (
+	"fmt"
+	"slices"
+
+	"vqlite/column"
+)
+
+// CountDistinct returns the number of distinct values in the named column,
+// as for select count(distinct name). It scans every row; an empty table
+// counts 0.
+func (t *BTree) CountDistinct(name string) (int, error) {
+	cols := t.bTreeMeta.TableMeta.Columns
+	i := slices.IndexFunc(cols, func(c column.Column) bool { return c.Name == name })
+	if i < 0 {
+		return 0, fmt.Errorf("CountDistinct: no column %q", name)
+	}
+	seen := make(map[any]struct{})
+	err := t.ForEach(func(_ uint32, row Row) error {
+		seen[row[i]] = struct{}{}
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("CountDistinct: %w", err)
+	}
+	return len(seen), nil
+}
@@ -0,0 +1,166 @@
+package table
+
+import (
+	"context"
+	"fmt"
+
+	"vqlite/column"
+)
+
+// AddColumn appends col to the end of the table's schema. Every row is
+// rewritten to the wider layout with the new column set to its type's zero
+// value, keeping its key, and the tree is rebuilt in place. The tree's
+// TableMeta is updated in place, so callers sharing it see the new layout.
+func (t *BTree) AddColumn(col column.Column) error {
+	tblMeta := t.bTreeMeta.TableMeta
+	for _, c := range tblMeta.Columns {
+		if c.Name == col.Name {
+			return fmt.Errorf("AddColumn: column %q already exists", col.Name)
+		}
+	}
+	schema := append(column.Schema{}, tblMeta.Columns...)
+	schema = append(schema, column.Column{Name: col.Name, Type: col.Type, MaxLength: col.MaxLength})
+	newMeta, err := BuildTableMeta(schema)
+	if err != nil {
+		return fmt.Errorf("AddColumn: %w", err)
+	}
+
+	data, err := t.allPairs(context.Background())
+	if err != nil {
+		return fmt.Errorf("AddColumn: %w", err)
+	}
+	def := zeroValue(col.Type)
+	for i := range data {
+		data[i].Row = append(data[i].Row, def)
+	}
+	newMeta.TTLColumn = tblMeta.TTLColumn
+
+	if err := t.rebuildAs(newMeta, t.keyColumn, data); err != nil {
+		return fmt.Errorf("AddColumn: %w", err)
+	}
+	return nil
+}
+
+// DropColumn removes the named column from the table's schema, rewriting
+// every row without it and recomputing the remaining offsets and RowSize. The
+// key column, the one KeyColumn reports, cannot be dropped; dropping a
+// column before it moves it down one.
+func (t *BTree) DropColumn(name string) error {
+	tblMeta := t.bTreeMeta.TableMeta
+	idx := -1
+	for i, c := range tblMeta.Columns {
+		if c.Name == name {
+			idx = i
+			break
+		}
+	}
+	if idx < 0 {
+		return fmt.Errorf("DropColumn: no column named %q", name)
+	}
+	if idx == t.keyColumn {
+		return fmt.Errorf("DropColumn: cannot drop key column %q", name)
+	}
+
+	var schema column.Schema
+	for i, c := range tblMeta.Columns {
+		if i != idx {
+			schema = append(schema, column.Column{Name: c.Name, Type: c.Type, MaxLength: c.MaxLength})
+		}
+	}
+	newMeta, err := BuildTableMeta(schema)
+	if err != nil {
+		return fmt.Errorf("DropColumn: %w", err)
+	}
+
+	data, err := t.allPairs(context.Background())
+	if err != nil {
+		return fmt.Errorf("DropColumn: %w", err)
+	}
+	for i := range data {
+		data[i].Row = append(data[i].Row[:idx:idx], data[i].Row[idx+1:]...)
+	}
+	if tblMeta.TTLColumn != name {
+		newMeta.TTLColumn = tblMeta.TTLColumn
+	}
+
+	key := t.keyColumn
+	if idx < key {
+		key--
+	}
+	if err := t.rebuildAs(newMeta, key, data); err != nil {
+		return fmt.Errorf("DropColumn: %w", err)
+	}
+	return nil
+}
+
+// rebuildAs rebuilds the tree from data laid out as meta and makes meta the
+// tree's layout, with key as its key column. Every row is serialized, the pages the new tree needs are
+// counted and an index's directory entry is checked to fit before anything
+// changes, so the usual failures leave the tree and its TableMeta as they
+// were; should the rebuild fail anyway, the old TableMeta and key column are
+// put back.
+func (t *BTree) rebuildAs(meta *TableMeta, key int, data []KeyRowPair) error {
+	buf := make([]byte, meta.RowSize)
+	for _, p := range data {
+		if err := SerializeRow(meta, p.Row, buf); err != nil {
+			return fmt.Errorf("key %d: %w", p.Key, err)
+		}
+	}
+	pages, err := t.nodePages()
+	if err != nil {
+		return err
+	}
+	p := t.bTreeMeta.Pager
+	avail := len(pages) + len(t.bTreeMeta.freeList().freePages) + max(0, p.MaxPages-p.NumPages)
+	if need := bulkLoadPages(len(data), meta); need > avail {
+		return fmt.Errorf("rebuild needs %d pages, %d available: %w", need, avail, ErrOutOfPages)
+	}
+
+	if t.dirOff != 0 {
+		mp, err := p.GetPage(metaPageNum)
+		if err != nil {
+			return fmt.Errorf("get meta page: %w", err)
+		}
+		if _, _, err := t.placeDirEntry(mp.Data[:], meta.Columns, key); err != nil {
+			return err
+		}
+	}
+
+	tblMeta := t.bTreeMeta.TableMeta
+	old, oldKey := *tblMeta, t.keyColumn
+	*tblMeta, t.keyColumn = *meta, key
+	if err := t.rebuild(data); err != nil {
+		*tblMeta, t.keyColumn = old, oldKey
+		return err
+	}
+	if err := t.refreshSchema(); err != nil {
+		*tblMeta, t.keyColumn = old, oldKey
+		return err
+	}
+	return nil
+}
+
+// bulkLoadPages returns how many pages bulkLoad takes for n rows laid out
+// as meta: full leaves, then interior levels of up to maxCells+1 children.
+func bulkLoadPages(n int, meta *TableMeta) int {
+	perLeaf := meta.RowsPerPage()
+	level := max(1, (n+perLeaf-1)/perLeaf)
+	pages := level
+	for level > 1 {
+		level = (level + maxCells) / (maxCells + 1)
+		pages += level
+	}
+	return pages
+}
+
+// zeroValue returns the value a row gets for a column it was never given.
+func zeroValue(t column.ColumnType) interface{} {
+	switch t {
+	case column.ColumnTypeText:
+		return ""
+	case column.ColumnTypeInt32:
+		return int32(0)
+	default:
+		return uint32(0)
+	}
+}
@@ -0,0 +1,286 @@
+package table
+
+import (
+	"errors"
+	"os"
+	"reflect"
+	"testing"
+
+	"vqlite/column"
+	"vqlite/pager"
+)
+
+// TestAddColumn adds a TEXT column to a populated table and verifies existing
+// rows gain the default value and the new layout accepts full rows.
+func TestAddColumn(t *testing.T) {
+	tp := newTempPager(t)
+	defer tp.cleanup()
+
+	schema := column.Schema{
+		{Name: "id", Type: column.ColumnTypeInt},
+		{Name: "name", Type: column.ColumnTypeText, MaxLength: 8},
+	}
+	meta, _ := BuildTableMeta(schema)
+	bt, err := NewBTree(tp.Pager, meta)
+	if err != nil {
+		t.Fatalf("NewBTree: %v", err)
+	}
+	for i := uint32(1); i <= 40; i++ {
+		if err := bt.Insert(i, Row{i, "user"}); err != nil {
+			t.Fatalf("insert %d: %v", i, err)
+		}
+	}
+
+	if err := bt.AddColumn(column.Column{Name: "email", Type: column.ColumnTypeText, MaxLength: 16}); err != nil {
+		t.Fatalf("AddColumn: %v", err)
+	}
+	if meta.NumCols != 3 || meta.RowSize != 4+8+16 {
+		t.Fatalf("meta = %d cols, RowSize %d; want 3 cols, RowSize 28", meta.NumCols, meta.RowSize)
+	}
+	if off := meta.Columns[2].Offset; off != 12 {
+		t.Errorf("email offset = %d; want 12", off)
+	}
+
+	cur, _ := bt.NewCursor()
+	want := uint32(1)
+	for cur.Valid() {
+		if got := cur.Value(); !reflect.DeepEqual(got, Row{want, "user", ""}) {
+			t.Fatalf("row %d = %v; want [%d user ]", want, got, want)
+		}
+		want++
+		cur.Next()
+	}
+	if want != 41 {
+		t.Fatalf("scanned %d rows; want 40", want-1)
+	}
+
+	if err := bt.Insert(41, Row{uint32(41), "new", "new@x.io"}); err != nil {
+		t.Fatalf("insert after AddColumn: %v", err)
+	}
+	row, found, err := bt.Search(41)
+	if err != nil || !found {
+		t.Fatalf("Search(41) found=%v err=%v", found, err)
+	}
+	if !reflect.DeepEqual(row, Row{uint32(41), "new", "new@x.io"}) {
+		t.Errorf("row 41 = %v", row)
+	}
+
+	if err := bt.AddColumn(column.Column{Name: "name", Type: column.ColumnTypeInt}); err == nil {
+		t.Errorf("expected error adding duplicate column")
+	}
+}
+
+// TestDropColumn drops a middle column and verifies the remaining columns
+// round-trip with corrected offsets, and that the key column is protected.
+func TestDropColumn(t *testing.T) {
+	tp := newTempPager(t)
+	defer tp.cleanup()
+
+	schema := column.Schema{
+		{Name: "id", Type: column.ColumnTypeInt},
+		{Name: "username", Type: column.ColumnTypeText, MaxLength: 8},
+		{Name: "email", Type: column.ColumnTypeText, MaxLength: 16},
+		{Name: "age", Type: column.ColumnTypeInt},
+	}
+	meta, _ := BuildTableMeta(schema)
+	bt, _ := NewBTree(tp.Pager, meta)
+	for i := uint32(1); i <= 30; i++ {
+		if err := bt.Insert(i, Row{i, "user", "user@x.io", 20 + i}); err != nil {
+			t.Fatalf("insert %d: %v", i, err)
+		}
+	}
+
+	if err := bt.DropColumn("id"); err == nil {
+		t.Errorf("expected error dropping key column")
+	}
+	if err := bt.DropColumn("missing"); err == nil {
+		t.Errorf("expected error dropping unknown column")
+	}
+
+	if err := bt.DropColumn("username"); err != nil {
+		t.Fatalf("DropColumn: %v", err)
+	}
+	wantOffsets := []uint32{0, 4, 20}
+	for i, c := range meta.Columns {
+		if c.Offset != wantOffsets[i] {
+			t.Errorf("column %q offset = %d; want %d", c.Name, c.Offset, wantOffsets[i])
+		}
+	}
+	if meta.NumCols != 3 || meta.RowSize != 24 {
+		t.Errorf("meta = %d cols, RowSize %d; want 3 cols, RowSize 24", meta.NumCols, meta.RowSize)
+	}
+
+	for i := uint32(1); i <= 30; i++ {
+		row, found, err := bt.Search(i)
+		if err != nil || !found {
+			t.Fatalf("Search(%d) found=%v err=%v", i, found, err)
+		}
+		if want := (Row{i, "user@x.io", 20 + i}); !reflect.DeepEqual(row, want) {
+			t.Errorf("row %d = %v; want %v", i, row, want)
+		}
+	}
+}
+
+// TestDropColumn_KeepsTheKeyColumn creates a table keyed on its second
+// column and checks DropColumn refuses that column but drops the first one,
+// and that after a reopen the key column has moved down with it.
+func TestDropColumn_KeepsTheKeyColumn(t *testing.T) {
+	dbFile := newTempDB(t)
+	defer os.Remove(dbFile)
+	_, bt, err := OpenTable(dbFile, column.Schema{{Name: "id", Type: column.ColumnTypeInt}})
+	if err != nil {
+		t.Fatalf("OpenTable: %v", err)
+	}
+	pets, err := bt.CreateTable("pets", column.Schema{
+		{Name: "name", Type: column.ColumnTypeText, MaxLength: 8},
+		{Name: "id", Type: column.ColumnTypeInt},
+		{Name: "age", Type: column.ColumnTypeInt},
+	}, 1)
+	if err != nil {
+		t.Fatalf("CreateTable: %v", err)
+	}
+	for i := uint32(1); i <= 20; i++ {
+		if err := pets.Insert(i, Row{"rex", i, i + 1}); err != nil {
+			t.Fatalf("insert %d: %v", i, err)
+		}
+	}
+	if err := pets.DropColumn("id"); err == nil {
+		t.Error("DropColumn of the key column succeeded; want an error")
+	}
+	if err := pets.DropColumn("name"); err != nil {
+		t.Fatalf("DropColumn name: %v", err)
+	}
+	if got := pets.KeyColumn(); got != 0 {
+		t.Errorf("KeyColumn after dropping name = %d; want 0", got)
+	}
+	if err := bt.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	_, bt, err = OpenTable(dbFile, column.Schema{{Name: "id", Type: column.ColumnTypeInt}})
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	defer bt.Close()
+	ixs, err := bt.Indexes()
+	if err != nil {
+		t.Fatalf("Indexes: %v", err)
+	}
+	pets = ixs["pets"]
+	if pets == nil || pets.KeyColumn() != 0 {
+		t.Fatalf("pets after reopen = %v; want key column 0", pets)
+	}
+	if err := pets.DropColumn("id"); err == nil {
+		t.Error("DropColumn of the key column after reopen succeeded; want an error")
+	}
+	if row, found, err := pets.Search(5); err != nil || !found || !reflect.DeepEqual(row, Row{uint32(5), uint32(6)}) {
+		t.Errorf("Search(5) = %v, %v, %v; want [5 6]", row, found, err)
+	}
+}
+
+// TestAddColumn_FailureLeavesTableAsItWas caps the file at its current size
+// and adds a column wide enough to need more leaves, then checks AddColumn
+// fails with ErrOutOfPages and leaves the schema, the rows and the file
+// untouched and usable.
+func TestAddColumn_FailureLeavesTableAsItWas(t *testing.T) {
+	tp := newTempPager(t)
+	defer tp.cleanup()
+
+	meta, _ := BuildTableMeta(column.Schema{
+		{Name: "id", Type: column.ColumnTypeInt},
+		{Name: "name", Type: column.ColumnTypeText, MaxLength: 8},
+	})
+	bt, err := NewBTree(tp.Pager, meta)
+	if err != nil {
+		t.Fatalf("NewBTree: %v", err)
+	}
+	for i := uint32(1); i <= 100; i++ {
+		if err := bt.Insert(i, Row{i, "user"}); err != nil {
+			t.Fatalf("insert %d: %v", i, err)
+		}
+	}
+	tp.MaxPages = tp.NumPages
+	pages := tp.NumPages
+
+	err = bt.AddColumn(column.Column{Name: "bio", Type: column.ColumnTypeText, MaxLength: 1000})
+	if !errors.Is(err, ErrOutOfPages) {
+		t.Fatalf("AddColumn = %v; want ErrOutOfPages", err)
+	}
+	if meta.NumCols != 2 || meta.RowSize != 12 || tp.NumPages != pages {
+		t.Errorf("after failed AddColumn: %d cols, RowSize %d, %d pages; want 2, 12, %d", meta.NumCols, meta.RowSize, tp.NumPages, pages)
+	}
+	if err := bt.Validate(); err != nil {
+		t.Errorf("Validate: %v", err)
+	}
+	n := uint32(0)
+	if err := bt.ForEach(func(key uint32, row Row) error {
+		n++
+		if !reflect.DeepEqual(row, Row{n, "user"}) {
+			t.Errorf("row %d = %v; want [%d user]", key, row, n)
+		}
+		return nil
+	}); err != nil {
+		t.Fatalf("ForEach: %v", err)
+	}
+	if n != 100 {
+		t.Errorf("scanned %d rows; want 100", n)
+	}
+	if err := bt.Insert(5, Row{uint32(5), "again"}); err != nil {
+		t.Errorf("insert after failed AddColumn: %v", err)
+	}
+
+	// a column that fits is still added
+	tp.MaxPages = pager.TableMaxPages
+	if err := bt.AddColumn(column.Column{Name: "bio", Type: column.ColumnTypeText, MaxLength: 1000}); err != nil {
+		t.Fatalf("AddColumn with room: %v", err)
+	}
+	if row, _, err := bt.Search(5); err != nil || !reflect.DeepEqual(row, Row{uint32(5), "again", ""}) {
+		t.Errorf("Search(5) = %v, %v; want [5 again ]", row, err)
+	}
+}
+
+// TestDropColumn_FailureLeavesTableAsItWas drops a column from a compressed
+// table, whose leaves hold more rows than the RowsPerPage a rebuild packs,
+// in a file capped at its current size, and checks DropColumn fails with
+// ErrOutOfPages and leaves the schema and rows as they were.
+func TestDropColumn_FailureLeavesTableAsItWas(t *testing.T) {
+	tp := newTempPager(t)
+	defer tp.cleanup()
+
+	meta, _ := BuildTableMeta(column.Schema{
+		{Name: "id", Type: column.ColumnTypeInt},
+		{Name: "note", Type: column.ColumnTypeText, MaxLength: 200},
+		{Name: "extra", Type: column.ColumnTypeInt},
+	})
+	bt, err := NewBTree(tp.Pager, meta)
+	if err != nil {
+		t.Fatalf("NewBTree: %v", err)
+	}
+	bt.SetCompression(true)
+	for i := uint32(1); i <= 150; i++ {
+		if err := bt.Insert(i, Row{i, "n", i}); err != nil {
+			t.Fatalf("insert %d: %v", i, err)
+		}
+	}
+	tp.MaxPages = tp.NumPages
+
+	if err := bt.DropColumn("extra"); !errors.Is(err, ErrOutOfPages) {
+		t.Fatalf("DropColumn = %v; want ErrOutOfPages", err)
+	}
+	if meta.NumCols != 3 || meta.Columns[2].Name != "extra" {
+		t.Errorf("after failed DropColumn: %d cols, last %q; want 3, extra", meta.NumCols, meta.Columns[meta.NumCols-1].Name)
+	}
+	n := uint32(0)
+	if err := bt.ForEach(func(key uint32, row Row) error {
+		n++
+		if !reflect.DeepEqual(row, Row{n, "n", n}) {
+			t.Errorf("row %d = %v; want [%d n %d]", key, row, n, n)
+		}
+		return nil
+	}); err != nil {
+		t.Fatalf("ForEach: %v", err)
+	}
+	if n != 150 {
+		t.Errorf("scanned %d rows; want 150", n)
+	}
+}
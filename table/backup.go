@@ -0,0 +1,43 @@
+package table
+
+import (
+	"fmt"
+	"io"
+	"os"
+)
+
+// Backup writes a consistent copy of the database to dstPath. It persists
+// the free list, flushes and fsyncs every dirty page, then copies the file.
+// There is no snapshot isolation, so the tree must not be written to while
+// Backup runs; the pager's file lock keeps other processes out.
+func (t *BTree) Backup(dstPath string) (err error) {
+	if err := t.writeFreeList(); err != nil {
+		return fmt.Errorf("backup: write free list: %w", err)
+	}
+	p := t.bTreeMeta.Pager
+	if err := p.Sync(); err != nil {
+		return fmt.Errorf("backup: sync: %w", err)
+	}
+	size, err := p.FileSize()
+	if err != nil {
+		return fmt.Errorf("backup: %w", err)
+	}
+
+	dst, err := os.OpenFile(dstPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return fmt.Errorf("backup: %w", err)
+	}
+	defer func() {
+		if cerr := dst.Close(); err == nil && cerr != nil {
+			err = fmt.Errorf("backup: %w", cerr)
+		}
+	}()
+	// a section reader leaves the pager's file offset alone
+	if _, err := io.Copy(dst, io.NewSectionReader(p.File, 0, size)); err != nil {
+		return fmt.Errorf("backup: copy: %w", err)
+	}
+	if err := dst.Sync(); err != nil {
+		return fmt.Errorf("backup: %w", err)
+	}
+	return nil
+}
@@ -1,24 +1,52 @@
 package table
 
 import (
+	"cmp"
+	"context"
 	"encoding/binary"
+	"errors"
 	"fmt"
+	"slices"
 	"sort"
+	"time"
 
 	"vqlite/pager"
 )
 
+// ErrOutOfPages is returned when an insert would need to split but the pager
+// cannot allocate enough pages. The tree is left unchanged.
+var ErrOutOfPages = errors.New("out of pages")
+
+// ErrCursorStale is returned by Cursor.Next when the tree was restructured
+// (rebuilt, vacuumed or defragmented) since the cursor was positioned. Seek
+// positions the cursor afresh.
+var ErrCursorStale = errors.New("cursor is stale")
+
+// ErrPageFreed is returned when loading a node from a page on the free list,
+// which still holds whatever node was there before it was freed.
+var ErrPageFreed = errors.New("page is on the free list")
+
+// ErrUninitializedPage is returned when loading a node from a page that was
+// allocated with pager.MarkNew set but never had a node written to it.
+var ErrUninitializedPage = errors.New("page allocated but never initialized")
+
 const (
 	maxCells = 12
 
 	metaPageNum = uint32(0) // page 0 reserved for tree metadata
 	metaRootOff = 0         // little-endian uint32 root page number
+	metaRowsOff = 8         // little-endian uint32 number of rows in the tree
 )
 
 // BTree manages the overall tree: root page and table meta.
 type BTree struct {
-	rootPage  uint32     // page number of the root node
-	bTreeMeta *BTreeMeta // convenience pointer for leaf/interior creation
+	rootPage  uint32        // page number of the root node
+	bTreeMeta *BTreeMeta    // convenience pointer for leaf/interior creation
+	gen       uint64        // bumped whenever node pages are freed or moved
+	version   uint64        // bumped by every mutation of the rows, see querycache.go
+	dirOff    int           // meta page offset of an index's directory entry, 0 for the primary tree
+	keyColumn int           // column the keys come from, see KeyColumn
+	rowids    *rowIDIndexes // set by EnableRowIDs, see rowid.go
 }
 
 // Cursor enables ordered traversal of the B+Tree.
@@ -28,26 +56,63 @@ type Cursor struct {
 	page  uint32
 	idx   int
 	valid bool
+	gen   uint64 // tree.gen when the cursor was positioned
+	all   bool   // include expired rows, see ttl.go
+
+	// StreamEvict drops each leaf from the page and node caches once the
+	// cursor moves past it, if the page is clean, so a one-shot scan of a
+	// large table keeps only the leaf it is on resident.
+	StreamEvict bool
 }
 
 type BTreeMeta struct {
-	Pager     *pager.Pager // for allocating pages, pageSize, etc.
-	TableMeta *TableMeta   // schema, row sizes, max cells
+	Pager          *pager.Pager // for allocating pages, pageSize, etc.
+	TableMeta      *TableMeta   // schema, row sizes, max cells
+	Compress       bool         // write leaves flate-compressed, see compress.go
+	SplitPolicy    SplitPolicy  // where full nodes are cut, see split.go
+	WriteThrough   bool         // flush dirty pages after each Insert/Delete, see SetDeferredFlush
+	CompactText    bool         // write leaves with variable-length cells, see compact.go
+	SkipSameRow    bool         // leave the page alone when an overwrite changes nothing
+	DeleteExpired  bool         // scans delete the expired rows they skip, see ttl.go
+	Duplicates     bool         // equal keys are kept as separate cells, see SetDuplicates
+	SeparateValues bool         // write leaves with keys and rows apart, see separate.go
+	VerifyOnLoad   bool         // Load checks leaf keys are in order, see validate.go
+
+	freePages []uint32   // pages released by the tree, reused before growing the file
+	primary   *BTreeMeta // for an index tree, the primary tree's meta owning freePages
+	hooks     Hooks
+	nodes     map[uint32]BTreeNode // node cache, see nodecache.go
+	nodeLoads int                  // loadNode calls, cached or not, see explain.go
+}
+
+// Hooks are optional callbacks fired on structural changes of the tree, for
+// debugging and metrics. Nil callbacks are skipped.
+type Hooks struct {
+	OnSplit    func(page uint32, splitKey uint32) // page is the node that split
+	OnMerge    func(left, right uint32)           // right was merged into left
+	OnAllocate func(page uint32)                  // page was handed out for a node
+}
+
+// SetHooks installs the callbacks fired on splits, merges and allocations.
+func (t *BTree) SetHooks(h Hooks) {
+	t.bTreeMeta.hooks = h
 }
 
 // NewBTree opens or initializes a B+Tree.
 // If the underlying pager has no pages yet, it allocates a new root leaf page
 // and serializes an empty leaf node marked as root.
 func NewBTree(p *pager.Pager, tblMeta *TableMeta) (*BTree, error) {
+	if err := checkRowFits(tblMeta.RowSize); err != nil {
+		return nil, fmt.Errorf("NewBTree: %w", err)
+	}
+	if err := tblMeta.checkTTLColumn(); err != nil {
+		return nil, fmt.Errorf("NewBTree: %w", err)
+	}
 	btMeta := &BTreeMeta{Pager: p, TableMeta: tblMeta}
 
-	// Case 1: brand-new file – allocate meta page (0) and root leaf (1).
+	// Case 1: brand-new file – the first allocation reserves meta page (0)
+	// and hands out the root leaf (1).
 	if p.NumPages == 0 {
-		// Ensure meta page 0 exists
-		if _, err := p.AllocatePage(); err != nil { // page 0
-			return nil, err
-		}
-
 		// Create root leaf
 		leaf, err := NewLeafNode(btMeta, true)
 		if err != nil {
@@ -72,11 +137,41 @@ func NewBTree(p *pager.Pager, tblMeta *TableMeta) (*BTree, error) {
 		return nil, err
 	}
 	rootPg := binary.LittleEndian.Uint32(mp.Data[metaRootOff : metaRootOff+4])
-	return &BTree{rootPage: rootPg, bTreeMeta: btMeta}, nil
+	if rootPg == metaPageNum || int(rootPg) >= p.NumPages {
+		return nil, fmt.Errorf("NewBTree: root page %d invalid for a file of %d pages", rootPg, p.NumPages)
+	}
+	t := &BTree{rootPage: rootPg, bTreeMeta: btMeta}
+	if err := t.readFreeList(); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// Search looks key up and returns its row; found is false if the key is not
+// in the tree, including when the tree has no rows at all.
+func (t *BTree) Search(key uint32) (Row, bool, error) {
+	c := &Cursor{tree: t}
+	if t.bTreeMeta.Duplicates {
+		// the first of a run of equal keys
+		if err := c.Seek(key); err != nil {
+			return nil, false, err
+		}
+		if c.Valid() && compareKeys(c.Key(), key) != 0 {
+			return nil, false, nil
+		}
+	} else if _, err := t.search(c, key); err != nil {
+		return nil, false, err
+	}
+	if !c.Valid() || t.bTreeMeta.TableMeta.expired(c.Value(), time.Now()) {
+		return nil, false, nil
+	}
+	return slices.Clone(c.Value()), true, nil
 }
 
-// Search descends from the root with the given cursor, returns comparison result and error.
-func (t *BTree) Search(c *Cursor, key uint32) (int, error) {
+// search descends from the root with the given cursor, leaving it on key's
+// slot in its leaf, and returns the comparison result.
+func (t *BTree) search(c *Cursor, key uint32) (int, error) {
+	c.gen = t.gen
 	root, err := t.loadNode(t.rootPage)
 	if err != nil {
 		return 0, err
@@ -84,69 +179,174 @@ func (t *BTree) Search(c *Cursor, key uint32) (int, error) {
 	return root.Search(c, key)
 }
 
-// Insert adds key+row into the tree, splitting and promoting at the root if needed.
-func (t *BTree) Insert(c *Cursor, key uint32, row Row) error {
+// Insert adds key+row into the tree, overwriting the row when key is already
+// present. It positions its own cursor the way Search does, so callers never
+// manage one, and propagates splits up the path from the root, growing a new root
+// when the old one splits.
+func (t *BTree) Insert(key uint32, row Row) error {
+	_, err := t.insert(key, row)
+	return err
+}
+
+// InsertBatch inserts each pair in order as Insert does and reports how many
+// added a new key and how many overwrote the row of a key already present.
+// It stops at the first failing pair, returning the counts so far.
+func (t *BTree) InsertBatch(pairs []KeyRowPair) (inserted, updated int, err error) {
+	for _, p := range pairs {
+		overwrote, err := t.insert(p.Key, p.Row)
+		if err != nil {
+			return inserted, updated, fmt.Errorf("key %d: %w", p.Key, err)
+		}
+		if overwrote {
+			updated++
+		} else {
+			inserted++
+		}
+	}
+	return inserted, updated, nil
+}
+
+// insert is Insert, also reporting whether key was already present and its
+// row overwritten rather than a new cell added.
+func (t *BTree) insert(key uint32, row Row) (updated bool, err error) {
+	defer t.flushWrites(&err)
+	// a row that cannot be serialized must not reach the leaf's cells
+	meta := t.bTreeMeta.TableMeta
+	if err := checkRow(meta, row); err != nil {
+		return false, fmt.Errorf("insert: %w", err)
+	}
+	if t.rowids != nil {
+		defer func() {
+			if err == nil && !updated {
+				err = t.assignRowID(key)
+			}
+		}()
+	}
+	c := &Cursor{tree: t}
+	if _, err := t.search(c, key); err != nil {
+		return false, fmt.Errorf("insert: search: %w", err)
+	}
 	leaf := c.leaf
 
 	// 1) If key exists at cursor, overwrite
-	if c.Valid() && leaf.cells[c.idx].Key == key {
-		leaf.cells[c.idx].Value = row
-		pg, err := t.bTreeMeta.Pager.GetPage(leaf.Page())
-		if err != nil {
-			return fmt.Errorf("insert: get leaf page: %w", err)
+	if !t.bTreeMeta.Duplicates && c.Valid() && compareKeys(leaf.cells[c.idx].Key, key) == 0 {
+		if t.bTreeMeta.SkipSameRow && leaf.cells[c.idx].Value.Equal(row) {
+			return true, nil
 		}
-		return leaf.Serialize(pg)
+		leaf.cells[c.idx].Value = row
+		return true, t.serializeNode(leaf)
 	}
 
-	// 2) Otherwise insert into leaf
-	sibling, splitKey, didSplit := leaf.Insert(c, key, row)
-	pg, err := t.bTreeMeta.Pager.GetPage(leaf.Page())
+	// 2) Make sure a split cannot run out of pages halfway through
+	if leaf.full() {
+		if err := t.checkSplitPages(key); err != nil {
+			return false, err
+		}
+	}
+	path, idxs, err := t.interiorPath(key)
 	if err != nil {
-		return fmt.Errorf("insert: get leaf page: %w", err)
+		return false, fmt.Errorf("insert: %w", err)
+	}
+
+	// 3) Otherwise insert into leaf; the new row is counted once it is in
+	defer func() {
+		if err == nil {
+			err = t.addRows(1)
+		}
+	}()
+	sibling, splitKey, didSplit := leaf.Insert(key, row)
+	if err := t.serializeNode(leaf); err != nil {
+		return false, fmt.Errorf("insert: %w", err)
 	}
 	if !didSplit {
-		return leaf.Serialize(pg)
+		return false, t.updateCounts(path, idxs, leaf)
 	}
 
-	// 3) Propagate splits up
+	// 4) Propagate splits up, splicing each new right node into its parent
 	var leftNode BTreeNode = leaf
-	var rightNode BTreeNode = sibling
-	upKey := splitKey
+	rightNode, upKey := sibling, splitKey
+	for i := len(path) - 1; i >= 0; i-- {
+		if err := t.serializeNode(rightNode); err != nil {
+			return false, fmt.Errorf("insert: %w", err)
+		}
+		rightNode, upKey, didSplit = path[i].insertChild(idxs[i], leftNode, rightNode, upKey)
+		if !didSplit {
+			return false, t.updateCounts(path[:i], idxs[:i], path[i])
+		}
+		leftNode = path[i]
+	}
+	// reached root: build new root
+	return false, t.handleRootSplit(leftNode, rightNode, upKey)
+}
 
+// interiorPath returns the interior nodes on the way from the root down to
+// key's leaf, each with the index of the child the descent takes.
+func (t *BTree) interiorPath(key uint32) ([]*InteriorNode, []int, error) {
+	var path []*InteriorNode
+	var idxs []int
+	pgno := t.rootPage
 	for {
-		parentPg := leftNode.(*InteriorNode).header.parentPage
-		// reached root: build new root
-		if parentPg == 0 {
-			return t.handleRootSplit(leftNode, rightNode, upKey)
+		node, err := t.loadNode(pgno)
+		if err != nil {
+			return nil, nil, fmt.Errorf("load page %d: %w", pgno, err)
+		}
+		if node.IsLeaf() {
+			return path, idxs, nil
 		}
+		interior := node.(*InteriorNode)
+		var i int
+		pgno, i = interior.childIndexFor(key)
+		path = append(path, interior)
+		idxs = append(idxs, i)
+	}
+}
 
-		parent, err := t.loadNode(parentPg)
+// checkSplitPages returns ErrOutOfPages when inserting key could need more
+// new pages than the pager can still hand out. Each full node on the path
+// from the root to key's leaf splits into one new page, and a root split
+// needs one more for the new root.
+func (t *BTree) checkSplitPages(key uint32) error {
+	var full []bool
+	pgno := t.rootPage
+	for {
+		node, err := t.loadNode(pgno)
 		if err != nil {
-			return fmt.Errorf("insert: load parent page %d: %w", parentPg, err)
+			return fmt.Errorf("insert: load page %d: %w", pgno, err)
 		}
-
-		// splice into interior; pass cursor for API consistency
-		newSib, newKey, split := parent.(*InteriorNode).Insert(c, upKey, row)
-		if !split {
-			ppg, _ := t.bTreeMeta.Pager.GetPage(parent.Page())
-			return parent.Serialize(ppg)
+		if node.IsLeaf() {
+			full = append(full, node.(*LeafNode).full())
+			break
 		}
+		interior := node.(*InteriorNode)
+		full = append(full, len(interior.cells) >= maxCells)
+		pgno = t.findChildPageInInterior(interior, key)
+	}
+
+	need := 0
+	for i := len(full) - 1; i >= 0 && full[i]; i-- {
+		need++
+	}
+	if need == len(full) {
+		need++ // new root
+	}
 
-		leftNode = parent
-		rightNode = newSib
-		upKey = newKey
+	p := t.bTreeMeta.Pager
+	if avail := len(t.bTreeMeta.freeList().freePages) + p.MaxPages - p.NumPages; need > avail {
+		return fmt.Errorf("insert: split needs %d pages, %d available: %w", need, avail, ErrOutOfPages)
 	}
+	return nil
 }
 
 // Delete removes the given key from the tree.
 // Returns true if the key was found and deleted, false if not found.
-func (t *BTree) Delete(key uint32) (bool, error) {
+func (t *BTree) Delete(key uint32) (found bool, err error) {
+	defer t.flushWrites(&err)
 	root, err := t.loadNode(t.rootPage)
 	if err != nil {
 		return false, fmt.Errorf("failed to load root node: %w", err)
 	}
 
-	found, _ := root.Delete(key)
+	found, _ = root.Delete(key)
 	if !found {
 		return false, nil // Key not found
 	}
@@ -161,9 +361,51 @@ func (t *BTree) Delete(key uint32) (bool, error) {
 		return false, fmt.Errorf("failed to serialize root node: %w", err)
 	}
 
+	if err := t.addRows(-1); err != nil {
+		return false, err
+	}
+	if t.rowids != nil {
+		if err := t.dropRowID(key); err != nil {
+			return false, err
+		}
+	}
 	return true, nil
 }
 
+// NumRows returns the number of rows in the tree, kept in the meta page.
+func (t *BTree) NumRows() (uint32, error) {
+	mp, err := t.bTreeMeta.Pager.GetPage(metaPageNum)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get meta page: %w", err)
+	}
+	_, rowsOff := t.metaOffsets()
+	return binary.LittleEndian.Uint32(mp.Data[rowsOff : rowsOff+4]), nil
+}
+
+// addRows adjusts the persisted row count by delta.
+func (t *BTree) addRows(delta int) error {
+	t.version++
+	mp, err := t.bTreeMeta.Pager.GetPage(metaPageNum)
+	if err != nil {
+		return fmt.Errorf("failed to get meta page: %w", err)
+	}
+	_, rowsOff := t.metaOffsets()
+	n := binary.LittleEndian.Uint32(mp.Data[rowsOff : rowsOff+4])
+	binary.LittleEndian.PutUint32(mp.Data[rowsOff:rowsOff+4], uint32(int(n)+delta))
+	mp.Dirty = true
+	return nil
+}
+
+// metaOffsets returns where in the meta page the tree keeps its root page
+// number and its row count: fixed slots for the primary tree, the tree's
+// directory entry for an index (see index.go).
+func (t *BTree) metaOffsets() (root, rows int) {
+	if t.dirOff == 0 {
+		return metaRootOff, metaRowsOff
+	}
+	return t.dirOff + dirRootOff, t.dirOff + dirRowsOff
+}
+
 // handleNoSplit handles the case where insertion doesn't cause a split.
 func (t *BTree) handleNoSplit(root BTreeNode) error {
 	page, err := t.bTreeMeta.Pager.GetPage(t.rootPage)
@@ -240,14 +482,15 @@ func (t *BTree) serializeSibling(sibling BTreeNode) error {
 func (t *BTree) createNewRoot(newRootPage uint32, oldRoot, sibling BTreeNode, splitKey uint32) error {
 	newRoot := &InteriorNode{
 		header: baseHeader{
-			pageNum:      newRootPage,
-			isRoot:       true,
-			parentPage:   0,
-			numCells:     1,
-			rightPointer: sibling.Page(),
+			pageNum:    newRootPage,
+			isRoot:     true,
+			parentPage: 0,
+			numCells:   1,
 		},
+		leftChild: oldRoot.Page(),
+		leftCount: subtreeCount(oldRoot),
 		cells: []InteriorCell{
-			{ChildPage: oldRoot.Page(), Key: splitKey},
+			{ChildPage: sibling.Page(), Key: splitKey, Count: subtreeCount(sibling)},
 		},
 	}
 
@@ -272,53 +515,81 @@ func (t *BTree) updateRootPointer(newRootPage uint32) error {
 		return fmt.Errorf("failed to get meta page: %w", err)
 	}
 
-	binary.LittleEndian.PutUint32(metaPage.Data[metaRootOff:metaRootOff+4], newRootPage)
+	rootOff, _ := t.metaOffsets()
+	binary.LittleEndian.PutUint32(metaPage.Data[rootOff:rootOff+4], newRootPage)
 	metaPage.Dirty = true
 
 	return nil
 }
 
-// loadNode reads pageNum, inspects the first byte, and returns
-// either a LeafNode (with meta) or InteriorNode.
+// loadNode returns the node stored on pageNum, either a LeafNode (with meta)
+// or an InteriorNode, reusing the cached instance when there is one.
 func (t *BTree) loadNode(pageNum uint32) (BTreeNode, error) {
-	p, err := t.bTreeMeta.Pager.GetPage(pageNum)
-	if err != nil {
-		return nil, err
+	return t.bTreeMeta.loadNode(pageNum)
+}
+
+// AllocatePage hands out the next free page number.
+func (t *BTree) AllocatePage() (uint32, error) {
+	return t.bTreeMeta.allocatePage()
+}
+
+// Sync persists the free list and makes every change so far durable,
+// leaving the tree open.
+func (t *BTree) Sync() error {
+	if err := t.writeFreeList(); err != nil {
+		return fmt.Errorf("sync: write free list: %w", err)
 	}
+	return t.bTreeMeta.Pager.Sync()
+}
 
-	switch p.Data[0] {
-	case nodeTypeLeaf:
-		return t.loadLeafNode(pageNum)
+// SetSkipSameRow makes Insert compare an overwritten row with the new one and
+// skip rewriting (and dirtying) the leaf when they are equal.
+func (t *BTree) SetSkipSameRow(on bool) {
+	t.bTreeMeta.SkipSameRow = on
+}
 
-	case nodeTypeInterior:
-		inode := &InteriorNode{bTreeMeta: t.bTreeMeta}
-		inode.header.pageNum = pageNum
-		if err := inode.Load(p); err != nil {
-			return nil, err
-		}
-		inode.header.pageNum = pageNum
-		return inode, nil
+// SetDeferredFlush controls when modified pages reach the file. Deferred
+// flushing, the default, keeps them in the page cache until Sync or Close,
+// which suits batch loads. With it off, every Insert and Delete writes its
+// dirty pages out before returning (without fsync).
+func (t *BTree) SetDeferredFlush(on bool) {
+	t.bTreeMeta.WriteThrough = !on
+}
 
-	default:
-		return nil, fmt.Errorf("loadNode: unknown node type %d", p.Data[0])
+// flushWrites writes out dirty pages at the end of a mutation unless
+// flushing is deferred. It keeps the mutation's own error, if any.
+func (t *BTree) flushWrites(err *error) {
+	t.version++
+	if *err != nil || !t.bTreeMeta.WriteThrough {
+		return
+	}
+	if ferr := t.bTreeMeta.Pager.WriteDirty(); ferr != nil {
+		*err = fmt.Errorf("flush: %w", ferr)
 	}
 }
 
-// AllocatePage hands out the next free page number.
-func (t *BTree) AllocatePage() (uint32, error) {
-	return t.bTreeMeta.Pager.AllocatePage()
+// Close persists the free list, truncates any free pages at the end of the
+// file, then flushes and closes the pager. It is safe to call when nothing is
+// free.
+func (t *BTree) Close() error {
+	if err := t.truncateFreeTail(); err != nil {
+		return fmt.Errorf("close: truncate free tail: %w", err)
+	}
+	if err := t.writeFreeList(); err != nil {
+		return fmt.Errorf("close: write free list: %w", err)
+	}
+	return t.bTreeMeta.Pager.Close()
 }
 
-// loadLeafNode creates a LeafNode bound to the given page and loads its data.
+// loadLeafNode returns the leaf stored on pageNum.
 func (t *BTree) loadLeafNode(pageNum uint32) (*LeafNode, error) {
-	p, err := t.bTreeMeta.Pager.GetPage(pageNum)
+	node, err := t.loadNode(pageNum)
 	if err != nil {
 		return nil, err
 	}
-	leaf := &LeafNode{bTreeMeta: t.bTreeMeta}
-	leaf.header.pageNum = pageNum
-	if err := leaf.Load(p); err != nil {
-		return nil, err
+	leaf, ok := node.(*LeafNode)
+	if !ok {
+		return nil, fmt.Errorf("loadLeafNode: page %d is not a leaf", pageNum)
 	}
 	return leaf, nil
 }
@@ -337,7 +608,11 @@ func rootHeader(n BTreeNode) *baseHeader {
 
 // firstLeaf descends to the left–most leaf of the tree.
 func (t *BTree) firstLeaf() (*LeafNode, uint32, error) {
-	pgno := t.rootPage
+	return t.firstLeafFrom(t.rootPage)
+}
+
+// firstLeafFrom returns the leftmost leaf below the node on pgno.
+func (t *BTree) firstLeafFrom(pgno uint32) (*LeafNode, uint32, error) {
 	for {
 		node, err := t.loadNode(pgno)
 		if err != nil {
@@ -346,70 +621,214 @@ func (t *BTree) firstLeaf() (*LeafNode, uint32, error) {
 		if node.IsLeaf() {
 			return node.(*LeafNode), pgno, nil
 		}
-		in := node.(*InteriorNode)
-		if len(in.cells) > 0 {
-			pgno = in.cells[0].ChildPage
-		} else {
-			pgno = in.header.rightPointer
+		pgno = node.(*InteriorNode).leftChild
+	}
+}
+
+// First returns the smallest key and its row; found is false for an empty
+// tree.
+func (t *BTree) First() (uint32, Row, bool, error) {
+	c, err := t.NewCursor()
+	if err != nil {
+		return 0, nil, false, err
+	}
+	if !c.Valid() {
+		return 0, nil, false, nil
+	}
+	return c.Key(), slices.Clone(c.Value()), true, nil
+}
+
+// Last returns the largest key and its row; found is false for an empty tree.
+func (t *BTree) Last() (uint32, Row, bool, error) {
+	key, row, found, err := t.lastIn(t.rootPage)
+	return key, slices.Clone(row), found, err
+}
+
+// lastIn returns the last cell of the subtree at pgno whose row has not
+// expired. Leaves can be empty after deletes, or hold only expired rows, so
+// it falls back to the children left of the rightmost one.
+func (t *BTree) lastIn(pgno uint32) (uint32, Row, bool, error) {
+	node, err := t.loadNode(pgno)
+	if err != nil {
+		return 0, nil, false, err
+	}
+	if node.IsLeaf() {
+		leaf := node.(*LeafNode)
+		i := leaf.lastLive(len(leaf.cells))
+		if i < 0 {
+			return 0, nil, false, nil
 		}
+		return leaf.cells[i].Key, leaf.cells[i].Value, true, nil
 	}
+	in := node.(*InteriorNode)
+	for i := in.numChildren() - 1; i >= 0; i-- {
+		key, row, found, err := t.lastIn(in.child(i))
+		if found || err != nil {
+			return key, row, found, err
+		}
+	}
+	return 0, nil, false, nil
 }
 
-// NewCursor returns a cursor positioned at the first row (if any).
+// Height returns the number of levels in the tree, found by descending the
+// leftmost path from the root. A tree whose root is a leaf has height 1.
+func (t *BTree) Height() (int, error) {
+	height := 1
+	pgno := t.rootPage
+	for {
+		node, err := t.loadNode(pgno)
+		if err != nil {
+			return 0, err
+		}
+		if node.IsLeaf() {
+			return height, nil
+		}
+		pgno = node.(*InteriorNode).leftChild
+		height++
+	}
+}
+
+// NewCursor returns a cursor positioned at the first row (if any). Empty
+// leaves at the start of the chain, e.g. after deletes, are skipped.
 func (t *BTree) NewCursor() (*Cursor, error) {
+	return t.newCursor(false)
+}
+
+// newCursor is NewCursor, optionally visiting expired rows too.
+func (t *BTree) newCursor(all bool) (*Cursor, error) {
 	leaf, pg, err := t.firstLeaf()
 	if err != nil {
 		return nil, err
 	}
-	c := &Cursor{tree: t, leaf: leaf, page: pg}
-	if leaf.header.numCells == 0 {
-		c.valid = false
-	} else {
-		c.idx = 0
-		c.valid = true
+	c := &Cursor{tree: t, leaf: leaf, page: pg, gen: t.gen, all: all}
+	if err := c.settle(); err != nil {
+		return nil, err
 	}
 	return c, nil
 }
 
-// Valid tells whether the cursor is positioned at an existing key/value.
-func (c *Cursor) Valid() bool { return c.valid }
+// settle moves the cursor forward over exhausted or empty leaves and expired
+// rows until it rests on a cell, or marks it invalid at the end of the leaf
+// chain.
+func (c *Cursor) settle() error {
+	for {
+		for c.idx >= int(c.leaf.header.numCells) {
+			// move to next leaf via rightPointer
+			if c.leaf.header.rightPointer == 0 {
+				c.valid = false
+				return nil
+			}
+			newLeaf, err := c.tree.loadLeafNode(c.leaf.header.rightPointer)
+			if err != nil {
+				return err
+			}
+			if c.StreamEvict && c.tree.bTreeMeta.Pager.Evict(c.page) {
+				c.tree.bTreeMeta.evictNode(c.page)
+			}
+			c.leaf = newLeaf
+			c.page = newLeaf.Page()
+			c.idx = 0
+		}
+		skipped, err := c.skipExpired()
+		if err != nil {
+			return err
+		}
+		if !skipped {
+			c.valid = true
+			return nil
+		}
+	}
+}
+
+// Valid tells whether the cursor is positioned at an existing key/value. A
+// stale cursor is never valid.
+func (c *Cursor) Valid() bool { return c.valid && !c.stale() }
+
+// stale reports whether the tree was restructured since c was positioned, so
+// its leaf may have been freed or reused.
+func (c *Cursor) stale() bool { return c.gen != c.tree.gen }
 
 // Key returns the current key. Call only if Valid() is true.
 func (c *Cursor) Key() uint32 { return c.leaf.cells[c.idx].Key }
 
-// Value returns the current row. Call only if Valid() is true.
+// Value returns the current row. Call only if Valid() is true. The row is the
+// one the cached leaf holds, not a copy: changing it would change the tree
+// behind serializeNode's back, so clone it before modifying it.
 func (c *Cursor) Value() Row { return c.leaf.cells[c.idx].Value }
 
-// Next advances to the next key in order.
+// Next advances to the next key in order. It returns ErrCursorStale if the
+// tree was restructured since the cursor was positioned.
 func (c *Cursor) Next() error {
 	if !c.valid {
 		return nil
 	}
-	c.idx++
-	if c.idx < int(c.leaf.header.numCells) {
-		return nil
-	}
-	// move to next leaf via rightPointer
-	if c.leaf.header.rightPointer == 0 {
+	if c.stale() {
 		c.valid = false
-		return nil
+		return ErrCursorStale
 	}
-	newLeaf, err := c.tree.loadLeafNode(c.leaf.header.rightPointer)
+	c.idx++
+	return c.settle()
+}
+
+// ForEach calls fn for every row in key order. It stops at the first error
+// fn returns and passes it back unchanged; otherwise it returns nil once all
+// rows have been visited. As with Cursor.Value, fn must not modify row.
+func (t *BTree) ForEach(fn func(key uint32, row Row) error) error {
+	return t.ForEachContext(context.Background(), fn)
+}
+
+// ctxCheckRows is how many rows long-running operations process between
+// checks of their context.
+const ctxCheckRows = 64
+
+// ForEachContext is ForEach that also stops, returning ctx.Err(), once ctx
+// is done. The context is checked before the first row and then every
+// ctxCheckRows rows.
+func (t *BTree) ForEachContext(ctx context.Context, fn func(key uint32, row Row) error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	c, err := t.NewCursor()
 	if err != nil {
 		return err
 	}
-	c.leaf = newLeaf
-	c.page = newLeaf.Page()
-	if newLeaf.header.numCells == 0 {
-		c.valid = false
-	} else {
-		c.idx = 0
-		c.valid = true
+	for n := 1; c.Valid(); n++ {
+		if err := fn(c.Key(), c.Value()); err != nil {
+			return err
+		}
+		if n%ctxCheckRows == 0 {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+		}
+		if err := c.Next(); err != nil {
+			return err
+		}
 	}
 	return nil
 }
 
-// findLeafForKey traverses the tree to find the leaf node that should contain the given key.
+// AllRows returns every row in key order as a map from column name to its
+// value, typed as DeserializeRow returns it (uint32, int32 or string).
+func (t *BTree) AllRows() ([]map[string]interface{}, error) {
+	cols := t.bTreeMeta.TableMeta.Columns
+	var rows []map[string]interface{}
+	err := t.ForEach(func(_ uint32, row Row) error {
+		m := make(map[string]interface{}, len(cols))
+		for i, col := range cols {
+			m[col.Name] = row[i]
+		}
+		rows = append(rows, m)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return rows, nil
+}
+
+// findLeafForKey traverses the tree to find the leaf node that should contain the given key
+// (with duplicates, the leaf holding the start of its run).
 // Returns the leaf node and its page number.
 func (t *BTree) findLeafForKey(key uint32) (*LeafNode, uint32, error) {
 	pgno := t.rootPage
@@ -422,28 +841,53 @@ func (t *BTree) findLeafForKey(key uint32) (*LeafNode, uint32, error) {
 			return node.(*LeafNode), pgno, nil
 		}
 
-		interior := node.(*InteriorNode)
-		pgno = t.findChildPageInInterior(interior, key)
+		pgno = t.seekChildPage(node.(*InteriorNode), key)
+	}
+}
+
+// seekChildPage returns the child of interior where a seek for key starts.
+// Without duplicates that is the child holding key. With duplicates a run of
+// equal keys can span several children, so it is the leftmost child that may
+// hold key; the leaf chain leads on to the rest of the run.
+func (t *BTree) seekChildPage(interior *InteriorNode, key uint32) uint32 {
+	if !t.bTreeMeta.Duplicates {
+		return t.findChildPageInInterior(interior, key)
 	}
+	i := sort.Search(len(interior.cells), func(i int) bool {
+		return compareKeys(interior.cells[i].Key, key) >= 0
+	})
+	return interior.child(i)
+}
+
+// SetDuplicates lets the tree hold several rows under one key, for
+// non-unique indexes and multimaps. Insert then always adds a cell, placing
+// it after the existing cells with an equal key so a run keeps insertion
+// order; Seek and Search land on the first cell of a run, and iteration
+// visits all of them. Delete removes one cell of the run.
+func (t *BTree) SetDuplicates(on bool) {
+	t.bTreeMeta.Duplicates = on
 }
 
 // findChildPageInInterior finds the appropriate child page for a given key in an interior node.
 // Uses binary search for efficiency, consistent with the Seek implementation.
 func (t *BTree) findChildPageInInterior(interior *InteriorNode, key uint32) uint32 {
-	// Binary search for the first cell with Key >= key
-	idx := sort.Search(len(interior.cells), func(i int) bool {
-		return interior.cells[i].Key >= key
-	})
+	pgno, _ := interior.childIndexFor(key)
+	return pgno
+}
 
-	if idx < len(interior.cells) {
-		return interior.cells[idx].ChildPage
+// Seek repositions the cursor to the first key >= target key. If there is no
+// such key, e.g. because the tree is empty, the cursor is left invalid.
+func (c *Cursor) Seek(target uint32) error {
+	if err := c.position(target); err != nil {
+		return err
 	}
-	return interior.header.rightPointer
+	return c.settle()
 }
 
-// Seek repositions the cursor to the first key >= target key.
-func (c *Cursor) Seek(target uint32) error {
-	// Find the appropriate leaf node
+// position puts the cursor on the slot of the first key >= target in the
+// leaf that can hold it, which may be one past its last cell, and marks it
+// current with the tree.
+func (c *Cursor) position(target uint32) error {
 	leaf, pgno, err := c.tree.findLeafForKey(target)
 	if err != nil {
 		return err
@@ -451,15 +895,15 @@ func (c *Cursor) Seek(target uint32) error {
 
 	// Binary search within the leaf for the target key
 	idx := sort.Search(int(leaf.header.numCells), func(i int) bool {
-		return leaf.cells[i].Key >= target
+		return compareKeys(leaf.cells[i].Key, target) >= 0
 	})
 
-	// Update cursor state
+	// Update cursor state; a target past this leaf's last key continues
+	// at the first key of the following leaves
+	c.gen = c.tree.gen
 	c.leaf = leaf
 	c.page = pgno
 	c.idx = idx
-	c.valid = idx < int(leaf.header.numCells)
-
 	return nil
 }
 
@@ -473,12 +917,14 @@ type KeyRowPair struct {
 type PageInfo struct {
 	pageNum uint32
 	minKey  uint32
+	count   uint32 // keys in the page's subtree
 }
 
 // buildAllLeaves creates and fills all leaf pages
 func (t *BTree) buildAllLeaves(data []KeyRowPair) ([]*LeafNode, error) {
 	var leaves []*LeafNode
 	dataIdx := 0
+	perLeaf := t.bTreeMeta.TableMeta.RowsPerPage()
 
 	for dataIdx < len(data) {
 		// Create a new leaf
@@ -488,7 +934,7 @@ func (t *BTree) buildAllLeaves(data []KeyRowPair) ([]*LeafNode, error) {
 		}
 
 		// Fill leaf to capacity or until we run out of data
-		for dataIdx < len(data) && len(leaf.cells) < maxCells {
+		for dataIdx < len(data) && len(leaf.cells) < perLeaf {
 			pair := data[dataIdx]
 			leaf.cells = append(leaf.cells, LeafCell{
 				Key:   pair.Key,
@@ -538,8 +984,165 @@ func (t *BTree) replaceTree(newRootPage uint32) error {
 		return fmt.Errorf("failed to get meta page: %w", err)
 	}
 
-	binary.LittleEndian.PutUint32(metaPage.Data[metaRootOff:metaRootOff+4], newRootPage)
+	rootOff, _ := t.metaOffsets()
+	binary.LittleEndian.PutUint32(metaPage.Data[rootOff:rootOff+4], newRootPage)
 	metaPage.Dirty = true
 
 	return nil
 }
+
+// buildInteriorLevel groups children into interior nodes of up to maxCells+1
+// children each and returns the new level, one PageInfo per interior node.
+func (t *BTree) buildInteriorLevel(children []PageInfo) ([]PageInfo, error) {
+	var parents []PageInfo
+	for i := 0; i < len(children); {
+		n := min(maxCells+1, len(children)-i)
+		// never leave a lone child for the last node of the level
+		if len(children)-i-n == 1 {
+			n--
+		}
+		group := children[i : i+n]
+
+		node, err := NewInteriorNode(t.bTreeMeta, false)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create interior node: %w", err)
+		}
+		node.leftChild = group[0].pageNum
+		node.leftCount = group[0].count
+		for _, c := range group[1:] {
+			node.cells = append(node.cells, InteriorCell{ChildPage: c.pageNum, Key: c.minKey, Count: c.count})
+		}
+		node.header.numCells = uint32(len(node.cells))
+
+		if err := t.serializeNode(node); err != nil {
+			return nil, fmt.Errorf("failed to serialize interior node: %w", err)
+		}
+		parents = append(parents, PageInfo{pageNum: node.Page(), minKey: group[0].minKey, count: subtreeCount(node)})
+		i += n
+	}
+	return parents, nil
+}
+
+// bulkLoad builds a new tree bottom-up from data, which must be sorted by key
+// without duplicates, and makes it the current tree. The old tree's pages are
+// left untouched; callers release them beforehand if they are no longer needed.
+func (t *BTree) bulkLoad(data []KeyRowPair) error {
+	leaves, err := t.buildAllLeaves(data)
+	if err != nil {
+		return err
+	}
+	if len(leaves) == 0 {
+		leaf, err := NewLeafNode(t.bTreeMeta, true)
+		if err != nil {
+			return fmt.Errorf("failed to create root leaf: %w", err)
+		}
+		if err := t.serializeNode(leaf); err != nil {
+			return fmt.Errorf("failed to serialize root leaf: %w", err)
+		}
+		return t.replaceTree(leaf.Page())
+	}
+
+	level := make([]PageInfo, len(leaves))
+	for i, leaf := range leaves {
+		level[i] = PageInfo{pageNum: leaf.Page(), minKey: leaf.cells[0].Key, count: uint32(len(leaf.cells))}
+	}
+	for len(level) > 1 {
+		if level, err = t.buildInteriorLevel(level); err != nil {
+			return err
+		}
+	}
+
+	root, err := t.loadNode(level[0].pageNum)
+	if err != nil {
+		return fmt.Errorf("failed to load new root: %w", err)
+	}
+	rootHeader(root).isRoot = true
+	if err := t.serializeNode(root); err != nil {
+		return fmt.Errorf("failed to serialize new root: %w", err)
+	}
+	return t.replaceTree(level[0].pageNum)
+}
+
+// WalkPages calls fn for every node reachable from the root in breadth-first
+// order: the root, then each level left to right. It stops at the first error
+// fn returns.
+func (t *BTree) WalkPages(fn func(pageNum uint32, node BTreeNode) error) error {
+	queue := []uint32{t.rootPage}
+	for len(queue) > 0 {
+		pgno := queue[0]
+		queue = queue[1:]
+		node, err := t.loadNode(pgno)
+		if err != nil {
+			return err
+		}
+		if err := fn(pgno, node); err != nil {
+			return err
+		}
+		if in, ok := node.(*InteriorNode); ok {
+			for i := 0; i < in.numChildren(); i++ {
+				queue = append(queue, in.child(i))
+			}
+		}
+	}
+	return nil
+}
+
+// nodePages returns the page number of every node reachable from the root.
+func (t *BTree) nodePages() ([]uint32, error) {
+	var pages []uint32
+	err := t.WalkPages(func(pgno uint32, _ BTreeNode) error {
+		pages = append(pages, pgno)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return pages, nil
+}
+
+// allPairs reads every key/row pair in key order, expired rows included. It
+// gives up with ctx.Err() once ctx is done.
+func (t *BTree) allPairs(ctx context.Context) ([]KeyRowPair, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	var data []KeyRowPair
+	c, err := t.newCursor(true)
+	if err != nil {
+		return nil, err
+	}
+	for c.Valid() {
+		data = append(data, KeyRowPair{Key: c.Key(), Row: c.Value()})
+		if len(data)%ctxCheckRows == 0 {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+		}
+		if err := c.Next(); err != nil {
+			return nil, err
+		}
+	}
+	return data, nil
+}
+
+// rebuild releases every node page of the current tree to the free list and
+// bulk-loads data into a fresh tree, reusing the released pages.
+func (t *BTree) rebuild(data []KeyRowPair) error {
+	t.gen++
+	t.version++
+	pages, err := t.nodePages()
+	if err != nil {
+		return fmt.Errorf("failed to collect tree pages: %w", err)
+	}
+	for _, pgno := range pages {
+		t.bTreeMeta.releasePage(pgno)
+	}
+	// allocation pops from the end, so hand out the lowest pages first and
+	// leave free ones at the end of the file for truncateFreeTail
+	fl := t.bTreeMeta.freeList()
+	slices.SortFunc(fl.freePages, func(a, b uint32) int { return cmp.Compare(b, a) })
+	if err := t.bulkLoad(data); err != nil {
+		return err
+	}
+	return t.writeFreeList()
+}
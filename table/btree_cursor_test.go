@@ -1,8 +1,16 @@
 package table
 
 import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"math"
 	"os"
+	"path/filepath"
 	"reflect"
+	"slices"
+	"strings"
 	"testing"
 	"vqlite/column"
 	"vqlite/pager"
@@ -48,7 +56,9 @@ func TestCursorIterate(t *testing.T) {
 
 // TestCursorSeek verifies Seek positions the cursor on the first key >= target.
 func TestCursorSeek(t *testing.T) {
-	pg, _ := pager.OpenPager(":memory:")
+	tp := newTempPager(t)
+	defer tp.cleanup()
+	pg := tp.Pager
 	schema := column.Schema{{Name: "id", Type: column.ColumnTypeInt}}
 	meta, _ := BuildTableMeta(schema)
 	bt, _ := NewBTree(pg, meta)
@@ -68,7 +78,9 @@ func TestCursorSeek(t *testing.T) {
 
 // TestCursorSeekRangeQueries demonstrates using Seek for range queries and iterations.
 func TestCursorSeekRangeQueries(t *testing.T) {
-	pg, _ := pager.OpenPager(":memory:")
+	tp := newTempPager(t)
+	defer tp.cleanup()
+	pg := tp.Pager
 	schema := column.Schema{{Name: "id", Type: column.ColumnTypeInt}}
 	meta, _ := BuildTableMeta(schema)
 	bt, _ := NewBTree(pg, meta)
@@ -127,3 +139,1491 @@ func TestCursorSeekRangeQueries(t *testing.T) {
 		t.Errorf("seek 1: expected key 10, got %d valid=%v", cursor.Key(), cursor.Valid())
 	}
 }
+
+// TestKeyCursor_MatchesCursor verifies a key-only scan yields exactly the keys
+// of a normal cursor scan, that Value() is refused, and that a rebuild makes
+// an open key cursor stale until it seeks again.
+func TestKeyCursor_MatchesCursor(t *testing.T) {
+	tp := newTempPager(t)
+	defer tp.cleanup()
+	pg := tp.Pager
+	schema := column.Schema{
+		{Name: "id", Type: column.ColumnTypeInt},
+		{Name: "name", Type: column.ColumnTypeText, MaxLength: 16},
+	}
+	meta, _ := BuildTableMeta(schema)
+	bt, _ := NewBTree(pg, meta)
+
+	for i := uint32(0); i < 100; i++ {
+		key := (i * 37) % 100
+		if err := bt.Insert(key, Row{key, "name"}); err != nil {
+			t.Fatalf("insert %d: %v", key, err)
+		}
+	}
+
+	var want []uint32
+	cur, _ := bt.NewCursor()
+	for cur.Valid() {
+		want = append(want, cur.Key())
+		cur.Next()
+	}
+
+	kc, err := bt.NewKeyCursor()
+	if err != nil {
+		t.Fatalf("NewKeyCursor: %v", err)
+	}
+	if _, err := kc.Value(); err != ErrKeyOnly {
+		t.Errorf("Value() err = %v; want ErrKeyOnly", err)
+	}
+	var got []uint32
+	for kc.Valid() {
+		got = append(got, kc.Key())
+		if err := kc.Next(); err != nil {
+			t.Fatalf("Next: %v", err)
+		}
+	}
+	if !reflect.DeepEqual(want, got) {
+		t.Fatalf("key scan = %v; want %v", got, want)
+	}
+
+	kc, _ = bt.NewKeyCursor()
+	if err := bt.AddColumn(column.Column{Name: "age", Type: column.ColumnTypeInt}); err != nil {
+		t.Fatalf("AddColumn: %v", err)
+	}
+	if kc.Valid() {
+		t.Error("key cursor still valid after the tree was rebuilt")
+	}
+	if err := kc.Next(); err != ErrCursorStale {
+		t.Errorf("Next after rebuild err = %v; want ErrCursorStale", err)
+	}
+	if err := kc.Seek(50); err != nil || !kc.Valid() || kc.Key() != 50 {
+		t.Errorf("Seek(50) after rebuild = valid %v, err %v; want key 50", kc.Valid(), err)
+	}
+}
+
+// TestRawScan_MatchesSerializeRow checks that RawScan hands out exactly the
+// bytes SerializeRow produces for each row, for plain and compressed leaves.
+func TestRawScan_MatchesSerializeRow(t *testing.T) {
+	for _, compress := range []bool{false, true} {
+		tp := newTempPager(t)
+		schema := column.Schema{
+			{Name: "id", Type: column.ColumnTypeInt},
+			{Name: "name", Type: column.ColumnTypeText, MaxLength: 16},
+		}
+		meta, _ := BuildTableMeta(schema)
+		bt, _ := NewBTree(tp.Pager, meta)
+		bt.SetCompression(compress)
+
+		rows := map[uint32]Row{}
+		for i := uint32(0); i < 50; i++ {
+			key := (i * 7) % 50
+			rows[key] = Row{key, fmt.Sprintf("row-%d", key)}
+			if err := bt.Insert(key, rows[key]); err != nil {
+				t.Fatalf("insert %d: %v", key, err)
+			}
+		}
+
+		var seen []uint32
+		err := bt.RawScan(func(key uint32, rowBytes []byte) bool {
+			want := make([]byte, meta.RowSize)
+			if err := SerializeRow(meta, rows[key], want); err != nil {
+				t.Fatalf("SerializeRow: %v", err)
+			}
+			if !bytes.Equal(rowBytes, want) {
+				t.Errorf("compress=%v key %d: raw %q; want %q", compress, key, rowBytes, want)
+			}
+			seen = append(seen, key)
+			return true
+		})
+		if err != nil {
+			t.Fatalf("RawScan: %v", err)
+		}
+		if len(seen) != len(rows) || !slices.IsSorted(seen) {
+			t.Errorf("compress=%v: scanned keys %v; want all %d in order", compress, seen, len(rows))
+		}
+
+		n := 0
+		bt.RawScan(func(uint32, []byte) bool { n++; return n < 3 })
+		if n != 3 {
+			t.Errorf("compress=%v: scan did not stop early, saw %d rows", compress, n)
+		}
+		tp.cleanup()
+	}
+}
+
+// BenchmarkScan_KeyCursor compares a key-only scan with a full cursor scan,
+// each starting with the page and node caches empty, so the full scan pays
+// for decoding every leaf and the key scan only for reading it.
+func BenchmarkScan_KeyCursor(b *testing.B) {
+	pg, _ := pager.OpenPager(filepath.Join(b.TempDir(), "scan.db"))
+	defer pg.Close()
+	schema := column.Schema{
+		{Name: "id", Type: column.ColumnTypeInt},
+		{Name: "name", Type: column.ColumnTypeText, MaxLength: 64},
+		{Name: "email", Type: column.ColumnTypeText, MaxLength: 64},
+	}
+	meta, _ := BuildTableMeta(schema)
+	bt, _ := NewBTree(pg, meta)
+	for i := uint32(0); i < 2000; i++ {
+		bt.Insert(i, Row{i, "username", "user@example.com"})
+	}
+
+	b.Run("cursor", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			b.StopTimer()
+			dropCaches(b, bt)
+			b.StartTimer()
+			cur, _ := bt.NewCursor()
+			for cur.Valid() {
+				_ = cur.Key()
+				cur.Next()
+			}
+		}
+	})
+	b.Run("key-cursor", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			b.StopTimer()
+			dropCaches(b, bt)
+			b.StartTimer()
+			kc, _ := bt.NewKeyCursor()
+			for kc.Valid() {
+				_ = kc.Key()
+				kc.Next()
+			}
+		}
+	})
+}
+
+// wideSchema has one INT key column and several TEXT columns, for
+// projection tests.
+var wideSchema = column.Schema{
+	{Name: "id", Type: column.ColumnTypeInt},
+	{Name: "a", Type: column.ColumnTypeText, MaxLength: 48},
+	{Name: "b", Type: column.ColumnTypeText, MaxLength: 48},
+	{Name: "age", Type: column.ColumnTypeInt32},
+	{Name: "c", Type: column.ColumnTypeText, MaxLength: 48},
+	{Name: "d", Type: column.ColumnTypeText, MaxLength: 48},
+}
+
+// TestScanColumns_MatchesFullRows projects two columns, out of schema order,
+// and checks every value matches the fully deserialized row.
+func TestScanColumns_MatchesFullRows(t *testing.T) {
+	tp := newTempPager(t)
+	defer tp.cleanup()
+	meta, _ := BuildTableMeta(wideSchema)
+	bt, _ := NewBTree(tp.Pager, meta)
+	for i := uint32(0); i < 60; i++ {
+		row := Row{i, fmt.Sprint("a", i), "b", int32(i) - 30, "", fmt.Sprint("d", i*i)}
+		if err := bt.Insert(i, row); err != nil {
+			t.Fatalf("insert %d: %v", i, err)
+		}
+	}
+	seen := 0
+	err := bt.ScanColumns([]string{"d", "age"}, func(key uint32, vals Row) bool {
+		full, found, err := bt.Search(key)
+		if err != nil || !found {
+			t.Fatalf("Search(%d) found=%v err=%v", key, found, err)
+		}
+		if !vals.Equal(Row{full[5], full[3]}) {
+			t.Errorf("key %d: projected %v; want %v", key, vals, Row{full[5], full[3]})
+		}
+		seen++
+		return true
+	})
+	if err != nil || seen != 60 {
+		t.Fatalf("ScanColumns visited %d rows, err %v; want 60, nil", seen, err)
+	}
+	if err := bt.ScanColumns([]string{"zzz"}, func(uint32, Row) bool { return true }); err == nil {
+		t.Error("ScanColumns with an unknown column succeeded; want an error")
+	}
+}
+
+// BenchmarkScan_ProjectOneColumn compares decoding whole rows from the raw
+// leaf bytes with projecting a single column of a wide table.
+func BenchmarkScan_ProjectOneColumn(b *testing.B) {
+	pg, _ := pager.OpenPager(filepath.Join(b.TempDir(), "project.db"))
+	defer pg.Close()
+	meta, _ := BuildTableMeta(wideSchema)
+	bt, _ := NewBTree(pg, meta)
+	for i := uint32(0); i < 200; i++ {
+		bt.Insert(i, Row{i, "alpha", "bravo", int32(i), "charlie", "delta"})
+	}
+
+	b.Run("full-row", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			bt.RawScan(func(_ uint32, rowBytes []byte) bool {
+				row, _ := DeserializeRow(meta, rowBytes)
+				_ = row[3]
+				return true
+			})
+		}
+	})
+	b.Run("project", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			bt.ScanColumns([]string{"age"}, func(_ uint32, vals Row) bool {
+				_ = vals[0]
+				return true
+			})
+		}
+	})
+}
+
+// TestCursor_SkipsEmptyLeaves deletes every key of the first leaf and checks
+// that a new cursor (and Seek into the emptied range) still finds the
+// remaining rows in the following leaves.
+func TestCursor_SkipsEmptyLeaves(t *testing.T) {
+	tp := newTempPager(t)
+	defer tp.cleanup()
+
+	schema := column.Schema{{Name: "id", Type: column.ColumnTypeInt}}
+	meta, _ := BuildTableMeta(schema)
+	bt, _ := NewBTree(tp.Pager, meta)
+	for i := uint32(0); i < 40; i++ {
+		if err := bt.Insert(i, Row{i}); err != nil {
+			t.Fatalf("insert %d: %v", i, err)
+		}
+	}
+
+	first, _, err := bt.firstLeaf()
+	if err != nil {
+		t.Fatalf("firstLeaf: %v", err)
+	}
+	var gone []uint32
+	for _, c := range first.cells {
+		gone = append(gone, c.Key)
+	}
+	for _, k := range gone {
+		if found, err := bt.Delete(k); err != nil || !found {
+			t.Fatalf("Delete(%d) found=%v err=%v", k, found, err)
+		}
+	}
+	if first, _, _ = bt.firstLeaf(); first.header.numCells != 0 {
+		t.Fatalf("first leaf still has %d cells", first.header.numCells)
+	}
+
+	next := gone[len(gone)-1] + 1
+	cur, err := bt.NewCursor()
+	if err != nil {
+		t.Fatalf("NewCursor: %v", err)
+	}
+	if !cur.Valid() || cur.Key() != next {
+		t.Fatalf("NewCursor at valid=%v key=%d; want key %d", cur.Valid(), cur.Key(), next)
+	}
+	n := 0
+	for ; cur.Valid(); cur.Next() {
+		n++
+	}
+	if want := 40 - len(gone); n != want {
+		t.Errorf("scanned %d rows; want %d", n, want)
+	}
+
+	if err := cur.Seek(0); err != nil {
+		t.Fatalf("Seek: %v", err)
+	}
+	if !cur.Valid() || cur.Key() != next {
+		t.Errorf("Seek(0) at valid=%v key=%d; want key %d", cur.Valid(), cur.Key(), next)
+	}
+
+	kc, err := bt.NewKeyCursor()
+	if err != nil {
+		t.Fatalf("NewKeyCursor: %v", err)
+	}
+	if !kc.Valid() || kc.Key() != next {
+		t.Errorf("NewKeyCursor at valid=%v; want key %d", kc.Valid(), next)
+	}
+}
+
+func TestFirstLast(t *testing.T) {
+	tp := newTempPager(t)
+	defer tp.cleanup()
+	schema := column.Schema{
+		{Name: "id", Type: column.ColumnTypeInt},
+		{Name: "name", Type: column.ColumnTypeText, MaxLength: 16},
+	}
+	meta, _ := BuildTableMeta(schema)
+	bt, _ := NewBTree(tp.Pager, meta)
+
+	if _, _, found, err := bt.First(); err != nil || found {
+		t.Fatalf("First on empty tree: found=%v err=%v", found, err)
+	}
+	if _, _, found, err := bt.Last(); err != nil || found {
+		t.Fatalf("Last on empty tree: found=%v err=%v", found, err)
+	}
+
+	for i := uint32(0); i < 50; i++ {
+		key := 10 + (i*13)%50
+		if err := bt.Insert(key, Row{key, fmt.Sprintf("row-%d", key)}); err != nil {
+			t.Fatalf("insert %d: %v", key, err)
+		}
+	}
+
+	key, row, found, err := bt.First()
+	if err != nil || !found || key != 10 || !row.Equal(Row{uint32(10), "row-10"}) {
+		t.Errorf("First() = %d, %v, %v, %v; want 10, row-10", key, row, found, err)
+	}
+	key, row, found, err = bt.Last()
+	if err != nil || !found || key != 59 || !row.Equal(Row{uint32(59), "row-59"}) {
+		t.Errorf("Last() = %d, %v, %v, %v; want 59, row-59", key, row, found, err)
+	}
+
+	// emptying the rightmost leaf must not hide the rows before it
+	for k := uint32(59); k >= 50; k-- {
+		if _, err := bt.Delete(k); err != nil {
+			t.Fatalf("Delete(%d): %v", k, err)
+		}
+	}
+	key, _, found, err = bt.Last()
+	if err != nil || !found || key != 49 {
+		t.Errorf("Last() after deletes = %d, %v, %v; want 49", key, found, err)
+	}
+}
+
+func TestForEach_StopsAtError(t *testing.T) {
+	tp := newTempPager(t)
+	defer tp.cleanup()
+	schema := column.Schema{{Name: "id", Type: column.ColumnTypeInt}}
+	meta, _ := BuildTableMeta(schema)
+	bt, _ := NewBTree(tp.Pager, meta)
+	for i := uint32(0); i < 30; i++ {
+		if err := bt.Insert(i, Row{i}); err != nil {
+			t.Fatalf("insert %d: %v", i, err)
+		}
+	}
+
+	errStop := errors.New("stop")
+	var seen []uint32
+	err := bt.ForEach(func(key uint32, row Row) error {
+		seen = append(seen, key)
+		if len(seen) == 3 {
+			return errStop
+		}
+		return nil
+	})
+	if err != errStop {
+		t.Fatalf("ForEach err = %v; want %v", err, errStop)
+	}
+	if !reflect.DeepEqual(seen, []uint32{0, 1, 2}) {
+		t.Errorf("visited %v; want [0 1 2]", seen)
+	}
+
+	n := 0
+	if err := bt.ForEach(func(uint32, Row) error { n++; return nil }); err != nil || n != 30 {
+		t.Errorf("full ForEach visited %d rows, err %v; want 30, nil", n, err)
+	}
+}
+
+// TestForEachContext_StopsWhenCancelled cancels a scan after a few rows and
+// checks it returns context.Canceled within one check interval, and that a
+// vacuum under a cancelled context leaves the tree untouched.
+func TestForEachContext_StopsWhenCancelled(t *testing.T) {
+	tp := newTempPager(t)
+	defer tp.cleanup()
+	schema := column.Schema{{Name: "id", Type: column.ColumnTypeInt}}
+	meta, _ := BuildTableMeta(schema)
+	bt, _ := NewBTree(tp.Pager, meta)
+	const n = 500
+	for i := uint32(0); i < n; i++ {
+		if err := bt.Insert(i, Row{i}); err != nil {
+			t.Fatalf("insert %d: %v", i, err)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	seen := 0
+	err := bt.ForEachContext(ctx, func(uint32, Row) error {
+		if seen++; seen == 5 {
+			cancel()
+		}
+		return nil
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("ForEachContext err = %v; want context.Canceled", err)
+	}
+	if seen > ctxCheckRows {
+		t.Errorf("scan visited %d rows after cancelling at 5; want at most %d", seen, ctxCheckRows)
+	}
+
+	root := bt.rootPage
+	if err := bt.VacuumContext(ctx); !errors.Is(err, context.Canceled) {
+		t.Fatalf("VacuumContext err = %v; want context.Canceled", err)
+	}
+	if bt.rootPage != root {
+		t.Errorf("cancelled vacuum moved the root from %d to %d", root, bt.rootPage)
+	}
+	seen = 0
+	if err := bt.ForEach(func(uint32, Row) error { seen++; return nil }); err != nil || seen != n {
+		t.Errorf("ForEach after cancelled vacuum visited %d rows, err %v; want %d, nil", seen, err, n)
+	}
+}
+
+// TestAllRows_TypedMapsInKeyOrder exports a mixed-type table and checks each
+// map carries every column name with its Go-typed value, in key order.
+func TestAllRows_TypedMapsInKeyOrder(t *testing.T) {
+	tp := newTempPager(t)
+	defer tp.cleanup()
+	schema := column.Schema{
+		{Name: "id", Type: column.ColumnTypeInt},
+		{Name: "delta", Type: column.ColumnTypeInt32},
+		{Name: "name", Type: column.ColumnTypeText, MaxLength: 8},
+	}
+	meta, _ := BuildTableMeta(schema)
+	bt, _ := NewBTree(tp.Pager, meta)
+	for _, k := range []uint32{20, 3, 15, 8, 1, 12, 30, 5, 25, 18, 9, 2, 40, 33} {
+		if err := bt.Insert(k, Row{k, -int32(k), fmt.Sprintf("n%d", k)}); err != nil {
+			t.Fatalf("insert %d: %v", k, err)
+		}
+	}
+
+	rows, err := bt.AllRows()
+	if err != nil {
+		t.Fatalf("AllRows: %v", err)
+	}
+	if len(rows) != 14 {
+		t.Fatalf("got %d rows; want 14", len(rows))
+	}
+	prev := uint32(0)
+	for i, m := range rows {
+		id, ok := m["id"].(uint32)
+		if !ok || id <= prev {
+			t.Fatalf("row %d: id = %v (%T); want uint32 above %d", i, m["id"], m["id"], prev)
+		}
+		prev = id
+		want := map[string]interface{}{"id": id, "delta": -int32(id), "name": fmt.Sprintf("n%d", id)}
+		if !reflect.DeepEqual(m, want) {
+			t.Errorf("row %d = %v; want %v", i, m, want)
+		}
+	}
+}
+
+// TestExplain_LookupReadsFewerPagesThanScan compares a keyed lookup with a
+// full scan for the same row over a multi-level tree.
+func TestExplain_LookupReadsFewerPagesThanScan(t *testing.T) {
+	tp := newTempPager(t)
+	defer tp.cleanup()
+	schema := column.Schema{{Name: "id", Type: column.ColumnTypeInt}}
+	meta, _ := BuildTableMeta(schema)
+	bt, _ := NewBTree(tp.Pager, meta)
+	const n = 300
+	for i := uint32(0); i < n; i++ {
+		if err := bt.Insert(i, Row{i}); err != nil {
+			t.Fatalf("insert %d: %v", i, err)
+		}
+	}
+
+	lookup, err := bt.ExplainLookup(150)
+	if err != nil {
+		t.Fatalf("ExplainLookup: %v", err)
+	}
+	scan, err := bt.ExplainScan(func(r Row) bool { return r[0] == uint32(150) })
+	if err != nil {
+		t.Fatalf("ExplainScan: %v", err)
+	}
+
+	if !lookup.Seek || scan.Seek {
+		t.Errorf("Seek = %v (lookup), %v (scan); want true, false", lookup.Seek, scan.Seek)
+	}
+	if lookup.RowsReturned != 1 || scan.RowsReturned != 1 {
+		t.Errorf("rows returned = %d (lookup), %d (scan); want 1, 1", lookup.RowsReturned, scan.RowsReturned)
+	}
+	if scan.RowsScanned != n {
+		t.Errorf("scan looked at %d rows; want %d", scan.RowsScanned, n)
+	}
+	h, _ := bt.Height()
+	if lookup.NodesLoaded != h {
+		t.Errorf("lookup loaded %d nodes; want the tree height %d", lookup.NodesLoaded, h)
+	}
+	if scan.NodesLoaded < 5*lookup.NodesLoaded {
+		t.Errorf("scan loaded %d nodes, lookup %d; want the scan far higher", scan.NodesLoaded, lookup.NodesLoaded)
+	}
+	if lookup.PagesRead != 0 || scan.PagesRead != 0 {
+		t.Errorf("pages read with every page cached = %d (lookup), %d (scan); want 0", lookup.PagesRead, scan.PagesRead)
+	}
+
+	// a fresh pager has to read the pages from the file
+	if err := bt.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	p, err := pager.OpenPager(tp.filename)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	bt, err = NewBTree(p, meta)
+	if err != nil {
+		t.Fatalf("NewBTree: %v", err)
+	}
+	defer bt.Close()
+	if lookup, err = bt.ExplainLookup(150); err != nil {
+		t.Fatalf("ExplainLookup after reopen: %v", err)
+	}
+	if scan, err = bt.ExplainScan(func(Row) bool { return true }); err != nil {
+		t.Fatalf("ExplainScan after reopen: %v", err)
+	}
+	if lookup.PagesRead < 1 || lookup.PagesRead > h {
+		t.Errorf("lookup after reopen read %d pages; want 1 to %d", lookup.PagesRead, h)
+	}
+	if scan.PagesRead < 5*lookup.PagesRead {
+		t.Errorf("scan after reopen read %d pages, lookup %d; want the scan far higher", scan.PagesRead, lookup.PagesRead)
+	}
+}
+
+// TestWalkPages_BreadthFirst checks a two-level tree is walked root first,
+// then each child exactly once in left-to-right order.
+func TestWalkPages_BreadthFirst(t *testing.T) {
+	tp := newTempPager(t)
+	defer tp.cleanup()
+	schema := column.Schema{{Name: "id", Type: column.ColumnTypeInt}}
+	meta, _ := BuildTableMeta(schema)
+	bt, _ := NewBTree(tp.Pager, meta)
+	for i := uint32(0); i < 40; i++ {
+		if err := bt.Insert(i, Row{i}); err != nil {
+			t.Fatalf("insert %d: %v", i, err)
+		}
+	}
+	if h, _ := bt.Height(); h != 2 {
+		t.Fatalf("height = %d; want 2", h)
+	}
+
+	var visited []uint32
+	err := bt.WalkPages(func(pgno uint32, node BTreeNode) error {
+		if node.Page() != pgno {
+			t.Errorf("node for page %d reports page %d", pgno, node.Page())
+		}
+		visited = append(visited, pgno)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("WalkPages: %v", err)
+	}
+
+	root, _ := bt.loadNode(bt.rootPage)
+	in := root.(*InteriorNode)
+	want := []uint32{bt.rootPage}
+	for i := 0; i < in.numChildren(); i++ {
+		want = append(want, in.child(i))
+	}
+	if !reflect.DeepEqual(visited, want) {
+		t.Errorf("visited %v; want %v", visited, want)
+	}
+}
+
+// TestCountRange_MatchesMaterializedScan compares CountRange with the length
+// of a materialized range scan, including empty, inverted and whole-tree
+// ranges, over a multi-level tree with a gap left by deletes.
+func TestCountRange_MatchesMaterializedScan(t *testing.T) {
+	tp := newTempPager(t)
+	defer tp.cleanup()
+	schema := column.Schema{
+		{Name: "id", Type: column.ColumnTypeInt},
+		{Name: "name", Type: column.ColumnTypeText, MaxLength: 8},
+	}
+	meta, _ := BuildTableMeta(schema)
+	bt, _ := NewBTree(tp.Pager, meta)
+	for i := uint32(0); i < 200; i += 2 {
+		if err := bt.Insert(i, Row{i, "x"}); err != nil {
+			t.Fatalf("insert %d: %v", i, err)
+		}
+	}
+	for i := uint32(60); i < 90; i += 2 {
+		if _, err := bt.Delete(i); err != nil {
+			t.Fatalf("delete %d: %v", i, err)
+		}
+	}
+
+	ranges := [][2]uint32{
+		{0, math.MaxUint32}, // whole tree
+		{0, 0},
+		{5, 5},     // odd key, absent
+		{4, 4},     // single key
+		{50, 120},  // spans the deleted gap
+		{61, 89},   // entirely inside the gap
+		{300, 400}, // past the last key
+		{10, 5},    // inverted
+		{199, 1000},
+	}
+	for _, r := range ranges {
+		want := 0
+		bt.ForEach(func(key uint32, _ Row) error {
+			if key >= r[0] && key <= r[1] {
+				want++
+			}
+			return nil
+		})
+		got, err := bt.CountRange(r[0], r[1])
+		if err != nil {
+			t.Fatalf("CountRange(%d, %d): %v", r[0], r[1], err)
+		}
+		if got != want {
+			t.Errorf("CountRange(%d, %d) = %d; want %d", r[0], r[1], got, want)
+		}
+	}
+}
+
+// TestKeyRange_MatchesScan checks KeyRange against a full scan over a
+// multi-level tree with keys deleted at both ends, that it reads no more than
+// two root-to-leaf paths, and that an empty tree reports ok false.
+func TestKeyRange_MatchesScan(t *testing.T) {
+	tp := newTempPager(t)
+	defer tp.cleanup()
+	meta, _ := BuildTableMeta(column.Schema{{Name: "id", Type: column.ColumnTypeInt}})
+	bt, _ := NewBTree(tp.Pager, meta)
+	if _, _, _, ok, err := bt.KeyRange(); err != nil || ok {
+		t.Fatalf("KeyRange of an empty tree: ok=%v err=%v; want false, nil", ok, err)
+	}
+	for i := uint32(0); i < 300; i++ {
+		if err := bt.Insert((i*37)%300+10, Row{(i*37)%300 + 10}); err != nil {
+			t.Fatalf("insert: %v", err)
+		}
+	}
+	for i := uint32(10); i < 14; i++ {
+		bt.Delete(i)
+		bt.Delete(319 - (i - 10))
+	}
+
+	var lo, hi uint32
+	count := 0
+	bt.ForEach(func(key uint32, _ Row) error {
+		if count == 0 {
+			lo = key
+		}
+		hi = key
+		count++
+		return nil
+	})
+	height, _ := bt.Height()
+	loads := bt.bTreeMeta.nodeLoads
+	gotLo, gotHi, gotCount, ok, err := bt.KeyRange()
+	if err != nil || !ok {
+		t.Fatalf("KeyRange: ok=%v err=%v", ok, err)
+	}
+	if gotLo != lo || gotHi != hi || gotCount != count {
+		t.Errorf("KeyRange = %d, %d, %d; want %d, %d, %d", gotLo, gotHi, gotCount, lo, hi, count)
+	}
+	if read := bt.bTreeMeta.nodeLoads - loads; read > 2*height+1 {
+		t.Errorf("KeyRange read %d nodes in a tree of height %d", read, height)
+	}
+}
+
+// TestEmptyTree_SeekSearchDelete checks the zero-rows boundary on a fresh
+// root leaf and on a multi-level tree whose rows have all been deleted: Seek
+// leaves the cursor invalid, Search finds nothing and Delete reports not
+// found, all without error.
+func TestEmptyTree_SeekSearchDelete(t *testing.T) {
+	tp := newTempPager(t)
+	defer tp.cleanup()
+	schema := column.Schema{{Name: "id", Type: column.ColumnTypeInt}}
+	meta, _ := BuildTableMeta(schema)
+	bt, err := NewBTree(tp.Pager, meta)
+	if err != nil {
+		t.Fatalf("NewBTree: %v", err)
+	}
+
+	checkEmpty := func(stage string) {
+		t.Helper()
+		c, err := bt.NewCursor()
+		if err != nil || c.Valid() {
+			t.Fatalf("%s: NewCursor valid=%v err=%v; want invalid", stage, c != nil && c.Valid(), err)
+		}
+		for _, k := range []uint32{0, 1, 42, math.MaxUint32} {
+			if err := c.Seek(k); err != nil || c.Valid() {
+				t.Errorf("%s: Seek(%d) valid=%v err=%v; want invalid, nil", stage, k, c.Valid(), err)
+			}
+			if row, found, err := bt.Search(k); err != nil || found || row != nil {
+				t.Errorf("%s: Search(%d) = %v, %v, %v; want nil, false, nil", stage, k, row, found, err)
+			}
+			if found, err := bt.Delete(k); err != nil || found {
+				t.Errorf("%s: Delete(%d) = %v, %v; want false, nil", stage, k, found, err)
+			}
+		}
+		if n, _ := bt.NumRows(); n != 0 {
+			t.Errorf("%s: NumRows = %d; want 0", stage, n)
+		}
+	}
+
+	checkEmpty("fresh tree")
+
+	for i := uint32(1); i <= 100; i++ {
+		if err := bt.Insert(i, Row{i}); err != nil {
+			t.Fatalf("insert %d: %v", i, err)
+		}
+	}
+	if h, _ := bt.Height(); h < 2 {
+		t.Fatalf("height = %d; want an interior root", h)
+	}
+	for i := uint32(1); i <= 100; i++ {
+		if found, err := bt.Delete(i); err != nil || !found {
+			t.Fatalf("Delete(%d) = %v, %v", i, found, err)
+		}
+	}
+	checkEmpty("emptied tree")
+}
+
+// TestCursor_StaleAfterVacuum opens a cursor mid-scan, vacuums the tree under
+// it and checks the cursor reports ErrCursorStale instead of reading freed
+// pages, then that a fresh Seek resumes the scan.
+func TestCursor_StaleAfterVacuum(t *testing.T) {
+	tp := newTempPager(t)
+	defer tp.cleanup()
+	schema := column.Schema{{Name: "id", Type: column.ColumnTypeInt}}
+	meta, _ := BuildTableMeta(schema)
+	bt, _ := NewBTree(tp.Pager, meta)
+	for i := uint32(0); i < 120; i++ {
+		if err := bt.Insert(i, Row{i}); err != nil {
+			t.Fatalf("insert %d: %v", i, err)
+		}
+	}
+	for i := uint32(0); i < 120; i += 3 {
+		if _, err := bt.Delete(i); err != nil {
+			t.Fatalf("delete %d: %v", i, err)
+		}
+	}
+
+	c, err := bt.NewCursor()
+	if err != nil {
+		t.Fatalf("NewCursor: %v", err)
+	}
+	for i := 0; i < 5; i++ {
+		if err := c.Next(); err != nil {
+			t.Fatalf("Next: %v", err)
+		}
+	}
+	last := c.Key()
+
+	if err := bt.Vacuum(); err != nil {
+		t.Fatalf("Vacuum: %v", err)
+	}
+	if c.Valid() {
+		t.Error("cursor still valid after Vacuum")
+	}
+	if err := c.Next(); !errors.Is(err, ErrCursorStale) {
+		t.Fatalf("Next after Vacuum = %v; want ErrCursorStale", err)
+	}
+
+	if err := c.Seek(last + 1); err != nil {
+		t.Fatalf("Seek: %v", err)
+	}
+	want := last + 1
+	for ; c.Valid(); want++ {
+		if want%3 == 0 {
+			want++
+		}
+		if c.Key() != want {
+			t.Fatalf("resumed scan key = %d; want %d", c.Key(), want)
+		}
+		if err := c.Next(); err != nil {
+			t.Fatalf("Next after Seek: %v", err)
+		}
+	}
+	if want != 120 {
+		t.Errorf("resumed scan ended before %d; want 120", want)
+	}
+}
+
+// TestLookupIn_SkipsMissingKeys looks up an unsorted IN list with repeats and
+// absent keys and checks exactly the present keys come back in order.
+func TestLookupIn_SkipsMissingKeys(t *testing.T) {
+	tp := newTempPager(t)
+	defer tp.cleanup()
+	schema := column.Schema{
+		{Name: "id", Type: column.ColumnTypeInt},
+		{Name: "name", Type: column.ColumnTypeText, MaxLength: 8},
+	}
+	meta, _ := BuildTableMeta(schema)
+	bt, _ := NewBTree(tp.Pager, meta)
+	for i := uint32(2); i <= 400; i += 2 {
+		if err := bt.Insert(i, Row{i, fmt.Sprintf("u%d", i)}); err != nil {
+			t.Fatalf("insert %d: %v", i, err)
+		}
+	}
+
+	got, err := bt.LookupIn([]uint32{398, 5, 2, 9, 100, 2, 1000, 0})
+	if err != nil {
+		t.Fatalf("LookupIn: %v", err)
+	}
+
+	var keys []uint32
+	for _, p := range got {
+		keys = append(keys, p.Key)
+		if !p.Row.Equal(Row{p.Key, fmt.Sprintf("u%d", p.Key)}) {
+			t.Errorf("row for %d = %v", p.Key, p.Row)
+		}
+	}
+	if want := []uint32{2, 100, 398}; !reflect.DeepEqual(keys, want) {
+		t.Fatalf("LookupIn keys = %v; want %v", keys, want)
+	}
+}
+
+// TestSeparateValues_RoundTrip writes a multi-leaf tree in the separated
+// key/row layout, reopens the file and checks cursors, key cursors, raw scans
+// and deletes all see the same data.
+func TestSeparateValues_RoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sep.db")
+	schema := column.Schema{
+		{Name: "id", Type: column.ColumnTypeInt},
+		{Name: "name", Type: column.ColumnTypeText, MaxLength: 24},
+		{Name: "delta", Type: column.ColumnTypeInt32},
+	}
+	_, bt, err := OpenTable(path, schema)
+	if err != nil {
+		t.Fatalf("OpenTable: %v", err)
+	}
+	bt.SetSeparateValues(true)
+	rowFor := func(k uint32) Row { return Row{k, fmt.Sprintf("name-%d", k), -int32(k)} }
+	for i := uint32(0); i < 150; i++ {
+		k := (i * 37) % 150
+		if err := bt.Insert(k, rowFor(k)); err != nil {
+			t.Fatalf("insert %d: %v", k, err)
+		}
+	}
+	if err := bt.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	_, bt, err = OpenTable(path, schema)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	defer bt.Close()
+	if p, _ := bt.bTreeMeta.Pager.GetPage(bt.rootPage); p.Data[0] != nodeTypeInterior {
+		t.Fatalf("root type %d; want a multi-level tree", p.Data[0])
+	}
+	leaf, _, _ := bt.firstLeaf()
+	if p, _ := bt.bTreeMeta.Pager.GetPage(leaf.Page()); p.Data[0] != nodeTypeLeafSeparated {
+		t.Fatalf("leaf type %d; want separated layout", p.Data[0])
+	}
+
+	want := uint32(0)
+	err = bt.ForEach(func(key uint32, row Row) error {
+		if key != want || !row.Equal(rowFor(key)) {
+			t.Fatalf("row %d = %d %v", want, key, row)
+		}
+		want++
+		return nil
+	})
+	if err != nil || want != 150 {
+		t.Fatalf("scan ended at %d, err %v", want, err)
+	}
+
+	want = 0
+	err = bt.RawScan(func(key uint32, rowBytes []byte) bool {
+		row, err := DeserializeRow(bt.bTreeMeta.TableMeta, rowBytes)
+		if err != nil || key != want || !row.Equal(rowFor(key)) {
+			t.Fatalf("raw row %d = %d %v (%v)", want, key, row, err)
+		}
+		want++
+		return true
+	})
+	if err != nil || want != 150 {
+		t.Fatalf("raw scan ended at %d, err %v", want, err)
+	}
+	if n, err := bt.CountRange(20, 79); err != nil || n != 60 {
+		t.Errorf("CountRange(20, 79) = %d, %v; want 60", n, err)
+	}
+
+	for k := uint32(0); k < 150; k += 2 {
+		if found, err := bt.Delete(k); err != nil || !found {
+			t.Fatalf("Delete(%d) = %v, %v", k, found, err)
+		}
+	}
+	kc, _ := bt.NewKeyCursor()
+	for want = 1; kc.Valid(); want += 2 {
+		if kc.Key() != want {
+			t.Fatalf("key cursor at %d; want %d", kc.Key(), want)
+		}
+		kc.Next()
+	}
+	if want != 151 {
+		t.Errorf("key cursor ended before %d; want 151", want)
+	}
+}
+
+// BenchmarkKeyScan_SeparateValues scans keys only in the fixed and separated
+// leaf layouts. The keybytes/leaf metric is the span of each leaf a key scan
+// reads: every cell in the fixed layout, just the key array when separated.
+func BenchmarkKeyScan_SeparateValues(b *testing.B) {
+	for _, separate := range []bool{false, true} {
+		name := "fixed"
+		if separate {
+			name = "separated"
+		}
+		b.Run(name, func(b *testing.B) {
+			pg, _ := pager.OpenPager(filepath.Join(b.TempDir(), "scan.db"))
+			defer pg.Close()
+			schema := column.Schema{
+				{Name: "id", Type: column.ColumnTypeInt},
+				{Name: "name", Type: column.ColumnTypeText, MaxLength: 200},
+			}
+			meta, _ := BuildTableMeta(schema)
+			bt, _ := NewBTree(pg, meta)
+			bt.SetSeparateValues(separate)
+			for i := uint32(0); i < 400; i++ {
+				bt.Insert(i, Row{i, "username"})
+			}
+			cells := meta.RowsPerPage()
+			span := headerSize + cells*int(meta.LeafCellSize())
+			if separate {
+				span = headerSize + cells*LeafNodeKeySize
+			}
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				kc, _ := bt.NewKeyCursor()
+				for kc.Valid() {
+					_ = kc.Key()
+					kc.Next()
+				}
+			}
+			b.ReportMetric(float64(span), "keybytes/leaf")
+		})
+	}
+}
+
+// TestCursor_ScanInto scans rows of the demo schema into a tagged struct and
+// checks the fields, then that unknown columns and mismatched types error.
+func TestCursor_ScanInto(t *testing.T) {
+	tp := newTempPager(t)
+	defer tp.cleanup()
+	schema := column.Schema{
+		{Name: "id", Type: column.ColumnTypeInt},
+		{Name: "username", Type: column.ColumnTypeText, MaxLength: 32},
+		{Name: "email", Type: column.ColumnTypeText, MaxLength: 64},
+		{Name: "age", Type: column.ColumnTypeInt},
+	}
+	meta, _ := BuildTableMeta(schema)
+	bt, _ := NewBTree(tp.Pager, meta)
+	bt.Insert(1, Row{uint32(1), "alice", "alice@example.com", uint32(30)})
+	bt.Insert(2, Row{uint32(2), "bob", "bob@example.com", uint32(25)})
+
+	type user struct {
+		ID       uint32 `vqlite:"id"`
+		Name     string `vqlite:"username"`
+		Email    string `vqlite:"email"`
+		Age      uint32 `vqlite:"age"`
+		Comment  string
+		Internal int `vqlite:"-"`
+	}
+	var got []user
+	c, _ := bt.NewCursor()
+	for c.Valid() {
+		u := user{Comment: "keep"}
+		if err := c.ScanInto(&u); err != nil {
+			t.Fatalf("ScanInto: %v", err)
+		}
+		got = append(got, u)
+		c.Next()
+	}
+	want := []user{
+		{ID: 1, Name: "alice", Email: "alice@example.com", Age: 30, Comment: "keep"},
+		{ID: 2, Name: "bob", Email: "bob@example.com", Age: 25, Comment: "keep"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("scanned %+v; want %+v", got, want)
+	}
+
+	c, _ = bt.NewCursor()
+	var unknown struct {
+		Phone string `vqlite:"phone"`
+	}
+	if err := c.ScanInto(&unknown); err == nil || !strings.Contains(err.Error(), "phone") {
+		t.Errorf("ScanInto with unknown column: err = %v; want it to name phone", err)
+	}
+	var wrongType struct {
+		Age string `vqlite:"age"`
+	}
+	if err := c.ScanInto(&wrongType); err == nil {
+		t.Error("ScanInto string field from INT column succeeded; want an error")
+	}
+	if err := c.ScanInto(user{}); err == nil {
+		t.Error("ScanInto with non-pointer succeeded; want an error")
+	}
+}
+
+// dropCaches writes out bt's dirty pages and empties the page and node
+// caches, so the next read of any node comes from the file.
+func dropCaches(tb testing.TB, bt *BTree) {
+	tb.Helper()
+	if err := bt.Sync(); err != nil {
+		tb.Fatalf("Sync: %v", err)
+	}
+	p := bt.bTreeMeta.Pager
+	for pg := range p.Pages {
+		p.Evict(uint32(pg))
+	}
+	bt.bTreeMeta.nodes = nil
+}
+
+// TestQueryCache_ServesRepeatAndInvalidates runs the same select twice with
+// the page and node caches emptied in between and checks the second reads
+// no page from the file, then that an insert makes the next run read the
+// tree again and see the new row.
+func TestQueryCache_ServesRepeatAndInvalidates(t *testing.T) {
+	tp := newTempPager(t)
+	defer tp.cleanup()
+	meta, _ := BuildTableMeta(column.Schema{
+		{Name: "id", Type: column.ColumnTypeInt},
+		{Name: "name", Type: column.ColumnTypeText, MaxLength: 8},
+	})
+	bt, _ := NewBTree(tp.Pager, meta)
+	for i := uint32(1); i <= 50; i++ {
+		if err := bt.Insert(i, Row{i, "user"}); err != nil {
+			t.Fatalf("insert %d: %v", i, err)
+		}
+	}
+	cache := NewQueryCache(bt, 4)
+	selectIn := func() ([]KeyRowPair, error) { return bt.LookupIn([]uint32{3, 30, 60}) }
+
+	first, err := cache.Query("select where id in (3, 30, 60)", selectIn)
+	if err != nil || len(first) != 2 {
+		t.Fatalf("first Query = %d rows, %v; want 2, nil", len(first), err)
+	}
+	dropCaches(t, bt)
+	reads := tp.Pager.Reads
+	second, err := cache.Query("select  where id in (3, 30, 60) ", selectIn)
+	if err != nil || !reflect.DeepEqual(second, first) {
+		t.Fatalf("second Query = %v, %v; want %v", second, err, first)
+	}
+	if n := tp.Pager.Reads - reads; n != 0 {
+		t.Errorf("cached Query read %d pages; want 0", n)
+	}
+
+	if err := bt.Insert(60, Row{uint32(60), "late"}); err != nil {
+		t.Fatalf("insert 60: %v", err)
+	}
+	dropCaches(t, bt)
+	reads = tp.Pager.Reads
+	third, err := cache.Query("select where id in (3, 30, 60)", selectIn)
+	if err != nil || len(third) != 3 {
+		t.Fatalf("Query after insert = %d rows, %v; want 3, nil", len(third), err)
+	}
+	if tp.Pager.Reads == reads {
+		t.Error("Query after insert was served from the cache")
+	}
+}
+
+// TestCountDistinct_IntAndText counts distinct values of an INT and a TEXT
+// column holding repeats, and checks an empty table counts 0.
+func TestCountDistinct_IntAndText(t *testing.T) {
+	tp := newTempPager(t)
+	defer tp.cleanup()
+	meta, _ := BuildTableMeta(column.Schema{
+		{Name: "id", Type: column.ColumnTypeInt},
+		{Name: "city", Type: column.ColumnTypeText, MaxLength: 8},
+		{Name: "age", Type: column.ColumnTypeInt},
+	})
+	bt, _ := NewBTree(tp.Pager, meta)
+	if n, err := bt.CountDistinct("age"); err != nil || n != 0 {
+		t.Errorf("CountDistinct on an empty table = %d, %v; want 0, nil", n, err)
+	}
+	cities := []string{"oslo", "rome", "lima"}
+	for i := uint32(1); i <= 60; i++ {
+		if err := bt.Insert(i, Row{i, cities[i%3], 20 + i%7}); err != nil {
+			t.Fatalf("insert %d: %v", i, err)
+		}
+	}
+	if n, err := bt.CountDistinct("age"); err != nil || n != 7 {
+		t.Errorf("CountDistinct(age) = %d, %v; want 7, nil", n, err)
+	}
+	if n, err := bt.CountDistinct("city"); err != nil || n != 3 {
+		t.Errorf("CountDistinct(city) = %d, %v; want 3, nil", n, err)
+	}
+	if _, err := bt.CountDistinct("zip"); err == nil {
+		t.Error("CountDistinct of an unknown column succeeded")
+	}
+}
+
+// TestSelect_ByRank checks Select(0) is the smallest key, Select(count-1)
+// the largest, a middle rank lands on the right key, and ranks outside the
+// table are not found.
+func TestSelect_ByRank(t *testing.T) {
+	tp := newTempPager(t)
+	defer tp.cleanup()
+	meta, _ := BuildTableMeta(column.Schema{{Name: "id", Type: column.ColumnTypeInt}})
+	bt, _ := NewBTree(tp.Pager, meta)
+	const count = 80
+	for i := uint32(count); i >= 1; i-- {
+		if err := bt.Insert(i*5, Row{i * 5}); err != nil {
+			t.Fatalf("insert %d: %v", i*5, err)
+		}
+	}
+
+	for _, tc := range []struct {
+		n    int
+		want uint32
+	}{{0, 5}, {count - 1, count * 5}, {41, 210}} {
+		key, row, found, err := bt.Select(tc.n)
+		if err != nil || !found || key != tc.want || !row.Equal(Row{tc.want}) {
+			t.Errorf("Select(%d) = %d, %v, %v, %v; want %d", tc.n, key, row, found, err, tc.want)
+		}
+	}
+	for _, n := range []int{-1, count, count + 10} {
+		if _, _, found, err := bt.Select(n); found || err != nil {
+			t.Errorf("Select(%d) found = %v, err = %v; want not found", n, found, err)
+		}
+	}
+}
+
+// TestScanWithLocation_ReportsLeafPages checks rows of one leaf report that
+// leaf's page, the page changes exactly at leaf boundaries, and returning
+// false stops the scan.
+func TestScanWithLocation_ReportsLeafPages(t *testing.T) {
+	tp := newTempPager(t)
+	defer tp.cleanup()
+	meta, _ := BuildTableMeta(column.Schema{{Name: "id", Type: column.ColumnTypeInt}})
+	bt, _ := NewBTree(tp.Pager, meta)
+	for i := uint32(1); i <= 100; i++ {
+		if err := bt.Insert(i, Row{i}); err != nil {
+			t.Fatalf("insert %d: %v", i, err)
+		}
+	}
+	// the page of every key, straight from the leaves
+	want := map[uint32]uint32{}
+	leaf, _, err := bt.firstLeaf()
+	if err != nil {
+		t.Fatalf("firstLeaf: %v", err)
+	}
+	leaves := 0
+	for {
+		leaves++
+		for _, c := range leaf.cells {
+			want[c.Key] = leaf.Page()
+		}
+		if leaf.header.rightPointer == 0 {
+			break
+		}
+		if leaf, err = bt.loadLeafNode(leaf.header.rightPointer); err != nil {
+			t.Fatalf("load leaf: %v", err)
+		}
+	}
+
+	changes, rows := 0, 0
+	var prev uint32
+	err = bt.ScanWithLocation(func(key uint32, row Row, pageNum uint32) bool {
+		if pageNum != want[key] {
+			t.Errorf("key %d on page %d; want %d", key, pageNum, want[key])
+		}
+		if rows > 0 && pageNum != prev {
+			changes++
+		}
+		prev = pageNum
+		rows++
+		return true
+	})
+	if err != nil || rows != 100 {
+		t.Fatalf("ScanWithLocation visited %d rows, err %v; want 100", rows, err)
+	}
+	if changes != leaves-1 {
+		t.Errorf("page changed %d times over %d leaves; want %d", changes, leaves, leaves-1)
+	}
+
+	rows = 0
+	bt.ScanWithLocation(func(uint32, Row, uint32) bool { rows++; return rows < 3 })
+	if rows != 3 {
+		t.Errorf("scan stopped after %d rows; want 3", rows)
+	}
+}
+
+// TestScanFromRoot_ScansOldRoot copies the root leaf aside just before the
+// insert that splits it, and checks a scan from the copy sees the rows as
+// they were while a scan from the new root sees them all.
+func TestScanFromRoot_ScansOldRoot(t *testing.T) {
+	tp := newTempPager(t)
+	defer tp.cleanup()
+	meta, _ := BuildTableMeta(column.Schema{{Name: "id", Type: column.ColumnTypeInt}})
+	bt, _ := NewBTree(tp.Pager, meta)
+	n := uint32(meta.RowsPerPage())
+	for i := uint32(0); i < n; i++ {
+		if err := bt.Insert(i, Row{i}); err != nil {
+			t.Fatalf("insert %d: %v", i, err)
+		}
+	}
+	snap, err := bt.AllocatePage()
+	if err != nil {
+		t.Fatalf("AllocatePage: %v", err)
+	}
+	if err := tp.Pager.CopyPage(bt.rootPage, snap); err != nil {
+		t.Fatalf("CopyPage: %v", err)
+	}
+	oldRoot := bt.rootPage
+	if err := bt.Insert(n, Row{n}); err != nil {
+		t.Fatalf("insert %d: %v", n, err)
+	}
+	if bt.rootPage == oldRoot {
+		t.Fatalf("root still on page %d; want the insert to split it", oldRoot)
+	}
+
+	keys := func(root uint32) []uint32 {
+		var got []uint32
+		err := bt.ScanFromRoot(root, func(key uint32, _ Row) bool {
+			got = append(got, key)
+			return true
+		})
+		if err != nil {
+			t.Fatalf("ScanFromRoot(%d): %v", root, err)
+		}
+		return got
+	}
+	var want []uint32
+	for i := uint32(0); i < n; i++ {
+		want = append(want, i)
+	}
+	if got := keys(snap); !slices.Equal(got, want) {
+		t.Errorf("scan of saved root = %v; want %v", got, want)
+	}
+	if got := keys(bt.rootPage); !slices.Equal(got, append(want, n)) {
+		t.Errorf("scan of current root = %v; want %v", got, append(want, n))
+	}
+	if err := bt.ScanFromRoot(metaPageNum, func(uint32, Row) bool { return true }); err == nil {
+		t.Error("ScanFromRoot(meta page) succeeded; want an error")
+	}
+}
+
+// TestHistogram_EqualDepthBuckets builds a histogram over a skewed key set,
+// dense at the low end and sparse above, and checks every bucket holds its
+// share of rows with boundaries following the keys.
+func TestHistogram_EqualDepthBuckets(t *testing.T) {
+	tp := newTempPager(t)
+	defer tp.cleanup()
+	meta, _ := BuildTableMeta(column.Schema{{Name: "id", Type: column.ColumnTypeInt}})
+	bt, _ := NewBTree(tp.Pager, meta)
+	if h, err := bt.Histogram(4); err != nil || h != nil {
+		t.Errorf("Histogram on an empty tree = %v, %v; want none", h, err)
+	}
+	// keys 1..200, then 1000, 1100, ..., 20900
+	var keys []uint32
+	for k := uint32(1); k <= 200; k++ {
+		keys = append(keys, k)
+	}
+	for k := uint32(1000); k < 21000; k += 100 {
+		keys = append(keys, k)
+	}
+	for _, k := range keys {
+		if err := bt.Insert(k, Row{k}); err != nil {
+			t.Fatalf("insert %d: %v", k, err)
+		}
+	}
+
+	h, err := bt.Histogram(4)
+	if err != nil {
+		t.Fatalf("Histogram: %v", err)
+	}
+	want := []HistBucket{{1, 100, 100}, {101, 200, 100}, {1000, 10900, 100}, {11000, 20900, 100}}
+	if !reflect.DeepEqual(h, want) {
+		t.Errorf("Histogram(4) = %v; want %v", h, want)
+	}
+	h, _ = bt.Histogram(3)
+	total := 0
+	for i, b := range h {
+		total += b.Rows
+		if b.Rows < 132 || b.Rows > 134 || b.Lo > b.Hi || (i > 0 && b.Lo <= h[i-1].Hi) {
+			t.Errorf("bucket %d = %+v out of bounds", i, b)
+		}
+	}
+	if total != len(keys) {
+		t.Errorf("buckets hold %d rows; want %d", total, len(keys))
+	}
+	if _, err := bt.Histogram(0); err == nil {
+		t.Error("Histogram(0) succeeded")
+	}
+}
+
+// TestCursor_StreamEvictBoundsResidentPages scans a reopened tree twice,
+// with and without StreamEvict, and checks an evicting scan never holds more
+// than the meta page, the interior path and two leaves, while a plain scan
+// ends up with every leaf cached. Both see every row.
+func TestCursor_StreamEvictBoundsResidentPages(t *testing.T) {
+	tp := newTempPager(t)
+	defer tp.cleanup()
+	meta, _ := BuildTableMeta(column.Schema{{Name: "id", Type: column.ColumnTypeInt}})
+	bt, _ := NewBTree(tp.Pager, meta)
+	for i := uint32(1); i <= 300; i++ {
+		if err := bt.Insert(i, Row{i}); err != nil {
+			t.Fatalf("insert %d: %v", i, err)
+		}
+	}
+	if err := bt.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	scan := func(evict bool) (rows, peak, leaves int) {
+		p, err := pager.OpenPager(tp.filename)
+		if err != nil {
+			t.Fatalf("reopen: %v", err)
+		}
+		defer p.Close()
+		bt, err := NewBTree(p, meta)
+		if err != nil {
+			t.Fatalf("NewBTree: %v", err)
+		}
+		c, err := bt.NewCursor()
+		if err != nil {
+			t.Fatalf("NewCursor: %v", err)
+		}
+		c.StreamEvict = evict
+		for c.Valid() {
+			rows++
+			peak = max(peak, p.CachedPages())
+			if err := c.Next(); err != nil {
+				t.Fatalf("Next: %v", err)
+			}
+		}
+		return rows, peak, p.NumPages
+	}
+
+	rows, peak, numPages := scan(true)
+	if rows != 300 {
+		t.Fatalf("evicting scan saw %d rows; want 300", rows)
+	}
+	h, _ := bt.Height()
+	bound := 1 + (h - 1) + 2
+	if peak > bound {
+		t.Errorf("evicting scan peaked at %d cached pages; want at most %d", peak, bound)
+	}
+	rows, peak, _ = scan(false)
+	if rows != 300 || peak < numPages/2 {
+		t.Errorf("plain scan saw %d rows, peaked at %d of %d pages; want 300 rows, most pages cached", rows, peak, numPages)
+	}
+}
+
+// TestSuccessorPredecessor checks both around an existing key, inside a gap
+// and at the ends of the key range, across leaf boundaries.
+func TestSuccessorPredecessor(t *testing.T) {
+	tp := newTempPager(t)
+	defer tp.cleanup()
+	meta, _ := BuildTableMeta(column.Schema{{Name: "id", Type: column.ColumnTypeInt}})
+	bt, _ := NewBTree(tp.Pager, meta)
+	for k := uint32(10); k <= 1000; k += 10 {
+		if err := bt.Insert(k, Row{k}); err != nil {
+			t.Fatalf("insert %d: %v", k, err)
+		}
+	}
+
+	for _, tc := range []struct {
+		key        uint32
+		succ, pred uint32 // 0 for none
+	}{
+		{500, 510, 490},
+		{505, 510, 500},
+		{10, 20, 0},
+		{1000, 0, 990},
+		{0, 10, 0},
+		{5000, 0, 1000},
+	} {
+		k, row, found, err := bt.Successor(tc.key)
+		if err != nil || found != (tc.succ != 0) || (found && (k != tc.succ || !row.Equal(Row{k}))) {
+			t.Errorf("Successor(%d) = %d, %v, %v, %v; want %d", tc.key, k, row, found, err, tc.succ)
+		}
+		k, row, found, err = bt.Predecessor(tc.key)
+		if err != nil || found != (tc.pred != 0) || (found && (k != tc.pred || !row.Equal(Row{k}))) {
+			t.Errorf("Predecessor(%d) = %d, %v, %v, %v; want %d", tc.key, k, row, found, err, tc.pred)
+		}
+	}
+}
+
+// TestWarmRootPath_LoadsOnlyFirstPath reopens a three-level tree, warms the
+// root path and checks the root, the interior node below it and the first
+// leaf are cached while no other page but the meta page is.
+func TestWarmRootPath_LoadsOnlyFirstPath(t *testing.T) {
+	tp := newTempPager(t)
+	defer tp.cleanup()
+	meta, _ := BuildTableMeta(column.Schema{{Name: "id", Type: column.ColumnTypeInt}})
+	bt, _ := NewBTree(tp.Pager, meta)
+	for i := uint32(1); i <= 400; i++ {
+		if err := bt.Insert(i, Row{i}); err != nil {
+			t.Fatalf("insert %d: %v", i, err)
+		}
+	}
+	if h, _ := bt.Height(); h < 3 {
+		t.Fatalf("height = %d; want at least 3", h)
+	}
+	if err := bt.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	p, err := pager.OpenPager(tp.filename)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	defer p.Close()
+	bt, err = NewBTree(p, meta)
+	if err != nil {
+		t.Fatalf("NewBTree: %v", err)
+	}
+	if err := bt.WarmRootPath(); err != nil {
+		t.Fatalf("WarmRootPath: %v", err)
+	}
+	cached := func(pgno uint32) bool {
+		return int(pgno) < len(p.Pages) && p.Pages[pgno] != nil && bt.bTreeMeta.cachedNode(pgno) != nil
+	}
+	root := bt.bTreeMeta.cachedNode(bt.rootPage)
+	if root == nil {
+		t.Fatalf("root page %d not cached", bt.rootPage)
+	}
+	mid := root.(*InteriorNode).leftChild
+	if !cached(mid) {
+		t.Errorf("interior page %d on the path not cached", mid)
+	}
+	first := bt.bTreeMeta.cachedNode(mid).(*InteriorNode).leftChild
+	if !cached(first) {
+		t.Fatalf("first leaf %d not cached", first)
+	}
+	if got := p.CachedPages(); got != 4 {
+		t.Errorf("%d pages cached; want the meta page and the three on the path", got)
+	}
+	second := bt.bTreeMeta.cachedNode(first).(*LeafNode).header.rightPointer
+	if second == 0 || cached(second) {
+		t.Errorf("second leaf %d cached after warming the root path", second)
+	}
+}
+
+// TestReadAPIs_ReturnCopies changes the rows returned by every single-row
+// read API and checks the tree, whose leaves stay in the node cache, still
+// holds the original values.
+func TestReadAPIs_ReturnCopies(t *testing.T) {
+	tp := newTempPager(t)
+	defer tp.cleanup()
+	meta, _ := BuildTableMeta(column.Schema{
+		{Name: "id", Type: column.ColumnTypeInt},
+		{Name: "name", Type: column.ColumnTypeText, MaxLength: 8},
+	})
+	bt, err := NewBTree(tp.Pager, meta)
+	if err != nil {
+		t.Fatalf("NewBTree: %v", err)
+	}
+	for i := uint32(1); i <= 30; i++ {
+		if err := bt.Insert(i, Row{i, "orig"}); err != nil {
+			t.Fatalf("insert %d: %v", i, err)
+		}
+	}
+
+	var rows []Row
+	row, _, _ := bt.Search(5)
+	rows = append(rows, row)
+	_, row, _, _ = bt.First()
+	rows = append(rows, row)
+	_, row, _, _ = bt.Last()
+	rows = append(rows, row)
+	_, row, _, _ = bt.Select(10)
+	rows = append(rows, row)
+	_, row, _, _ = bt.Successor(14)
+	rows = append(rows, row)
+	_, row, _, _ = bt.Predecessor(20)
+	rows = append(rows, row)
+	pairs, _ := bt.LookupIn([]uint32{25})
+	rows = append(rows, pairs[0].Row)
+	for i, r := range rows {
+		if r == nil {
+			t.Fatalf("read %d returned no row", i)
+		}
+		r[1] = "changed"
+	}
+
+	err = bt.ForEach(func(key uint32, row Row) error {
+		if row[1] != "orig" {
+			t.Errorf("row %d = %v after changing a returned copy", key, row)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("ForEach: %v", err)
+	}
+}
@@ -1,7 +1,10 @@
 package table
 
 import (
+	"encoding/binary"
 	"os"
+	"reflect"
+	"slices"
 	"testing"
 	"vqlite/column"
 	"vqlite/pager"
@@ -204,3 +207,402 @@ func TestBTreeDelete_EmptyTree(t *testing.T) {
 		t.Errorf("Expected not to find key in empty tree")
 	}
 }
+
+// countLeaves walks the leaf chain from the leftmost leaf.
+func countLeaves(t *testing.T, bt *BTree) int {
+	t.Helper()
+	leaf, _, err := bt.firstLeaf()
+	if err != nil {
+		t.Fatalf("firstLeaf: %v", err)
+	}
+	n := 1
+	for leaf.header.rightPointer != 0 {
+		if leaf, err = bt.loadLeafNode(leaf.header.rightPointer); err != nil {
+			t.Fatalf("loadLeafNode: %v", err)
+		}
+		n++
+	}
+	return n
+}
+
+// TestDefragment_MergesUnderfullLeaves thins out every leaf by deleting most
+// keys, then checks that Defragment reduces the leaf count and frees pages
+// while keeping the remaining keys searchable and in order.
+func TestDefragment_MergesUnderfullLeaves(t *testing.T) {
+	tp := newTempPager(t)
+	defer tp.cleanup()
+
+	schema := column.Schema{{Name: "id", Type: column.ColumnTypeInt}}
+	meta, _ := BuildTableMeta(schema)
+	bt, err := NewBTree(tp.Pager, meta)
+	if err != nil {
+		t.Fatalf("NewBTree: %v", err)
+	}
+	const n = 60
+	for i := uint32(0); i < n; i++ {
+		if err := bt.Insert(i, Row{i}); err != nil {
+			t.Fatalf("insert %d: %v", i, err)
+		}
+	}
+	var kept []uint32
+	for i := uint32(0); i < n; i++ {
+		if i%4 == 0 {
+			kept = append(kept, i)
+			continue
+		}
+		if found, err := bt.Delete(i); err != nil || !found {
+			t.Fatalf("Delete(%d) found=%v err=%v", i, found, err)
+		}
+	}
+
+	before := countLeaves(t, bt)
+	if err := bt.Defragment(); err != nil {
+		t.Fatalf("Defragment: %v", err)
+	}
+	after := countLeaves(t, bt)
+	if after >= before {
+		t.Errorf("leaf count %d -> %d; want fewer", before, after)
+	}
+	if got := len(bt.bTreeMeta.freePages); got != before-after {
+		t.Errorf("%d pages freed; want %d", got, before-after)
+	}
+
+	var got []uint32
+	c, err := bt.NewCursor()
+	if err != nil {
+		t.Fatalf("NewCursor: %v", err)
+	}
+	for ; c.Valid(); c.Next() {
+		got = append(got, c.Key())
+	}
+	if !reflect.DeepEqual(got, kept) {
+		t.Fatalf("keys after Defragment = %v; want %v", got, kept)
+	}
+	for _, k := range kept {
+		if _, found, err := bt.Search(k); err != nil || !found {
+			t.Errorf("Search(%d) found=%v err=%v", k, found, err)
+		}
+	}
+}
+
+// TestRelinkLeaves_RepairsBrokenChain zeroes the rightPointer of a leaf in
+// the middle of the tree, checks a scan now stops there, and checks
+// RelinkLeaves makes a scan visit every key again.
+func TestRelinkLeaves_RepairsBrokenChain(t *testing.T) {
+	tp := newTempPager(t)
+	defer tp.cleanup()
+
+	schema := column.Schema{{Name: "id", Type: column.ColumnTypeInt}}
+	meta, _ := BuildTableMeta(schema)
+	bt, err := NewBTree(tp.Pager, meta)
+	if err != nil {
+		t.Fatalf("NewBTree: %v", err)
+	}
+	const n = 80
+	for i := uint32(0); i < n; i++ {
+		if err := bt.Insert(i, Row{i}); err != nil {
+			t.Fatalf("insert %d: %v", i, err)
+		}
+	}
+	scan := func() int {
+		c, err := bt.NewCursor()
+		if err != nil {
+			t.Fatalf("NewCursor: %v", err)
+		}
+		seen := 0
+		for ; c.Valid(); c.Next() {
+			if c.Key() != uint32(seen) {
+				t.Fatalf("scan key %d at position %d", c.Key(), seen)
+			}
+			seen++
+		}
+		return seen
+	}
+
+	var leaves []*LeafNode
+	bt.WalkPages(func(_ uint32, node BTreeNode) error {
+		if leaf, ok := node.(*LeafNode); ok {
+			leaves = append(leaves, leaf)
+		}
+		return nil
+	})
+	if len(leaves) < 3 {
+		t.Fatalf("tree has %d leaves; want at least 3", len(leaves))
+	}
+	broken := leaves[len(leaves)/2]
+	broken.header.rightPointer = 0
+	if err := bt.serializeNode(broken); err != nil {
+		t.Fatalf("serializeNode: %v", err)
+	}
+	if got := scan(); got >= n {
+		t.Fatalf("scan with a broken chain saw %d keys; want fewer than %d", got, n)
+	}
+
+	if err := bt.RelinkLeaves(); err != nil {
+		t.Fatalf("RelinkLeaves: %v", err)
+	}
+	if got := scan(); got != n {
+		t.Errorf("scan after RelinkLeaves saw %d keys; want %d", got, n)
+	}
+}
+
+// TestFragmentation_RisesAfterDeletesAndDropsAfterVacuum deletes most keys
+// of a packed tree and checks Fragmentation climbs, then that Vacuum brings
+// it back down.
+func TestFragmentation_RisesAfterDeletesAndDropsAfterVacuum(t *testing.T) {
+	tp := newTempPager(t)
+	defer tp.cleanup()
+
+	schema := column.Schema{{Name: "id", Type: column.ColumnTypeInt}}
+	meta, _ := BuildTableMeta(schema)
+	bt, err := NewBTree(tp.Pager, meta)
+	if err != nil {
+		t.Fatalf("NewBTree: %v", err)
+	}
+	const n = 240
+	for i := uint32(0); i < n; i++ {
+		if err := bt.Insert(i, Row{i}); err != nil {
+			t.Fatalf("insert %d: %v", i, err)
+		}
+	}
+	if err := bt.Vacuum(); err != nil {
+		t.Fatalf("Vacuum: %v", err)
+	}
+	packed, err := bt.Fragmentation()
+	if err != nil {
+		t.Fatalf("Fragmentation: %v", err)
+	}
+	if packed > 0.1 {
+		t.Errorf("fragmentation of a vacuumed tree = %.2f; want at most 0.1", packed)
+	}
+
+	for i := uint32(0); i < n; i++ {
+		if i%5 == 0 {
+			continue
+		}
+		if found, err := bt.Delete(i); err != nil || !found {
+			t.Fatalf("Delete(%d) found=%v err=%v", i, found, err)
+		}
+	}
+	thinned, err := bt.Fragmentation()
+	if err != nil {
+		t.Fatalf("Fragmentation: %v", err)
+	}
+	if thinned < 0.5 {
+		t.Errorf("fragmentation after deleting 80%% of keys = %.2f; want above 0.5", thinned)
+	}
+
+	if err := bt.Vacuum(); err != nil {
+		t.Fatalf("Vacuum: %v", err)
+	}
+	if after, err := bt.Fragmentation(); err != nil || after >= thinned || after > 0.1 {
+		t.Errorf("fragmentation after Vacuum = %.2f, %v; want at most 0.1 (was %.2f)", after, err, thinned)
+	}
+}
+
+// TestMinCells_PerNodeKind checks the under-full thresholds are half of each
+// node kind's own capacity, so a wide row lowers the leaf minimum but not the
+// interior one, and that a leaf reports underflow exactly below its minimum.
+func TestMinCells_PerNodeKind(t *testing.T) {
+	narrow, _ := BuildTableMeta(column.Schema{{Name: "id", Type: column.ColumnTypeInt}})
+	wide, _ := BuildTableMeta(column.Schema{
+		{Name: "id", Type: column.ColumnTypeInt},
+		{Name: "body", Type: column.ColumnTypeText, MaxLength: 1000},
+	})
+	for _, m := range []*TableMeta{narrow, wide} {
+		if got, want := m.minLeafCells(), m.RowsPerPage()/2; got != want {
+			t.Errorf("row size %d: minLeafCells = %d; want %d", m.RowSize, got, want)
+		}
+	}
+	if narrow.minLeafCells() == wide.minLeafCells() {
+		t.Errorf("minLeafCells = %d for both row sizes; want the wide rows lower", narrow.minLeafCells())
+	}
+	if minInteriorCells != maxCells/2 {
+		t.Errorf("minInteriorCells = %d; want %d", minInteriorCells, maxCells/2)
+	}
+
+	tp := newTempPager(t)
+	defer tp.cleanup()
+	bt, err := NewBTree(tp.Pager, wide)
+	if err != nil {
+		t.Fatalf("NewBTree: %v", err)
+	}
+	per := uint32(wide.RowsPerPage())
+	for i := uint32(0); i <= per; i++ {
+		if err := bt.Insert(i, Row{i, "x"}); err != nil {
+			t.Fatalf("insert %d: %v", i, err)
+		}
+	}
+	root, err := bt.loadNode(bt.rootPage)
+	if err != nil || root.IsLeaf() {
+		t.Fatalf("root = %v, %v; want an interior node after a split", root, err)
+	}
+	leaf, err := bt.loadLeafNode(root.(*InteriorNode).leftChild)
+	if err != nil {
+		t.Fatalf("loadLeafNode: %v", err)
+	}
+	for len(leaf.cells) > 0 {
+		n := len(leaf.cells)
+		found, under := leaf.Delete(leaf.cells[0].Key)
+		if !found {
+			t.Fatalf("Delete of a key in the leaf not found")
+		}
+		if want := n-1 < wide.minLeafCells(); under != want {
+			t.Errorf("Delete leaving %d cells reported underflow %v; want %v", n-1, under, want)
+		}
+	}
+}
+
+// TestTruncate_EmptiesTree truncates a multi-level tree and checks nothing is
+// left to find, the root is an empty leaf recorded in the meta page, and the
+// freed pages are reused by later inserts instead of growing the file.
+func TestTruncate_EmptiesTree(t *testing.T) {
+	tp := newTempPager(t)
+	defer tp.cleanup()
+	schema := column.Schema{{Name: "id", Type: column.ColumnTypeInt}}
+	meta, _ := BuildTableMeta(schema)
+	bt, _ := NewBTree(tp.Pager, meta)
+	for i := uint32(0); i < 150; i++ {
+		if err := bt.Insert(i, Row{i}); err != nil {
+			t.Fatalf("insert %d: %v", i, err)
+		}
+	}
+	pages := tp.NumPages
+
+	if err := bt.Truncate(false); err != nil {
+		t.Fatalf("Truncate: %v", err)
+	}
+	if c, err := bt.NewCursor(); err != nil || c.Valid() {
+		t.Fatalf("cursor after Truncate valid=%v err=%v; want empty", c != nil && c.Valid(), err)
+	}
+	if _, found, _ := bt.Search(42); found {
+		t.Error("Search(42) found a row after Truncate")
+	}
+	if n, _ := bt.NumRows(); n != 0 {
+		t.Errorf("NumRows = %d; want 0", n)
+	}
+	root, err := bt.loadNode(bt.rootPage)
+	if err != nil || !root.IsLeaf() || len(root.(*LeafNode).cells) != 0 {
+		t.Fatalf("root after Truncate = %v, %v; want an empty leaf", root, err)
+	}
+	mp, _ := tp.GetPage(metaPageNum)
+	if got := binary.LittleEndian.Uint32(mp.Data[metaRootOff:]); got != bt.rootPage {
+		t.Errorf("meta root = %d; want %d", got, bt.rootPage)
+	}
+	if len(bt.bTreeMeta.freePages) == 0 {
+		t.Fatal("no pages freed by Truncate")
+	}
+
+	for i := uint32(0); i < 150; i++ {
+		if err := bt.Insert(i, Row{i}); err != nil {
+			t.Fatalf("reinsert %d: %v", i, err)
+		}
+	}
+	if tp.NumPages != pages {
+		t.Errorf("file grew from %d to %d pages; freed pages were not reused", pages, tp.NumPages)
+	}
+	if n, _ := bt.NumRows(); n != 150 {
+		t.Errorf("NumRows after refill = %d; want 150", n)
+	}
+}
+
+// TestIncrementalVacuum_CompactsInSteps frees pages all over the file with
+// deletes and Defragment, then runs IncrementalVacuum two pages at a time
+// until done, and checks the file holds only live pages and every remaining
+// row, before and after a reopen.
+func TestIncrementalVacuum_CompactsInSteps(t *testing.T) {
+	dbFile := newTempDB(t)
+	defer os.Remove(dbFile)
+	schema := column.Schema{
+		{Name: "id", Type: column.ColumnTypeInt},
+		{Name: "name", Type: column.ColumnTypeText, MaxLength: 200},
+	}
+	_, bt, err := OpenTable(dbFile, schema)
+	if err != nil {
+		t.Fatalf("OpenTable: %v", err)
+	}
+	const n = 400
+	for i := uint32(0); i < n; i++ {
+		if err := bt.Insert(i, Row{i, "row"}); err != nil {
+			t.Fatalf("insert %d: %v", i, err)
+		}
+	}
+	var want []uint32
+	for i := uint32(0); i < n; i++ {
+		if i%40 < 30 {
+			if _, err := bt.Delete(i); err != nil {
+				t.Fatalf("delete %d: %v", i, err)
+			}
+		} else {
+			want = append(want, i)
+		}
+	}
+	if err := bt.Defragment(); err != nil {
+		t.Fatalf("Defragment: %v", err)
+	}
+	p := bt.bTreeMeta.Pager
+	if len(bt.bTreeMeta.freePages) == 0 {
+		t.Fatal("no free pages to vacuum")
+	}
+	start := p.NumPages
+
+	calls := 0
+	for done := false; !done; calls++ {
+		var reclaimed int
+		before := p.NumPages
+		if reclaimed, done, err = bt.IncrementalVacuum(2); err != nil {
+			t.Fatalf("IncrementalVacuum: %v", err)
+		}
+		if reclaimed != before-p.NumPages {
+			t.Errorf("reclaimed %d pages; file shrank by %d", reclaimed, before-p.NumPages)
+		}
+		if calls > start {
+			t.Fatal("IncrementalVacuum never reported done")
+		}
+	}
+	if calls < 2 {
+		t.Errorf("vacuum finished in %d call; want several steps", calls)
+	}
+	check := func(when string) {
+		t.Helper()
+		pages, err := bt.nodePages()
+		if err != nil {
+			t.Fatalf("%s: nodePages: %v", when, err)
+		}
+		if free := len(bt.bTreeMeta.freePages); free != 0 || p.NumPages != len(pages)+1 {
+			t.Errorf("%s: %d pages, %d free, for %d nodes; want %d pages, none free", when, p.NumPages, free, len(pages), len(pages)+1)
+		}
+		if err := bt.Validate(); err != nil {
+			t.Errorf("%s: Validate: %v", when, err)
+		}
+		var got []uint32
+		if err := bt.ForEach(func(key uint32, _ Row) error {
+			got = append(got, key)
+			return nil
+		}); err != nil {
+			t.Fatalf("%s: ForEach: %v", when, err)
+		}
+		if !slices.Equal(got, want) {
+			t.Errorf("%s: %d keys left; want %d", when, len(got), len(want))
+		}
+		for _, k := range []uint32{30, 199, n - 1} {
+			if _, found, err := bt.Search(k); err != nil || !found {
+				t.Errorf("%s: Search(%d) = %v, %v; want found", when, k, found, err)
+			}
+		}
+	}
+	check("after vacuum")
+	if p.NumPages >= start {
+		t.Fatalf("file did not shrink: %d pages, was %d", p.NumPages, start)
+	}
+	if err := bt.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	_, bt, err = OpenTable(dbFile, schema)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	defer bt.Close()
+	p = bt.bTreeMeta.Pager
+	check("after reopen")
+}
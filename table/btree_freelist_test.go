@@ -0,0 +1,230 @@
+package table
+
+import (
+	"encoding/binary"
+	"errors"
+	"os"
+	"testing"
+
+	"vqlite/column"
+	"vqlite/pager"
+)
+
+// TestBTreeClose_TruncatesFreeTail frees the pages at the end of the file and
+// verifies Close shrinks the file to the last live page.
+func TestBTreeClose_TruncatesFreeTail(t *testing.T) {
+	tp := newTempPager(t)
+	defer os.Remove(tp.filename)
+
+	schema := column.Schema{{Name: "id", Type: column.ColumnTypeInt}}
+	meta, _ := BuildTableMeta(schema)
+	bt, err := NewBTree(tp.Pager, meta)
+	if err != nil {
+		t.Fatalf("NewBTree: %v", err)
+	}
+
+	// pages 0 (meta) and 1 (root) exist; add 2, 3 and 4
+	for i := 0; i < 3; i++ {
+		if _, err := bt.AllocatePage(); err != nil {
+			t.Fatalf("AllocatePage: %v", err)
+		}
+	}
+	// keep page 2 live, free the trailing pages 3 and 4
+	for _, pgno := range []uint32{4, 3} {
+		if err := bt.FreePage(pgno); err != nil {
+			t.Fatalf("FreePage(%d): %v", pgno, err)
+		}
+	}
+
+	if err := bt.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	fi, err := os.Stat(tp.filename)
+	if err != nil {
+		t.Fatalf("Stat: %v", err)
+	}
+	if want := int64(3 * pager.PageSize); fi.Size() != want {
+		t.Errorf("file size = %d; want %d", fi.Size(), want)
+	}
+
+	// the free list no longer mentions the truncated pages
+	pg, err := pager.OpenPager(tp.filename)
+	if err != nil {
+		t.Fatalf("OpenPager: %v", err)
+	}
+	defer pg.Close()
+	bt, err = NewBTree(pg, meta)
+	if err != nil {
+		t.Fatalf("reopen NewBTree: %v", err)
+	}
+	if n := len(bt.bTreeMeta.freePages); n != 0 {
+		t.Errorf("free pages after reopen = %d; want 0", n)
+	}
+}
+
+// TestBTreeClose_NoFreeTail verifies Close is a plain flush+close when a free
+// page is not at the end of the file, and that it survives a reopen.
+func TestBTreeClose_NoFreeTail(t *testing.T) {
+	tp := newTempPager(t)
+	defer os.Remove(tp.filename)
+
+	schema := column.Schema{{Name: "id", Type: column.ColumnTypeInt}}
+	meta, _ := BuildTableMeta(schema)
+	bt, _ := NewBTree(tp.Pager, meta)
+
+	bt.AllocatePage() // 2
+	bt.AllocatePage() // 3
+	if err := bt.FreePage(2); err != nil {
+		t.Fatalf("FreePage: %v", err)
+	}
+	if err := bt.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	pg, _ := pager.OpenPager(tp.filename)
+	defer pg.Close()
+	if pg.NumPages != 4 {
+		t.Errorf("NumPages = %d; want 4", pg.NumPages)
+	}
+	bt, err := NewBTree(pg, meta)
+	if err != nil {
+		t.Fatalf("reopen NewBTree: %v", err)
+	}
+
+	// the persisted free page is handed out again before the file grows
+	pgno, err := bt.AllocatePage()
+	if err != nil {
+		t.Fatalf("AllocatePage: %v", err)
+	}
+	if pgno != 2 {
+		t.Errorf("AllocatePage = %d; want reused page 2", pgno)
+	}
+}
+
+// TestAllocatePage_NeverHandsOutMeta checks that page 0 stays the meta page:
+// node allocation skips it even on a pager with no pages yet, FreePage
+// refuses it, and loading it as a node fails while the meta fields read back.
+func TestAllocatePage_NeverHandsOutMeta(t *testing.T) {
+	tp := newTempPager(t)
+	defer tp.cleanup()
+	schema := column.Schema{{Name: "id", Type: column.ColumnTypeInt}}
+	meta, _ := BuildTableMeta(schema)
+
+	// a node created straight on an empty pager reserves page 0 first
+	leaf, err := NewLeafNode(&BTreeMeta{Pager: tp.Pager, TableMeta: meta}, true)
+	if err != nil {
+		t.Fatalf("NewLeafNode: %v", err)
+	}
+	if leaf.Page() == metaPageNum || tp.NumPages != 2 {
+		t.Fatalf("leaf on page %d with %d pages; want page 1 after the meta page", leaf.Page(), tp.NumPages)
+	}
+
+	tp2 := newTempPager(t)
+	defer tp2.cleanup()
+	bt, err := NewBTree(tp2.Pager, meta)
+	if err != nil {
+		t.Fatalf("NewBTree: %v", err)
+	}
+	if bt.rootPage < 1 {
+		t.Errorf("root on page %d; want >= 1", bt.rootPage)
+	}
+	pgno, err := bt.AllocatePage()
+	if err != nil || pgno < 1 {
+		t.Errorf("AllocatePage = %d, %v; want a page >= 1", pgno, err)
+	}
+	if err := bt.FreePage(metaPageNum); err == nil {
+		t.Error("FreePage(0) succeeded; want an error")
+	}
+	if _, err := bt.loadNode(metaPageNum); err == nil {
+		t.Error("loadNode(0) succeeded; want an error")
+	}
+	for i := uint32(1); i <= 30; i++ {
+		if err := bt.Insert(i, Row{i}); err != nil {
+			t.Fatalf("insert %d: %v", i, err)
+		}
+	}
+	mp, _ := tp2.GetPage(metaPageNum)
+	if root := binary.LittleEndian.Uint32(mp.Data[metaRootOff:]); root != bt.rootPage {
+		t.Errorf("meta root = %d; want %d", root, bt.rootPage)
+	}
+	if n, _ := bt.NumRows(); n != 30 {
+		t.Errorf("NumRows = %d; want 30", n)
+	}
+}
+
+// TestAllocatePage_MarkNewDetectsFlushBeforeInit allocates a page with
+// MarkNew set, flushes it without writing a node, and checks that after a
+// reopen loading it fails as uninitialized instead of decoding as an interior.
+func TestAllocatePage_MarkNewDetectsFlushBeforeInit(t *testing.T) {
+	tp := newTempPager(t)
+	defer tp.cleanup()
+	tp.MarkNew = true
+	meta, _ := BuildTableMeta(column.Schema{{Name: "id", Type: column.ColumnTypeInt}})
+	bt, err := NewBTree(tp.Pager, meta)
+	if err != nil {
+		t.Fatalf("NewBTree: %v", err)
+	}
+	pgno, err := bt.AllocatePage()
+	if err != nil {
+		t.Fatalf("AllocatePage: %v", err)
+	}
+	if err := tp.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	p, err := pager.OpenPager(tp.filename)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	defer p.Close()
+	bt, err = NewBTree(p, meta)
+	if err != nil {
+		t.Fatalf("NewBTree after reopen: %v", err)
+	}
+	if _, err := bt.loadNode(pgno); !errors.Is(err, ErrUninitializedPage) {
+		t.Errorf("loadNode(%d) err = %v; want ErrUninitializedPage", pgno, err)
+	}
+	if _, err := bt.loadNode(bt.rootPage); err != nil {
+		t.Errorf("loadNode(root) = %v; want the serialized root leaf", err)
+	}
+}
+
+// TestLoadNode_RefusesFreedPage frees a leaf page that still holds a
+// serialized node and checks loading it fails with ErrPageFreed, even though
+// the node is still cached, until the page is handed out again.
+func TestLoadNode_RefusesFreedPage(t *testing.T) {
+	tp := newTempPager(t)
+	defer tp.cleanup()
+	meta, _ := BuildTableMeta(column.Schema{{Name: "id", Type: column.ColumnTypeInt}})
+	bt, err := NewBTree(tp.Pager, meta)
+	if err != nil {
+		t.Fatalf("NewBTree: %v", err)
+	}
+	leaf, err := NewLeafNode(bt.bTreeMeta, false)
+	if err != nil {
+		t.Fatalf("NewLeafNode: %v", err)
+	}
+	leaf.Insert(7, Row{uint32(7)})
+	if err := bt.serializeNode(leaf); err != nil {
+		t.Fatalf("serialize: %v", err)
+	}
+	if _, err := bt.loadNode(leaf.Page()); err != nil {
+		t.Fatalf("loadNode before free: %v", err)
+	}
+
+	if err := bt.FreePage(leaf.Page()); err != nil {
+		t.Fatalf("FreePage: %v", err)
+	}
+	if _, err := bt.loadNode(leaf.Page()); !errors.Is(err, ErrPageFreed) {
+		t.Errorf("loadNode of a freed page err = %v; want ErrPageFreed", err)
+	}
+
+	pgno, err := bt.AllocatePage()
+	if err != nil || pgno != leaf.Page() {
+		t.Fatalf("AllocatePage = %d, %v; want freed page %d", pgno, err, leaf.Page())
+	}
+	if _, err := bt.loadNode(pgno); errors.Is(err, ErrPageFreed) {
+		t.Errorf("loadNode after reallocation still reports the page free")
+	}
+}
@@ -0,0 +1,103 @@
+package table
+
+import (
+	"errors"
+	"testing"
+
+	"vqlite/column"
+)
+
+// FuzzBTree decodes the input into a sequence of 3-byte operations
+// (op, key low, key high) and applies each to a B-tree and to a reference
+// map, checking after every step that both agree and that a full scan
+// returns the keys in strictly ascending order.
+func FuzzBTree(f *testing.F) {
+	f.Add([]byte{0, 1, 0, 0, 2, 0, 2, 1, 0, 1, 1, 0})
+	ascending := make([]byte, 0, 3*120)
+	for i := 0; i < 120; i++ {
+		ascending = append(ascending, 0, byte(i), 0)
+	}
+	f.Add(ascending)
+	churn := append([]byte(nil), ascending...)
+	for i := 0; i < 120; i += 2 {
+		churn = append(churn, 1, byte(i), 0)
+	}
+	for i := 0; i < 120; i++ {
+		churn = append(churn, 2, byte(i), 0)
+	}
+	f.Add(churn)
+
+	f.Fuzz(func(t *testing.T, ops []byte) {
+		tp := newTempPager(t)
+		defer tp.cleanup()
+		schema := column.Schema{
+			{Name: "id", Type: column.ColumnTypeInt},
+			{Name: "v", Type: column.ColumnTypeInt},
+		}
+		meta, _ := BuildTableMeta(schema)
+		bt, err := NewBTree(tp.Pager, meta)
+		if err != nil {
+			t.Fatalf("NewBTree: %v", err)
+		}
+		ref := map[uint32]Row{}
+
+		for i := 0; i+2 < len(ops); i += 3 {
+			key := (uint32(ops[i+1]) | uint32(ops[i+2])<<8) % 300
+			switch ops[i] % 3 {
+			case 0:
+				row := Row{key, uint32(i)}
+				err := bt.Insert(key, row)
+				if errors.Is(err, ErrOutOfPages) {
+					continue
+				}
+				if err != nil {
+					t.Fatalf("op %d: Insert(%d): %v", i/3, key, err)
+				}
+				ref[key] = row
+			case 1:
+				found, err := bt.Delete(key)
+				if err != nil {
+					t.Fatalf("op %d: Delete(%d): %v", i/3, key, err)
+				}
+				if _, want := ref[key]; found != want {
+					t.Fatalf("op %d: Delete(%d) found=%v; want %v", i/3, key, found, want)
+				}
+				delete(ref, key)
+			case 2:
+				row, found, err := bt.Search(key)
+				if err != nil {
+					t.Fatalf("op %d: Search(%d): %v", i/3, key, err)
+				}
+				want, ok := ref[key]
+				if found != ok || (ok && !row.Equal(want)) {
+					t.Fatalf("op %d: Search(%d) = %v, %v; want %v, %v", i/3, key, row, found, want, ok)
+				}
+			}
+			checkScan(t, bt, ref)
+		}
+	})
+}
+
+// checkScan walks the whole tree with a cursor and compares it with ref.
+func checkScan(t *testing.T, bt *BTree, ref map[uint32]Row) {
+	t.Helper()
+	c, err := bt.NewCursor()
+	if err != nil {
+		t.Fatalf("NewCursor: %v", err)
+	}
+	n := 0
+	var prev uint32
+	for ; c.Valid(); c.Next() {
+		if n > 0 && c.Key() <= prev {
+			t.Fatalf("scan: key %d after %d", c.Key(), prev)
+		}
+		if want, ok := ref[c.Key()]; !ok || !c.Value().Equal(want) {
+			t.Fatalf("scan: key %d row %v; want %v (present=%v)", c.Key(), c.Value(), want, ok)
+		}
+		prev = c.Key()
+		n++
+	}
+	if n != len(ref) {
+		t.Fatalf("scan saw %d keys; want %d", n, len(ref))
+	}
+}
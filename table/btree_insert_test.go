@@ -0,0 +1,795 @@
+package table
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"vqlite/column"
+	"vqlite/pager"
+)
+
+// TestInsert_OutOfPagesLeavesTreeUnchanged fills the root leaf of a pager that
+// has room for only one more page. The next insert needs two (sibling + new
+// root), so it must fail with ErrOutOfPages before touching the tree.
+func TestInsert_OutOfPagesLeavesTreeUnchanged(t *testing.T) {
+	tp := newTempPager(t)
+	defer tp.cleanup()
+
+	schema := column.Schema{{Name: "id", Type: column.ColumnTypeInt}}
+	meta, _ := BuildTableMeta(schema)
+	bt, err := NewBTree(tp.Pager, meta)
+	if err != nil {
+		t.Fatalf("NewBTree: %v", err)
+	}
+	for i := uint32(0); i < maxCells; i++ {
+		if err := bt.Insert(i, Row{i}); err != nil {
+			t.Fatalf("insert %d: %v", i, err)
+		}
+	}
+
+	tp.MaxPages = tp.NumPages + 1
+	pagesBefore := tp.NumPages
+	rootBefore := bt.rootPage
+
+	err = bt.Insert(maxCells, Row{uint32(maxCells)})
+	if !errors.Is(err, ErrOutOfPages) {
+		t.Fatalf("insert err = %v; want ErrOutOfPages", err)
+	}
+	if tp.NumPages != pagesBefore || bt.rootPage != rootBefore {
+		t.Errorf("tree changed: pages %d->%d, root %d->%d", pagesBefore, tp.NumPages, rootBefore, bt.rootPage)
+	}
+	if _, found, _ := bt.Search(maxCells); found {
+		t.Errorf("key %d present after failed insert", maxCells)
+	}
+	cur, _ := bt.NewCursor()
+	n := uint32(0)
+	for cur.Valid() {
+		if cur.Key() != n {
+			t.Fatalf("key %d = %d", n, cur.Key())
+		}
+		n++
+		cur.Next()
+	}
+	if n != maxCells {
+		t.Errorf("rows after failed insert = %d; want %d", n, maxCells)
+	}
+
+	// overwriting an existing key never splits, so it still works
+	if err := bt.Insert(3, Row{uint32(3)}); err != nil {
+		t.Errorf("overwrite at capacity: %v", err)
+	}
+
+	// once capacity is available again the same insert succeeds
+	tp.MaxPages = tp.NumPages + 2
+	if err := bt.Insert(maxCells, Row{uint32(maxCells)}); err != nil {
+		t.Fatalf("retry insert: %v", err)
+	}
+	if _, found, _ := bt.Search(maxCells); !found {
+		t.Errorf("key %d missing after retry", maxCells)
+	}
+}
+
+// TestHooks_SplitAndAllocate registers callbacks and inserts enough keys to
+// split the root leaf, checking the split key and the allocated pages.
+func TestHooks_SplitAndAllocate(t *testing.T) {
+	tp := newTempPager(t)
+	defer tp.cleanup()
+
+	schema := column.Schema{{Name: "id", Type: column.ColumnTypeInt}}
+	meta, _ := BuildTableMeta(schema)
+	bt, _ := NewBTree(tp.Pager, meta)
+
+	type split struct{ page, key uint32 }
+	var splits []split
+	var allocs []uint32
+	bt.SetHooks(Hooks{
+		OnSplit:    func(page, key uint32) { splits = append(splits, split{page, key}) },
+		OnAllocate: func(page uint32) { allocs = append(allocs, page) },
+	})
+
+	for i := uint32(0); i < maxCells; i++ {
+		bt.Insert(i, Row{i})
+	}
+	if len(splits) != 0 || len(allocs) != 0 {
+		t.Fatalf("hooks fired before the leaf was full: splits=%v allocs=%v", splits, allocs)
+	}
+
+	rootPage := bt.rootPage
+	if err := bt.Insert(maxCells, Row{uint32(maxCells)}); err != nil {
+		t.Fatalf("insert: %v", err)
+	}
+
+	if len(splits) != 1 {
+		t.Fatalf("split hook fired %d times; want 1", len(splits))
+	}
+	if want := (split{rootPage, maxCells / 2}); splits[0] != want {
+		t.Errorf("split = %+v; want %+v", splits[0], want)
+	}
+	// one page for the new sibling leaf, one for the new root
+	if len(allocs) != 2 {
+		t.Errorf("allocations = %v; want 2 pages", allocs)
+	}
+}
+
+// TestNodeCache_ReusesSplitSibling verifies that after an insert splits the
+// root leaf, loading the new sibling returns the instance created during the
+// split rather than a fresh copy decoded from its page.
+func TestNodeCache_ReusesSplitSibling(t *testing.T) {
+	tp := newTempPager(t)
+	defer tp.cleanup()
+
+	schema := column.Schema{{Name: "id", Type: column.ColumnTypeInt}}
+	meta, _ := BuildTableMeta(schema)
+	bt, _ := NewBTree(tp.Pager, meta)
+
+	var sibPage uint32
+	bt.SetHooks(Hooks{OnAllocate: func(page uint32) {
+		if sibPage == 0 {
+			sibPage = page // the sibling is allocated before the new root
+		}
+	}})
+	for i := uint32(0); i <= maxCells; i++ {
+		if err := bt.Insert(i, Row{i}); err != nil {
+			t.Fatalf("insert %d: %v", i, err)
+		}
+	}
+	if sibPage == 0 {
+		t.Fatalf("no split happened")
+	}
+
+	created := bt.bTreeMeta.cachedNode(sibPage)
+	if created == nil {
+		t.Fatalf("sibling page %d not cached after split", sibPage)
+	}
+	loaded, err := bt.loadNode(sibPage)
+	if err != nil {
+		t.Fatalf("loadNode: %v", err)
+	}
+	if loaded != created {
+		t.Errorf("loadNode returned a new instance for cached page %d", sibPage)
+	}
+
+	// a mutation through the tree is visible on the cached instance
+	if err := bt.Insert(maxCells+1, Row{uint32(maxCells + 1)}); err != nil {
+		t.Fatalf("insert: %v", err)
+	}
+	leaf := loaded.(*LeafNode)
+	if last := leaf.cells[len(leaf.cells)-1].Key; last != maxCells+1 {
+		t.Errorf("cached sibling last key = %d; want %d", last, maxCells+1)
+	}
+}
+
+func TestHeight(t *testing.T) {
+	tp := newTempPager(t)
+	defer tp.cleanup()
+
+	schema := column.Schema{{Name: "id", Type: column.ColumnTypeInt}}
+	meta, _ := BuildTableMeta(schema)
+	bt, err := NewBTree(tp.Pager, meta)
+	if err != nil {
+		t.Fatalf("NewBTree: %v", err)
+	}
+	for i := uint32(0); i < 3; i++ {
+		if err := bt.Insert(i, Row{i}); err != nil {
+			t.Fatalf("insert %d: %v", i, err)
+		}
+	}
+	if h, err := bt.Height(); err != nil || h != 1 {
+		t.Fatalf("Height() = %d, %v; want 1", h, err)
+	}
+
+	for i := uint32(3); i <= maxCells; i++ {
+		if err := bt.Insert(i, Row{i}); err != nil {
+			t.Fatalf("insert %d: %v", i, err)
+		}
+	}
+	if h, err := bt.Height(); err != nil || h != 2 {
+		t.Fatalf("Height() = %d, %v; want 2", h, err)
+	}
+}
+
+// TestSplitPolicy_RightBiasedDenserForSequentialKeys inserts ascending keys
+// under both policies and compares the average number of cells per leaf.
+func TestSplitPolicy_RightBiasedDenserForSequentialKeys(t *testing.T) {
+	const n = 200
+	fill := func(p SplitPolicy) float64 {
+		tp := newTempPager(t)
+		defer tp.cleanup()
+		schema := column.Schema{{Name: "id", Type: column.ColumnTypeInt}}
+		meta, _ := BuildTableMeta(schema)
+		bt, err := NewBTree(tp.Pager, meta)
+		if err != nil {
+			t.Fatalf("NewBTree: %v", err)
+		}
+		bt.SetSplitPolicy(p)
+		for i := uint32(0); i < n; i++ {
+			if err := bt.Insert(i, Row{i}); err != nil {
+				t.Fatalf("insert %d: %v", i, err)
+			}
+		}
+		for i := uint32(0); i < n; i++ {
+			if _, found, err := bt.Search(i); err != nil || !found {
+				t.Fatalf("policy %d: Search(%d) found=%v err=%v", p, i, found, err)
+			}
+		}
+		return float64(n) / float64(countLeaves(t, bt))
+	}
+
+	balanced, biased := fill(Balanced), fill(RightBiased)
+	if biased <= balanced {
+		t.Errorf("average leaf fill: RightBiased %.1f, Balanced %.1f; want RightBiased higher", biased, balanced)
+	}
+}
+
+// TestSplitPolicy_AdaptiveFillsAppendedLeaves checks that Adaptive packs
+// leaves nearly full for ascending keys, yet still splits a leaf evenly when
+// the key that overflowed it landed in the middle.
+func TestSplitPolicy_AdaptiveFillsAppendedLeaves(t *testing.T) {
+	tp := newTempPager(t)
+	defer tp.cleanup()
+	schema := column.Schema{{Name: "id", Type: column.ColumnTypeInt}}
+	meta, _ := BuildTableMeta(schema)
+	bt, err := NewBTree(tp.Pager, meta)
+	if err != nil {
+		t.Fatalf("NewBTree: %v", err)
+	}
+	bt.SetSplitPolicy(Adaptive)
+	const n = 600
+	for i := uint32(0); i < n; i++ {
+		if err := bt.Insert(i, Row{i}); err != nil {
+			t.Fatalf("insert %d: %v", i, err)
+		}
+	}
+	if fill := float64(n) / float64(countLeaves(t, bt)); fill < 0.95*maxCells {
+		t.Errorf("average leaf fill = %.2f; want close to %d", fill, maxCells)
+	}
+	for i := uint32(0); i < n; i++ {
+		if _, found, err := bt.Search(i); err != nil || !found {
+			t.Fatalf("Search(%d) found=%v err=%v", i, found, err)
+		}
+	}
+
+	tp2 := newTempPager(t)
+	defer tp2.cleanup()
+	mid, err := NewBTree(tp2.Pager, meta)
+	if err != nil {
+		t.Fatalf("NewBTree: %v", err)
+	}
+	mid.SetSplitPolicy(Adaptive)
+	for i := uint32(0); i < maxCells; i++ {
+		if err := mid.Insert(i*2, Row{i * 2}); err != nil {
+			t.Fatalf("insert %d: %v", i*2, err)
+		}
+	}
+	if err := mid.Insert(maxCells-1, Row{uint32(maxCells - 1)}); err != nil {
+		t.Fatalf("insert middle key: %v", err)
+	}
+	leaf, _, err := mid.firstLeaf()
+	if err != nil {
+		t.Fatalf("firstLeaf: %v", err)
+	}
+	if got := len(leaf.cells); got != (maxCells+1)/2 {
+		t.Errorf("left leaf after a middle split holds %d cells; want %d", got, (maxCells+1)/2)
+	}
+}
+
+// TestInsertBatch_CountsInsertsAndUpdates inserts a batch repeating some keys,
+// some already in the tree and some earlier in the batch, and checks the
+// inserted and updated counts and that the last row for each key wins.
+func TestInsertBatch_CountsInsertsAndUpdates(t *testing.T) {
+	tp := newTempPager(t)
+	defer tp.cleanup()
+	schema := column.Schema{{Name: "id", Type: column.ColumnTypeInt}, {Name: "v", Type: column.ColumnTypeInt}}
+	meta, _ := BuildTableMeta(schema)
+	bt, err := NewBTree(tp.Pager, meta)
+	if err != nil {
+		t.Fatalf("NewBTree: %v", err)
+	}
+	for i := uint32(0); i < 10; i++ {
+		if err := bt.Insert(i, Row{i, uint32(0)}); err != nil {
+			t.Fatalf("insert %d: %v", i, err)
+		}
+	}
+
+	var batch []KeyRowPair
+	for i := uint32(5); i < 40; i++ { // 5..9 exist already
+		batch = append(batch, KeyRowPair{Key: i, Row: Row{i, uint32(1)}})
+	}
+	for i := uint32(20); i < 23; i++ { // repeats within the batch
+		batch = append(batch, KeyRowPair{Key: i, Row: Row{i, uint32(2)}})
+	}
+	inserted, updated, err := bt.InsertBatch(batch)
+	if err != nil {
+		t.Fatalf("InsertBatch: %v", err)
+	}
+	if inserted != 30 || updated != 8 {
+		t.Errorf("InsertBatch = %d inserted, %d updated; want 30, 8", inserted, updated)
+	}
+	if rows, err := bt.NumRows(); err != nil || rows != 40 {
+		t.Errorf("NumRows = %d, %v; want 40, nil", rows, err)
+	}
+	for key, want := range map[uint32]uint32{3: 0, 7: 1, 21: 2, 39: 1} {
+		row, found, err := bt.Search(key)
+		if err != nil || !found || row[1] != want {
+			t.Errorf("Search(%d) = %v, %v, %v; want v=%d", key, row, found, err, want)
+		}
+	}
+
+	inserted, updated, err = bt.InsertBatch([]KeyRowPair{{Key: 50, Row: Row{uint32(50), uint32(0)}}, {Key: 51, Row: Row{"bad"}}})
+	if err == nil || inserted != 1 || updated != 0 {
+		t.Errorf("InsertBatch with a bad row = %d, %d, %v; want 1, 0 and an error", inserted, updated, err)
+	}
+}
+
+// dirtyPages counts cached pages not yet written to the file.
+func dirtyPages(p *pager.Pager) int {
+	n := 0
+	for _, pg := range p.Pages {
+		if pg != nil && pg.Dirty {
+			n++
+		}
+	}
+	return n
+}
+
+func TestDeferredFlush(t *testing.T) {
+	for _, deferred := range []bool{true, false} {
+		tp := newTempPager(t)
+		schema := column.Schema{{Name: "id", Type: column.ColumnTypeInt}}
+		meta, _ := BuildTableMeta(schema)
+		bt, _ := NewBTree(tp.Pager, meta)
+		bt.SetDeferredFlush(deferred)
+		for i := uint32(0); i < 30; i++ {
+			if err := bt.Insert(i, Row{i}); err != nil {
+				t.Fatalf("insert %d: %v", i, err)
+			}
+		}
+		if _, err := bt.Delete(3); err != nil {
+			t.Fatalf("Delete: %v", err)
+		}
+		if got := dirtyPages(tp.Pager); deferred && got == 0 {
+			t.Errorf("deferred: no dirty pages before Sync")
+		} else if !deferred && got != 0 {
+			t.Errorf("write-through: %d dirty pages after Delete", got)
+		}
+		if err := bt.Sync(); err != nil {
+			t.Fatalf("Sync: %v", err)
+		}
+		if got := dirtyPages(tp.Pager); got != 0 {
+			t.Errorf("deferred=%v: %d dirty pages after Sync", deferred, got)
+		}
+		tp.cleanup()
+	}
+}
+
+// BenchmarkInsert_DeferredFlush compares 10k sequential inserts with
+// flushing deferred to the end against flushing after every insert.
+func BenchmarkInsert_DeferredFlush(b *testing.B) {
+	for _, deferred := range []bool{true, false} {
+		name := "immediate"
+		if deferred {
+			name = "deferred"
+		}
+		b.Run(name, func(b *testing.B) {
+			schema := column.Schema{{Name: "id", Type: column.ColumnTypeInt}}
+			meta, _ := BuildTableMeta(schema)
+			for i := 0; i < b.N; i++ {
+				p, err := pager.OpenPager(filepath.Join(b.TempDir(), "bench.db"))
+				if err != nil {
+					b.Fatalf("OpenPager: %v", err)
+				}
+				p.MaxPages = 1 << 16
+				bt, _ := NewBTree(p, meta)
+				bt.SetDeferredFlush(deferred)
+				for k := uint32(0); k < 10000; k++ {
+					if err := bt.Insert(k, Row{k}); err != nil {
+						b.Fatalf("insert %d: %v", k, err)
+					}
+				}
+				if err := bt.Sync(); err != nil {
+					b.Fatalf("Sync: %v", err)
+				}
+				p.File.Close()
+			}
+		})
+	}
+}
+
+// TestInsert_SeparatorKeyRoutesLikeSearch inserts keys equal to the root's
+// separator, both as an overwrite and after deleting it, and checks Search
+// finds exactly the stored row with no duplicate left behind.
+func TestInsert_SeparatorKeyRoutesLikeSearch(t *testing.T) {
+	tp := newTempPager(t)
+	defer tp.cleanup()
+	schema := column.Schema{
+		{Name: "id", Type: column.ColumnTypeInt},
+		{Name: "v", Type: column.ColumnTypeInt},
+	}
+	meta, _ := BuildTableMeta(schema)
+	bt, _ := NewBTree(tp.Pager, meta)
+	for i := uint32(0); i <= maxCells; i++ {
+		if err := bt.Insert(i, Row{i, uint32(0)}); err != nil {
+			t.Fatalf("insert %d: %v", i, err)
+		}
+	}
+	root, err := bt.loadNode(bt.rootPage)
+	if err != nil || root.IsLeaf() {
+		t.Fatalf("root is not interior after %d inserts (err %v)", maxCells+1, err)
+	}
+	sep := root.(*InteriorNode).cells[0].Key
+
+	if err := bt.Insert(sep, Row{sep, uint32(1)}); err != nil {
+		t.Fatalf("overwrite %d: %v", sep, err)
+	}
+	if row, found, err := bt.Search(sep); err != nil || !found || !row.Equal(Row{sep, uint32(1)}) {
+		t.Fatalf("Search(%d) after overwrite = %v, %v, %v", sep, row, found, err)
+	}
+
+	if found, err := bt.Delete(sep); err != nil || !found {
+		t.Fatalf("Delete(%d) found=%v err=%v", sep, found, err)
+	}
+	if err := bt.Insert(sep, Row{sep, uint32(2)}); err != nil {
+		t.Fatalf("reinsert %d: %v", sep, err)
+	}
+	if row, found, err := bt.Search(sep); err != nil || !found || !row.Equal(Row{sep, uint32(2)}) {
+		t.Fatalf("Search(%d) after reinsert = %v, %v, %v", sep, row, found, err)
+	}
+
+	n := 0
+	bt.ForEach(func(key uint32, _ Row) error {
+		if key == sep {
+			n++
+		}
+		return nil
+	})
+	if n != 1 {
+		t.Errorf("key %d appears %d times in a scan; want 1", sep, n)
+	}
+}
+
+// TestNumRows_PersistsAcrossReopen counts inserts, overwrites and deletes and
+// checks the stored count after closing and reopening the file.
+func TestNumRows_PersistsAcrossReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rows.db")
+	schema := column.Schema{
+		{Name: "id", Type: column.ColumnTypeInt},
+		{Name: "v", Type: column.ColumnTypeInt},
+	}
+	meta, _ := BuildTableMeta(schema)
+
+	pg, err := pager.OpenPager(path)
+	if err != nil {
+		t.Fatalf("OpenPager: %v", err)
+	}
+	bt, _ := NewBTree(pg, meta)
+	if n, err := bt.NumRows(); err != nil || n != 0 {
+		t.Fatalf("NumRows on new tree = %d, %v; want 0", n, err)
+	}
+	for i := uint32(0); i < 40; i++ {
+		if err := bt.Insert(i, Row{i, uint32(0)}); err != nil {
+			t.Fatalf("insert %d: %v", i, err)
+		}
+	}
+	for i := uint32(0); i < 10; i++ {
+		if err := bt.Insert(i, Row{i, uint32(1)}); err != nil {
+			t.Fatalf("overwrite %d: %v", i, err)
+		}
+	}
+	if n, _ := bt.NumRows(); n != 40 {
+		t.Errorf("NumRows after overwrites = %d; want 40", n)
+	}
+	for _, k := range []uint32{3, 17, 39, 1000} {
+		if _, err := bt.Delete(k); err != nil {
+			t.Fatalf("Delete(%d): %v", k, err)
+		}
+	}
+	if err := bt.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	pg, err = pager.OpenPager(path)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	defer pg.Close()
+	bt, err = NewBTree(pg, meta)
+	if err != nil {
+		t.Fatalf("reopen NewBTree: %v", err)
+	}
+	if n, err := bt.NumRows(); err != nil || n != 37 {
+		t.Errorf("NumRows after reopen = %d, %v; want 37", n, err)
+	}
+}
+
+func TestSkipSameRow(t *testing.T) {
+	tp := newTempPager(t)
+	defer tp.cleanup()
+	schema := column.Schema{
+		{Name: "id", Type: column.ColumnTypeInt},
+		{Name: "name", Type: column.ColumnTypeText, MaxLength: 8},
+	}
+	meta, _ := BuildTableMeta(schema)
+	bt, _ := NewBTree(tp.Pager, meta)
+	bt.SetSkipSameRow(true)
+	for i := uint32(0); i < 20; i++ {
+		if err := bt.Insert(i, Row{i, "a"}); err != nil {
+			t.Fatalf("insert %d: %v", i, err)
+		}
+	}
+	if err := bt.Sync(); err != nil {
+		t.Fatalf("Sync: %v", err)
+	}
+
+	if err := bt.Insert(7, Row{uint32(7), "a"}); err != nil {
+		t.Fatalf("same-row overwrite: %v", err)
+	}
+	if n := dirtyPages(tp.Pager); n != 0 {
+		t.Errorf("same-row overwrite dirtied %d pages; want 0", n)
+	}
+
+	if err := bt.Insert(7, Row{uint32(7), "b"}); err != nil {
+		t.Fatalf("overwrite: %v", err)
+	}
+	if n := dirtyPages(tp.Pager); n != 1 {
+		t.Errorf("changing overwrite dirtied %d pages; want 1", n)
+	}
+	if row, _, _ := bt.Search(7); !row.Equal(Row{uint32(7), "b"}) {
+		t.Errorf("Search(7) = %v; want [7 b]", row)
+	}
+}
+
+// TestInsert_EmptyAndPopulatedTree inserts through the two-argument API into a
+// fresh root leaf, then into a tree that has grown interior levels, checking
+// every key is found and that re-inserting a key replaces its row.
+func TestInsert_EmptyAndPopulatedTree(t *testing.T) {
+	tp := newTempPager(t)
+	defer tp.cleanup()
+
+	schema := column.Schema{
+		{Name: "id", Type: column.ColumnTypeInt},
+		{Name: "v", Type: column.ColumnTypeInt},
+	}
+	meta, _ := BuildTableMeta(schema)
+	bt, err := NewBTree(tp.Pager, meta)
+	if err != nil {
+		t.Fatalf("NewBTree: %v", err)
+	}
+
+	if err := bt.Insert(500, Row{uint32(500), uint32(0)}); err != nil {
+		t.Fatalf("insert into empty tree: %v", err)
+	}
+	if row, found, err := bt.Search(500); err != nil || !found || !row.Equal(Row{uint32(500), uint32(0)}) {
+		t.Fatalf("Search(500) = %v, %v, %v; want the inserted row", row, found, err)
+	}
+	if h, _ := bt.Height(); h != 1 {
+		t.Errorf("height after first insert = %d; want 1", h)
+	}
+
+	// descending keys split the leftmost leaf again and again
+	for i := uint32(499); i >= 300; i-- {
+		if err := bt.Insert(i, Row{i, uint32(0)}); err != nil {
+			t.Fatalf("insert %d: %v", i, err)
+		}
+	}
+	if h, _ := bt.Height(); h < 3 {
+		t.Fatalf("height = %d; want interior levels after 201 inserts", h)
+	}
+	for i := uint32(501); i <= 700; i++ {
+		if err := bt.Insert(i, Row{i, uint32(0)}); err != nil {
+			t.Fatalf("insert %d: %v", i, err)
+		}
+	}
+	if err := bt.Insert(450, Row{uint32(450), uint32(1)}); err != nil {
+		t.Fatalf("overwrite 450: %v", err)
+	}
+
+	if n, _ := bt.NumRows(); n != 401 {
+		t.Errorf("NumRows = %d; want 401", n)
+	}
+	want := uint32(300)
+	err = bt.ForEach(func(key uint32, row Row) error {
+		if key != want {
+			t.Fatalf("scan key = %d; want %d", key, want)
+		}
+		if v := row[1].(uint32); (key == 450) != (v == 1) {
+			t.Errorf("key %d has v = %d", key, v)
+		}
+		want++
+		return nil
+	})
+	if err != nil || want != 701 {
+		t.Errorf("scan ended at %d, err %v; want 701, nil", want, err)
+	}
+}
+
+// TestDuplicates_RunKeepsInsertionOrder stores many rows under one key among
+// unique neighbours, enough for the run to span leaves, and checks Seek lands
+// on the first of them and iteration returns every one in insertion order.
+func TestDuplicates_RunKeepsInsertionOrder(t *testing.T) {
+	tp := newTempPager(t)
+	defer tp.cleanup()
+	schema := column.Schema{
+		{Name: "id", Type: column.ColumnTypeInt},
+		{Name: "seq", Type: column.ColumnTypeInt},
+	}
+	meta, _ := BuildTableMeta(schema)
+	bt, err := NewBTree(tp.Pager, meta)
+	if err != nil {
+		t.Fatalf("NewBTree: %v", err)
+	}
+	bt.SetDuplicates(true)
+
+	for i := uint32(1); i <= 40; i++ {
+		if err := bt.Insert(i*10, Row{i * 10, uint32(0)}); err != nil {
+			t.Fatalf("insert %d: %v", i*10, err)
+		}
+	}
+	const dup, runLen = 200, 3 * maxCells
+	for seq := uint32(1); seq <= runLen; seq++ {
+		if err := bt.Insert(dup, Row{uint32(dup), seq}); err != nil {
+			t.Fatalf("insert duplicate %d: %v", seq, err)
+		}
+	}
+	if n, _ := bt.NumRows(); n != 40+runLen {
+		t.Errorf("NumRows = %d; want %d", n, 40+runLen)
+	}
+
+	c, _ := bt.NewCursor()
+	if err := c.Seek(dup); err != nil {
+		t.Fatalf("Seek: %v", err)
+	}
+	// the unique row inserted first leads the run, then the duplicates in order
+	for want := uint32(0); want <= runLen; want++ {
+		if !c.Valid() || c.Key() != dup {
+			t.Fatalf("run ended after %d rows; want %d", want, runLen+1)
+		}
+		if seq := c.Value()[1].(uint32); seq != want {
+			t.Fatalf("row %d of run has seq %d", want, seq)
+		}
+		if err := c.Next(); err != nil {
+			t.Fatalf("Next: %v", err)
+		}
+	}
+	if !c.Valid() || c.Key() != dup+10 {
+		t.Errorf("after run at valid=%v; want key %d", c.Valid(), dup+10)
+	}
+
+	if row, found, err := bt.Search(dup); err != nil || !found || row[1].(uint32) != 0 {
+		t.Errorf("Search(%d) = %v, %v, %v; want the first row of the run", dup, row, found, err)
+	}
+	if n, err := bt.CountRange(dup, dup); err != nil || n != runLen+1 {
+		t.Errorf("CountRange = %d, %v; want %d", n, err, runLen+1)
+	}
+}
+
+// TestSubtreeCounts_TrackInsertsAndDeletes inserts keys out of order and
+// deletes some, then checks every interior cell's count matches its subtree
+// and the root's counts sum to the row count, also after a reopen, and that
+// CountRange answers from the counts without walking the leaves.
+func TestSubtreeCounts_TrackInsertsAndDeletes(t *testing.T) {
+	tp := newTempPager(t)
+	defer tp.cleanup()
+	meta, _ := BuildTableMeta(column.Schema{{Name: "id", Type: column.ColumnTypeInt}})
+	bt, err := NewBTree(tp.Pager, meta)
+	if err != nil {
+		t.Fatalf("NewBTree: %v", err)
+	}
+	const n = 400
+	for i := uint32(0); i < n; i++ {
+		k := i * 7919 % n
+		if err := bt.Insert(k, Row{k}); err != nil {
+			t.Fatalf("insert %d: %v", k, err)
+		}
+	}
+	for k := uint32(0); k < n; k += 5 {
+		if found, err := bt.Delete(k); err != nil || !found {
+			t.Fatalf("delete %d = %v, %v", k, found, err)
+		}
+	}
+	const want = n - n/5
+
+	// keysUnder counts the keys of the subtree at pgno by visiting its
+	// leaves, checking every count on the way
+	var keysUnder func(tree *BTree, pgno uint32) uint32
+	keysUnder = func(tree *BTree, pgno uint32) uint32 {
+		node, err := tree.loadNode(pgno)
+		if err != nil {
+			t.Fatalf("load %d: %v", pgno, err)
+		}
+		in, ok := node.(*InteriorNode)
+		if !ok {
+			return subtreeCount(node)
+		}
+		var sum uint32
+		for i := 0; i < in.numChildren(); i++ {
+			got := keysUnder(tree, in.child(i))
+			if in.childCount(i) != got {
+				t.Errorf("page %d child %d count = %d; subtree has %d keys", pgno, i, in.childCount(i), got)
+			}
+			sum += got
+		}
+		return sum
+	}
+	check := func(tree *BTree) {
+		if h, _ := tree.Height(); h < 3 {
+			t.Fatalf("height = %d; want at least 3 levels", h)
+		}
+		root, _ := tree.loadNode(tree.rootPage)
+		if got := keysUnder(tree, tree.rootPage); got != want || subtreeCount(root) != want {
+			t.Errorf("root counts sum to %d over %d keys; want %d", subtreeCount(root), got, want)
+		}
+	}
+	check(bt)
+
+	h, _ := bt.Height()
+	loads := bt.bTreeMeta.nodeLoads
+	got, err := bt.CountRange(10, 389)
+	if err != nil || got != 380-380/5 {
+		t.Errorf("CountRange(10, 389) = %d, %v; want %d", got, err, 380-380/5)
+	}
+	if read := bt.bTreeMeta.nodeLoads - loads; read > 2*h {
+		t.Errorf("CountRange loaded %d nodes; want at most %d, two root-to-leaf paths", read, 2*h)
+	}
+
+	if err := bt.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	p, err := pager.OpenPager(tp.filename)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	defer p.Close()
+	reopened, err := NewBTree(p, meta)
+	if err != nil {
+		t.Fatalf("NewBTree after reopen: %v", err)
+	}
+	check(reopened)
+}
+
+// TestInsert_BareIntoMultiLevelTree reopens a tree of three levels and
+// inserts into it straight away, with no Search or cursor beforehand, at the
+// front, the back and between existing keys.
+func TestInsert_BareIntoMultiLevelTree(t *testing.T) {
+	tp := newTempPager(t)
+	defer tp.cleanup()
+	meta, _ := BuildTableMeta(column.Schema{{Name: "id", Type: column.ColumnTypeInt}})
+	bt, err := NewBTree(tp.Pager, meta)
+	if err != nil {
+		t.Fatalf("NewBTree: %v", err)
+	}
+	for k := uint32(10); k <= 2000; k += 10 {
+		if err := bt.Insert(k, Row{k}); err != nil {
+			t.Fatalf("insert %d: %v", k, err)
+		}
+	}
+	if err := bt.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	p, err := pager.OpenPager(tp.filename)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	defer p.Close()
+	bt, err = NewBTree(p, meta)
+	if err != nil {
+		t.Fatalf("NewBTree after reopen: %v", err)
+	}
+	if root, _ := bt.loadNode(bt.rootPage); root.IsLeaf() {
+		t.Fatal("root is a leaf; want an interior root")
+	}
+	for _, k := range []uint32{1, 2005, 995, 15} {
+		if err := bt.Insert(k, Row{k}); err != nil {
+			t.Fatalf("bare insert %d: %v", k, err)
+		}
+	}
+	for _, k := range []uint32{1, 15, 995, 2005, 1000} {
+		if row, found, err := bt.Search(k); err != nil || !found || !row.Equal(Row{k}) {
+			t.Errorf("Search(%d) = %v, %v, %v", k, row, found, err)
+		}
+	}
+	if n, _ := bt.NumRows(); n != 204 {
+		t.Errorf("NumRows = %d; want 204", n)
+	}
+}
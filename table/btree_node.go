@@ -9,13 +9,21 @@ import (
 )
 
 const (
-	minCells = maxCells / 2 // minimum cells to avoid underflow
+	// an interior node holds up to maxCells cells whatever the row size,
+	// and is under-full below half of that; leaves use
+	// TableMeta.minLeafCells
+	minInteriorCells = maxCells / 2
 
 	// on-disk header layout
 	nodeTypeLeaf     = 1
 	nodeTypeInterior = 0
 	// type (1) + isRoot (1) + parentPage (4) + numCells (4) + rightPointer (4)
 	headerSize = 1 + 1 + 4 + 4 + 4
+	// interior pages follow the common header with their leftmost child (4)
+	// and its subtree key count (4)
+	interiorHeaderSize = headerSize + 4 + 4
+	// childPage (4) + key (4) + subtree key count (4)
+	interiorCellSize = 12
 )
 
 // BTreeNode is the interface for any node in the B+-tree.
@@ -28,7 +36,7 @@ type BTreeNode interface {
 	// Insert tries to insert the given key and value
 	// into this node.  If the node overflows, it returns (newNode, splitKey, true).
 	// Otherwise (nil, 0, false).
-	Insert(c *Cursor, key uint32, value Row) (newNode BTreeNode, splitKey uint32, split bool)
+	Insert(key uint32, value Row) (newNode BTreeNode, splitKey uint32, split bool)
 
 	// Delete tries to delete the given key from this node.
 	// Returns (found, needsRebalance) where found indicates if key was deleted
@@ -49,9 +57,14 @@ type LeafCell struct {
 	Key   uint32
 	Value Row
 }
+
+// InteriorCell is a separator key together with the child holding the keys
+// >= Key (up to the next separator) and the number of keys in that child's
+// subtree. Keys below the first separator live in the node's leftChild.
 type InteriorCell struct {
 	ChildPage uint32
 	Key       uint32
+	Count     uint32
 }
 
 // LeafNode implements BTreeNode for leaf pages.
@@ -59,6 +72,7 @@ type LeafNode struct {
 	header    baseHeader
 	cells     []LeafCell
 	bTreeMeta *BTreeMeta
+	format    byte // page type last read or written, 0 for a new leaf; see writeFormat
 }
 
 func (n *LeafNode) Page() uint32 {
@@ -69,7 +83,7 @@ func (n *LeafNode) IsLeaf() bool { return true }
 // NewLeafNode allocates a fresh page and returns a new leaf node
 func NewLeafNode(meta *BTreeMeta, isRoot bool) (*LeafNode, error) {
 	// 1) Allocate a fresh page (from free-list or by extending the file)
-	pgno, err := meta.Pager.AllocatePage()
+	pgno, err := meta.allocatePage()
 	if err != nil {
 		return nil, fmt.Errorf("NewLeafNode: could not allocate page: %w", err)
 	}
@@ -86,6 +100,7 @@ func NewLeafNode(meta *BTreeMeta, isRoot bool) (*LeafNode, error) {
 		},
 		cells: make([]LeafCell, 0, maxCells),
 	}
+	meta.cacheNode(n)
 
 	// 3) Mark the page dirty so on next flush it will be zeroed & initialized
 	pg, err := meta.Pager.GetPage(pgno)
@@ -100,7 +115,7 @@ func NewLeafNode(meta *BTreeMeta, isRoot bool) (*LeafNode, error) {
 func (n *LeafNode) Search(c *Cursor, key uint32) (int, error) {
 	// 1) Binary‐search in this leaf
 	idx := sort.Search(len(n.cells), func(i int) bool {
-		return n.cells[i].Key >= key
+		return compareKeys(n.cells[i].Key, key) >= 0
 	})
 
 	// 2) Update the cursor
@@ -108,7 +123,7 @@ func (n *LeafNode) Search(c *Cursor, key uint32) (int, error) {
 	c.page = n.header.pageNum // its page number
 	c.idx = idx               // slot index
 	// 3) Decide exact vs before vs after
-	if idx < len(n.cells) && n.cells[idx].Key == key {
+	if idx < len(n.cells) && compareKeys(n.cells[idx].Key, key) == 0 {
 		c.valid = true
 		return 0, nil
 	}
@@ -119,45 +134,34 @@ func (n *LeafNode) Search(c *Cursor, key uint32) (int, error) {
 	return -1, nil
 }
 
-// Insert uses c.idx (positioned by Search) to insert or update in-place. On overflow, splits and updates cursor.
-func (n *LeafNode) Insert(c *Cursor, key uint32, value Row) (BTreeNode, uint32, bool) {
-	idx := c.idx
-	// update existing
-	if idx < len(n.cells) && n.cells[idx].Key == key {
-		n.cells[idx].Value = value
-		n.header.numCells = uint32(len(n.cells))
-		return nil, 0, false
-	}
-	// clamp insertion index
-	if idx > len(n.cells) {
-		idx = len(n.cells)
-	}
-	// insert new cell
+// Insert adds a cell for key after any cells with an equal key, keeping the
+// leaf sorted. Replacing an existing key's row is up to the caller. On
+// overflow the leaf splits and the new right sibling is returned.
+func (n *LeafNode) Insert(key uint32, value Row) (BTreeNode, uint32, bool) {
+	idx := sort.Search(len(n.cells), func(i int) bool {
+		return compareKeys(n.cells[i].Key, key) > 0
+	})
 	n.cells = slices.Insert(n.cells, idx, LeafCell{Key: key, Value: value})
 	n.header.numCells = uint32(len(n.cells))
 	// no split
-	if len(n.cells) <= maxCells {
-		c.idx = idx
+	if !n.overflows() {
 		return nil, 0, false
 	}
 	// split leaf
 	sib, _ := NewLeafNode(n.bTreeMeta, false)
 	sib.header.parentPage = n.header.parentPage
 	sib.header.rightPointer = n.header.rightPointer
-	mid := len(n.cells) / 2
+	sib.format = n.format
+	mid := n.bTreeMeta.leafSplitPoint(len(n.cells), idx)
 	sib.cells = append(sib.cells, n.cells[mid:]...)
 	sib.header.numCells = uint32(len(sib.cells))
 	n.cells = n.cells[:mid]
 	n.header.numCells = uint32(len(n.cells))
 	n.header.rightPointer = sib.Page()
-	// determine new cursor position
-	if idx >= mid {
-		c.leaf = sib
-		c.idx = idx - mid
-	} else {
-		c.idx = idx
-	}
 	splitKey := sib.cells[0].Key
+	if h := n.bTreeMeta.hooks.OnSplit; h != nil {
+		h(n.Page(), splitKey)
+	}
 	return sib, splitKey, true
 }
 
@@ -167,31 +171,95 @@ func (n *LeafNode) Insert(c *Cursor, key uint32, value Row) (BTreeNode, uint32,
 func (n *LeafNode) Delete(key uint32) (found bool, needsRebalance bool) {
 	// Find the key using binary search
 	idx := sort.Search(int(n.header.numCells), func(i int) bool {
-		return n.cells[i].Key >= key
+		return compareKeys(n.cells[i].Key, key) >= 0
 	})
 
 	// Check if we found the exact key
-	if idx >= int(n.header.numCells) || n.cells[idx].Key != key {
+	if idx >= int(n.header.numCells) || compareKeys(n.cells[idx].Key, key) != 0 {
 		return false, false // Key not found
 	}
 
 	// Remove the cell at idx
 	n.cells = append(n.cells[:idx], n.cells[idx+1:]...)
 	n.header.numCells = uint32(len(n.cells))
+	if cap(n.cells)-len(n.cells) > maxCells {
+		n.Compact()
+	}
+
+	// Rebalancing is left to the caller (see Defragment); a root leaf may
+	// hold any number of cells
+	return true, !n.header.isRoot && len(n.cells) < n.bTreeMeta.TableMeta.minLeafCells()
+}
+
+// Compact releases the spare capacity that deletes leave in the leaf's cell
+// slice; Delete calls it once that exceeds maxCells slots. The page itself
+// never needs compacting: every format's Serialize writes the cells back to
+// back after the header and zeroes the rest, so the bytes of a deleted cell,
+// fixed or variable length, are free space as soon as the leaf is written.
+func (n *LeafNode) Compact() {
+	cells := make([]LeafCell, len(n.cells))
+	copy(cells, n.cells)
+	n.cells = cells
+}
 
-	// For simplicity, we don't implement full rebalancing here
-	// Just return true for found, false for needsRebalance
-	// This is a simplified deletion that works for basic cases
-	return true, false
+// FreeSpace returns how many bytes of the page the leaf leaves unused when
+// serialized in its format: the tail after the cells, or after the
+// compressed stream of a compressed leaf.
+func (n *LeafNode) FreeSpace() (int, error) {
+	switch n.writeFormat() {
+	case nodeTypeLeafCompact:
+		cells, err := n.compactCells()
+		if err != nil {
+			return 0, err
+		}
+		return pager.PageSize - headerSize - len(cells), nil
+	case nodeTypeLeafCompressed:
+		raw, err := n.encodeCells()
+		if err != nil {
+			return 0, err
+		}
+		z, err := compressCells(raw)
+		if err != nil {
+			return 0, err
+		}
+		return pager.PageSize - compressedHeaderSize - len(z), nil
+	default:
+		return pager.PageSize - headerSize - len(n.cells)*int(n.bTreeMeta.TableMeta.LeafCellSize()), nil
+	}
 }
 
 // Serialize writes the header + all cells to p.Data.
 // Each cell is: [ key:uint32 | serialized row (meta.RowSize bytes) ].
 // Uses table.SerializeRow from row.go :contentReference[oaicite:0]{index=0}.
+// Every layout checks or encodes all rows before p is touched, so a row that
+// fails to serialize leaves the page as it was.
 func (n *LeafNode) Serialize(p *pager.Page) error {
-	// zero-out
-	for i := range p.Data {
-		p.Data[i] = 0
+	format := n.writeFormat()
+	var err error
+	switch format {
+	case nodeTypeLeafCompact:
+		err = n.serializeCompact(p)
+	case nodeTypeLeafCompressed:
+		err = n.serializeCompressed(p)
+	case nodeTypeLeafSeparated:
+		err = n.serializeSeparated(p)
+	default:
+		err = n.serializeFixed(p)
+	}
+	if err == nil {
+		n.format = format
+	}
+	return err
+}
+
+// serializeFixed writes the header and the cells in the fixed [ key | row ]
+// layout.
+func (n *LeafNode) serializeFixed(p *pager.Page) error {
+	if fit := int(LeafMaxCells(n.bTreeMeta.TableMeta.RowSize)); len(n.cells) > fit {
+		return fmt.Errorf("LeafNode.Serialize: %d cells do not fit in a page, at most %d do", len(n.cells), fit)
+	}
+	if err := n.checkRows(); err != nil {
+		return err
 	}
 	// header
 	n.header.writeTo(p.Data[:headerSize], nodeTypeLeaf)
@@ -206,22 +274,41 @@ func (n *LeafNode) Serialize(p *pager.Page) error {
 		}
 		off += int(n.bTreeMeta.TableMeta.RowSize)
 	}
+	zeroTail(p, off)
+	p.Dirty = true
+	return nil
+}
+
+// checkRows checks every row of the leaf serializes before a layout that
+// writes straight into the page touches it, so a bad row leaves the page as
+// it was.
+func (n *LeafNode) checkRows() error {
+	for _, c := range n.cells {
+		if err := checkRow(n.bTreeMeta.TableMeta, c.Value); err != nil {
+			return fmt.Errorf("LeafNode.Serialize: key %d: %w", c.Key, err)
+		}
+	}
 	return nil
 }
 
 func (n *LeafNode) Load(p *pager.Page) error {
-	if p.Data[0] != nodeTypeLeaf {
+	if !isLeafType(p.Data[0]) {
 		return fmt.Errorf("LeafNode.Load: not a leaf (type=%d)", p.Data[0])
 	}
 	n.header.readFrom(p.Data[:headerSize])
+	n.format = p.Data[0]
 	cnt := int(n.header.numCells)
+	region, err := cellRegion(p, cnt, n.bTreeMeta.TableMeta)
+	if err != nil {
+		return fmt.Errorf("LeafNode.Load: %w", err)
+	}
 	n.cells = make([]LeafCell, cnt)
-	off := headerSize
+	off := 0
 	for i := 0; i < cnt; i++ {
-		key := binary.LittleEndian.Uint32(p.Data[off : off+4])
+		key := binary.LittleEndian.Uint32(region[off : off+4])
 		off += 4
 		buf := make([]byte, n.bTreeMeta.TableMeta.RowSize)
-		copy(buf, p.Data[off:off+int(n.bTreeMeta.TableMeta.RowSize)])
+		copy(buf, region[off:off+int(n.bTreeMeta.TableMeta.RowSize)])
 		off += int(n.bTreeMeta.TableMeta.RowSize)
 		row, err := DeserializeRow(n.bTreeMeta.TableMeta, buf)
 		if err != nil {
@@ -229,12 +316,21 @@ func (n *LeafNode) Load(p *pager.Page) error {
 		}
 		n.cells[i] = LeafCell{Key: key, Value: row}
 	}
+	if n.bTreeMeta.VerifyOnLoad {
+		if err := n.verifySorted(); err != nil {
+			return fmt.Errorf("LeafNode.Load: %w", err)
+		}
+	}
 	return nil
 }
 
-// InteriorNode implements BTreeNode for interior pages.
+// InteriorNode implements BTreeNode for interior pages. A node with N cells
+// has N+1 children: leftChild followed by each cell's ChildPage. The header's
+// rightPointer is unused for interior nodes.
 type InteriorNode struct {
 	header    baseHeader
+	leftChild uint32
+	leftCount uint32 // keys in leftChild's subtree, see count.go
 	cells     []InteriorCell
 	bTreeMeta *BTreeMeta
 }
@@ -246,11 +342,11 @@ func (n *InteriorNode) Page() uint32 {
 func (n *InteriorNode) IsLeaf() bool { return false }
 
 // NewInteriorNode allocates a fresh page (like NewLeafNode) and returns an
-// empty interior node. The caller should set header.rightPointer and/or cells
+// empty interior node. The caller should set leftChild and/or cells
 // before serialization if needed.
 func NewInteriorNode(meta *BTreeMeta, isRoot bool) (*InteriorNode, error) {
 	// 1) allocate new page
-	pgno, err := meta.Pager.AllocatePage()
+	pgno, err := meta.allocatePage()
 	if err != nil {
 		return nil, fmt.Errorf("NewInteriorNode: could not allocate page: %w", err)
 	}
@@ -266,6 +362,7 @@ func NewInteriorNode(meta *BTreeMeta, isRoot bool) (*InteriorNode, error) {
 		},
 		cells: make([]InteriorCell, 0, maxCells),
 	}
+	meta.cacheNode(n)
 
 	// mark page dirty so it will be zeroed/serialized later
 	pg, err := meta.Pager.GetPage(pgno)
@@ -277,41 +374,33 @@ func NewInteriorNode(meta *BTreeMeta, isRoot bool) (*InteriorNode, error) {
 	return n, nil
 }
 
-// Insert descends to child, recurses, and splices on split; splits this node if needed.
-// Cursor is accepted for API consistency but only used at leaf level.
-func (n *InteriorNode) Insert(c *Cursor, key uint32, value Row) (BTreeNode, uint32, bool) {
-	// find branch index
-	i := sort.Search(len(n.cells), func(i int) bool { return n.cells[i].Key >= key })
-	var childPg uint32
-	if i < len(n.cells) {
-		childPg = n.cells[i].ChildPage
-	} else {
-		childPg = n.header.rightPointer
-	}
+// Insert descends to child, recurses, and splices on split; splits this node
+// if needed. Without a split, writing n back is up to the caller.
+func (n *InteriorNode) Insert(key uint32, value Row) (BTreeNode, uint32, bool) {
+	childPg, i := n.childIndexFor(key)
 
 	// load child node
-	page, _ := n.bTreeMeta.Pager.GetPage(childPg)
-	var child BTreeNode
-	if page.Data[0] == nodeTypeLeaf {
-		leaf := &LeafNode{bTreeMeta: n.bTreeMeta}
-		leaf.header.pageNum = childPg
-		leaf.Load(page)
-		child = leaf
-	} else {
-		in := &InteriorNode{bTreeMeta: n.bTreeMeta}
-		in.header.pageNum = childPg
-		in.Load(page)
-		child = in
+	child, err := n.bTreeMeta.loadNode(childPg)
+	if err != nil {
+		return nil, 0, false
 	}
 
 	// recurse
-	sib, splitKey, didSplit := child.Insert(c, key, value)
+	sib, splitKey, didSplit := child.Insert(key, value)
 	if !didSplit {
+		n.setChildCount(i, subtreeCount(child))
 		return nil, 0, false
 	}
 
-	// splice in new child pointer
-	n.cells = slices.Insert(n.cells, i, InteriorCell{ChildPage: sib.Page(), Key: splitKey})
+	return n.insertChild(i, child, sib, splitKey)
+}
+
+// insertChild splices sib in as child i+1, right after child i that split
+// at splitKey, and serializes the node. If that overflows the node it splits
+// in turn and returns the new right node and the key promoted to the parent.
+func (n *InteriorNode) insertChild(i int, child, sib BTreeNode, splitKey uint32) (BTreeNode, uint32, bool) {
+	n.setChildCount(i, subtreeCount(child))
+	n.cells = slices.Insert(n.cells, i, InteriorCell{ChildPage: sib.Page(), Key: splitKey, Count: subtreeCount(sib)})
 	n.header.numCells = uint32(len(n.cells))
 
 	// if no overflow, serialize
@@ -324,16 +413,16 @@ func (n *InteriorNode) Insert(c *Cursor, key uint32, value Row) (BTreeNode, uint
 	// split interior node
 	sibInt, _ := NewInteriorNode(n.bTreeMeta, false)
 	sibInt.header.parentPage = n.header.parentPage
-	mid := len(n.cells) / 2
+	mid := n.bTreeMeta.interiorSplitPoint(len(n.cells), i)
 	med := n.cells[mid]
 
+	sibInt.leftChild = med.ChildPage
+	sibInt.leftCount = med.Count
 	sibInt.cells = append(sibInt.cells, n.cells[mid+1:]...)
 	sibInt.header.numCells = uint32(len(sibInt.cells))
-	sibInt.header.rightPointer = n.header.rightPointer
 
 	n.cells = n.cells[:mid]
 	n.header.numCells = uint32(len(n.cells))
-	n.header.rightPointer = med.ChildPage
 
 	// serialize both halves
 	if pN, _ := n.bTreeMeta.Pager.GetPage(n.Page()); pN != nil {
@@ -342,6 +431,9 @@ func (n *InteriorNode) Insert(c *Cursor, key uint32, value Row) (BTreeNode, uint
 	if pS, _ := n.bTreeMeta.Pager.GetPage(sibInt.Page()); pS != nil {
 		sibInt.Serialize(pS)
 	}
+	if h := n.bTreeMeta.hooks.OnSplit; h != nil {
+		h(n.Page(), med.Key)
+	}
 	return sibInt, med.Key, true
 }
 
@@ -351,16 +443,7 @@ func (n *InteriorNode) Insert(c *Cursor, key uint32, value Row) (BTreeNode, uint
 // and needsRebalance indicates if this node needs rebalancing due to underflow.
 func (n *InteriorNode) Delete(key uint32) (found bool, needsRebalance bool) {
 	// Find the appropriate child to descend to
-	i := sort.Search(len(n.cells), func(i int) bool {
-		return n.cells[i].Key >= key
-	})
-
-	var childPg uint32
-	if i < len(n.cells) {
-		childPg = n.cells[i].ChildPage
-	} else {
-		childPg = n.header.rightPointer
-	}
+	childPg, i := n.childIndexFor(key)
 
 	// Load the child node
 	p, err := n.bTreeMeta.Pager.GetPage(childPg)
@@ -368,21 +451,9 @@ func (n *InteriorNode) Delete(key uint32) (found bool, needsRebalance bool) {
 		return false, false // Error loading child
 	}
 
-	var child BTreeNode
-	if p.Data[0] == nodeTypeLeaf {
-		leaf := &LeafNode{bTreeMeta: n.bTreeMeta}
-		leaf.header.pageNum = childPg
-		if err := leaf.Load(p); err != nil {
-			return false, false
-		}
-		child = leaf
-	} else {
-		interior := &InteriorNode{bTreeMeta: n.bTreeMeta}
-		interior.header.pageNum = childPg
-		if err := interior.Load(p); err != nil {
-			return false, false
-		}
-		child = interior
+	child, err := n.bTreeMeta.loadNode(childPg)
+	if err != nil {
+		return false, false
 	}
 
 	// Recursively delete from child
@@ -395,41 +466,58 @@ func (n *InteriorNode) Delete(key uint32) (found bool, needsRebalance bool) {
 	if err := child.Serialize(p); err != nil {
 		return false, false
 	}
+	n.setChildCount(i, subtreeCount(child))
 
-	// For simplicity, we don't implement full rebalancing here
-	// Just return that deletion was successful
-	return true, false
+	// Deleting a key never removes a child, so only a node that was already
+	// under-full reports it
+	return true, !n.header.isRoot && len(n.cells) < minInteriorCells
 }
 
-// Serialize writes header + each InteriorCell ([ childPage:uint32 | key:uint32 ]).
+// Serialize writes header + leftChild + leftCount + each InteriorCell
+// ([ childPage:uint32 | key:uint32 | count:uint32 ]).
 func (n *InteriorNode) Serialize(p *pager.Page) error {
-	for i := range p.Data {
-		p.Data[i] = 0
-	}
 	n.header.writeTo(p.Data[:headerSize], nodeTypeInterior)
-	off := headerSize
+	binary.LittleEndian.PutUint32(p.Data[headerSize:headerSize+4], n.leftChild)
+	binary.LittleEndian.PutUint32(p.Data[headerSize+4:interiorHeaderSize], n.leftCount)
+	off := interiorHeaderSize
 	for _, c := range n.cells {
 		binary.LittleEndian.PutUint32(p.Data[off:off+4], c.ChildPage)
 		binary.LittleEndian.PutUint32(p.Data[off+4:off+8], c.Key)
-		off += 8
+		binary.LittleEndian.PutUint32(p.Data[off+8:off+12], c.Count)
+		off += interiorCellSize
 	}
+	zeroTail(p, off)
+	p.Dirty = true
 	return nil
 }
 
+// zeroTail clears p.Data from off to the end of the page. Serialize rewrites
+// every byte before off, so only the tail can still hold stale bytes from a
+// previous (larger) serialization.
+func zeroTail(p *pager.Page, off int) {
+	clear(p.Data[off:])
+}
+
 // Load reads header + cells for an interior page.
 func (n *InteriorNode) Load(p *pager.Page) error {
 	if p.Data[0] != nodeTypeInterior {
 		return fmt.Errorf("InteriorNode.Load: not interior (type=%d)", p.Data[0])
 	}
 	n.header.readFrom(p.Data[:headerSize])
+	n.leftChild = binary.LittleEndian.Uint32(p.Data[headerSize : headerSize+4])
+	n.leftCount = binary.LittleEndian.Uint32(p.Data[headerSize+4 : interiorHeaderSize])
 	cnt := int(n.header.numCells)
+	if cnt > (pager.PageSize-interiorHeaderSize)/interiorCellSize {
+		return fmt.Errorf("InteriorNode.Load: page declares %d cells but at most %d fit", cnt, (pager.PageSize-interiorHeaderSize)/interiorCellSize)
+	}
 	n.cells = make([]InteriorCell, cnt)
-	off := headerSize
+	off := interiorHeaderSize
 	for i := 0; i < cnt; i++ {
 		child := binary.LittleEndian.Uint32(p.Data[off : off+4])
 		key := binary.LittleEndian.Uint32(p.Data[off+4 : off+8])
-		off += 8
-		n.cells[i] = InteriorCell{ChildPage: child, Key: key}
+		count := binary.LittleEndian.Uint32(p.Data[off+8 : off+12])
+		off += interiorCellSize
+		n.cells[i] = InteriorCell{ChildPage: child, Key: key, Count: count}
 	}
 	return nil
 }
@@ -437,20 +525,10 @@ func (n *InteriorNode) Load(p *pager.Page) error {
 // Search on an interior page: pick the correct child, load it, and recurse.
 // Returns –1/0/+1 from the eventual leaf, and updates the same *Cursor.
 func (n *InteriorNode) Search(c *Cursor, key uint32) (int, error) {
-	// 1) Find the first cell whose Key > search key
-	childIdx := sort.Search(len(n.cells), func(i int) bool {
-		return n.cells[i].Key >= key
-	})
-
-	// 2) Choose the child page pointer
-	var childPg uint32
-	if childIdx < len(n.cells) {
-		childPg = n.cells[childIdx].ChildPage
-	} else {
-		childPg = n.header.rightPointer
-	}
+	// 1) Choose the child page pointer
+	childPg, _ := n.childIndexFor(key)
 
-	// 3) Load that child node
+	// 2) Load that child node
 	node, err := c.tree.loadNode(childPg)
 	if err != nil {
 		return 0, err
@@ -458,3 +536,24 @@ func (n *InteriorNode) Search(c *Cursor, key uint32) (int, error) {
 
 	return node.Search(c, key)
 }
+
+// numChildren returns how many children the node has, one more than cells.
+func (n *InteriorNode) numChildren() int { return len(n.cells) + 1 }
+
+// child returns the page of child i: leftChild for 0, else cells[i-1].ChildPage.
+func (n *InteriorNode) child(i int) uint32 {
+	if i == 0 {
+		return n.leftChild
+	}
+	return n.cells[i-1].ChildPage
+}
+
+// childIndexFor returns the child page that holds key together with its
+// child index (0 for leftChild, i for cells[i-1].ChildPage). Every descent
+// goes through here so search, insert and delete route keys identically.
+func (n *InteriorNode) childIndexFor(key uint32) (uint32, int) {
+	i := sort.Search(len(n.cells), func(i int) bool {
+		return compareKeys(n.cells[i].Key, key) > 0
+	})
+	return n.child(i), i
+}
@@ -1,8 +1,14 @@
 package table
 
 import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
 	"os"
 	"reflect"
+	"slices"
+	"strings"
 	"testing"
 
 	"vqlite/column"
@@ -131,6 +137,51 @@ func TestLeafNode_LoadError(t *testing.T) {
 	}
 }
 
+// TestLeafNode_LoadInconsistentNumCells crafts leaf and interior pages whose
+// declared cell counts cannot fit the page for the schema's row size and
+// checks loading them fails with an error instead of panicking.
+func TestLeafNode_LoadInconsistentNumCells(t *testing.T) {
+	tp := newTempPager(t)
+	defer tp.cleanup()
+	schema := column.Schema{
+		{Name: "id", Type: column.ColumnTypeInt},
+		{Name: "name", Type: column.ColumnTypeText, MaxLength: 60},
+	}
+	tblMeta, _ := BuildTableMeta(schema)
+	btMeta := &BTreeMeta{Pager: tp.Pager, TableMeta: tblMeta}
+
+	leaf, err := NewLeafNode(btMeta, true)
+	if err != nil {
+		t.Fatalf("NewLeafNode: %v", err)
+	}
+	leaf.cells = []LeafCell{{Key: 1, Value: Row{uint32(1), "a"}}}
+	leaf.header.numCells = 1
+	page, _ := tp.GetPage(leaf.Page())
+	if err := leaf.Serialize(page); err != nil {
+		t.Fatalf("Serialize: %v", err)
+	}
+	fit := int(LeafMaxCells(tblMeta.RowSize))
+	for _, n := range []uint32{uint32(fit) + 1, 1 << 20, math.MaxUint32} {
+		binary.LittleEndian.PutUint32(page.Data[6:10], n)
+		loaded := &LeafNode{bTreeMeta: btMeta}
+		err := loaded.Load(page)
+		if err == nil || !strings.Contains(err.Error(), "cells") {
+			t.Errorf("Load with numCells %d: err = %v; want a cell count error", n, err)
+		}
+	}
+	binary.LittleEndian.PutUint32(page.Data[6:10], uint32(fit))
+	if err := (&LeafNode{bTreeMeta: btMeta}).Load(page); err != nil {
+		t.Errorf("Load with numCells %d (a full page): %v", fit, err)
+	}
+
+	inPage, _ := tp.GetPage(leaf.Page())
+	inPage.Data[0] = nodeTypeInterior
+	binary.LittleEndian.PutUint32(inPage.Data[6:10], 1<<20)
+	if err := (&InteriorNode{bTreeMeta: btMeta}).Load(inPage); err == nil {
+		t.Error("InteriorNode.Load with numCells 1<<20 succeeded; want an error")
+	}
+}
+
 // TestInteriorNode_SerializeLoad creates an interior node, serializes it, then
 // loads it back and ensures the header and cell array round-trip intact.
 func TestInteriorNode_SerializeLoad(t *testing.T) {
@@ -143,12 +194,12 @@ func TestInteriorNode_SerializeLoad(t *testing.T) {
 	interior := &InteriorNode{
 		bTreeMeta: &BTreeMeta{},
 		header: baseHeader{
-			pageNum:      pgno,
-			isRoot:       true,
-			numCells:     2,
-			rightPointer: 3,
+			pageNum:  pgno,
+			isRoot:   true,
+			numCells: 2,
 		},
-		cells: []InteriorCell{{ChildPage: 10, Key: 100}, {ChildPage: 20, Key: 200}},
+		leftChild: 3,
+		cells:     []InteriorCell{{ChildPage: 10, Key: 100}, {ChildPage: 20, Key: 200}},
 	}
 
 	if err := interior.Serialize(page); err != nil {
@@ -164,14 +215,39 @@ func TestInteriorNode_SerializeLoad(t *testing.T) {
 	if loaded.header.numCells != interior.header.numCells {
 		t.Errorf("numCells = %d; want %d", loaded.header.numCells, interior.header.numCells)
 	}
-	if loaded.header.rightPointer != interior.header.rightPointer {
-		t.Errorf("rightPointer = %d; want %d", loaded.header.rightPointer, interior.header.rightPointer)
+	if loaded.leftChild != interior.leftChild {
+		t.Errorf("leftChild = %d; want %d", loaded.leftChild, interior.leftChild)
 	}
 	if !reflect.DeepEqual(loaded.cells, interior.cells) {
 		t.Errorf("cells = %v; want %v", loaded.cells, interior.cells)
 	}
 }
 
+// TestInteriorNode_ChildRouting pins which child a key descends into: the
+// leftmost child below the first separator, the child right of a separator
+// for keys equal to or above it, and the last child above the last separator.
+func TestInteriorNode_ChildRouting(t *testing.T) {
+	interior := &InteriorNode{
+		header:    baseHeader{numCells: 2},
+		leftChild: 3,
+		cells:     []InteriorCell{{ChildPage: 10, Key: 100}, {ChildPage: 20, Key: 200}},
+	}
+	bt := &BTree{}
+	cases := []struct {
+		key  uint32
+		want uint32
+	}{
+		{0, 3}, {99, 3},
+		{100, 10}, {150, 10}, {199, 10},
+		{200, 20}, {1 << 31, 20},
+	}
+	for _, tc := range cases {
+		if got := bt.findChildPageInInterior(interior, tc.key); got != tc.want {
+			t.Errorf("key %d routed to page %d; want %d", tc.key, got, tc.want)
+		}
+	}
+}
+
 // TestLeafNode_Insert_NoSplit ensures inserts maintain sorted key order and
 // no split occurs while the number of cells ≤ maxCells.
 func TestLeafNode_Insert_NoSplit(t *testing.T) {
@@ -290,12 +366,12 @@ func TestInteriorNode_Insert_LeafSplit(t *testing.T) {
 		t.Fatalf("serialize leaf: %v", err)
 	}
 
-	// Build an interior root whose rightPointer points to the leaf
+	// Build an interior root whose only child is the leaf
 	root, err := NewInteriorNode(btMeta, true)
 	if err != nil {
 		t.Fatalf("NewInteriorNode: %v", err)
 	}
-	root.header.rightPointer = leaf.Page()
+	root.leftChild = leaf.Page()
 
 	// Insert a key that will cause the child leaf to split
 	newKey := uint32(maxCells) // one greater than existing max key in leaf
@@ -319,7 +395,10 @@ func TestInteriorNode_Insert_LeafSplit(t *testing.T) {
 		t.Errorf("promoted key = %d; want %d", promotedKey, expectedPromoted)
 	}
 
-	// Ensure the child page numbers are valid and distinct
+	// The original leaf keeps the low half; the new cell points at the sibling
+	if root.leftChild != leaf.Page() {
+		t.Errorf("leftChild = %d; want original leaf page %d", root.leftChild, leaf.Page())
+	}
 	if root.cells[0].ChildPage == leaf.Page() {
 		t.Errorf("ChildPage for new cell should be sibling, got original leaf page %d", leaf.Page())
 	}
@@ -381,11 +460,12 @@ func TestInteriorNode_Insert_InteriorSplit(t *testing.T) {
 	if err != nil {
 		t.Fatalf("NewInteriorNode: %v", err)
 	}
-	for i, k := range keysForCells {
-		root.cells = append(root.cells, InteriorCell{ChildPage: leaves[i].Page(), Key: k})
+	root.leftChild = leaves[0].Page()
+	for i, k := range keysForCells[1:] {
+		root.cells = append(root.cells, InteriorCell{ChildPage: leaves[i+1].Page(), Key: k})
 	}
+	root.cells = append(root.cells, InteriorCell{ChildPage: rightLeaf.Page(), Key: 1000})
 	root.header.numCells = uint32(maxCells)
-	root.header.rightPointer = rightLeaf.Page()
 
 	// Insert a key that will land in the rightmost leaf, forcing it to split
 	bigKey := uint32(5000)
@@ -412,9 +492,507 @@ func TestInteriorNode_Insert_InteriorSplit(t *testing.T) {
 		t.Errorf("right numCells = %d; want %d", rightCells, maxCells-mid)
 	}
 
-	// The splitKey should equal the promoted median key
-	expectedMed := keysForCells[mid]
+	// The splitKey should equal the promoted median key. The first leaf has
+	// no separator, so cell mid separates leaves mid and mid+1.
+	expectedMed := keysForCells[mid+1]
 	if splitKey != expectedMed {
 		t.Errorf("splitKey = %d; want %d", splitKey, expectedMed)
 	}
 }
+
+// TestSerialize_TailZeroMatchesFullZero dirties a page with garbage, then
+// serializes a leaf in every format and an interior node over it. The result
+// must be byte-identical to serializing onto a freshly zeroed page.
+func TestSerialize_TailZeroMatchesFullZero(t *testing.T) {
+	tp := newTempPager(t)
+	defer tp.cleanup()
+
+	schema := column.Schema{
+		{Name: "id", Type: column.ColumnTypeInt},
+		{Name: "name", Type: column.ColumnTypeText, MaxLength: 8},
+	}
+	tblMeta, _ := BuildTableMeta(schema)
+	btMeta := &BTreeMeta{Pager: tp.Pager, TableMeta: tblMeta}
+
+	leaf := &LeafNode{
+		bTreeMeta: btMeta,
+		header:    baseHeader{pageNum: 1, numCells: 2, rightPointer: 7},
+		cells: []LeafCell{
+			{Key: 1, Value: Row{uint32(1), "a"}},
+			{Key: 2, Value: Row{uint32(2), "bob"}},
+		},
+	}
+	interior := &InteriorNode{
+		bTreeMeta: btMeta,
+		header:    baseHeader{pageNum: 2, isRoot: true, numCells: 1},
+		leftChild: 3,
+		cells:     []InteriorCell{{ChildPage: 1, Key: 2}},
+	}
+
+	check := func(name string, node BTreeNode) {
+		t.Helper()
+		var clean pager.Page
+		if err := node.Serialize(&clean); err != nil {
+			t.Fatalf("%s: Serialize clean: %v", name, err)
+		}
+
+		var dirty pager.Page
+		for i := range dirty.Data {
+			dirty.Data[i] = 0xFF
+		}
+		if err := node.Serialize(&dirty); err != nil {
+			t.Fatalf("%s: Serialize dirty: %v", name, err)
+		}
+
+		if dirty.Data != clean.Data {
+			t.Errorf("%s: serialized page over garbage differs from zeroed page", name)
+		}
+	}
+
+	for _, f := range []struct {
+		name   string
+		format byte
+		set    func(m *BTreeMeta)
+	}{
+		{"fixed", nodeTypeLeaf, func(m *BTreeMeta) {}},
+		{"compressed", nodeTypeLeafCompressed, func(m *BTreeMeta) { m.Compress = true }},
+		{"compact", nodeTypeLeafCompact, func(m *BTreeMeta) { m.CompactText = true }},
+		{"separated", nodeTypeLeafSeparated, func(m *BTreeMeta) { m.SeparateValues = true }},
+	} {
+		*btMeta = BTreeMeta{Pager: tp.Pager, TableMeta: tblMeta}
+		f.set(btMeta)
+		if got := leaf.writeFormat(); got != f.format {
+			t.Fatalf("%s leaf writes format %d; want %d", f.name, got, f.format)
+		}
+		check(f.name+" leaf", leaf)
+	}
+	check("interior", interior)
+}
+
+// BenchmarkLeafNode_Serialize compares Serialize, which zeroes only the
+// page's free space, against first clearing the whole page as Serialize once
+// did, for the two layouts written in place.
+func BenchmarkLeafNode_Serialize(b *testing.B) {
+	schema := column.Schema{{Name: "id", Type: column.ColumnTypeInt}}
+	tblMeta, _ := BuildTableMeta(schema)
+	for _, separate := range []bool{false, true} {
+		leaf := &LeafNode{bTreeMeta: &BTreeMeta{TableMeta: tblMeta, SeparateValues: separate}}
+		for i := uint32(0); i < maxCells; i++ {
+			leaf.cells = append(leaf.cells, LeafCell{Key: i, Value: Row{i}})
+		}
+		leaf.header.numCells = uint32(len(leaf.cells))
+		name := "fixed"
+		if separate {
+			name = "separated"
+		}
+
+		var p pager.Page
+		b.Run(name+"/tail-zero", func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				leaf.Serialize(&p)
+			}
+		})
+		b.Run(name+"/full-zero", func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				clear(p.Data[:])
+				leaf.Serialize(&p)
+			}
+		})
+	}
+}
+
+// TestLeafNode_CompressedRoundTrip stores highly compressible TEXT rows in a
+// compressed tree and checks it needs fewer pages than an uncompressed one,
+// and that every row survives a close and reopen.
+func TestLeafNode_CompressedRoundTrip(t *testing.T) {
+	schema := column.Schema{
+		{Name: "id", Type: column.ColumnTypeInt},
+		{Name: "note", Type: column.ColumnTypeText, MaxLength: 200},
+	}
+	note := strings.Repeat("a", 200)
+
+	build := func(compress bool) (*tempPager, int) {
+		tp := newTempPager(t)
+		meta, _ := BuildTableMeta(schema)
+		bt, err := NewBTree(tp.Pager, meta)
+		if err != nil {
+			t.Fatalf("NewBTree: %v", err)
+		}
+		bt.SetCompression(compress)
+		for i := uint32(0); i < 120; i++ {
+			if err := bt.Insert(i, Row{i, note}); err != nil {
+				t.Fatalf("insert %d (compress=%v): %v", i, compress, err)
+			}
+		}
+		if err := bt.Close(); err != nil {
+			t.Fatalf("Close: %v", err)
+		}
+		return tp, tp.NumPages
+	}
+
+	plain, plainPages := build(false)
+	defer os.Remove(plain.filename)
+	packed, packedPages := build(true)
+	defer os.Remove(packed.filename)
+
+	if packedPages >= plainPages {
+		t.Errorf("compressed tree uses %d pages; uncompressed uses %d", packedPages, plainPages)
+	}
+
+	// reopen without enabling compression: the page type byte drives decoding
+	pg, err := pager.OpenPager(packed.filename)
+	if err != nil {
+		t.Fatalf("OpenPager: %v", err)
+	}
+	defer pg.Close()
+	meta, _ := BuildTableMeta(schema)
+	bt, err := NewBTree(pg, meta)
+	if err != nil {
+		t.Fatalf("reopen NewBTree: %v", err)
+	}
+	cur, _ := bt.NewCursor()
+	want := uint32(0)
+	for cur.Valid() {
+		if got := cur.Value(); !reflect.DeepEqual(got, Row{want, note}) {
+			t.Fatalf("row %d = %v", want, got)
+		}
+		want++
+		cur.Next()
+	}
+	if want != 120 {
+		t.Errorf("scanned %d rows; want 120", want)
+	}
+
+	kc, _ := bt.NewKeyCursor()
+	n := 0
+	for ; kc.Valid(); kc.Next() {
+		if kc.Key() != uint32(n) {
+			t.Fatalf("key cursor key %d = %d", n, kc.Key())
+		}
+		n++
+	}
+	if n != 120 {
+		t.Errorf("key cursor saw %d keys; want 120", n)
+	}
+}
+
+// TestLeafNode_CompressedLeavesWithCompressionOff fills leaves well past
+// RowsPerPage with compression on, then deletes and inserts with it off, in
+// the same session and after a reopen without it.
+func TestLeafNode_CompressedLeavesWithCompressionOff(t *testing.T) {
+	checkDenseLeavesWithOptionOff(t, func(bt *BTree, on bool) { bt.SetCompression(on) })
+}
+
+// TestLeafNode_CompactLeavesWithCompactTextOff is the same check for compact
+// leaves, which size by bytes rather than by RowsPerPage.
+func TestLeafNode_CompactLeavesWithCompactTextOff(t *testing.T) {
+	checkDenseLeavesWithOptionOff(t, func(bt *BTree, on bool) { bt.SetCompactText(on) })
+}
+
+// checkDenseLeavesWithOptionOff loads 60 rows of TEXT(255) into a tree with
+// option on, which packs more of them per leaf than the fixed format holds.
+// It then turns option off and deletes and inserts rows, closes the file,
+// reopens it without option and does so again, checking every row and the
+// tree's order each time.
+func checkDenseLeavesWithOptionOff(t *testing.T, option func(bt *BTree, on bool)) {
+	t.Helper()
+	tp := newTempPager(t)
+	defer os.Remove(tp.filename)
+	meta, _ := BuildTableMeta(column.Schema{
+		{Name: "id", Type: column.ColumnTypeInt},
+		{Name: "note", Type: column.ColumnTypeText, MaxLength: 255},
+	})
+	bt, err := NewBTree(tp.Pager, meta)
+	if err != nil {
+		t.Fatalf("NewBTree: %v", err)
+	}
+	option(bt, true)
+	want := map[uint32]bool{}
+	for i := uint32(0); i < 60; i++ {
+		if err := bt.Insert(i, Row{i, "a"}); err != nil {
+			t.Fatalf("insert %d: %v", i, err)
+		}
+		want[i] = true
+	}
+	first, _ := bt.loadNode(bt.rootPage)
+	for !first.IsLeaf() {
+		first, _ = bt.loadNode(first.(*InteriorNode).leftChild)
+	}
+	if n := len(first.(*LeafNode).cells); n <= meta.RowsPerPage() {
+		t.Fatalf("dense leaf holds %d rows; want more than the %d of the fixed format", n, meta.RowsPerPage())
+	}
+
+	mutate := func(when string, next uint32) {
+		t.Helper()
+		for _, k := range []uint32{5, 6, 33} {
+			if found, err := bt.Delete(k + next); err != nil {
+				t.Fatalf("%s: Delete(%d): %v", when, k+next, err)
+			} else if found {
+				delete(want, k+next)
+			}
+		}
+		for k := next; k < next+20; k++ {
+			if err := bt.Insert(k*3+1000, Row{k*3 + 1000, "b"}); err != nil {
+				t.Fatalf("%s: insert %d: %v", when, k*3+1000, err)
+			}
+			want[k*3+1000] = true
+		}
+		if err := bt.Validate(); err != nil {
+			t.Errorf("%s: Validate: %v", when, err)
+		}
+		got := 0
+		if err := bt.ForEach(func(key uint32, row Row) error {
+			if !want[key] || row[0] != key {
+				t.Errorf("%s: unexpected row %v under key %d", when, row, key)
+			}
+			got++
+			return nil
+		}); err != nil {
+			t.Fatalf("%s: ForEach: %v", when, err)
+		}
+		if got != len(want) {
+			t.Errorf("%s: %d rows; want %d", when, got, len(want))
+		}
+	}
+	option(bt, false)
+	mutate("option turned off", 0)
+	if err := bt.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	pg, err := pager.OpenPager(tp.filename)
+	if err != nil {
+		t.Fatalf("OpenPager: %v", err)
+	}
+	if bt, err = NewBTree(pg, meta); err != nil {
+		t.Fatalf("reopen NewBTree: %v", err)
+	}
+	defer bt.Close()
+	mutate("after reopen", 1)
+}
+
+// TestLeafNode_CompactText mixes long and short TEXT values in one compact
+// leaf, checks far more rows fit than in the fixed-width format, and that the
+// page loads back to the same rows.
+func TestLeafNode_CompactText(t *testing.T) {
+	tp := newTempPager(t)
+	defer tp.cleanup()
+
+	schema := column.Schema{
+		{Name: "id", Type: column.ColumnTypeInt},
+		{Name: "body", Type: column.ColumnTypeText, MaxLength: 1000},
+	}
+	tblMeta, _ := BuildTableMeta(schema)
+	btMeta := &BTreeMeta{Pager: tp.Pager, TableMeta: tblMeta, CompactText: true}
+
+	leaf, err := NewLeafNode(btMeta, true)
+	if err != nil {
+		t.Fatalf("NewLeafNode: %v", err)
+	}
+	long := strings.Repeat("L", 1000)
+	var want []LeafCell
+	for k := uint32(0); ; k++ {
+		body := fmt.Sprintf("s%d", k)
+		if k < 2 {
+			body = long
+		}
+		if _, _, split := leaf.Insert(k, Row{k, body}); split {
+			break
+		}
+		want = append(want, LeafCell{Key: k, Value: Row{k, body}})
+	}
+	if fixed := tblMeta.RowsPerPage(); len(want) <= 5*fixed {
+		t.Errorf("compact leaf held %d rows; fixed-width holds %d", len(want), fixed)
+	}
+
+	// rebuild the full leaf and round-trip it through its page
+	leaf.cells = want
+	leaf.header.numCells = uint32(len(want))
+	page, _ := tp.GetPage(leaf.Page())
+	if err := leaf.Serialize(page); err != nil {
+		t.Fatalf("Serialize: %v", err)
+	}
+	if page.Data[0] != nodeTypeLeafCompact {
+		t.Fatalf("page type = %d; want %d", page.Data[0], nodeTypeLeafCompact)
+	}
+	loaded := &LeafNode{bTreeMeta: &BTreeMeta{Pager: tp.Pager, TableMeta: tblMeta}}
+	if err := loaded.Load(page); err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if !reflect.DeepEqual(loaded.cells, want) {
+		t.Errorf("loaded %d cells differ from the %d written", len(loaded.cells), len(want))
+	}
+}
+
+// TestLeafNode_CompactReclaimsDeletedSpace deletes variable-length cells from
+// the middle of a compact leaf and checks their bytes come back as free space
+// at the end of the page, with no gap left where they were, and that Compact
+// trims the cell slice without changing what is written.
+func TestLeafNode_CompactReclaimsDeletedSpace(t *testing.T) {
+	tp := newTempPager(t)
+	defer tp.cleanup()
+
+	schema := column.Schema{
+		{Name: "id", Type: column.ColumnTypeInt},
+		{Name: "body", Type: column.ColumnTypeText, MaxLength: 1000},
+	}
+	tblMeta, _ := BuildTableMeta(schema)
+	btMeta := &BTreeMeta{Pager: tp.Pager, TableMeta: tblMeta, CompactText: true}
+	leaf, err := NewLeafNode(btMeta, true)
+	if err != nil {
+		t.Fatalf("NewLeafNode: %v", err)
+	}
+	for k := uint32(0); k < 40; k++ {
+		leaf.Insert(k, Row{k, strings.Repeat("x", int(k)*3)})
+	}
+	page, _ := tp.GetPage(leaf.Page())
+	if err := leaf.Serialize(page); err != nil {
+		t.Fatalf("Serialize: %v", err)
+	}
+	before, err := leaf.FreeSpace()
+	if err != nil {
+		t.Fatalf("FreeSpace: %v", err)
+	}
+
+	freed := 0
+	for k := uint32(10); k < 30; k++ {
+		row, _ := encodeCompactRow(tblMeta, Row{k, strings.Repeat("x", int(k)*3)})
+		freed += 6 + len(row)
+		if found, _ := leaf.Delete(k); !found {
+			t.Fatalf("Delete(%d) not found", k)
+		}
+	}
+	if err := leaf.Serialize(page); err != nil {
+		t.Fatalf("Serialize: %v", err)
+	}
+	after, err := leaf.FreeSpace()
+	if err != nil {
+		t.Fatalf("FreeSpace: %v", err)
+	}
+	if after-before != freed {
+		t.Errorf("free space grew by %d bytes; want the %d deleted", after-before, freed)
+	}
+	used := pager.PageSize - after
+	if tail := page.Data[used:]; slices.ContainsFunc(tail, func(b byte) bool { return b != 0 }) {
+		t.Errorf("bytes after the last cell at %d are not all zero", used)
+	}
+
+	written := page.Data
+	leaf.Compact()
+	if cap(leaf.cells) != len(leaf.cells) {
+		t.Errorf("after Compact cap = %d, len = %d; want equal", cap(leaf.cells), len(leaf.cells))
+	}
+	if err := leaf.Serialize(page); err != nil {
+		t.Fatalf("Serialize: %v", err)
+	}
+	if page.Data != written {
+		t.Error("Compact changed the serialized page")
+	}
+	loaded := &LeafNode{bTreeMeta: btMeta}
+	if err := loaded.Load(page); err != nil || len(loaded.cells) != 20 {
+		t.Fatalf("Load = %d cells, %v; want 20", len(loaded.cells), err)
+	}
+}
+
+// TestLeafNode_VerifySortedCatchesUnsortedLeaf writes a leaf page whose keys
+// are out of order and checks Load only rejects it with VerifyOnLoad set,
+// and that Validate finds it in a tree, naming the offending pair.
+func TestLeafNode_VerifySortedCatchesUnsortedLeaf(t *testing.T) {
+	tp := newTempPager(t)
+	defer tp.cleanup()
+	meta, _ := BuildTableMeta(column.Schema{{Name: "id", Type: column.ColumnTypeInt}})
+	bt, err := NewBTree(tp.Pager, meta)
+	if err != nil {
+		t.Fatalf("NewBTree: %v", err)
+	}
+	for _, k := range []uint32{1, 3, 5, 7} {
+		if err := bt.Insert(k, Row{k}); err != nil {
+			t.Fatalf("insert %d: %v", k, err)
+		}
+	}
+	if err := bt.Validate(); err != nil {
+		t.Fatalf("Validate of a sorted tree: %v", err)
+	}
+
+	leaf, err := bt.loadLeafNode(bt.rootPage)
+	if err != nil {
+		t.Fatalf("loadLeafNode: %v", err)
+	}
+	leaf.cells[1], leaf.cells[2] = leaf.cells[2], leaf.cells[1] // 1 5 3 7
+	page, _ := tp.GetPage(bt.rootPage)
+	if err := leaf.Serialize(page); err != nil {
+		t.Fatalf("Serialize: %v", err)
+	}
+	bt.bTreeMeta.evictNode(bt.rootPage)
+
+	plain := &LeafNode{bTreeMeta: &BTreeMeta{Pager: tp.Pager, TableMeta: meta}}
+	if err := plain.Load(page); err != nil {
+		t.Errorf("Load without VerifyOnLoad: %v", err)
+	}
+	checked := &LeafNode{bTreeMeta: &BTreeMeta{Pager: tp.Pager, TableMeta: meta, VerifyOnLoad: true}}
+	err = checked.Load(page)
+	if !errors.Is(err, ErrKeysOutOfOrder) || !strings.Contains(err.Error(), "key 5 at cell 1 followed by 3") {
+		t.Errorf("Load with VerifyOnLoad err = %v; want ErrKeysOutOfOrder naming 5 then 3", err)
+	}
+	if err := bt.Validate(); !errors.Is(err, ErrKeysOutOfOrder) {
+		t.Errorf("Validate err = %v; want ErrKeysOutOfOrder", err)
+	}
+}
+
+// TestLeafSerialize_FailureLeavesPageIntact inserts a row of the wrong type
+// into a populated tree and checks the insert fails while the leaf page and
+// the rows already stored are unchanged, also when serializing a leaf that
+// holds the bad row directly.
+func TestLeafSerialize_FailureLeavesPageIntact(t *testing.T) {
+	tp := newTempPager(t)
+	defer tp.cleanup()
+	meta, _ := BuildTableMeta(column.Schema{
+		{Name: "id", Type: column.ColumnTypeInt},
+		{Name: "name", Type: column.ColumnTypeText, MaxLength: 8},
+	})
+	bt, err := NewBTree(tp.Pager, meta)
+	if err != nil {
+		t.Fatalf("NewBTree: %v", err)
+	}
+	for i := uint32(1); i <= 5; i++ {
+		if err := bt.Insert(i, Row{i, "ok"}); err != nil {
+			t.Fatalf("insert %d: %v", i, err)
+		}
+	}
+	pg, _ := tp.GetPage(bt.rootPage)
+	before := pg.Data
+
+	if err := bt.Insert(3, Row{"three", "bad"}); err == nil {
+		t.Fatal("insert of a mistyped row succeeded")
+	}
+	if err := bt.Insert(9, Row{uint32(9), 9}); err == nil {
+		t.Fatal("insert of a mistyped row succeeded")
+	}
+	if pg.Data != before {
+		t.Error("failed insert changed the leaf page")
+	}
+	for i := uint32(1); i <= 5; i++ {
+		row, found, err := bt.Search(i)
+		if err != nil || !found || !row.Equal(Row{i, "ok"}) {
+			t.Errorf("Search(%d) = %v, %v, %v; want the original row", i, row, found, err)
+		}
+	}
+	if _, found, _ := bt.Search(9); found {
+		t.Error("mistyped row for key 9 is in the tree")
+	}
+
+	for _, separate := range []bool{false, true} {
+		bt.SetSeparateValues(separate)
+		leaf, _ := bt.loadLeafNode(bt.rootPage)
+		bad := &LeafNode{bTreeMeta: leaf.bTreeMeta, header: leaf.header,
+			cells: append(slices.Clone(leaf.cells), LeafCell{Key: 6, Value: Row{uint32(6), 6}})}
+		bad.header.numCells++
+		snapshot := pg.Data
+		if err := bad.Serialize(pg); err == nil {
+			t.Fatalf("Serialize of a mistyped row succeeded (separate=%v)", separate)
+		}
+		if pg.Data != snapshot {
+			t.Errorf("failed Serialize changed the page (separate=%v)", separate)
+		}
+	}
+}
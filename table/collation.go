@@ -0,0 +1,55 @@
+package table
+
+import (
+	"cmp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Collation decides how two TEXT values are ordered.
+type Collation int
+
+const (
+	// Binary orders strings byte by byte, so "Zebra" sorts before "apple".
+	Binary Collation = iota
+	// NoCase ignores ASCII and Unicode case: "Apple" and "apple" compare
+	// equal, as do "K" and the Kelvin sign, exactly when strings.EqualFold
+	// says so.
+	NoCase
+)
+
+// Compare returns -1, 0 or +1 as a sorts before, equal to, or after b.
+func (c Collation) Compare(a, b string) int {
+	if c != NoCase {
+		return strings.Compare(a, b)
+	}
+	for a != "" && b != "" {
+		ra, na := utf8.DecodeRuneInString(a)
+		rb, nb := utf8.DecodeRuneInString(b)
+		if r := cmp.Compare(foldRune(ra), foldRune(rb)); r != 0 {
+			return r
+		}
+		a, b = a[na:], b[nb:]
+	}
+	return cmp.Compare(len(a), len(b))
+}
+
+// Equal reports whether a and b are the same key under c.
+func (c Collation) Equal(a, b string) bool {
+	if c == NoCase {
+		return strings.EqualFold(a, b)
+	}
+	return a == b
+}
+
+// foldRune maps r to one rune standing for every rune strings.EqualFold
+// treats as equal to it: the lower case of the smallest of them, so letters
+// sort among other characters as their lower case does.
+func foldRune(r rune) rune {
+	least := r
+	for f := unicode.SimpleFold(r); f != r; f = unicode.SimpleFold(f) {
+		least = min(least, f)
+	}
+	return unicode.ToLower(least)
+}
@@ -0,0 +1,128 @@
+package table
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+
+	"vqlite/column"
+	"vqlite/pager"
+)
+
+// nodeTypeLeafCompact marks a leaf whose cells have variable length:
+//
+//	[ key:uint32 | rowLen:uint16 | row ]
+//
+// where row holds INT columns as 4 bytes and TEXT columns as a uint16 length
+// followed by only the bytes actually used, instead of MaxLength padded bytes.
+// How many cells fit is a byte budget rather than a fixed count.
+const nodeTypeLeafCompact = 3
+
+// SetCompactText turns the variable-length cell format on or off for leaves
+// written from now on. It takes precedence over compression. Pages already on
+// disk keep their format; the type byte tells Load how to read each one.
+func (t *BTree) SetCompactText(on bool) {
+	t.bTreeMeta.CompactText = on
+}
+
+// encodeCompactRow serializes row in the compact format.
+func encodeCompactRow(meta *TableMeta, row Row) ([]byte, error) {
+	fixed := make([]byte, meta.RowSize)
+	if err := SerializeRow(meta, row, fixed); err != nil {
+		return nil, err
+	}
+	out := make([]byte, 0, meta.RowSize)
+	for _, col := range meta.Columns {
+		field := fixed[col.Offset : col.Offset+col.ByteSize]
+		if col.Type == column.ColumnTypeText {
+			field = bytes.TrimRight(field, "\x00")
+			out = binary.LittleEndian.AppendUint16(out, uint16(len(field)))
+		}
+		out = append(out, field...)
+	}
+	return out, nil
+}
+
+// expandCompactRow turns a compact row back into the fixed-width layout of
+// SerializeRow, writing it to dst (meta.RowSize bytes).
+func expandCompactRow(meta *TableMeta, src, dst []byte) error {
+	clear(dst)
+	off := 0
+	for _, col := range meta.Columns {
+		size := int(col.ByteSize)
+		if col.Type == column.ColumnTypeText {
+			if off+2 > len(src) {
+				return fmt.Errorf("compact row: truncated length of column %q", col.Name)
+			}
+			size = int(binary.LittleEndian.Uint16(src[off:]))
+			off += 2
+			if size > int(col.ByteSize) {
+				return fmt.Errorf("compact row: column %q holds %d bytes, max %d", col.Name, size, col.ByteSize)
+			}
+		}
+		if off+size > len(src) {
+			return fmt.Errorf("compact row: truncated column %q", col.Name)
+		}
+		copy(dst[col.Offset:], src[off:off+size])
+		off += size
+	}
+	return nil
+}
+
+// compactCells encodes every cell of the leaf in the compact format.
+func (n *LeafNode) compactCells() ([]byte, error) {
+	var buf []byte
+	for _, c := range n.cells {
+		row, err := encodeCompactRow(n.bTreeMeta.TableMeta, c.Value)
+		if err != nil {
+			return nil, err
+		}
+		buf = binary.LittleEndian.AppendUint32(buf, c.Key)
+		buf = binary.LittleEndian.AppendUint16(buf, uint16(len(row)))
+		buf = append(buf, row...)
+	}
+	return buf, nil
+}
+
+// serializeCompact writes the header followed by the compact cells. It fails
+// if they do not fit in the page.
+func (n *LeafNode) serializeCompact(p *pager.Page) error {
+	cells, err := n.compactCells()
+	if err != nil {
+		return fmt.Errorf("LeafNode.Serialize: %w", err)
+	}
+	if headerSize+len(cells) > pager.PageSize {
+		return fmt.Errorf("LeafNode.Serialize: %d bytes of cells do not fit in a page", len(cells))
+	}
+	n.header.writeTo(p.Data[:headerSize], nodeTypeLeafCompact)
+	off := copy(p.Data[headerSize:], cells) + headerSize
+	zeroTail(p, off)
+	p.Dirty = true
+	return nil
+}
+
+// expandCompactCells converts the compact cells of page p into the fixed
+// [ key | row ] layout of an uncompressed leaf.
+func expandCompactCells(p *pager.Page, numCells int, meta *TableMeta) ([]byte, error) {
+	cellSize := int(LeafCellSize(meta.RowSize))
+	raw := make([]byte, numCells*cellSize)
+	src := p.Data[headerSize:]
+	off := 0
+	for i := 0; i < numCells; i++ {
+		if off+6 > len(src) {
+			return nil, fmt.Errorf("compact cell %d: truncated header", i)
+		}
+		dst := raw[i*cellSize : (i+1)*cellSize]
+		copy(dst[:4], src[off:off+4])
+		rowLen := int(binary.LittleEndian.Uint16(src[off+4:]))
+		off += 6
+		if off+rowLen > len(src) {
+			return nil, fmt.Errorf("compact cell %d: row of %d bytes exceeds page", i, rowLen)
+		}
+		if err := expandCompactRow(meta, src[off:off+rowLen], dst[4:]); err != nil {
+			return nil, fmt.Errorf("compact cell %d: %w", i, err)
+		}
+		off += rowLen
+	}
+	return raw, nil
+}
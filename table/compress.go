@@ -0,0 +1,181 @@
+package table
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	"vqlite/pager"
+)
+
+const (
+	// nodeTypeLeafCompressed marks a leaf whose cell region is stored
+	// flate-compressed after a uint32 compressed-length prefix.
+	nodeTypeLeafCompressed = 2
+
+	compressedHeaderSize = headerSize + 4
+)
+
+// SetCompression turns per-leaf compression on or off for leaves written from
+// now on. Pages already on disk keep their format; the type byte tells Load
+// how to read each one.
+func (t *BTree) SetCompression(on bool) {
+	t.bTreeMeta.Compress = on
+}
+
+// isLeafType reports whether a page type byte denotes a leaf in any format.
+func isLeafType(b byte) bool {
+	return b == nodeTypeLeaf || b == nodeTypeLeafCompressed || b == nodeTypeLeafCompact || b == nodeTypeLeafSeparated
+}
+
+// encodeCells serializes all cells back to back into a fresh buffer, in the
+// same [ key | row ] layout used by uncompressed pages.
+func (n *LeafNode) encodeCells() ([]byte, error) {
+	rowSize := int(n.bTreeMeta.TableMeta.RowSize)
+	buf := make([]byte, len(n.cells)*(4+rowSize))
+	off := 0
+	for _, c := range n.cells {
+		binary.LittleEndian.PutUint32(buf[off:off+4], c.Key)
+		off += 4
+		if err := SerializeRow(n.bTreeMeta.TableMeta, c.Value, buf[off:off+rowSize]); err != nil {
+			return nil, err
+		}
+		off += rowSize
+	}
+	return buf, nil
+}
+
+// serializeCompressed writes the header followed by the compressed cell
+// region. It fails if even the compressed cells do not fit in the page.
+func (n *LeafNode) serializeCompressed(p *pager.Page) error {
+	raw, err := n.encodeCells()
+	if err != nil {
+		return fmt.Errorf("LeafNode.Serialize: %w", err)
+	}
+	z, err := compressCells(raw)
+	if err != nil {
+		return fmt.Errorf("LeafNode.Serialize: %w", err)
+	}
+	if compressedHeaderSize+len(z) > pager.PageSize {
+		return fmt.Errorf("LeafNode.Serialize: %d compressed bytes do not fit in a page", len(z))
+	}
+	n.header.writeTo(p.Data[:headerSize], nodeTypeLeafCompressed)
+	binary.LittleEndian.PutUint32(p.Data[headerSize:compressedHeaderSize], uint32(len(z)))
+	off := copy(p.Data[compressedHeaderSize:], z) + compressedHeaderSize
+	zeroTail(p, off)
+	p.Dirty = true
+	return nil
+}
+
+// cellRegion returns the cell bytes of a leaf page in the fixed-width
+// [ key | row ] layout, inflating compressed pages and expanding compact ones
+// first.
+func cellRegion(p *pager.Page, numCells int, meta *TableMeta) ([]byte, error) {
+	switch p.Data[0] {
+	case nodeTypeLeafCompact:
+		return expandCompactCells(p, numCells, meta)
+	case nodeTypeLeafSeparated:
+		return joinSeparatedCells(p, numCells, meta)
+	case nodeTypeLeafCompressed:
+		zlen := int(binary.LittleEndian.Uint32(p.Data[headerSize:compressedHeaderSize]))
+		if compressedHeaderSize+zlen > pager.PageSize {
+			return nil, fmt.Errorf("compressed length %d exceeds page", zlen)
+		}
+		return decompressCells(p.Data[compressedHeaderSize:compressedHeaderSize+zlen], numCells*int(LeafCellSize(meta.RowSize)))
+	default:
+		cellSize := int(LeafCellSize(meta.RowSize))
+		if numCells < 0 || numCells > (pager.PageSize-headerSize)/cellSize {
+			return nil, fmt.Errorf("page declares %d cells of %d bytes but at most %d fit; was it written with a different row size?",
+				numCells, cellSize, (pager.PageSize-headerSize)/cellSize)
+		}
+		return p.Data[headerSize : headerSize+numCells*cellSize], nil
+	}
+}
+
+// overflows reports whether the leaf holds more than fits in one page in
+// the format it would be written in.
+func (n *LeafNode) overflows() bool {
+	return !n.fits(n.writeFormat())
+}
+
+// configuredFormat returns the leaf page type the tree's options select for
+// leaves written from now on.
+func (m *BTreeMeta) configuredFormat() byte {
+	switch {
+	case m.CompactText:
+		return nodeTypeLeafCompact
+	case m.Compress:
+		return nodeTypeLeafCompressed
+	case m.SeparateValues:
+		return nodeTypeLeafSeparated
+	default:
+		return nodeTypeLeaf
+	}
+}
+
+// writeFormat returns the page type Serialize writes the leaf as: the
+// configured one, unless the cells do not fit it but the leaf's page is
+// compressed or compact. Compress and CompactText are not recorded in the
+// file, so a dense leaf can meet a tree with them off, for instance after a
+// reopen without them; it then keeps the format of its page, and so do the
+// halves it splits into, until its cells fit the configured format again.
+func (n *LeafNode) writeFormat() byte {
+	want := n.bTreeMeta.configuredFormat()
+	if n.format == want || (n.format != nodeTypeLeafCompressed && n.format != nodeTypeLeafCompact) || n.fits(want) {
+		return want
+	}
+	return n.format
+}
+
+// fits reports whether the leaf's cells fit in one page of the given type.
+func (n *LeafNode) fits(format byte) bool {
+	switch format {
+	case nodeTypeLeafCompact:
+		cells, err := n.compactCells()
+		return err != nil || headerSize+len(cells) <= pager.PageSize // Serialize reports the bad row
+	case nodeTypeLeafCompressed:
+		raw, err := n.encodeCells()
+		if err != nil {
+			return true
+		}
+		z, err := compressCells(raw)
+		return err == nil && compressedHeaderSize+len(z) <= pager.PageSize
+	default:
+		return len(n.cells) <= n.bTreeMeta.TableMeta.RowsPerPage()
+	}
+}
+
+// full reports whether one more insert may split the leaf. How much fits in
+// a compressed or compact leaf depends on the data, so those are always
+// treated as full, whether the options or the leaf's own page make it one.
+func (n *LeafNode) full() bool {
+	return n.bTreeMeta.Compress || n.bTreeMeta.CompactText || n.format == nodeTypeLeafCompressed ||
+		n.format == nodeTypeLeafCompact || len(n.cells) >= n.bTreeMeta.TableMeta.RowsPerPage()
+}
+
+func compressCells(raw []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w, err := flate.NewWriter(&buf, flate.BestSpeed)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(raw); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func decompressCells(z []byte, rawLen int) ([]byte, error) {
+	raw := make([]byte, rawLen)
+	r := flate.NewReader(bytes.NewReader(z))
+	defer r.Close()
+	if _, err := io.ReadFull(r, raw); err != nil {
+		return nil, fmt.Errorf("decompress cells: %w", err)
+	}
+	return raw, nil
+}
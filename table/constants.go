@@ -19,7 +19,9 @@ const (
 	// Leaf Node Header Layout
 	LeafNodeNumCellsSize   = unsafe.Sizeof(uint32(0))
 	LeafNodeNumCellsOffset = CommonNodeHeaderSize
-	LeafNodeHeaderSize     = uint32(CommonNodeHeaderSize + LeafNodeNumCellsSize)
+	LeafNodeNextLeafSize   = unsafe.Sizeof(uint32(0))
+	LeafNodeNextLeafOffset = LeafNodeNumCellsOffset + LeafNodeNumCellsSize
+	LeafNodeHeaderSize     = uint32(CommonNodeHeaderSize + LeafNodeNumCellsSize + LeafNodeNextLeafSize)
 
 	// Leaf Node Body Layout (key + value)
 	LeafNodeKeySize   = 4
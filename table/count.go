@@ -0,0 +1,225 @@
+package table
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Subtree key counts. Every interior node records, for each child, how many
+// keys live in that child's subtree: leftCount for leftChild and Count in
+// each cell. Insert, Delete, splits, bulk loads and leaf merges keep them
+// exact, so rank and range-count queries can skip whole subtrees instead of
+// reading their leaves.
+
+// subtreeCount returns the number of keys below node.
+func subtreeCount(node BTreeNode) uint32 {
+	switch v := node.(type) {
+	case *LeafNode:
+		return uint32(len(v.cells))
+	case *InteriorNode:
+		n := v.leftCount
+		for _, c := range v.cells {
+			n += c.Count
+		}
+		return n
+	default:
+		return 0
+	}
+}
+
+// childCount returns the key count of child i (0 for leftChild).
+func (n *InteriorNode) childCount(i int) uint32 {
+	if i == 0 {
+		return n.leftCount
+	}
+	return n.cells[i-1].Count
+}
+
+// setChildCount records the key count of child i (0 for leftChild).
+func (n *InteriorNode) setChildCount(i int, count uint32) {
+	if i == 0 {
+		n.leftCount = count
+	} else {
+		n.cells[i-1].Count = count
+	}
+}
+
+// updateCounts refreshes the counts on path, as returned by interiorPath,
+// after child, the node below its last entry, gained or lost keys, and
+// serializes each node.
+func (t *BTree) updateCounts(path []*InteriorNode, idxs []int, child BTreeNode) error {
+	for i := len(path) - 1; i >= 0; i-- {
+		path[i].setChildCount(idxs[i], subtreeCount(child))
+		if err := t.serializeNode(path[i]); err != nil {
+			return err
+		}
+		child = path[i]
+	}
+	return nil
+}
+
+// leafChanged refreshes the counts above leaf after keys were removed from
+// it outside Insert and Delete. The path is found by descending towards
+// key, a key of the leaf; if that leads elsewhere, as it can for a run of
+// duplicate keys spanning leaves, every count is recomputed.
+func (t *BTree) leafChanged(leaf *LeafNode, key uint32) error {
+	path, idxs, err := t.interiorPath(key)
+	if err != nil {
+		return err
+	}
+	if len(path) > 0 && path[len(path)-1].child(idxs[len(idxs)-1]) != leaf.Page() {
+		_, err := t.recount(t.rootPage)
+		return err
+	}
+	return t.updateCounts(path, idxs, leaf)
+}
+
+// recount recomputes the counts of the subtree at pgno from its leaves up,
+// serializing every interior node, and returns the subtree's key count.
+func (t *BTree) recount(pgno uint32) (uint32, error) {
+	node, err := t.loadNode(pgno)
+	if err != nil {
+		return 0, err
+	}
+	in, ok := node.(*InteriorNode)
+	if !ok {
+		return subtreeCount(node), nil
+	}
+	for i := 0; i < in.numChildren(); i++ {
+		n, err := t.recount(in.child(i))
+		if err != nil {
+			return 0, err
+		}
+		in.setChildCount(i, n)
+	}
+	if err := t.serializeNode(in); err != nil {
+		return 0, err
+	}
+	return subtreeCount(in), nil
+}
+
+// countLess returns how many keys in the tree are below key, reading one
+// node per level. At each interior node the children left of the one that
+// can hold key contribute their whole count; with duplicate keys a run of
+// key may start in that child but never further left.
+func (t *BTree) countLess(key uint32) (uint32, error) {
+	var n uint32
+	pgno := t.rootPage
+	for {
+		node, err := t.loadNode(pgno)
+		if err != nil {
+			return 0, fmt.Errorf("load page %d: %w", pgno, err)
+		}
+		if leaf, ok := node.(*LeafNode); ok {
+			return n + uint32(sort.Search(len(leaf.cells), func(i int) bool {
+				return compareKeys(leaf.cells[i].Key, key) >= 0
+			})), nil
+		}
+		in := node.(*InteriorNode)
+		i := sort.Search(len(in.cells), func(i int) bool {
+			return compareKeys(in.cells[i].Key, key) >= 0
+		})
+		for j := 0; j < i; j++ {
+			n += in.childCount(j)
+		}
+		pgno = in.child(i)
+	}
+}
+
+// CountRange returns how many keys lie in [lo, hi]. It uses the subtree
+// counts, so it reads one root-to-leaf path per bound whatever the size of
+// the range. The counts include expired rows not yet purged, so for a TTL
+// table it scans the range instead.
+func (t *BTree) CountRange(lo, hi uint32) (int, error) {
+	if lo > hi {
+		return 0, nil
+	}
+	if t.bTreeMeta.TableMeta.TTLColumn != "" {
+		return t.countRangeByScan(lo, hi)
+	}
+	below, err := t.countLess(lo)
+	if err != nil {
+		return 0, err
+	}
+	var upTo uint32
+	if hi == ^uint32(0) {
+		root, err := t.loadNode(t.rootPage)
+		if err != nil {
+			return 0, err
+		}
+		upTo = subtreeCount(root)
+	} else if upTo, err = t.countLess(hi + 1); err != nil {
+		return 0, err
+	}
+	return int(upTo - below), nil
+}
+
+// countRangeByScan counts the keys in [lo, hi] a cursor visits.
+func (t *BTree) countRangeByScan(lo, hi uint32) (int, error) {
+	c := &Cursor{tree: t}
+	if err := c.Seek(lo); err != nil {
+		return 0, err
+	}
+	n := 0
+	for c.Valid() && compareKeys(c.Key(), hi) <= 0 {
+		n++
+		if err := c.Next(); err != nil {
+			return 0, err
+		}
+	}
+	return n, nil
+}
+
+// selectByCount descends to the row at position n using the subtree counts.
+func (t *BTree) selectByCount(n int) (uint32, Row, bool, error) {
+	rank := uint32(n)
+	pgno := t.rootPage
+	for {
+		node, err := t.loadNode(pgno)
+		if err != nil {
+			return 0, nil, false, err
+		}
+		if leaf, ok := node.(*LeafNode); ok {
+			if int(rank) >= len(leaf.cells) {
+				return 0, nil, false, nil
+			}
+			c := leaf.cells[rank]
+			return c.Key, c.Value, true, nil
+		}
+		in := node.(*InteriorNode)
+		i := 0
+		for ; i < in.numChildren()-1 && rank >= in.childCount(i); i++ {
+			rank -= in.childCount(i)
+		}
+		pgno = in.child(i)
+	}
+}
+
+// KeyRange returns the smallest and largest key and the number of keys, with
+// ok false for an empty tree. The count comes from the root's subtree counts
+// and the extremes from the leftmost and rightmost leaves, so it reads about
+// two root-to-leaf paths whatever the size of the tree. Expired rows are
+// passed over; for a TTL table the count comes from a scan, as CountRange's
+// does.
+func (t *BTree) KeyRange() (lo, hi uint32, count int, ok bool, err error) {
+	if t.bTreeMeta.TableMeta.TTLColumn != "" {
+		count, err = t.countRangeByScan(0, ^uint32(0))
+	} else {
+		var root BTreeNode
+		if root, err = t.loadNode(t.rootPage); err == nil {
+			count = int(subtreeCount(root))
+		}
+	}
+	if err != nil || count == 0 {
+		return 0, 0, 0, false, err
+	}
+	c, err := t.NewCursor()
+	if err != nil {
+		return 0, 0, 0, false, err
+	}
+	hi, _, _, err = t.lastIn(t.rootPage)
+	if err != nil {
+		return 0, 0, 0, false, err
+	}
+	return c.Key(), hi, count, true, nil
+}
@@ -0,0 +1,326 @@
+package table
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+)
+
+// Defragment merges adjacent under-full leaves that share a parent, and
+// empty leaves into their neighbours, which is much cheaper than rebuilding
+// the whole tree. The right leaf of each merged
+// pair is released to the free list and its separator removed from the parent;
+// no other interior structure is touched.
+func (t *BTree) Defragment() error {
+	pages, err := t.nodePages()
+	if err != nil {
+		return fmt.Errorf("failed to collect tree pages: %w", err)
+	}
+	for _, pgno := range pages {
+		if slices.Contains(t.bTreeMeta.freeList().freePages, pgno) {
+			continue // a leaf merged away earlier in this pass
+		}
+		node, err := t.loadNode(pgno)
+		if err != nil {
+			return err
+		}
+		if in, ok := node.(*InteriorNode); ok {
+			if err := t.mergeLeafChildren(in); err != nil {
+				return err
+			}
+		}
+	}
+	return t.writeFreeList()
+}
+
+// mergeLeafChildren merges neighbouring leaf children of in that are both
+// under-full, or of which one is empty, left to right, and serializes every
+// node it changes.
+func (t *BTree) mergeLeafChildren(in *InteriorNode) error {
+	// two under-full leaves always fit in one, as does a leaf with an empty one
+	fill := t.bTreeMeta.TableMeta.minLeafCells()
+	changed := false
+	for j := 0; j+1 < in.numChildren(); {
+		if len(t.bTreeMeta.freeList().freePages) >= maxFreePages {
+			break
+		}
+		left, err := t.loadNode(in.child(j))
+		if err != nil {
+			return err
+		}
+		if !left.IsLeaf() {
+			// children of an interior node are all on the same level
+			return nil
+		}
+		right, err := t.loadLeafNode(in.child(j + 1))
+		if err != nil {
+			return err
+		}
+		l := left.(*LeafNode)
+		empty := len(l.cells) == 0 || len(right.cells) == 0
+		if !empty && (len(l.cells) >= fill || len(right.cells) >= fill) {
+			j++
+			continue
+		}
+
+		n := len(l.cells)
+		l.cells = append(slices.Clip(l.cells), right.cells...)
+		if l.overflows() {
+			// the pair is small by count but not by bytes
+			l.cells = l.cells[:n]
+			j++
+			continue
+		}
+		l.header.numCells = uint32(len(l.cells))
+		l.header.rightPointer = right.header.rightPointer
+		if err := t.serializeNode(l); err != nil {
+			return fmt.Errorf("failed to serialize merged leaf: %w", err)
+		}
+
+		// drop the separator in front of the right leaf
+		in.setChildCount(j, uint32(len(l.cells)))
+		in.cells = slices.Delete(in.cells, j, j+1)
+		in.header.numCells = uint32(len(in.cells))
+		t.bTreeMeta.releasePage(right.Page())
+		if h := t.bTreeMeta.hooks.OnMerge; h != nil {
+			h(l.Page(), right.Page())
+		}
+		changed = true
+	}
+	if !changed {
+		return nil
+	}
+	t.gen++
+	return t.serializeNode(in)
+}
+
+// Vacuum rebuilds the whole tree from its rows with every leaf packed full,
+// then truncates the free pages left at the end of the file. Cursors
+// positioned before it become stale.
+func (t *BTree) Vacuum() error {
+	return t.VacuumContext(context.Background())
+}
+
+// VacuumContext is Vacuum that gives up with ctx.Err(), leaving the tree
+// unchanged, if ctx is done while the rows are being read. Once the rebuild
+// has started it runs to completion, as stopping part way would lose rows.
+func (t *BTree) VacuumContext(ctx context.Context) error {
+	data, err := t.allPairs(ctx)
+	if err != nil {
+		return fmt.Errorf("vacuum: %w", err)
+	}
+	if err := t.rebuild(data); err != nil {
+		return fmt.Errorf("vacuum: %w", err)
+	}
+	if err := t.truncateFreeTail(); err != nil {
+		return fmt.Errorf("vacuum: truncate free tail: %w", err)
+	}
+	return t.writeFreeList()
+}
+
+// Truncate deletes every row: all node pages go to the free list, a single
+// empty root leaf (on one of them) takes their place, and the row count is
+// reset to zero. Cursors positioned before it become stale.
+//
+// resetCounter says what happens to row ids. With it, the next-rowid counter
+// starts over, so the next key inserted gets row id 1, and the row id
+// indexes are emptied with the tree. Without it, row ids go on counting from
+// where they were, as after deleting the rows one by one, and the index
+// entries left behind are skipped by ScanByRowID. Only the primary tree has
+// a counter to reset.
+func (t *BTree) Truncate(resetCounter bool) error {
+	if resetCounter && t.dirOff != 0 {
+		return errors.New("truncate: only the primary tree has a row id counter")
+	}
+	if err := t.rebuild(nil); err != nil {
+		return fmt.Errorf("truncate: %w", err)
+	}
+	n, err := t.NumRows()
+	if err != nil {
+		return fmt.Errorf("truncate: %w", err)
+	}
+	if err := t.addRows(-int(n)); err != nil {
+		return fmt.Errorf("truncate: %w", err)
+	}
+	if resetCounter {
+		if err := t.resetRowIDs(); err != nil {
+			return fmt.Errorf("truncate: %w", err)
+		}
+	}
+	return nil
+}
+
+// RelinkLeaves rebuilds the rightPointer chain the cursor follows from the
+// interior nodes, for recovering a file where a crash left a leaf's pointer
+// unset or stale and scans stop early. Leaves are visited level by level, so
+// they come in key order; only those whose pointer is wrong are rewritten.
+func (t *BTree) RelinkLeaves() error {
+	_, err := t.relinkLeaves()
+	return err
+}
+
+// relinkLeaves is RelinkLeaves, also reporting how many leaves it rewrote.
+func (t *BTree) relinkLeaves() (int, error) {
+	var leaves []*LeafNode
+	err := t.WalkPages(func(_ uint32, node BTreeNode) error {
+		if leaf, ok := node.(*LeafNode); ok {
+			leaves = append(leaves, leaf)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("relink leaves: %w", err)
+	}
+	relinked := 0
+	for i, leaf := range leaves {
+		var next uint32
+		if i+1 < len(leaves) {
+			next = leaves[i+1].Page()
+		}
+		if leaf.header.rightPointer == next {
+			continue
+		}
+		leaf.header.rightPointer = next
+		if err := t.serializeNode(leaf); err != nil {
+			return relinked, fmt.Errorf("relink leaves: page %d: %w", leaf.Page(), err)
+		}
+		relinked++
+	}
+	return relinked, nil
+}
+
+// IncrementalVacuum shrinks the file by moving at most maxPages of the
+// tree's pages from the end of the file onto the lowest free pages and
+// truncating the free pages left at the end, so compaction can be spread
+// over many calls instead of one Vacuum. Unlike Vacuum it leaves the leaves
+// as full as they were. done reports that the file cannot shrink further
+// this way: no free page lies below its last page, or that page belongs to
+// an index tree, whose pages are left alone. Cursors positioned before a
+// call that moved pages become stale.
+func (t *BTree) IncrementalVacuum(maxPages int) (reclaimed int, done bool, err error) {
+	p := t.bTreeMeta.Pager
+	before := p.NumPages
+	locs, err := t.pageLocations()
+	if err != nil {
+		return 0, false, fmt.Errorf("incremental vacuum: %w", err)
+	}
+	fl := t.bTreeMeta.freeList()
+	for moved := 0; ; moved++ {
+		if err := t.truncateFreeTail(); err != nil {
+			return before - p.NumPages, false, fmt.Errorf("incremental vacuum: truncate free tail: %w", err)
+		}
+		last := uint32(p.NumPages - 1)
+		_, ours := locs[last]
+		if len(fl.freePages) == 0 || !ours {
+			done = true
+			break
+		}
+		if moved >= maxPages {
+			break
+		}
+		i := slices.Index(fl.freePages, slices.Min(fl.freePages))
+		dst := fl.freePages[i]
+		fl.freePages = slices.Delete(fl.freePages, i, i+1)
+		if err := t.movePage(locs, last, dst); err != nil {
+			return before - p.NumPages, false, fmt.Errorf("incremental vacuum: move page %d to %d: %w", last, dst, err)
+		}
+	}
+	return before - p.NumPages, done, t.writeFreeList()
+}
+
+// pageLocation records where the tree points at one of its nodes.
+type pageLocation struct {
+	parent   uint32 // interior node holding the page as a child, 0 for the root
+	idx      int    // child index within parent
+	prevLeaf uint32 // for a leaf, the leaf whose rightPointer names it, or 0
+}
+
+// pageLocations maps every node page of the tree to its location.
+func (t *BTree) pageLocations() (map[uint32]pageLocation, error) {
+	locs := map[uint32]pageLocation{t.rootPage: {}}
+	var prevLeaf uint32
+	err := t.WalkPages(func(pgno uint32, node BTreeNode) error {
+		switch n := node.(type) {
+		case *InteriorNode:
+			for i := 0; i < n.numChildren(); i++ {
+				locs[n.child(i)] = pageLocation{parent: pgno, idx: i}
+			}
+		case *LeafNode:
+			// leaves are all on the last level, which WalkPages visits in key order
+			loc := locs[pgno]
+			loc.prevLeaf = prevLeaf
+			locs[pgno] = loc
+			prevLeaf = pgno
+		}
+		return nil
+	})
+	return locs, err
+}
+
+// movePage copies the node on page src onto the free page dst, points its
+// parent (or the meta page, for the root) and its left leaf at dst, releases
+// src, and updates locs to match.
+func (t *BTree) movePage(locs map[uint32]pageLocation, src, dst uint32) error {
+	m := t.bTreeMeta
+	node, err := t.loadNode(src)
+	if err != nil {
+		return err
+	}
+	if err := m.Pager.CopyPage(src, dst); err != nil {
+		return err
+	}
+	m.evictNode(src)
+	m.evictNode(dst)
+	m.releasePage(src)
+	t.gen++
+
+	loc := locs[src]
+	delete(locs, src)
+	locs[dst] = loc
+	if src == t.rootPage {
+		if err := t.updateRootPointer(dst); err != nil {
+			return err
+		}
+	} else {
+		parent, err := t.loadNode(loc.parent)
+		if err != nil {
+			return err
+		}
+		in := parent.(*InteriorNode)
+		if loc.idx == 0 {
+			in.leftChild = dst
+		} else {
+			in.cells[loc.idx-1].ChildPage = dst
+		}
+		if err := t.serializeNode(in); err != nil {
+			return err
+		}
+	}
+
+	switch n := node.(type) {
+	case *InteriorNode:
+		for i := 0; i < n.numChildren(); i++ {
+			c := locs[n.child(i)]
+			c.parent = dst
+			locs[n.child(i)] = c
+		}
+	case *LeafNode:
+		if loc.prevLeaf != 0 {
+			prev, err := t.loadLeafNode(loc.prevLeaf)
+			if err != nil {
+				return err
+			}
+			prev.header.rightPointer = dst
+			if err := t.serializeNode(prev); err != nil {
+				return err
+			}
+		}
+		if next := n.header.rightPointer; next != 0 {
+			c := locs[next]
+			c.prevLeaf = dst
+			locs[next] = c
+		}
+	}
+	return nil
+}
@@ -0,0 +1,128 @@
+package table
+
+import (
+	"errors"
+	"time"
+)
+
+// QueryStats describes how a query ran: whether it went straight to its key
+// or scanned every row, how many tree nodes it loaded, how many pages it read
+// from the file, and how many rows it looked at versus returned. NodesLoaded
+// counts every node load, whether or not the node cache or the pager already
+// held it, so it reflects the access path; PagesRead is the pager's disk
+// I/O, and is 0 for a query whose pages were all cached.
+type QueryStats struct {
+	Seek         bool
+	NodesLoaded  int
+	PagesRead    int
+	RowsScanned  int
+	RowsReturned int
+	Elapsed      time.Duration
+}
+
+// ExplainLookup runs a select by primary key (where id = key) and reports
+// how it ran.
+func (t *BTree) ExplainLookup(key uint32) (QueryStats, error) {
+	stats := QueryStats{Seek: true}
+	start, loads, reads := time.Now(), t.bTreeMeta.nodeLoads, t.bTreeMeta.Pager.Reads
+	c := &Cursor{tree: t}
+	if err := c.Seek(key); err != nil {
+		return stats, err
+	}
+	if c.Valid() {
+		stats.RowsScanned = 1
+		if c.Key() == key {
+			stats.RowsReturned = 1
+		}
+	}
+	stats.NodesLoaded = t.bTreeMeta.nodeLoads - loads
+	stats.PagesRead = t.bTreeMeta.Pager.Reads - reads
+	stats.Elapsed = time.Since(start)
+	return stats, nil
+}
+
+// ExplainScan runs a full-scan select that returns the rows match accepts
+// and reports how it ran.
+func (t *BTree) ExplainScan(match func(Row) bool) (QueryStats, error) {
+	var stats QueryStats
+	start, loads, reads := time.Now(), t.bTreeMeta.nodeLoads, t.bTreeMeta.Pager.Reads
+	err := t.ForEach(func(_ uint32, row Row) error {
+		stats.RowsScanned++
+		if match(row) {
+			stats.RowsReturned++
+		}
+		return nil
+	})
+	if err != nil {
+		return stats, err
+	}
+	stats.NodesLoaded = t.bTreeMeta.nodeLoads - loads
+	stats.PagesRead = t.bTreeMeta.Pager.Reads - reads
+	stats.Elapsed = time.Since(start)
+	return stats, nil
+}
+
+// HistBucket is one bucket of a key histogram: the smallest and largest key
+// it covers and how many rows fall in between.
+type HistBucket struct {
+	Lo, Hi uint32
+	Rows   int
+}
+
+// Histogram splits the key range into at most buckets buckets holding about
+// the same number of rows each, for judging how selective a key range is. It
+// reads the key slots of every leaf; an empty tree has no buckets.
+func (t *BTree) Histogram(buckets int) ([]HistBucket, error) {
+	if buckets < 1 {
+		return nil, errors.New("Histogram: need at least one bucket")
+	}
+	root, err := t.loadNode(t.rootPage)
+	if err != nil {
+		return nil, err
+	}
+	total := int(subtreeCount(root))
+	if total == 0 {
+		return nil, nil
+	}
+	depth := (total + buckets - 1) / buckets
+
+	c, err := t.NewKeyCursor()
+	if err != nil {
+		return nil, err
+	}
+	var out []HistBucket
+	for c.Valid() {
+		key := c.Key()
+		if n := len(out); n == 0 || out[n-1].Rows == depth {
+			out = append(out, HistBucket{Lo: key})
+		}
+		b := &out[len(out)-1]
+		b.Hi = key
+		b.Rows++
+		if err := c.Next(); err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
+}
+
+// Fragmentation estimates how much of the space the tree holds goes unused,
+// from 0 for full leaves and no free pages up to 1: the share of row slots
+// empty across all leaves and free-list pages, each page counted as
+// RowsPerPage slots. Interior pages are left out. A value well above what a
+// fresh Vacuum leaves suggests vacuuming.
+func (t *BTree) Fragmentation() (float64, error) {
+	rows, leaves := 0, 0
+	err := t.WalkPages(func(_ uint32, node BTreeNode) error {
+		if leaf, ok := node.(*LeafNode); ok {
+			rows += len(leaf.cells)
+			leaves++
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	slots := (leaves + len(t.bTreeMeta.freeList().freePages)) * t.bTreeMeta.TableMeta.RowsPerPage()
+	return 1 - float64(rows)/float64(slots), nil
+}
@@ -0,0 +1,139 @@
+package table
+
+import (
+	"encoding/binary"
+	"fmt"
+	"slices"
+
+	"vqlite/pager"
+)
+
+const (
+	// free-list layout inside the meta page (page 0)
+	metaFreeCountOff = 4  // little-endian uint32 number of free pages
+	metaFreeListOff  = 64 // start of the free page numbers, 4 bytes each
+	maxFreePages     = pager.TableMaxPages
+)
+
+// allocatePage hands out a page for a new node, preferring a page from the
+// free list over extending the file. Page 0 is the meta page and is never
+// handed out: on an empty file it is reserved first.
+func (m *BTreeMeta) allocatePage() (uint32, error) {
+	var pgno uint32
+	if fl := m.freeList(); len(fl.freePages) > 0 {
+		n := len(fl.freePages)
+		pgno = fl.freePages[n-1]
+		fl.freePages = fl.freePages[:n-1]
+	} else {
+		if m.Pager.NumPages == 0 {
+			if _, err := m.Pager.AllocatePage(); err != nil {
+				return 0, fmt.Errorf("reserve meta page: %w", err)
+			}
+		}
+		var err error
+		if pgno, err = m.Pager.AllocatePage(); err != nil {
+			return 0, err
+		}
+	}
+	m.evictNode(pgno)
+	if m.hooks.OnAllocate != nil {
+		m.hooks.OnAllocate(pgno)
+	}
+	return pgno, nil
+}
+
+// FreePage returns pgno to the free list so a later allocation can reuse it.
+// The caller must make sure no node still references the page.
+func (t *BTree) FreePage(pgno uint32) error {
+	if pgno == metaPageNum || pgno == t.rootPage {
+		return fmt.Errorf("FreePage: page %d is in use by the tree", pgno)
+	}
+	if pgno >= uint32(t.bTreeMeta.Pager.NumPages) {
+		return fmt.Errorf("FreePage: page %d beyond EOF (%d pages)", pgno, t.bTreeMeta.Pager.NumPages)
+	}
+	if slices.Contains(t.bTreeMeta.freeList().freePages, pgno) {
+		return fmt.Errorf("FreePage: page %d is already free", pgno)
+	}
+	if len(t.bTreeMeta.freeList().freePages) >= maxFreePages {
+		return fmt.Errorf("FreePage: free list full (%d pages)", maxFreePages)
+	}
+	t.bTreeMeta.releasePage(pgno)
+	return t.writeFreeList()
+}
+
+// releasePage puts pgno on the free list and drops its cached node.
+func (m *BTreeMeta) releasePage(pgno uint32) {
+	fl := m.freeList()
+	fl.freePages = append(fl.freePages, pgno)
+	m.evictNode(pgno)
+}
+
+// freeList returns the meta holding the file's free list: the primary
+// tree's, which index trees in the same file share.
+func (m *BTreeMeta) freeList() *BTreeMeta {
+	if m.primary != nil {
+		return m.primary
+	}
+	return m
+}
+
+// readFreeList loads the persisted free list from the meta page.
+func (t *BTree) readFreeList() error {
+	mp, err := t.bTreeMeta.Pager.GetPage(metaPageNum)
+	if err != nil {
+		return err
+	}
+	cnt := binary.LittleEndian.Uint32(mp.Data[metaFreeCountOff : metaFreeCountOff+4])
+	if cnt > maxFreePages {
+		return fmt.Errorf("readFreeList: corrupt free count %d", cnt)
+	}
+	free := make([]uint32, cnt)
+	off := metaFreeListOff
+	for i := range free {
+		free[i] = binary.LittleEndian.Uint32(mp.Data[off : off+4])
+		if free[i] == metaPageNum {
+			return fmt.Errorf("readFreeList: meta page %d on the free list", metaPageNum)
+		}
+		off += 4
+	}
+	t.bTreeMeta.freeList().freePages = free
+	return nil
+}
+
+// writeFreeList stores the in-memory free list into the meta page.
+func (t *BTree) writeFreeList() error {
+	mp, err := t.bTreeMeta.Pager.GetPage(metaPageNum)
+	if err != nil {
+		return err
+	}
+	free := t.bTreeMeta.freeList().freePages
+	binary.LittleEndian.PutUint32(mp.Data[metaFreeCountOff:metaFreeCountOff+4], uint32(len(free)))
+	off := metaFreeListOff
+	for _, pgno := range free {
+		binary.LittleEndian.PutUint32(mp.Data[off:off+4], pgno)
+		off += 4
+	}
+	clear(mp.Data[off : metaFreeListOff+4*maxFreePages])
+	mp.Dirty = true
+	return nil
+}
+
+// truncateFreeTail drops free pages sitting at the end of the file so the
+// file shrinks to the last live page.
+func (t *BTree) truncateFreeTail() error {
+	p := t.bTreeMeta.Pager
+	n := p.NumPages
+	fl := t.bTreeMeta.freeList()
+	for n > 0 {
+		i := slices.Index(fl.freePages, uint32(n-1))
+		if i < 0 {
+			break
+		}
+		fl.freePages = slices.Delete(fl.freePages, i, i+1)
+		n--
+	}
+	if n == p.NumPages {
+		return nil
+	}
+	return p.Truncate(n)
+}
@@ -0,0 +1,270 @@
+package table
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"slices"
+
+	"vqlite/column"
+	"vqlite/pager"
+)
+
+// Index directory inside the meta page, after the stored schema:
+//
+//	[ count:uint16 | entries... ]
+//
+// with each entry stored as
+//
+//	[ root:uint32 | rows:uint32 | nameLen:uint8 | name | key:uint16 | numCols:uint16 | columns... ]
+//
+// where key is the index of the column the tree's keys come from, and columns
+// are in the stored schema format. Entries are only ever appended, so
+// an index tree keeps its root and row count at a fixed offset for as long as
+// its columns stay the same, the way the primary tree uses metaRootOff and
+// metaRowsOff. When AddColumn or DropColumn changes them, the entry is
+// rewritten in place if it is the last one, and otherwise left behind as a
+// tombstone with root 0 and appended anew; the meta page is never a root, so
+// readDirectory skips tombstones.
+const (
+	metaDirOff = 3072
+
+	dirRootOff = 0 // within an entry
+	dirRowsOff = 4
+	dirNameOff = 8
+)
+
+// ErrIndexExists is returned by CreateIndex for a name already in the directory.
+var ErrIndexExists = errors.New("index already exists")
+
+// dirEntry is one decoded entry of the index directory.
+type dirEntry struct {
+	off    int // of the entry within the meta page
+	size   int
+	name   string
+	root   uint32
+	key    int
+	schema column.Schema
+}
+
+// readDirectory decodes the index directory from the meta page data,
+// returning its entries and the offset just past the last one.
+func readDirectory(data []byte) ([]dirEntry, int, error) {
+	n := int(binary.LittleEndian.Uint16(data[metaDirOff:]))
+	off := metaDirOff + 2
+	entries := make([]dirEntry, 0, n)
+	for i := 0; i < n; i++ {
+		if off+dirNameOff+1 > len(data) {
+			return nil, 0, fmt.Errorf("index directory truncated at entry %d", i)
+		}
+		e := dirEntry{off: off, root: binary.LittleEndian.Uint32(data[off+dirRootOff:])}
+		nameLen := int(data[off+dirNameOff])
+		p := off + dirNameOff + 1
+		if p+nameLen > len(data) {
+			return nil, 0, fmt.Errorf("index directory truncated in name of entry %d", i)
+		}
+		e.name = string(data[p : p+nameLen])
+		p += nameLen
+		if p+2 > len(data) {
+			return nil, 0, fmt.Errorf("index directory truncated in key column of entry %d", i)
+		}
+		e.key = int(binary.LittleEndian.Uint16(data[p:]))
+		schema, next, err := readColumns(data, p+2)
+		if err != nil {
+			return nil, 0, fmt.Errorf("index %q: %w", e.name, err)
+		}
+		if e.key >= len(schema) {
+			return nil, 0, fmt.Errorf("index %q: key column %d out of %d columns", e.name, e.key, len(schema))
+		}
+		e.schema, e.size = schema, next-off
+		if e.root != metaPageNum {
+			entries = append(entries, e)
+		}
+		off = next
+	}
+	return entries, off, nil
+}
+
+// CreateIndex adds an empty tree named name with the given key and value
+// columns to the file and records its root in the meta page, so Indexes
+// finds it again after a reopen. The index shares the primary tree's pager
+// and free list; closing the primary tree persists both. Keeping an index in
+// step with the primary tree is up to the caller.
+func (t *BTree) CreateIndex(name string, schema column.Schema) (*BTree, error) {
+	return t.CreateTable(name, schema, 0)
+}
+
+// CreateTable is CreateIndex for a tree whose keys come from column key of
+// schema, which must be an INT column. The key column is recorded in the
+// directory entry, so KeyColumn reports it again after a reopen.
+func (t *BTree) CreateTable(name string, schema column.Schema, key int) (*BTree, error) {
+	if t.dirOff != 0 {
+		return nil, errors.New("CreateIndex: not called on the primary tree")
+	}
+	if len(name) == 0 || len(name) > 255 {
+		return nil, fmt.Errorf("CreateIndex: name %q must be 1 to 255 bytes", name)
+	}
+	if key < 0 || key >= len(schema) || schema[key].Type != column.ColumnTypeInt {
+		return nil, fmt.Errorf("CreateIndex: key column %d of %d is not an INT column", key, len(schema))
+	}
+	meta, err := BuildTableMeta(schema)
+	if err != nil {
+		return nil, fmt.Errorf("CreateIndex: %w", err)
+	}
+	if err := checkRowFits(meta.RowSize); err != nil {
+		return nil, fmt.Errorf("CreateIndex: %w", err)
+	}
+	p := t.bTreeMeta.Pager
+	mp, err := p.GetPage(metaPageNum)
+	if err != nil {
+		return nil, fmt.Errorf("CreateIndex: get meta page: %w", err)
+	}
+	entries, end, err := readDirectory(mp.Data[:])
+	if err != nil {
+		return nil, fmt.Errorf("CreateIndex: %w", err)
+	}
+	for _, e := range entries {
+		if e.name == name {
+			return nil, fmt.Errorf("CreateIndex: %q: %w", name, ErrIndexExists)
+		}
+	}
+	buf, err := encodeDirEntry(name, key, meta.Columns)
+	if err != nil {
+		return nil, fmt.Errorf("CreateIndex: %w", err)
+	}
+	if end+len(buf) > pager.PageSize {
+		return nil, fmt.Errorf("CreateIndex: index directory full, %d bytes left", pager.PageSize-end)
+	}
+
+	ix := &BTree{
+		bTreeMeta: &BTreeMeta{Pager: p, TableMeta: meta, primary: t.bTreeMeta},
+		dirOff:    end,
+		keyColumn: key,
+	}
+	leaf, err := NewLeafNode(ix.bTreeMeta, true)
+	if err != nil {
+		return nil, fmt.Errorf("CreateIndex: %w", err)
+	}
+	lp, err := p.GetPage(leaf.Page())
+	if err != nil {
+		return nil, fmt.Errorf("CreateIndex: %w", err)
+	}
+	if err := leaf.Serialize(lp); err != nil {
+		return nil, fmt.Errorf("CreateIndex: %w", err)
+	}
+	ix.rootPage = leaf.Page()
+	binary.LittleEndian.PutUint32(buf[dirRootOff:], ix.rootPage)
+
+	copy(mp.Data[end:], buf)
+	binary.LittleEndian.PutUint16(mp.Data[metaDirOff:], uint16(len(entries)+1))
+	mp.Dirty = true
+	return ix, nil
+}
+
+// encodeDirEntry lays out a directory entry for a tree named name, with its
+// root and row count left zero.
+func encodeDirEntry(name string, key int, cols column.Schema) ([]byte, error) {
+	buf := make([]byte, dirNameOff, dirNameOff+1+len(name))
+	buf = append(buf, byte(len(name)))
+	buf = append(buf, name...)
+	buf = binary.LittleEndian.AppendUint16(buf, uint16(key))
+	return appendColumns(buf, cols)
+}
+
+// placeDirEntry encodes t's directory entry as it reads once its columns are
+// cols and its key column key, carrying over its name, root and row count,
+// and returns the offset it goes to: its own if it is the last entry, the
+// end of the directory otherwise. It fails, changing nothing, if the entry
+// does not fit there.
+func (t *BTree) placeDirEntry(data []byte, cols column.Schema, key int) (int, []byte, error) {
+	entries, end, err := readDirectory(data)
+	if err != nil {
+		return 0, nil, err
+	}
+	i := slices.IndexFunc(entries, func(e dirEntry) bool { return e.off == t.dirOff })
+	if i < 0 {
+		return 0, nil, fmt.Errorf("no index directory entry at offset %d", t.dirOff)
+	}
+	e := entries[i]
+	buf, err := encodeDirEntry(e.name, key, cols)
+	if err != nil {
+		return 0, nil, err
+	}
+	copy(buf[dirRootOff:dirNameOff], data[e.off+dirRootOff:e.off+dirNameOff])
+	off := end
+	if e.off+e.size == end {
+		off = e.off
+	}
+	if off+len(buf) > pager.PageSize {
+		return 0, nil, fmt.Errorf("index directory full, %d bytes left", pager.PageSize-off)
+	}
+	return off, buf, nil
+}
+
+// refreshDirEntry rewrites t's directory entry after its columns or key
+// column changed, moving it as placeDirEntry says and tombstoning the old
+// one if it moved. Trees for the same entry loaded earlier by Indexes keep
+// the old offset and must be loaded again.
+func (t *BTree) refreshDirEntry() error {
+	mp, err := t.bTreeMeta.Pager.GetPage(metaPageNum)
+	if err != nil {
+		return fmt.Errorf("get meta page: %w", err)
+	}
+	off, buf, err := t.placeDirEntry(mp.Data[:], t.bTreeMeta.TableMeta.Columns, t.keyColumn)
+	if err != nil {
+		return err
+	}
+	if off != t.dirOff {
+		binary.LittleEndian.PutUint32(mp.Data[t.dirOff+dirRootOff:], metaPageNum)
+		n := binary.LittleEndian.Uint16(mp.Data[metaDirOff:])
+		binary.LittleEndian.PutUint16(mp.Data[metaDirOff:], n+1)
+	}
+	clear(mp.Data[off:])
+	copy(mp.Data[off:], buf)
+	mp.Dirty = true
+	t.dirOff = off
+	return nil
+}
+
+// Indexes reconstructs every index recorded in the meta page, keyed by name.
+func (t *BTree) Indexes() (map[string]*BTree, error) {
+	p := t.bTreeMeta.Pager
+	mp, err := p.GetPage(metaPageNum)
+	if err != nil {
+		return nil, fmt.Errorf("Indexes: get meta page: %w", err)
+	}
+	entries, _, err := readDirectory(mp.Data[:])
+	if err != nil {
+		return nil, fmt.Errorf("Indexes: %w", err)
+	}
+	primary := t.bTreeMeta.freeList()
+	out := make(map[string]*BTree, len(entries))
+	for _, e := range entries {
+		if e.root == metaPageNum || int(e.root) >= p.NumPages {
+			return nil, fmt.Errorf("Indexes: %q: root page %d invalid for a file of %d pages", e.name, e.root, p.NumPages)
+		}
+		meta, err := BuildTableMeta(e.schema)
+		if err != nil {
+			return nil, fmt.Errorf("Indexes: %q: %w", e.name, err)
+		}
+		out[e.name] = &BTree{
+			rootPage:  e.root,
+			bTreeMeta: &BTreeMeta{Pager: p, TableMeta: meta, primary: primary},
+			dirOff:    e.off,
+			keyColumn: e.key,
+		}
+	}
+	return out, nil
+}
+
+// KeyColumn returns the index of the column the tree's keys come from: the
+// one given to CreateTable, and 0 for the primary tree and plain indexes.
+func (t *BTree) KeyColumn() int {
+	return t.keyColumn
+}
+
+// Schema returns a copy of the tree's columns, such as an index reloaded by
+// Indexes was created with.
+func (t *BTree) Schema() column.Schema {
+	return slices.Clone(t.bTreeMeta.TableMeta.Columns)
+}
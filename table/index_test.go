@@ -0,0 +1,508 @@
+package table
+
+import (
+	"errors"
+	"os"
+	"slices"
+	"testing"
+
+	"vqlite/column"
+)
+
+// TestIndexes_SurviveReopen creates a primary tree and two indexes, fills all
+// three enough to split, closes the file, and checks a reopen recovers each
+// index's root, schema and rows from the meta page directory.
+func TestIndexes_SurviveReopen(t *testing.T) {
+	dbFile := newTempDB(t)
+	defer os.Remove(dbFile)
+	schema := column.Schema{
+		{Name: "id", Type: column.ColumnTypeInt},
+		{Name: "name", Type: column.ColumnTypeText, MaxLength: 16},
+		{Name: "age", Type: column.ColumnTypeInt},
+	}
+	byName := column.Schema{
+		{Name: "name_hash", Type: column.ColumnTypeInt},
+		{Name: "id", Type: column.ColumnTypeInt},
+	}
+	byAge := column.Schema{
+		{Name: "age", Type: column.ColumnTypeInt},
+		{Name: "id", Type: column.ColumnTypeInt},
+	}
+
+	_, bt, err := OpenTable(dbFile, schema)
+	if err != nil {
+		t.Fatalf("OpenTable: %v", err)
+	}
+	nameIx, err := bt.CreateIndex("by_name", byName)
+	if err != nil {
+		t.Fatalf("CreateIndex by_name: %v", err)
+	}
+	ageIx, err := bt.CreateIndex("by_age", byAge)
+	if err != nil {
+		t.Fatalf("CreateIndex by_age: %v", err)
+	}
+	if _, err := bt.CreateIndex("by_age", byAge); !errors.Is(err, ErrIndexExists) {
+		t.Fatalf("duplicate CreateIndex err = %v; want ErrIndexExists", err)
+	}
+	const n = 60
+	for i := uint32(1); i <= n; i++ {
+		if err := bt.Insert(i, Row{i, "user", i % 90}); err != nil {
+			t.Fatalf("insert %d: %v", i, err)
+		}
+		if err := nameIx.Insert(i*7, Row{i * 7, i}); err != nil {
+			t.Fatalf("by_name insert %d: %v", i, err)
+		}
+		if err := ageIx.Insert(i+1000, Row{i + 1000, i}); err != nil {
+			t.Fatalf("by_age insert %d: %v", i, err)
+		}
+	}
+	roots := map[string]uint32{"by_name": nameIx.rootPage, "by_age": ageIx.rootPage}
+	if h, _ := nameIx.Height(); h < 2 {
+		t.Fatalf("by_name height = %d; want a split tree", h)
+	}
+	if err := bt.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	_, bt, err = OpenTable(dbFile, schema)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	defer bt.Close()
+	ixs, err := bt.Indexes()
+	if err != nil {
+		t.Fatalf("Indexes: %v", err)
+	}
+	if len(ixs) != 2 {
+		t.Fatalf("Indexes returned %d trees; want 2", len(ixs))
+	}
+	for name, want := range map[string]column.Schema{"by_name": byName, "by_age": byAge} {
+		ix := ixs[name]
+		if ix == nil {
+			t.Fatalf("index %q missing after reopen", name)
+		}
+		if ix.rootPage != roots[name] {
+			t.Errorf("%s root = %d; want %d", name, ix.rootPage, roots[name])
+		}
+		got := make([]string, len(ix.bTreeMeta.TableMeta.Columns))
+		for i, c := range ix.bTreeMeta.TableMeta.Columns {
+			got[i] = c.Name
+		}
+		if !slices.Equal(got, []string{want[0].Name, want[1].Name}) {
+			t.Errorf("%s columns = %v; want %s, %s", name, got, want[0].Name, want[1].Name)
+		}
+		if rows, err := ix.NumRows(); err != nil || rows != n {
+			t.Errorf("%s NumRows = %d, %v; want %d, nil", name, rows, err, n)
+		}
+	}
+	row, found, err := ixs["by_name"].Search(7 * 23)
+	if err != nil || !found || !row.Equal(Row{uint32(7 * 23), uint32(23)}) {
+		t.Errorf("by_name Search = %v, %v, %v; want row for id 23", row, found, err)
+	}
+	if rows, err := bt.NumRows(); err != nil || rows != n {
+		t.Errorf("primary NumRows = %d, %v; want %d, nil", rows, err, n)
+	}
+}
+
+// TestScanByRowID_InsertionOrder inserts keys out of order, deletes one and
+// inserts another again, and checks ScanByRowID returns them in insertion
+// order both before and after a reopen.
+func TestScanByRowID_InsertionOrder(t *testing.T) {
+	dbFile := newTempDB(t)
+	defer os.Remove(dbFile)
+	schema := column.Schema{
+		{Name: "id", Type: column.ColumnTypeInt},
+		{Name: "name", Type: column.ColumnTypeText, MaxLength: 16},
+	}
+	_, bt, err := OpenTable(dbFile, schema)
+	if err != nil {
+		t.Fatalf("OpenTable: %v", err)
+	}
+	if err := bt.Insert(500, Row{uint32(500), "before"}); err != nil {
+		t.Fatalf("Insert: %v", err)
+	}
+	if err := bt.EnableRowIDs(); err != nil {
+		t.Fatalf("EnableRowIDs: %v", err)
+	}
+	// enough keys to split the row id trees
+	want := []uint32{500}
+	for i := uint32(0); i < 40; i++ {
+		key := (i * 37) % 101
+		if err := bt.Insert(key, Row{key, "user"}); err != nil {
+			t.Fatalf("Insert %d: %v", key, err)
+		}
+		want = append(want, key)
+	}
+	if _, err := bt.Delete(37); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if err := bt.Insert(74, Row{uint32(74), "overwritten"}); err != nil {
+		t.Fatalf("overwrite: %v", err)
+	}
+	if _, err := bt.Delete(0); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if err := bt.Insert(0, Row{uint32(0), "again"}); err != nil {
+		t.Fatalf("reinsert: %v", err)
+	}
+	want = slices.DeleteFunc(want, func(k uint32) bool { return k == 37 || k == 0 })
+	want = append(want, 0)
+
+	check := func(when string) {
+		t.Helper()
+		var got []uint32
+		var last uint32
+		err := bt.ScanByRowID(func(rowid, key uint32, row Row) bool {
+			if rowid <= last || row[0].(uint32) != key {
+				t.Errorf("%s: row id %d for key %d after row id %d, row %v", when, rowid, key, last, row)
+			}
+			last = rowid
+			got = append(got, key)
+			return true
+		})
+		if err != nil {
+			t.Fatalf("%s: ScanByRowID: %v", when, err)
+		}
+		if !slices.Equal(got, want) {
+			t.Errorf("%s: keys = %v; want %v", when, got, want)
+		}
+	}
+	check("before reopen")
+	if id, found, err := bt.RowID(500); err != nil || !found || id != 1 {
+		t.Errorf("RowID(500) = %d, %v, %v; want 1, true, nil", id, found, err)
+	}
+	if _, found, err := bt.RowID(37); err != nil || found {
+		t.Errorf("RowID of a deleted key = %v, %v; want false, nil", found, err)
+	}
+	if err := bt.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	_, bt, err = OpenTable(dbFile, schema)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	defer bt.Close()
+	if err := bt.ScanByRowID(func(uint32, uint32, Row) bool { return true }); err == nil {
+		t.Error("ScanByRowID before EnableRowIDs succeeded; want an error")
+	}
+	if err := bt.EnableRowIDs(); err != nil {
+		t.Fatalf("EnableRowIDs after reopen: %v", err)
+	}
+	if err := bt.Insert(1000, Row{uint32(1000), "last"}); err != nil {
+		t.Fatalf("Insert: %v", err)
+	}
+	want = append(want, 1000)
+	check("after reopen")
+}
+
+// TestTruncate_RowIDCounter truncates a tree with row ids in both modes and
+// checks the row ids handed out afterwards, before and after a reopen: they
+// go on from the old counter when it is kept and start over at 1 when it is
+// reset, and either way ScanByRowID sees only the new keys.
+func TestTruncate_RowIDCounter(t *testing.T) {
+	schema := column.Schema{{Name: "id", Type: column.ColumnTypeInt}}
+	for _, reset := range []bool{false, true} {
+		dbFile := newTempDB(t)
+		defer os.Remove(dbFile)
+		_, bt, err := OpenTable(dbFile, schema)
+		if err != nil {
+			t.Fatalf("OpenTable: %v", err)
+		}
+		if err := bt.EnableRowIDs(); err != nil {
+			t.Fatalf("EnableRowIDs: %v", err)
+		}
+		for i := uint32(1); i <= 30; i++ {
+			if err := bt.Insert(i, Row{i}); err != nil {
+				t.Fatalf("Insert %d: %v", i, err)
+			}
+		}
+		if err := bt.Truncate(reset); err != nil {
+			t.Fatalf("reset=%v: Truncate: %v", reset, err)
+		}
+		first := uint32(31)
+		if reset {
+			first = 1
+		}
+		for _, key := range []uint32{7, 3} {
+			if err := bt.Insert(key, Row{key}); err != nil {
+				t.Fatalf("Insert %d: %v", key, err)
+			}
+		}
+		if err := bt.Close(); err != nil {
+			t.Fatalf("Close: %v", err)
+		}
+
+		_, bt, err = OpenTable(dbFile, schema)
+		if err != nil {
+			t.Fatalf("reopen: %v", err)
+		}
+		if err := bt.EnableRowIDs(); err != nil {
+			t.Fatalf("EnableRowIDs after reopen: %v", err)
+		}
+		if err := bt.Insert(9, Row{uint32(9)}); err != nil {
+			t.Fatalf("Insert 9: %v", err)
+		}
+		var ids, keys []uint32
+		if err := bt.ScanByRowID(func(rowid, key uint32, _ Row) bool {
+			ids, keys = append(ids, rowid), append(keys, key)
+			return true
+		}); err != nil {
+			t.Fatalf("ScanByRowID: %v", err)
+		}
+		if want := []uint32{first, first + 1, first + 2}; !slices.Equal(ids, want) {
+			t.Errorf("reset=%v: row ids = %v; want %v", reset, ids, want)
+		}
+		if want := []uint32{7, 3, 9}; !slices.Equal(keys, want) {
+			t.Errorf("reset=%v: keys by row id = %v; want %v", reset, keys, want)
+		}
+		bt.Close()
+	}
+
+	dbFile := newTempDB(t)
+	defer os.Remove(dbFile)
+	_, bt, err := OpenTable(dbFile, schema)
+	if err != nil {
+		t.Fatalf("OpenTable: %v", err)
+	}
+	defer bt.Close()
+	ix, err := bt.CreateIndex("ix", schema)
+	if err != nil {
+		t.Fatalf("CreateIndex: %v", err)
+	}
+	if err := ix.Truncate(true); err == nil {
+		t.Error("Truncate(true) on an index succeeded; want an error")
+	}
+}
+
+// TestExists_StopsAtFirstDuplicate fills an index with a run of one value
+// spanning several leaves and checks Exists finds it reading one node per
+// level, where LookupIn reads every leaf of the run.
+func TestExists_StopsAtFirstDuplicate(t *testing.T) {
+	dbFile := newTempDB(t)
+	defer os.Remove(dbFile)
+	_, bt, err := OpenTable(dbFile, column.Schema{{Name: "id", Type: column.ColumnTypeInt}})
+	if err != nil {
+		t.Fatalf("OpenTable: %v", err)
+	}
+	defer bt.Close()
+	ix, err := bt.CreateIndex("by_age", column.Schema{
+		{Name: "age", Type: column.ColumnTypeInt},
+		{Name: "id", Type: column.ColumnTypeInt},
+	})
+	if err != nil {
+		t.Fatalf("CreateIndex: %v", err)
+	}
+	ix.SetDuplicates(true)
+	for id := uint32(1); id <= 300; id++ {
+		age := uint32(40)
+		if id%3 != 0 {
+			age = id % 80
+		}
+		if err := ix.Insert(age, Row{age, id}); err != nil {
+			t.Fatalf("insert %d: %v", id, err)
+		}
+	}
+	height, _ := ix.Height()
+
+	loads := ix.bTreeMeta.nodeLoads
+	if ok, err := ix.Exists(40); err != nil || !ok {
+		t.Fatalf("Exists(40) = %v, %v; want true, nil", ok, err)
+	}
+	existsReads := ix.bTreeMeta.nodeLoads - loads
+	if existsReads > height {
+		t.Errorf("Exists read %d nodes; want at most the height, %d", existsReads, height)
+	}
+	loads = ix.bTreeMeta.nodeLoads
+	pairs, err := ix.LookupIn([]uint32{40})
+	if err != nil {
+		t.Fatalf("LookupIn: %v", err)
+	}
+	if len(pairs) < 100 || ix.bTreeMeta.nodeLoads-loads <= existsReads {
+		t.Errorf("LookupIn found %d rows reading %d nodes; want over 100 rows and more reads than Exists", len(pairs), ix.bTreeMeta.nodeLoads-loads)
+	}
+
+	for _, age := range []uint32{80, 1000} {
+		if ok, err := ix.Exists(age); err != nil || ok {
+			t.Errorf("Exists(%d) = %v, %v; want false, nil", age, ok, err)
+		}
+	}
+	if ok, err := ix.Exists(0); err != nil || !ok {
+		t.Errorf("Exists(0) = %v, %v; want true, nil", ok, err)
+	}
+}
+
+// TestStoreSchema_KeepsIndexDirectory creates an index, stores the schema
+// and adds a column, which rewrites it, and checks the index is still in
+// the directory after a reopen.
+func TestStoreSchema_KeepsIndexDirectory(t *testing.T) {
+	dbFile := newTempDB(t)
+	defer os.Remove(dbFile)
+	schema := column.Schema{{Name: "id", Type: column.ColumnTypeInt}}
+	_, bt, err := OpenTable(dbFile, schema)
+	if err != nil {
+		t.Fatalf("OpenTable: %v", err)
+	}
+	ix, err := bt.CreateIndex("ix", column.Schema{{Name: "v", Type: column.ColumnTypeInt}})
+	if err != nil {
+		t.Fatalf("CreateIndex: %v", err)
+	}
+	if err := ix.Insert(3, Row{uint32(3)}); err != nil {
+		t.Fatalf("index insert: %v", err)
+	}
+	if err := bt.StoreSchema(); err != nil {
+		t.Fatalf("StoreSchema: %v", err)
+	}
+	if err := bt.AddColumn(column.Column{Name: "age", Type: column.ColumnTypeInt}); err != nil {
+		t.Fatalf("AddColumn: %v", err)
+	}
+	if err := bt.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	info, err := InspectFile(dbFile)
+	if err != nil || len(info.Schema) != 2 {
+		t.Fatalf("InspectFile = %v, %v; want the two-column schema", info, err)
+	}
+	_, bt, err = OpenTable(dbFile, info.Schema)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	defer bt.Close()
+	ixs, err := bt.Indexes()
+	if err != nil {
+		t.Fatalf("Indexes: %v", err)
+	}
+	if ixs["ix"] == nil || len(ixs) != 1 {
+		t.Fatalf("Indexes after StoreSchema = %v; want only ix", ixs)
+	}
+	if _, found, err := ixs["ix"].Search(3); err != nil || !found {
+		t.Errorf("ix Search(3) = %v, %v; want found", found, err)
+	}
+}
+
+// TestAlterIndex_UpdatesItsDirectoryEntry adds a column to the first of two
+// indexes, which moves its directory entry behind the second, and drops one
+// from the second, now last, and checks after a reopen that the primary's
+// stored schema is untouched and that both indexes come back with their new
+// columns and their rows.
+func TestAlterIndex_UpdatesItsDirectoryEntry(t *testing.T) {
+	dbFile := newTempDB(t)
+	defer os.Remove(dbFile)
+	schema := column.Schema{{Name: "id", Type: column.ColumnTypeInt}}
+	_, bt, err := OpenTable(dbFile, schema)
+	if err != nil {
+		t.Fatalf("OpenTable: %v", err)
+	}
+	if err := bt.StoreSchema(); err != nil {
+		t.Fatalf("StoreSchema: %v", err)
+	}
+	a, err := bt.CreateIndex("a", column.Schema{{Name: "k", Type: column.ColumnTypeInt}})
+	if err != nil {
+		t.Fatalf("CreateIndex a: %v", err)
+	}
+	b, err := bt.CreateIndex("b", column.Schema{
+		{Name: "k", Type: column.ColumnTypeInt},
+		{Name: "v", Type: column.ColumnTypeInt},
+	})
+	if err != nil {
+		t.Fatalf("CreateIndex b: %v", err)
+	}
+	for i := uint32(1); i <= 40; i++ {
+		if err := a.Insert(i, Row{i}); err != nil {
+			t.Fatalf("a insert %d: %v", i, err)
+		}
+		if err := b.Insert(i, Row{i, i * 2}); err != nil {
+			t.Fatalf("b insert %d: %v", i, err)
+		}
+	}
+	if err := a.AddColumn(column.Column{Name: "note", Type: column.ColumnTypeText, MaxLength: 8}); err != nil {
+		t.Fatalf("a AddColumn: %v", err)
+	}
+	if err := b.DropColumn("v"); err != nil {
+		t.Fatalf("b DropColumn: %v", err)
+	}
+	if err := a.StoreSchema(); err == nil {
+		t.Error("StoreSchema on an index succeeded; want an error")
+	}
+	if err := bt.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	info, err := InspectFile(dbFile)
+	if err != nil || len(info.Schema) != 1 || info.Schema[0].Name != "id" {
+		t.Fatalf("InspectFile = %v, %v; want the primary's one-column schema", info, err)
+	}
+	_, bt, err = OpenTable(dbFile, schema)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	defer bt.Close()
+	ixs, err := bt.Indexes()
+	if err != nil {
+		t.Fatalf("Indexes: %v", err)
+	}
+	if len(ixs) != 2 {
+		t.Fatalf("Indexes = %v; want a and b", ixs)
+	}
+	for name, want := range map[string]Row{"a": {uint32(7), ""}, "b": {uint32(7)}} {
+		ix := ixs[name]
+		if ix == nil {
+			t.Fatalf("index %q missing after reopen", name)
+		}
+		if got := len(ix.Schema()); got != len(want) {
+			t.Errorf("index %q has %d columns; want %d", name, got, len(want))
+		}
+		row, found, err := ix.Search(7)
+		if err != nil || !found || !slices.Equal(row, want) {
+			t.Errorf("index %q Search(7) = %v, %v, %v; want %v", name, row, found, err, want)
+		}
+		if n, err := ix.NumRows(); err != nil || n != 40 {
+			t.Errorf("index %q NumRows = %d, %v; want 40", name, n, err)
+		}
+	}
+}
+
+// TestCreateTable_RecordsKeyColumn checks CreateTable refuses a key column
+// that is missing or not INT, and that a reopen reports the key column and
+// schema it was created with.
+func TestCreateTable_RecordsKeyColumn(t *testing.T) {
+	dbFile := newTempDB(t)
+	defer os.Remove(dbFile)
+	pets := column.Schema{
+		{Name: "name", Type: column.ColumnTypeText, MaxLength: 8},
+		{Name: "id", Type: column.ColumnTypeInt},
+	}
+	_, bt, err := OpenTable(dbFile, column.Schema{{Name: "id", Type: column.ColumnTypeInt}})
+	if err != nil {
+		t.Fatalf("OpenTable: %v", err)
+	}
+	for _, key := range []int{-1, 0, 2} {
+		if _, err := bt.CreateTable("pets", pets, key); err == nil {
+			t.Errorf("CreateTable with key column %d succeeded", key)
+		}
+	}
+	if _, err := bt.CreateTable("pets", pets, 1); err != nil {
+		t.Fatalf("CreateTable: %v", err)
+	}
+	if err := bt.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	_, bt, err = OpenTable(dbFile, column.Schema{{Name: "id", Type: column.ColumnTypeInt}})
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	defer bt.Close()
+	ixs, err := bt.Indexes()
+	if err != nil {
+		t.Fatalf("Indexes: %v", err)
+	}
+	got := ixs["pets"]
+	if got == nil || got.KeyColumn() != 1 || len(got.Schema()) != 2 || got.Schema()[1].Name != "id" {
+		t.Fatalf("reopened pets = %v; want key column 1 of (name, id)", got)
+	}
+	if bt.KeyColumn() != 0 {
+		t.Errorf("primary KeyColumn = %d; want 0", bt.KeyColumn())
+	}
+}
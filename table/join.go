@@ -0,0 +1,37 @@
+package table
+
+// JoinOnKey is an inner join of two trees on their primary keys: it walks
+// both in key order in lockstep and calls fn with the rows of every key found
+// in both. When one side falls behind it seeks forward to the other's key
+// instead of stepping through the gap. It stops at the first error fn
+// returns and passes it back unchanged.
+func JoinOnKey(left, right *BTree, fn func(key uint32, l, r Row) error) error {
+	lc, err := left.NewCursor()
+	if err != nil {
+		return err
+	}
+	rc, err := right.NewCursor()
+	if err != nil {
+		return err
+	}
+	for lc.Valid() && rc.Valid() {
+		switch c := compareKeys(lc.Key(), rc.Key()); {
+		case c < 0:
+			err = lc.Seek(rc.Key())
+		case c > 0:
+			err = rc.Seek(lc.Key())
+		default:
+			if err := fn(lc.Key(), lc.Value(), rc.Value()); err != nil {
+				return err
+			}
+			if err := lc.Next(); err != nil {
+				return err
+			}
+			err = rc.Next()
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
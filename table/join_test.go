@@ -0,0 +1,61 @@
+package table
+
+import (
+	"reflect"
+	"testing"
+
+	"vqlite/column"
+)
+
+// TestJoinOnKey joins users (ids 1–60) with orders on every third id plus ids
+// past the users, and checks only the shared ids are produced, in order, with
+// both sides' rows.
+func TestJoinOnKey(t *testing.T) {
+	open := func(schema column.Schema) *BTree {
+		tp := newTempPager(t)
+		t.Cleanup(tp.cleanup)
+		meta, _ := BuildTableMeta(schema)
+		bt, err := NewBTree(tp.Pager, meta)
+		if err != nil {
+			t.Fatalf("NewBTree: %v", err)
+		}
+		return bt
+	}
+	users := open(column.Schema{
+		{Name: "id", Type: column.ColumnTypeInt},
+		{Name: "name", Type: column.ColumnTypeText, MaxLength: 8},
+	})
+	orders := open(column.Schema{
+		{Name: "id", Type: column.ColumnTypeInt},
+		{Name: "total", Type: column.ColumnTypeInt},
+	})
+	for i := uint32(1); i <= 60; i++ {
+		if err := users.Insert(i, Row{i, "user"}); err != nil {
+			t.Fatalf("insert user %d: %v", i, err)
+		}
+	}
+	var want []uint32
+	for i := uint32(3); i <= 90; i += 3 {
+		if err := orders.Insert(i, Row{i, i * 100}); err != nil {
+			t.Fatalf("insert order %d: %v", i, err)
+		}
+		if i <= 60 {
+			want = append(want, i)
+		}
+	}
+
+	var got []uint32
+	err := JoinOnKey(users, orders, func(key uint32, l, r Row) error {
+		if !l.Equal(Row{key, "user"}) || !r.Equal(Row{key, key * 100}) {
+			t.Errorf("key %d joined %v with %v", key, l, r)
+		}
+		got = append(got, key)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("JoinOnKey: %v", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("joined keys = %v; want %v", got, want)
+	}
+}
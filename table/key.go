@@ -0,0 +1,50 @@
+package table
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/binary"
+)
+
+// KeyCodec describes a key type by its byte encoding: every key encodes to
+// Size() bytes, and Compare orders two encodings the same way the keys they
+// encode are ordered. Keys of any type (wider integers, text, composites)
+// can be searched and split on through their encodings alone.
+type KeyCodec interface {
+	Size() int
+	Compare(a, b []byte) int
+}
+
+// Uint32Keys is the codec for the tree's uint32 keys, encoded big-endian so
+// that byte order is numeric order.
+type Uint32Keys struct{}
+
+func (Uint32Keys) Size() int { return 4 }
+
+// Encode returns the 4-byte encoding of k.
+func (Uint32Keys) Encode(k uint32) []byte { return binary.BigEndian.AppendUint32(nil, k) }
+
+// Decode returns the key encoded in b.
+func (Uint32Keys) Decode(b []byte) uint32 { return binary.BigEndian.Uint32(b) }
+
+func (Uint32Keys) Compare(a, b []byte) int { return bytes.Compare(a, b) }
+
+// Uint64Keys is the codec for uint64 keys, encoded big-endian.
+type Uint64Keys struct{}
+
+func (Uint64Keys) Size() int { return 8 }
+
+// Encode returns the 8-byte encoding of k.
+func (Uint64Keys) Encode(k uint64) []byte { return binary.BigEndian.AppendUint64(nil, k) }
+
+// Decode returns the key encoded in b.
+func (Uint64Keys) Decode(b []byte) uint64 { return binary.BigEndian.Uint64(b) }
+
+func (Uint64Keys) Compare(a, b []byte) int { return bytes.Compare(a, b) }
+
+// compareKeys orders two cell keys. It is the one comparison every search,
+// insert and delete uses, and agrees with Uint32Keys.Compare on the keys'
+// encodings without encoding them.
+func compareKeys(a, b uint32) int {
+	return cmp.Compare(a, b)
+}
@@ -0,0 +1,237 @@
+package table
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"time"
+
+	"vqlite/column"
+
+	"vqlite/pager"
+)
+
+// ErrKeyOnly is returned by KeyCursor.Value: a key cursor never decodes rows.
+var ErrKeyOnly = errors.New("key cursor does not read row values")
+
+// KeyCursor walks the keys of the tree in order, reading only the key slot of
+// each cell straight from the leaf pages and never calling DeserializeRow.
+// Like Cursor it passes over expired rows, decoding just their TTL column,
+// though it never deletes them, and it goes stale when the tree is
+// restructured.
+type KeyCursor struct {
+	tree   *BTree
+	page   *pager.Page
+	header baseHeader // of page, read when the cursor moves onto it
+	cells  []byte     // cell region of page, inflated for compressed leaves
+	idx    int
+	valid  bool
+	gen    uint64 // tree.gen when the cursor was positioned
+
+	// separated leaves are read in place: keys after the header, rows from
+	// rowsOff on
+	separated bool
+	rowsOff   int
+}
+
+// NewKeyCursor returns a key-only cursor positioned at the first key (if any).
+func (t *BTree) NewKeyCursor() (*KeyCursor, error) {
+	pgno := t.rootPage
+	for {
+		p, err := t.bTreeMeta.Pager.GetPage(pgno)
+		if err != nil {
+			return nil, err
+		}
+		if isLeafType(p.Data[0]) {
+			c := &KeyCursor{tree: t, gen: t.gen}
+			if err := c.setPage(p); err != nil {
+				return nil, err
+			}
+			if err := c.settle(); err != nil {
+				return nil, err
+			}
+			return c, nil
+		}
+		node, err := t.loadNode(pgno)
+		if err != nil {
+			return nil, err
+		}
+		pgno = node.(*InteriorNode).leftChild
+	}
+}
+
+// Valid tells whether the cursor is positioned at an existing key. A stale
+// cursor is never valid.
+func (c *KeyCursor) Valid() bool { return c.valid && c.gen == c.tree.gen }
+
+// Key returns the current key. Call only if Valid() is true.
+func (c *KeyCursor) Key() uint32 { return c.keyAt(c.idx) }
+
+// keyAt reads the key of cell i of the current leaf.
+func (c *KeyCursor) keyAt(i int) uint32 {
+	if c.separated {
+		return binary.LittleEndian.Uint32(c.page.Data[headerSize+4*i:])
+	}
+	off := i * int(LeafCellSize(c.tree.bTreeMeta.TableMeta.RowSize))
+	return binary.LittleEndian.Uint32(c.cells[off : off+4])
+}
+
+// rowAt returns the serialized row of cell i of the current leaf.
+func (c *KeyCursor) rowAt(i int) []byte {
+	rowSize := int(c.tree.bTreeMeta.TableMeta.RowSize)
+	if c.separated {
+		off := c.rowsOff + i*rowSize
+		return c.page.Data[off : off+rowSize]
+	}
+	off := i*(LeafNodeKeySize+rowSize) + LeafNodeKeySize
+	return c.cells[off : off+rowSize]
+}
+
+// Value always fails with ErrKeyOnly.
+func (c *KeyCursor) Value() (Row, error) { return nil, ErrKeyOnly }
+
+// Next advances to the next key in order. It returns ErrCursorStale if the
+// tree was restructured since the cursor was positioned.
+func (c *KeyCursor) Next() error {
+	if !c.valid {
+		return nil
+	}
+	if c.gen != c.tree.gen {
+		c.valid = false
+		return ErrCursorStale
+	}
+	c.idx++
+	return c.settle()
+}
+
+// Seek repositions the cursor to the first key >= target. Interior nodes are
+// descended as usual; the leaf is binary-searched on its key slots only.
+func (c *KeyCursor) Seek(target uint32) error {
+	pgno := c.tree.rootPage
+	for {
+		p, err := c.tree.bTreeMeta.Pager.GetPage(pgno)
+		if err != nil {
+			return err
+		}
+		if isLeafType(p.Data[0]) {
+			if err := c.setPage(p); err != nil {
+				return err
+			}
+			c.gen = c.tree.gen
+			c.idx = sort.Search(c.numCells(), func(i int) bool {
+				return compareKeys(c.keyAt(i), target) >= 0
+			})
+			return c.settle()
+		}
+		node, err := c.tree.loadNode(pgno)
+		if err != nil {
+			return err
+		}
+		pgno = c.tree.seekChildPage(node.(*InteriorNode), target)
+	}
+}
+
+// settle skips exhausted and empty leaves and expired rows until the cursor
+// rests on a key, or marks it invalid at the end of the leaf chain.
+func (c *KeyCursor) settle() error {
+	meta := c.tree.bTreeMeta.TableMeta
+	ttl := meta.ttlIndex()
+	for {
+		for c.idx >= c.numCells() {
+			next := c.header.rightPointer
+			if next == 0 {
+				c.valid = false
+				return nil
+			}
+			p, err := c.tree.bTreeMeta.Pager.GetPage(next)
+			if err != nil {
+				return err
+			}
+			if err := c.setPage(p); err != nil {
+				return err
+			}
+			c.idx = 0
+		}
+		if ttl < 0 || !expiredValue(decodeColumn(meta.Columns[ttl], c.rowAt(c.idx)), time.Now()) {
+			c.valid = true
+			return nil
+		}
+		c.idx++
+	}
+}
+
+// setPage moves the cursor onto leaf page p.
+func (c *KeyCursor) setPage(p *pager.Page) error {
+	var h baseHeader
+	h.readFrom(p.Data[:headerSize])
+	numCells := int(h.numCells)
+	if p.Data[0] == nodeTypeLeafSeparated {
+		rowsOff, err := separatedRowsOff(numCells, c.tree.bTreeMeta.TableMeta)
+		if err != nil {
+			return err
+		}
+		c.page, c.header, c.cells = p, h, nil
+		c.separated, c.rowsOff = true, rowsOff
+		return nil
+	}
+	cells, err := cellRegion(p, numCells, c.tree.bTreeMeta.TableMeta)
+	if err != nil {
+		return err
+	}
+	c.page = p
+	c.header = h
+	c.cells = cells
+	c.separated = false
+	return nil
+}
+
+// numCells returns the cell count from the current leaf's header.
+func (c *KeyCursor) numCells() int {
+	return int(c.header.numCells)
+}
+
+// RawScan calls fn for every key in order with the row's serialized bytes,
+// read straight from the leaf pages without DeserializeRow. rowBytes aliases
+// page memory and is only valid until fn returns. Returning false from fn
+// stops the scan.
+func (t *BTree) RawScan(fn func(key uint32, rowBytes []byte) bool) error {
+	c, err := t.NewKeyCursor()
+	if err != nil {
+		return err
+	}
+	for c.Valid() {
+		if !fn(c.Key(), c.rowAt(c.idx)) {
+			return nil
+		}
+		if err := c.Next(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ScanColumns calls fn for every key in order with the values of the named
+// columns, in the order given, decoded straight from the leaf pages. Other
+// columns are never decoded, so projecting a few columns of a wide table
+// allocates far less than a full scan. vals is reused between calls and only
+// valid until fn returns. Returning false from fn stops the scan.
+func (t *BTree) ScanColumns(names []string, fn func(key uint32, vals Row) bool) error {
+	all := t.bTreeMeta.TableMeta.Columns
+	cols := make([]column.Column, len(names))
+	for i, name := range names {
+		j := slices.IndexFunc(all, func(c column.Column) bool { return c.Name == name })
+		if j < 0 {
+			return fmt.Errorf("ScanColumns: no column named %q", name)
+		}
+		cols[i] = all[j]
+	}
+	vals := make(Row, len(cols))
+	return t.RawScan(func(key uint32, rowBytes []byte) bool {
+		for i, c := range cols {
+			vals[i] = decodeColumn(c, rowBytes)
+		}
+		return fn(key, vals)
+	})
+}
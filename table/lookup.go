@@ -0,0 +1,43 @@
+package table
+
+import "slices"
+
+// LookupIn returns the rows whose keys are among keys, as for
+// where id in (...), in key order. The keys are sorted and deduplicated and
+// each is found with its own Seek, so only the leaves holding them are read;
+// keys that are not in the tree are skipped.
+func (t *BTree) LookupIn(keys []uint32) ([]KeyRowPair, error) {
+	keys = slices.Clone(keys)
+	slices.SortFunc(keys, compareKeys)
+	keys = slices.Compact(keys)
+
+	var out []KeyRowPair
+	c := &Cursor{tree: t}
+	for _, key := range keys {
+		if err := c.Seek(key); err != nil {
+			return nil, err
+		}
+		for c.Valid() && compareKeys(c.Key(), key) == 0 {
+			out = append(out, KeyRowPair{Key: c.Key(), Row: slices.Clone(c.Value())})
+			if !t.bTreeMeta.Duplicates {
+				break
+			}
+			if err := c.Next(); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return out, nil
+}
+
+// Exists reports whether key is in the tree. On a tree with duplicate keys,
+// such as a secondary index, it stops at the first cell of the run instead
+// of reading the rest as LookupIn does, so it costs one root-to-leaf
+// descent.
+func (t *BTree) Exists(key uint32) (bool, error) {
+	c := &Cursor{tree: t}
+	if err := c.Seek(key); err != nil {
+		return false, err
+	}
+	return c.Valid() && compareKeys(c.Key(), key) == 0, nil
+}
@@ -0,0 +1,57 @@
+package table
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+)
+
+// ErrKeyRangesOverlap is returned by Merge when the two trees' key ranges
+// intersect.
+var ErrKeyRangesOverlap = errors.New("key ranges overlap")
+
+// Merge adds every row of other to t. The trees must share a schema and
+// their key ranges must be disjoint, otherwise Merge fails with
+// ErrKeyRangesOverlap before changing anything. Both trees are read in key
+// order and t is rebuilt holding the union; other is left unchanged.
+func (t *BTree) Merge(other *BTree) error {
+	return t.MergeContext(context.Background(), other)
+}
+
+// MergeContext is Merge that gives up with ctx.Err(), changing nothing, if
+// ctx is done while either tree is being read. Once the rebuild has started
+// it runs to completion.
+func (t *BTree) MergeContext(ctx context.Context, other *BTree) error {
+	if !slices.Equal(t.bTreeMeta.TableMeta.Columns, other.bTreeMeta.TableMeta.Columns) {
+		return errors.New("merge: schemas differ")
+	}
+	data, err := t.allPairs(ctx)
+	if err != nil {
+		return fmt.Errorf("merge: read tree: %w", err)
+	}
+	more, err := other.allPairs(ctx)
+	if err != nil {
+		return fmt.Errorf("merge: read other tree: %w", err)
+	}
+	if len(more) == 0 {
+		return nil
+	}
+
+	switch {
+	case len(data) == 0:
+		data = more
+	case compareKeys(data[len(data)-1].Key, more[0].Key) < 0:
+		data = append(data, more...)
+	case compareKeys(more[len(more)-1].Key, data[0].Key) < 0:
+		data = append(more, data...)
+	default:
+		return fmt.Errorf("merge: [%d, %d] and [%d, %d]: %w",
+			data[0].Key, data[len(data)-1].Key, more[0].Key, more[len(more)-1].Key, ErrKeyRangesOverlap)
+	}
+
+	if err := t.rebuild(data); err != nil {
+		return fmt.Errorf("merge: %w", err)
+	}
+	return t.addRows(len(more))
+}
@@ -0,0 +1,63 @@
+package table
+
+import (
+	"errors"
+	"testing"
+
+	"vqlite/column"
+)
+
+// TestMerge_DisjointRanges merges a tree of keys 101–200 into one of 1–100,
+// checks a full scan yields 1–200 in order, and that an overlapping merge is
+// rejected without touching either tree.
+func TestMerge_DisjointRanges(t *testing.T) {
+	schema := column.Schema{
+		{Name: "id", Type: column.ColumnTypeInt},
+		{Name: "name", Type: column.ColumnTypeText, MaxLength: 8},
+	}
+	open := func(lo, hi uint32) *BTree {
+		tp := newTempPager(t)
+		t.Cleanup(tp.cleanup)
+		meta, _ := BuildTableMeta(schema)
+		bt, err := NewBTree(tp.Pager, meta)
+		if err != nil {
+			t.Fatalf("NewBTree: %v", err)
+		}
+		for i := lo; i <= hi; i++ {
+			if err := bt.Insert(i, Row{i, "user"}); err != nil {
+				t.Fatalf("insert %d: %v", i, err)
+			}
+		}
+		return bt
+	}
+	low, high := open(1, 100), open(101, 200)
+
+	if err := low.Merge(high); err != nil {
+		t.Fatalf("Merge: %v", err)
+	}
+	want := uint32(1)
+	err := low.ForEach(func(key uint32, row Row) error {
+		if key != want || !row.Equal(Row{want, "user"}) {
+			t.Fatalf("scan got %d %v; want %d", key, row, want)
+		}
+		want++
+		return nil
+	})
+	if err != nil || want != 201 {
+		t.Fatalf("scan ended at %d, err %v; want 201, nil", want, err)
+	}
+	if n, _ := low.NumRows(); n != 200 {
+		t.Errorf("NumRows = %d; want 200", n)
+	}
+	if n, _ := high.NumRows(); n != 100 {
+		t.Errorf("other NumRows = %d; want 100", n)
+	}
+
+	mid := open(150, 250)
+	if err := low.Merge(mid); !errors.Is(err, ErrKeyRangesOverlap) {
+		t.Fatalf("overlapping Merge err = %v; want ErrKeyRangesOverlap", err)
+	}
+	if n, _ := low.NumRows(); n != 200 {
+		t.Errorf("NumRows after rejected merge = %d; want 200", n)
+	}
+}
@@ -0,0 +1,94 @@
+package table
+
+import (
+	"fmt"
+	"slices"
+
+	"vqlite/pager"
+)
+
+// The node cache maps a page number to the node deserialized from it, so
+// nodes that were just created or loaded are reused instead of being decoded
+// from p.Data again. Mutations go to the cached instance, which callers then
+// serialize back to its page as before. Like the pager's page cache it is
+// unbounded; entries are dropped when a page is freed or handed out again.
+//
+// Because a cached leaf lives on, the rows read from it must not be changed
+// in place. Search, First, Last, Select, Successor, Predecessor and LookupIn
+// return copies; Cursor.Value and the scan callbacks hand out the leaf's own
+// rows, read-only.
+
+// cachedNode returns the cached node for pgno, or nil.
+func (m *BTreeMeta) cachedNode(pgno uint32) BTreeNode {
+	return m.nodes[pgno]
+}
+
+// cacheNode remembers n as the in-memory node for its page.
+func (m *BTreeMeta) cacheNode(n BTreeNode) {
+	if m.nodes == nil {
+		m.nodes = make(map[uint32]BTreeNode)
+	}
+	m.nodes[n.Page()] = n
+}
+
+// evictNode forgets any cached node for pgno.
+func (m *BTreeMeta) evictNode(pgno uint32) {
+	delete(m.nodes, pgno)
+}
+
+// loadNode returns the cached node for pageNum, or reads the page, inspects
+// the first byte, and deserializes either a LeafNode or an InteriorNode.
+func (m *BTreeMeta) loadNode(pageNum uint32) (BTreeNode, error) {
+	if pageNum == metaPageNum {
+		return nil, fmt.Errorf("loadNode: page %d is the meta page", pageNum)
+	}
+	if slices.Contains(m.freeList().freePages, pageNum) {
+		return nil, fmt.Errorf("loadNode: page %d: %w", pageNum, ErrPageFreed)
+	}
+	m.nodeLoads++
+	if n := m.cachedNode(pageNum); n != nil {
+		return n, nil
+	}
+	p, err := m.Pager.GetPage(pageNum)
+	if err != nil {
+		return nil, err
+	}
+
+	var node BTreeNode
+	switch p.Data[0] {
+	case nodeTypeLeaf, nodeTypeLeafCompressed, nodeTypeLeafCompact, nodeTypeLeafSeparated:
+		leaf := &LeafNode{bTreeMeta: m}
+		leaf.header.pageNum = pageNum
+		if err := leaf.Load(p); err != nil {
+			return nil, err
+		}
+		node = leaf
+
+	case nodeTypeInterior:
+		inode := &InteriorNode{bTreeMeta: m}
+		inode.header.pageNum = pageNum
+		if err := inode.Load(p); err != nil {
+			return nil, err
+		}
+		node = inode
+
+	case pager.PageUninitialized:
+		return nil, fmt.Errorf("loadNode: page %d: %w", pageNum, ErrUninitializedPage)
+
+	default:
+		return nil, fmt.Errorf("loadNode: unknown node type %d", p.Data[0])
+	}
+	m.cacheNode(node)
+	return node, nil
+}
+
+// WarmRootPath loads the root and each node down to the first leaf into the
+// page and node caches, so a scan from the start of the tree begins without
+// reading the file. Unlike preloading the whole file it touches one page per
+// level.
+func (t *BTree) WarmRootPath() error {
+	if _, _, err := t.firstLeafFrom(t.rootPage); err != nil {
+		return fmt.Errorf("WarmRootPath: %w", err)
+	}
+	return nil
+}
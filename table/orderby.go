@@ -0,0 +1,128 @@
+package table
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+	"strings"
+
+	"vqlite/column"
+)
+
+// SortKey is one column of an ORDER BY clause. TEXT columns compare under
+// Collation.
+type SortKey struct {
+	Column    string
+	Desc      bool
+	Collation Collation
+	Nulls     NullOrder
+}
+
+// NullOrder says where a sort puts NULLs, which a Row holds as nil. Stored
+// rows have no NULLs yet; rows built in memory can.
+type NullOrder int
+
+const (
+	NullsDefault NullOrder = iota // below every value, as in SQLite: first ascending, last descending
+	NullsFirst                    // before every value in either direction
+	NullsLast                     // after every value in either direction
+)
+
+// ParseOrderBy parses the column list of an ORDER BY clause, such as
+// "age asc, email desc nulls first". A column without a direction sorts
+// ascending, and without a nulls clause puts NULLs as NullsDefault does.
+func ParseOrderBy(clause string) ([]SortKey, error) {
+	var keys []SortKey
+	for _, item := range strings.Split(clause, ",") {
+		fields := strings.Fields(strings.ToLower(item))
+		if len(fields) == 0 {
+			return nil, fmt.Errorf("order by %q: want a column", strings.TrimSpace(item))
+		}
+		k := SortKey{Column: strings.Fields(item)[0]}
+		rest := fields[1:]
+		if len(rest) > 0 && (rest[0] == "asc" || rest[0] == "desc") {
+			k.Desc = rest[0] == "desc"
+			rest = rest[1:]
+		}
+		if len(rest) == 2 && rest[0] == "nulls" && (rest[1] == "first" || rest[1] == "last") {
+			k.Nulls = NullsFirst
+			if rest[1] == "last" {
+				k.Nulls = NullsLast
+			}
+			rest = rest[2:]
+		}
+		if len(rest) > 0 {
+			return nil, fmt.Errorf("order by %q: want a column, an optional asc or desc and an optional nulls first or last", strings.TrimSpace(item))
+		}
+		keys = append(keys, k)
+	}
+	return keys, nil
+}
+
+// SortRows sorts rows, laid out as in schema, by keys: by the first
+// key, ties broken by the next, and so on, each ascending or descending.
+// Rows equal on every key keep their order, so sorting a scan leaves ties
+// in primary key order.
+func SortRows(schema column.Schema, rows []Row, keys []SortKey) error {
+	type sortCol struct {
+		idx int
+		typ column.ColumnType
+		SortKey
+	}
+	cols := make([]sortCol, len(keys))
+	for i, k := range keys {
+		j := slices.IndexFunc(schema, func(c column.Column) bool { return c.Name == k.Column })
+		if j < 0 {
+			return fmt.Errorf("order by: no column named %q", k.Column)
+		}
+		cols[i] = sortCol{idx: j, typ: schema[j].Type, SortKey: k}
+	}
+	slices.SortStableFunc(rows, func(a, b Row) int {
+		for _, c := range cols {
+			if a[c.idx] == nil || b[c.idx] == nil {
+				if r := c.compareNulls(a[c.idx] == nil, b[c.idx] == nil); r != 0 {
+					return r
+				}
+				continue
+			}
+			var r int
+			switch c.typ {
+			case column.ColumnTypeText:
+				r = c.Collation.Compare(a[c.idx].(string), b[c.idx].(string))
+			case column.ColumnTypeInt32:
+				r = cmp.Compare(a[c.idx].(int32), b[c.idx].(int32))
+			default:
+				r = cmp.Compare(a[c.idx].(uint32), b[c.idx].(uint32))
+			}
+			if c.Desc {
+				r = -r
+			}
+			if r != 0 {
+				return r
+			}
+		}
+		return 0
+	})
+	return nil
+}
+
+// compareNulls orders two values of which at least one is NULL, aNull and
+// bNull telling which, in the final direction of k.
+func (k SortKey) compareNulls(aNull, bNull bool) int {
+	var r int
+	switch {
+	case aNull && bNull:
+		return 0
+	case aNull:
+		r = -1
+	default:
+		r = 1
+	}
+	switch {
+	case k.Nulls == NullsLast:
+		r = -r
+	case k.Nulls == NullsDefault && k.Desc:
+		r = -r
+	}
+	return r
+}
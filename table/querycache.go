@@ -0,0 +1,81 @@
+package table
+
+import (
+	"container/list"
+	"strings"
+)
+
+// QueryCache keeps the results of recent queries against one tree, keyed by
+// the normalized query text, and evicts the least recently used entry once
+// it holds size of them. Every mutation of the tree bumps its version, which
+// empties the cache on the next lookup, so a cached result is never older
+// than the rows it was read from.
+type QueryCache struct {
+	tree    *BTree
+	size    int
+	version uint64
+	lru     *list.List // of *cacheEntry, most recently used first
+	entries map[string]*list.Element
+}
+
+type cacheEntry struct {
+	query string
+	rows  []KeyRowPair
+}
+
+// NewQueryCache returns an empty cache for t holding at most size results.
+func NewQueryCache(t *BTree, size int) *QueryCache {
+	return &QueryCache{
+		tree:    t,
+		size:    max(size, 1),
+		version: t.version,
+		lru:     list.New(),
+		entries: make(map[string]*list.Element),
+	}
+}
+
+// Query returns the cached result of query if the tree has not changed
+// since it was stored, and otherwise runs run, caches what it returns and
+// returns that. Results that failed are not cached. Callers must not modify
+// the returned rows.
+func (c *QueryCache) Query(query string, run func() ([]KeyRowPair, error)) ([]KeyRowPair, error) {
+	if c.version != c.tree.version {
+		c.Clear()
+		c.version = c.tree.version
+	}
+	query = normalizeQuery(query)
+	if el, ok := c.entries[query]; ok {
+		c.lru.MoveToFront(el)
+		return el.Value.(*cacheEntry).rows, nil
+	}
+	rows, err := run()
+	if err != nil {
+		return nil, err
+	}
+	if c.version != c.tree.version {
+		// run itself changed the tree
+		return rows, nil
+	}
+	c.entries[query] = c.lru.PushFront(&cacheEntry{query: query, rows: rows})
+	if c.lru.Len() > c.size {
+		oldest := c.lru.Back()
+		c.lru.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cacheEntry).query)
+	}
+	return rows, nil
+}
+
+// Len returns the number of cached results.
+func (c *QueryCache) Len() int { return c.lru.Len() }
+
+// Clear drops every cached result.
+func (c *QueryCache) Clear() {
+	c.lru.Init()
+	clear(c.entries)
+}
+
+// normalizeQuery collapses runs of whitespace so queries differing only in
+// spacing share an entry.
+func normalizeQuery(query string) string {
+	return strings.Join(strings.Fields(query), " ")
+}
@@ -0,0 +1,97 @@
+package table
+
+import (
+	"slices"
+	"sort"
+)
+
+// Select returns the key and row at 0-based position n in key order, as an
+// order statistic for pagination or percentiles; found is false when n is
+// negative or not below the number of rows. It descends by the subtree
+// counts, except for a table with a TTL column, whose expired rows are
+// counted but not returned, where it scans.
+func (t *BTree) Select(n int) (uint32, Row, bool, error) {
+	if n < 0 {
+		return 0, nil, false, nil
+	}
+	sel := t.selectByCount
+	if t.bTreeMeta.TableMeta.TTLColumn != "" {
+		sel = t.selectByScan
+	}
+	key, row, found, err := sel(n)
+	return key, slices.Clone(row), found, err
+}
+
+// selectByScan walks the first n+1 rows with a cursor.
+func (t *BTree) selectByScan(n int) (uint32, Row, bool, error) {
+	c, err := t.NewCursor()
+	if err != nil {
+		return 0, nil, false, err
+	}
+	for i := 0; c.Valid(); i++ {
+		if i == n {
+			return c.Key(), c.Value(), true, nil
+		}
+		if err := c.Next(); err != nil {
+			return 0, nil, false, err
+		}
+	}
+	return 0, nil, false, nil
+}
+
+// Successor returns the smallest key strictly greater than key and its row;
+// found is false when key is the largest key or above it. Unlike Seek, an
+// equal key is passed over, together with any duplicates of it.
+func (t *BTree) Successor(key uint32) (uint32, Row, bool, error) {
+	c := &Cursor{tree: t}
+	if err := c.Seek(key); err != nil {
+		return 0, nil, false, err
+	}
+	for c.Valid() && compareKeys(c.Key(), key) == 0 {
+		if err := c.Next(); err != nil {
+			return 0, nil, false, err
+		}
+	}
+	if !c.Valid() {
+		return 0, nil, false, nil
+	}
+	return c.Key(), slices.Clone(c.Value()), true, nil
+}
+
+// Predecessor returns the largest key strictly less than key and its row;
+// found is false when key is the smallest key or below it.
+func (t *BTree) Predecessor(key uint32) (uint32, Row, bool, error) {
+	k, row, found, err := t.lastBelow(t.rootPage, key)
+	return k, slices.Clone(row), found, err
+}
+
+// lastBelow returns the last cell below key in the subtree at pgno whose row
+// has not expired. Like lastIn it falls back to the children further left
+// when a leaf has no such cell.
+func (t *BTree) lastBelow(pgno, key uint32) (uint32, Row, bool, error) {
+	node, err := t.loadNode(pgno)
+	if err != nil {
+		return 0, nil, false, err
+	}
+	if leaf, ok := node.(*LeafNode); ok {
+		i := sort.Search(len(leaf.cells), func(i int) bool {
+			return compareKeys(leaf.cells[i].Key, key) >= 0
+		})
+		if i = leaf.lastLive(i); i < 0 {
+			return 0, nil, false, nil
+		}
+		return leaf.cells[i].Key, leaf.cells[i].Value, true, nil
+	}
+	in := node.(*InteriorNode)
+	// children right of i hold only keys >= key
+	i := sort.Search(len(in.cells), func(i int) bool {
+		return compareKeys(in.cells[i].Key, key) >= 0
+	})
+	for ; i >= 0; i-- {
+		k, row, found, err := t.lastBelow(in.child(i), key)
+		if found || err != nil {
+			return k, row, found, err
+		}
+	}
+	return 0, nil, false, nil
+}
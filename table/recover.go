@@ -0,0 +1,50 @@
+package table
+
+import (
+	"fmt"
+	"vqlite/column"
+	"vqlite/pager"
+)
+
+// RecoveryReport describes what RecoverDatabase found and repaired.
+type RecoveryReport struct {
+	// TruncatedBytes is the size of the trailing partial page cut off the
+	// file, or 0 if it ended on a page boundary.
+	TruncatedBytes int64
+	// RelinkedLeaves counts the leaves whose next-leaf pointer was rewritten.
+	RelinkedLeaves int
+	// Valid reports whether the tree passed Validate after the repairs;
+	// Problem holds the first problem Validate found when it did not.
+	Valid   bool
+	Problem error
+}
+
+// RecoverDatabase repairs the table in filename after a crash and checks
+// what is left. It cuts off a trailing partial page, opens the tree with
+// schema, rebuilds the leaf chain with RelinkLeaves and runs Validate, then
+// closes the file. There is no write-ahead log to replay. A tree that fails
+// validation is reported through the report, not the error, which is kept
+// for failures that stopped recovery.
+func RecoverDatabase(filename string, schema column.Schema) (report RecoveryReport, err error) {
+	pr, err := pager.RecoverDatabase(filename)
+	if err != nil {
+		return report, fmt.Errorf("recover: %w", err)
+	}
+	report.TruncatedBytes = pr.TruncatedBytes
+
+	_, bt, err := OpenTable(filename, schema)
+	if err != nil {
+		return report, fmt.Errorf("recover: %w", err)
+	}
+	defer func() {
+		if cerr := bt.Close(); err == nil && cerr != nil {
+			err = fmt.Errorf("recover: %w", cerr)
+		}
+	}()
+	if report.RelinkedLeaves, err = bt.relinkLeaves(); err != nil {
+		return report, fmt.Errorf("recover: %w", err)
+	}
+	report.Problem = bt.Validate()
+	report.Valid = report.Problem == nil
+	return report, nil
+}
@@ -9,12 +9,76 @@ import (
 
 type Row []interface{}
 
+// Equal reports whether r and other hold the same values in the same order.
+func (r Row) Equal(other Row) bool {
+	if len(r) != len(other) {
+		return false
+	}
+	for i := range r {
+		if r[i] != other[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// Diff describes how r differs from other, naming each differing column by
+// its name in meta. It returns "" when the rows are equal.
+func (r Row) Diff(other Row, meta *TableMeta) string {
+	if len(r) != len(other) {
+		return fmt.Sprintf("row has %d columns, other has %d", len(r), len(other))
+	}
+	var diffs []string
+	for i := range r {
+		if r[i] == other[i] {
+			continue
+		}
+		name := fmt.Sprintf("#%d", i)
+		if meta != nil && i < len(meta.Columns) {
+			name = meta.Columns[i].Name
+		}
+		diffs = append(diffs, fmt.Sprintf("column %q: %v (%T) != %v (%T)", name, r[i], r[i], other[i], other[i]))
+	}
+	return strings.Join(diffs, "; ")
+}
+
+// checkRow reports why SerializeRow would reject row, if it would: a wrong
+// number of columns or a value of the wrong Go type for its column.
+func checkRow(meta *TableMeta, row Row) error {
+	if len(row) != meta.NumCols {
+		return fmt.Errorf("SerializeRow: row has %d columns, expected %d", len(row), meta.NumCols)
+	}
+	for i, colMeta := range meta.Columns {
+		var ok bool
+		var want string
+		switch colMeta.Type {
+		case column.ColumnTypeInt:
+			_, ok = row[i].(uint32)
+			want = "uint32"
+		case column.ColumnTypeInt32:
+			_, ok = row[i].(int32)
+			want = "int32"
+		case column.ColumnTypeText:
+			_, ok = row[i].(string)
+			want = "string"
+		default:
+			ok = true
+		}
+		if !ok {
+			return fmt.Errorf("SerializeRow: column %q expects %s, got %T", colMeta.Name, want, row[i])
+		}
+	}
+	return nil
+}
+
+// SerializeRow writes row into dst in the fixed layout of meta. It checks
+// the whole row before writing, so on error dst is left untouched.
 func SerializeRow(meta *TableMeta, row Row, dst []byte) error {
 	if uint32(len(dst)) != meta.RowSize {
 		return fmt.Errorf("SerializeRow: dst length %d, expected %d", len(dst), meta.RowSize)
 	}
-	if len(row) != meta.NumCols {
-		return fmt.Errorf("SerializeRow: row has %d columns, expected %d", len(row), meta.NumCols)
+	if err := checkRow(meta, row); err != nil {
+		return err
 	}
 
 	// Zero out the entire destination (in case of leftover bytes).
@@ -26,18 +90,13 @@ func SerializeRow(meta *TableMeta, row Row, dst []byte) error {
 		base := colMeta.Offset
 		switch colMeta.Type {
 		case column.ColumnTypeInt:
-			val, ok := row[i].(uint32)
-			if !ok {
-				return fmt.Errorf("SerializeRow: column %q expects uint32, got %T", colMeta.Name, row[i])
-			}
-			binary.LittleEndian.PutUint32(dst[base:base+4], val)
+			binary.LittleEndian.PutUint32(dst[base:base+4], row[i].(uint32))
+
+		case column.ColumnTypeInt32:
+			binary.LittleEndian.PutUint32(dst[base:base+4], uint32(row[i].(int32)))
 
 		case column.ColumnTypeText:
-			s, ok := row[i].(string)
-			if !ok {
-				return fmt.Errorf("SerializeRow: column %q expects string, got %T", colMeta.Name, row[i])
-			}
-			bytes := []byte(s)
+			bytes := []byte(row[i].(string))
 			if uint32(len(bytes)) > colMeta.MaxLength {
 				copy(dst[base:base+colMeta.MaxLength], bytes[:colMeta.MaxLength])
 			} else {
@@ -56,20 +115,31 @@ func DeserializeRow(meta *TableMeta, src []byte) (Row, error) {
 
 	row := make(Row, meta.NumCols)
 	for i, colMeta := range meta.Columns {
-		base := colMeta.Offset
-		switch colMeta.Type {
-		case column.ColumnTypeInt:
-			val := binary.LittleEndian.Uint32(src[base : base+4])
-			row[i] = val
-
-		case column.ColumnTypeText:
-			raw := src[base : base+colMeta.ByteSize]
-			// Trim any trailing zero bytes so we get the original string.
-			str := string(raw)
-			str = strings.TrimRight(str, "\x00")
-			row[i] = str
-		}
+		row[i] = decodeColumn(colMeta, src)
 	}
 
 	return row, nil
 }
+
+// decodeColumn reads the value of col from the serialized row src.
+func decodeColumn(col column.Column, src []byte) any {
+	base := col.Offset
+	switch col.Type {
+	case column.ColumnTypeInt:
+		return binary.LittleEndian.Uint32(src[base : base+4])
+
+	case column.ColumnTypeInt32:
+		return int32(binary.LittleEndian.Uint32(src[base : base+4]))
+
+	case column.ColumnTypeText:
+		raw := src[base : base+col.ByteSize]
+		// Drop the trailing zero padding before converting, so the string
+		// is built once and only as long as the value.
+		end := len(raw)
+		for end > 0 && raw[end-1] == 0 {
+			end--
+		}
+		return string(raw[:end])
+	}
+	return nil
+}
@@ -0,0 +1,205 @@
+package table
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"vqlite/column"
+)
+
+// Row ids. A primary tree can number its keys in insertion order: each Insert
+// of a new key takes the next value of a counter in the meta page as the
+// key's row id and records it in two index trees, one keyed by row id for
+// scanning in insertion order and one keyed by primary key for finding a
+// key's row id again when it is deleted or inserted anew.
+//
+// Only Insert and Delete maintain the indexes. Rows removed some other way,
+// by Truncate or a TTL purge say, leave entries behind that ScanByRowID
+// skips, since it checks each entry against both the primary tree and the
+// key index.
+
+// metaNextRowIDOff is where the meta page keeps the next row id to hand
+// out, a little-endian uint32; 0 in a file that never had one means 1.
+const metaNextRowIDOff = 16
+
+// Names of the row id index trees in the index directory.
+const (
+	rowIDIndexName = "_rowid"
+	rowIDKeysName  = "_rowid_keys"
+)
+
+// rowIDIndexes are the two index trees behind row ids.
+type rowIDIndexes struct {
+	byRowID *BTree // rowid -> (rowid, key)
+	byKey   *BTree // key -> (key, rowid)
+}
+
+// EnableRowIDs starts numbering the tree's keys in insertion order, creating
+// the row id indexes the first time and reattaching them on later calls, so
+// a reopened file must call it again like the other Set options. Keys
+// already in the tree without a row id are given one in key order.
+func (t *BTree) EnableRowIDs() error {
+	if t.dirOff != 0 {
+		return errors.New("EnableRowIDs: not called on the primary tree")
+	}
+	if t.bTreeMeta.Duplicates {
+		return errors.New("EnableRowIDs: a tree with duplicate keys has no row id per key")
+	}
+	ixs, err := t.Indexes()
+	if err != nil {
+		return fmt.Errorf("EnableRowIDs: %w", err)
+	}
+	r := &rowIDIndexes{byRowID: ixs[rowIDIndexName], byKey: ixs[rowIDKeysName]}
+	if r.byRowID == nil {
+		if r.byRowID, err = t.CreateIndex(rowIDIndexName, column.Schema{
+			{Name: "rowid", Type: column.ColumnTypeInt},
+			{Name: "key", Type: column.ColumnTypeInt},
+		}); err != nil {
+			return fmt.Errorf("EnableRowIDs: %w", err)
+		}
+	}
+	if r.byKey == nil {
+		if r.byKey, err = t.CreateIndex(rowIDKeysName, column.Schema{
+			{Name: "key", Type: column.ColumnTypeInt},
+			{Name: "rowid", Type: column.ColumnTypeInt},
+		}); err != nil {
+			return fmt.Errorf("EnableRowIDs: %w", err)
+		}
+	}
+
+	var missing []uint32
+	err = t.ForEach(func(key uint32, _ Row) error {
+		_, found, err := r.byKey.Search(key)
+		if err == nil && !found {
+			missing = append(missing, key)
+		}
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("EnableRowIDs: %w", err)
+	}
+	t.rowids = r
+	for _, key := range missing {
+		if err := t.assignRowID(key); err != nil {
+			return fmt.Errorf("EnableRowIDs: %w", err)
+		}
+	}
+	return nil
+}
+
+// resetRowIDs empties the row id indexes, those attached by EnableRowIDs or
+// else any the file has, and puts the next-rowid counter back to its start.
+func (t *BTree) resetRowIDs() error {
+	r := t.rowids
+	if r == nil {
+		ixs, err := t.Indexes()
+		if err != nil {
+			return err
+		}
+		r = &rowIDIndexes{byRowID: ixs[rowIDIndexName], byKey: ixs[rowIDKeysName]}
+	}
+	for _, ix := range []*BTree{r.byRowID, r.byKey} {
+		if ix == nil {
+			continue
+		}
+		if err := ix.Truncate(false); err != nil {
+			return fmt.Errorf("row id index: %w", err)
+		}
+	}
+	mp, err := t.bTreeMeta.Pager.GetPage(metaPageNum)
+	if err != nil {
+		return fmt.Errorf("row id: get meta page: %w", err)
+	}
+	binary.LittleEndian.PutUint32(mp.Data[metaNextRowIDOff:], 0)
+	mp.Dirty = true
+	return nil
+}
+
+// assignRowID gives key the next row id.
+func (t *BTree) assignRowID(key uint32) error {
+	mp, err := t.bTreeMeta.Pager.GetPage(metaPageNum)
+	if err != nil {
+		return fmt.Errorf("row id: get meta page: %w", err)
+	}
+	id := max(binary.LittleEndian.Uint32(mp.Data[metaNextRowIDOff:]), 1)
+	binary.LittleEndian.PutUint32(mp.Data[metaNextRowIDOff:], id+1)
+	mp.Dirty = true
+
+	if err := t.dropRowID(key); err != nil {
+		return err
+	}
+	if err := t.rowids.byRowID.Insert(id, Row{id, key}); err != nil {
+		return fmt.Errorf("row id %d: %w", id, err)
+	}
+	if err := t.rowids.byKey.Insert(key, Row{key, id}); err != nil {
+		return fmt.Errorf("row id %d: %w", id, err)
+	}
+	return nil
+}
+
+// dropRowID removes key's row id from both indexes, if it has one.
+func (t *BTree) dropRowID(key uint32) error {
+	row, found, err := t.rowids.byKey.Search(key)
+	if err != nil || !found {
+		return err
+	}
+	if _, err := t.rowids.byRowID.Delete(row[1].(uint32)); err != nil {
+		return fmt.Errorf("row id %d: %w", row[1], err)
+	}
+	if _, err := t.rowids.byKey.Delete(key); err != nil {
+		return fmt.Errorf("row id of key %d: %w", key, err)
+	}
+	return nil
+}
+
+// RowID returns the row id of key, with found false if key is not in the
+// tree or the tree does not have row ids enabled.
+func (t *BTree) RowID(key uint32) (rowid uint32, found bool, err error) {
+	if t.rowids == nil {
+		return 0, false, nil
+	}
+	if _, found, err := t.Search(key); err != nil || !found {
+		return 0, false, err
+	}
+	row, found, err := t.rowids.byKey.Search(key)
+	if err != nil || !found {
+		return 0, false, err
+	}
+	return row[1].(uint32), true, nil
+}
+
+// ScanByRowID calls fn with every row in insertion order, that is by
+// ascending row id, until fn returns false. A key deleted and inserted again
+// comes at the position of its last insert.
+func (t *BTree) ScanByRowID(fn func(rowid, key uint32, row Row) bool) error {
+	if t.rowids == nil {
+		return errors.New("ScanByRowID: row ids not enabled")
+	}
+	// read the ids first; fn may change the tree
+	var ids []Row
+	if err := t.rowids.byRowID.ForEach(func(_ uint32, row Row) error {
+		ids = append(ids, row)
+		return nil
+	}); err != nil {
+		return fmt.Errorf("ScanByRowID: %w", err)
+	}
+	for _, ent := range ids {
+		id, key := ent[0].(uint32), ent[1].(uint32)
+		cur, found, err := t.rowids.byKey.Search(key)
+		if err != nil {
+			return fmt.Errorf("ScanByRowID: %w", err)
+		}
+		if !found || cur[1].(uint32) != id {
+			continue // left behind by a delete outside Delete
+		}
+		row, found, err := t.Search(key)
+		if err != nil {
+			return fmt.Errorf("ScanByRowID: %w", err)
+		}
+		if found && !fn(id, key, row) {
+			return nil
+		}
+	}
+	return nil
+}
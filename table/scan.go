@@ -0,0 +1,94 @@
+package table
+
+import (
+	"fmt"
+	"reflect"
+	"slices"
+
+	"vqlite/column"
+)
+
+// ScanInto copies the current row into the struct dst points to. Each field
+// tagged `vqlite:"name"` receives the column of that name; untagged fields
+// and fields tagged "-" are left alone. A tag naming an unknown column, or a
+// field whose type cannot hold the column's value (uint32 for INT, int32 for
+// INT32, string for TEXT), is an error. Call only if Valid() is true.
+func (c *Cursor) ScanInto(dst interface{}) error {
+	v := reflect.ValueOf(dst)
+	if v.Kind() != reflect.Pointer || v.IsNil() || v.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("ScanInto: dst must be a non-nil pointer to a struct, got %T", dst)
+	}
+	v = v.Elem()
+	cols := c.tree.bTreeMeta.TableMeta.Columns
+	row := c.Value()
+	for i := 0; i < v.NumField(); i++ {
+		f := v.Type().Field(i)
+		name, ok := f.Tag.Lookup("vqlite")
+		if !ok || name == "-" {
+			continue
+		}
+		idx := slices.IndexFunc(cols, func(col column.Column) bool { return col.Name == name })
+		if idx < 0 {
+			return fmt.Errorf("ScanInto: field %s: no column named %q", f.Name, name)
+		}
+		if !f.IsExported() {
+			return fmt.Errorf("ScanInto: field %s is unexported", f.Name)
+		}
+		val := reflect.ValueOf(row[idx])
+		if !val.Type().AssignableTo(f.Type) {
+			return fmt.Errorf("ScanInto: field %s is %s, column %q holds %s", f.Name, f.Type, name, val.Type())
+		}
+		v.Field(i).Set(val)
+	}
+	return nil
+}
+
+// ScanWithLocation calls fn for every row in key order together with the
+// page number of the leaf holding it, for checking how rows are laid out
+// across leaves. It stops early when fn returns false. fn must not modify
+// row, which is the cached leaf's own.
+func (t *BTree) ScanWithLocation(fn func(key uint32, row Row, pageNum uint32) bool) error {
+	c, err := t.NewCursor()
+	if err != nil {
+		return err
+	}
+	for c.Valid() {
+		if !fn(c.Key(), c.Value(), c.page) {
+			return nil
+		}
+		if err := c.Next(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ScanFromRoot calls fn for every row of the tree rooted at rootPage in key
+// order, as a cursor scan of the current tree would, stopping early when fn
+// returns false. rootPage may be any root, such as one recorded before
+// later writes. There is no copy-on-write, so an older root only shows its
+// old rows if the pages below it have not been rewritten since, for example
+// a root leaf saved aside with Pager.CopyPage. fn must not modify row, which
+// is the cached leaf's own.
+func (t *BTree) ScanFromRoot(rootPage uint32, fn func(key uint32, row Row) bool) error {
+	if rootPage == metaPageNum || int(rootPage) >= t.bTreeMeta.Pager.NumPages {
+		return fmt.Errorf("ScanFromRoot: page %d is not a node page of a %d-page file", rootPage, t.bTreeMeta.Pager.NumPages)
+	}
+	leaf, pg, err := t.firstLeafFrom(rootPage)
+	if err != nil {
+		return fmt.Errorf("ScanFromRoot: %w", err)
+	}
+	c := &Cursor{tree: t, leaf: leaf, page: pg, gen: t.gen}
+	if err := c.settle(); err != nil {
+		return err
+	}
+	for c.Valid() {
+		if !fn(c.Key(), c.Value()) {
+			return nil
+		}
+		if err := c.Next(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,258 @@
+package table
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"vqlite/column"
+	"vqlite/pager"
+)
+
+// FormatVersion is the file format version recorded next to a stored schema.
+// Version 2 added subtree key counts to interior pages.
+const FormatVersion = 2
+
+// Self-describing schema inside the meta page (page 0), between the free list
+// and the index directory (index.go):
+//
+//	[ version:uint16 | numCols:uint16 | columns... ]
+//
+// with each column stored as [ type:uint8 | maxLength:uint32 | nameLen:uint8 | name ].
+// A zero version means no schema has been stored.
+const metaSchemaOff = 512
+
+// metaUserVersionOff is where the meta page keeps the user version, a
+// little-endian uint32 the engine never interprets.
+const metaUserVersionOff = 12
+
+// ErrNoSchema is returned by InspectFile for a file without a stored schema.
+var ErrNoSchema = errors.New("no schema stored in file")
+
+// FileInfo describes a database file as read by InspectFile.
+type FileInfo struct {
+	Version   uint16
+	Schema    column.Schema
+	NumRows   uint32
+	Height    int
+	NumPages  int
+	FreePages int
+}
+
+// StoreSchema records the tree's schema and the format version in the meta
+// page, making the file readable by InspectFile without a catalog. Once
+// stored, AddColumn and DropColumn keep it up to date. Index and catalog
+// trees keep their columns in their directory entries instead.
+func (t *BTree) StoreSchema() error {
+	if t.dirOff != 0 {
+		return errors.New("StoreSchema: not called on the primary tree")
+	}
+	buf, err := encodeSchema(t.bTreeMeta.TableMeta.Columns)
+	if err != nil {
+		return fmt.Errorf("StoreSchema: %w", err)
+	}
+	mp, err := t.bTreeMeta.Pager.GetPage(metaPageNum)
+	if err != nil {
+		return fmt.Errorf("StoreSchema: get meta page: %w", err)
+	}
+	clear(mp.Data[metaSchemaOff:metaDirOff])
+	copy(mp.Data[metaSchemaOff:], buf)
+	mp.Dirty = true
+	return nil
+}
+
+// GetUserVersion returns the number last stored with SetUserVersion, or 0 for
+// a file that never had one, like SQLite's PRAGMA user_version. Migration
+// code can use it to tell which schema changes a file has seen.
+func (t *BTree) GetUserVersion() (uint32, error) {
+	mp, err := t.bTreeMeta.Pager.GetPage(metaPageNum)
+	if err != nil {
+		return 0, fmt.Errorf("GetUserVersion: get meta page: %w", err)
+	}
+	return binary.LittleEndian.Uint32(mp.Data[metaUserVersionOff:]), nil
+}
+
+// SetUserVersion stores v in the meta page. There is one user version per
+// file, shared by the primary tree and its indexes.
+func (t *BTree) SetUserVersion(v uint32) error {
+	mp, err := t.bTreeMeta.Pager.GetPage(metaPageNum)
+	if err != nil {
+		return fmt.Errorf("SetUserVersion: get meta page: %w", err)
+	}
+	binary.LittleEndian.PutUint32(mp.Data[metaUserVersionOff:], v)
+	mp.Dirty = true
+	return nil
+}
+
+// refreshSchema records the tree's columns after they changed: for the
+// primary tree it rewrites the stored schema, if the file has one, and for
+// an index or catalog tree its own directory entry.
+func (t *BTree) refreshSchema() error {
+	if t.dirOff != 0 {
+		return t.refreshDirEntry()
+	}
+	mp, err := t.bTreeMeta.Pager.GetPage(metaPageNum)
+	if err != nil {
+		return fmt.Errorf("get meta page: %w", err)
+	}
+	if binary.LittleEndian.Uint16(mp.Data[metaSchemaOff:]) == 0 {
+		return nil
+	}
+	return t.StoreSchema()
+}
+
+// encodeSchema lays out cols in the stored schema format.
+func encodeSchema(cols column.Schema) ([]byte, error) {
+	buf := binary.LittleEndian.AppendUint16(nil, FormatVersion)
+	buf, err := appendColumns(buf, cols)
+	if err != nil {
+		return nil, err
+	}
+	if len(buf) > metaDirOff-metaSchemaOff {
+		return nil, fmt.Errorf("schema needs %d bytes, meta page has room for %d", len(buf), metaDirOff-metaSchemaOff)
+	}
+	return buf, nil
+}
+
+// appendColumns appends the column count and cols in the stored format.
+func appendColumns(buf []byte, cols column.Schema) ([]byte, error) {
+	buf = binary.LittleEndian.AppendUint16(buf, uint16(len(cols)))
+	for _, c := range cols {
+		if len(c.Name) > 255 {
+			return nil, fmt.Errorf("column name %q longer than 255 bytes", c.Name)
+		}
+		buf = append(buf, byte(c.Type))
+		buf = binary.LittleEndian.AppendUint32(buf, c.MaxLength)
+		buf = append(buf, byte(len(c.Name)))
+		buf = append(buf, c.Name...)
+	}
+	return buf, nil
+}
+
+// decodeSchema reads a stored schema from the meta page data.
+func decodeSchema(data []byte) (uint16, column.Schema, error) {
+	data = data[metaSchemaOff:]
+	version := binary.LittleEndian.Uint16(data)
+	if version == 0 {
+		return 0, nil, ErrNoSchema
+	}
+	if version != FormatVersion {
+		return 0, nil, fmt.Errorf("unsupported format version %d", version)
+	}
+	schema, _, err := readColumns(data, 2)
+	if err != nil {
+		return 0, nil, err
+	}
+	return version, schema, nil
+}
+
+// readColumns reads a column count and the columns following it from data at
+// off, returning them and the offset just past the last one.
+func readColumns(data []byte, off int) (column.Schema, int, error) {
+	if off+2 > len(data) {
+		return nil, 0, errors.New("schema truncated before column count")
+	}
+	n := int(binary.LittleEndian.Uint16(data[off:]))
+	off += 2
+	schema := make(column.Schema, 0, n)
+	for i := 0; i < n; i++ {
+		if off+6 > len(data) {
+			return nil, 0, fmt.Errorf("schema truncated at column %d", i)
+		}
+		c := column.Column{
+			Type:      column.ColumnType(data[off]),
+			MaxLength: binary.LittleEndian.Uint32(data[off+1:]),
+		}
+		nameLen := int(data[off+5])
+		off += 6
+		if off+nameLen > len(data) {
+			return nil, 0, fmt.Errorf("schema truncated in name of column %d", i)
+		}
+		c.Name = string(data[off : off+nameLen])
+		off += nameLen
+		schema = append(schema, c)
+	}
+	return schema, off, nil
+}
+
+// InspectFile opens the database at path using the schema stored in it and
+// returns that schema together with basic tree statistics. It fails with
+// ErrNoSchema if StoreSchema was never called on the file.
+func InspectFile(path string) (*FileInfo, error) {
+	p, err := pager.OpenPager(path)
+	if err != nil {
+		return nil, err
+	}
+	defer p.Close()
+	if p.NumPages == 0 {
+		return nil, fmt.Errorf("InspectFile: %s: %w", path, ErrNoSchema)
+	}
+	mp, err := p.GetPage(metaPageNum)
+	if err != nil {
+		return nil, fmt.Errorf("InspectFile: get meta page: %w", err)
+	}
+	version, schema, err := decodeSchema(mp.Data[:])
+	if err != nil {
+		return nil, fmt.Errorf("InspectFile: %s: %w", path, err)
+	}
+	meta, err := BuildTableMeta(schema)
+	if err != nil {
+		return nil, fmt.Errorf("InspectFile: stored schema: %w", err)
+	}
+	bt, err := NewBTree(p, meta)
+	if err != nil {
+		return nil, fmt.Errorf("InspectFile: %w", err)
+	}
+
+	info := &FileInfo{
+		Version:   version,
+		Schema:    meta.Columns,
+		NumPages:  p.NumPages,
+		FreePages: len(bt.bTreeMeta.freeList().freePages),
+	}
+	if info.NumRows, err = bt.NumRows(); err != nil {
+		return nil, fmt.Errorf("InspectFile: %w", err)
+	}
+	if info.Height, err = bt.Height(); err != nil {
+		return nil, fmt.Errorf("InspectFile: %w", err)
+	}
+	return info, nil
+}
+
+// ParseSchema parses a column list such as
+//
+//	id INT, username TEXT(32), email TEXT(64), age INT
+//
+// into a schema. Each entry is a name followed by INT, INT32 or TEXT(n);
+// type names are case-insensitive and whitespace around tokens is ignored.
+func ParseSchema(def string) (column.Schema, error) {
+	var schema column.Schema
+	for i, part := range strings.Split(def, ",") {
+		fields := strings.Fields(part)
+		if len(fields) < 2 {
+			return nil, fmt.Errorf("ParseSchema: column %d %q: want a name and a type", i+1, strings.TrimSpace(part))
+		}
+		name := fields[0]
+		col := column.Column{Name: name}
+		spec := strings.ToUpper(strings.Join(fields[1:], ""))
+		switch {
+		case spec == "INT":
+			col.Type = column.ColumnTypeInt
+		case spec == "INT32":
+			col.Type = column.ColumnTypeInt32
+		case strings.HasPrefix(spec, "TEXT(") && strings.HasSuffix(spec, ")"):
+			n, err := strconv.ParseUint(spec[len("TEXT("):len(spec)-1], 10, 32)
+			if err != nil || n == 0 {
+				return nil, fmt.Errorf("ParseSchema: column %q: TEXT length must be a positive integer", name)
+			}
+			col.Type = column.ColumnTypeText
+			col.MaxLength = uint32(n)
+		default:
+			return nil, fmt.Errorf("ParseSchema: column %q: unknown type %q", name, spec)
+		}
+		schema = append(schema, col)
+	}
+	return schema, nil
+}
@@ -0,0 +1,75 @@
+package table
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"vqlite/pager"
+)
+
+// nodeTypeLeafSeparated marks a leaf that stores its keys and rows apart:
+//
+//	[ header | key 0 | key 1 | ... | free space | row 0 | row 1 | ... ]
+//
+// The keys sit back to back after the header and the rows fill the end of
+// the page, so a key-only scan reads one small region at the front. It holds
+// exactly as many cells as the fixed [ key | row ] layout.
+const nodeTypeLeafSeparated = 4
+
+// SetSeparateValues turns the separated key/row layout on or off for leaves
+// written from now on. Compact text and compression take precedence over it.
+// Pages already on disk keep their format; the type byte tells Load how to
+// read each one.
+func (t *BTree) SetSeparateValues(on bool) {
+	t.bTreeMeta.SeparateValues = on
+}
+
+// serializeSeparated writes the header, the keys and the rows in the
+// separated layout.
+func (n *LeafNode) serializeSeparated(p *pager.Page) error {
+	rowSize := int(n.bTreeMeta.TableMeta.RowSize)
+	rowsOff := pager.PageSize - len(n.cells)*rowSize
+	if headerSize+len(n.cells)*4 > rowsOff {
+		return fmt.Errorf("LeafNode.Serialize: %d cells do not fit in a page", len(n.cells))
+	}
+	if err := n.checkRows(); err != nil {
+		return err
+	}
+	n.header.writeTo(p.Data[:headerSize], nodeTypeLeafSeparated)
+	for i, c := range n.cells {
+		binary.LittleEndian.PutUint32(p.Data[headerSize+4*i:], c.Key)
+		row := p.Data[rowsOff+i*rowSize : rowsOff+(i+1)*rowSize]
+		if err := SerializeRow(n.bTreeMeta.TableMeta, c.Value, row); err != nil {
+			return fmt.Errorf("LeafNode.Serialize: %w", err)
+		}
+	}
+	// the gap between the keys and the rows is free space
+	clear(p.Data[headerSize+4*len(n.cells) : rowsOff])
+	p.Dirty = true
+	return nil
+}
+
+// separatedRowsOff returns where the rows of a separated leaf with numCells
+// cells start, or an error if that many cells cannot fit.
+func separatedRowsOff(numCells int, meta *TableMeta) (int, error) {
+	if fit := int(LeafMaxCells(meta.RowSize)); numCells > fit {
+		return 0, fmt.Errorf("page declares %d cells but at most %d fit", numCells, fit)
+	}
+	return pager.PageSize - numCells*int(meta.RowSize), nil
+}
+
+// joinSeparatedCells converts the cells of separated page p into the fixed
+// [ key | row ] layout of an uncompressed leaf.
+func joinSeparatedCells(p *pager.Page, numCells int, meta *TableMeta) ([]byte, error) {
+	rowsOff, err := separatedRowsOff(numCells, meta)
+	if err != nil {
+		return nil, err
+	}
+	rowSize := int(meta.RowSize)
+	raw := make([]byte, 0, numCells*(4+rowSize))
+	for i := 0; i < numCells; i++ {
+		raw = append(raw, p.Data[headerSize+4*i:headerSize+4*i+4]...)
+		raw = append(raw, p.Data[rowsOff+i*rowSize:rowsOff+(i+1)*rowSize]...)
+	}
+	return raw, nil
+}
@@ -0,0 +1,56 @@
+package table
+
+// SplitPolicy decides where a full node is cut in two.
+type SplitPolicy int
+
+const (
+	// Balanced splits a node into two halves of equal size.
+	Balanced SplitPolicy = iota
+	// RightBiased keeps almost everything in the left node and starts a
+	// nearly empty right sibling. With ascending keys (e.g. auto-increment)
+	// the left nodes are never written again, so they stay densely packed.
+	RightBiased
+	// Adaptive picks per split from where the new key went: a node that
+	// overflowed by appending past its last key splits like RightBiased
+	// (SQLite's quick balance), any other like Balanced. Append-mostly
+	// workloads get dense nodes without giving up even splits elsewhere.
+	Adaptive
+)
+
+// SetSplitPolicy chooses how leaf and interior nodes split from now on.
+// Existing nodes are left as they are.
+func (t *BTree) SetSplitPolicy(p SplitPolicy) {
+	t.bTreeMeta.SplitPolicy = p
+}
+
+// rightBiased reports whether a node of n cells that overflowed with a new
+// cell at index at splits right-biased.
+func (m *BTreeMeta) rightBiased(n, at int) bool {
+	switch m.SplitPolicy {
+	case RightBiased:
+		return true
+	case Adaptive:
+		return at == n-1
+	default:
+		return false
+	}
+}
+
+// leafSplitPoint returns the index of the first of n cells that moves to the
+// new right leaf; at is the index of the cell just inserted.
+func (m *BTreeMeta) leafSplitPoint(n, at int) int {
+	if m.rightBiased(n, at) {
+		return n - 1
+	}
+	return n / 2
+}
+
+// interiorSplitPoint returns the index of the median among n interior cells;
+// cells before it stay left, cells after it move to the new right node. at
+// is the index of the cell just inserted.
+func (m *BTreeMeta) interiorSplitPoint(n, at int) int {
+	if m.rightBiased(n, at) {
+		return n - 2
+	}
+	return n / 2
+}
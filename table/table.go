@@ -11,6 +11,10 @@ type TableMeta struct {
 	NumCols int
 	Columns column.Schema
 	RowSize uint32
+
+	// TTLColumn optionally names an INT column holding each row's expiry as
+	// a Unix time in seconds; cursor scans and Search skip rows past it.
+	TTLColumn string
 }
 
 // Table is now a pure catalog entry, mirroring SQLite‘s design.  It carries
@@ -21,12 +25,6 @@ type Table struct {
 	Name     string
 	Meta     *TableMeta
 	RootPage uint32
-
-	// NOTE: The fields below remain temporarily so existing helper functions
-	// compile until we finish migrating InsertRow/GetRow to the B-tree layer.
-	// They will be removed in a subsequent commit.
-	Pager   *pager.Pager // TODO: delete after migration
-	NumRows uint32       // cached only by old InsertRow implementation
 }
 
 // Legacy Cursor & flat-row access removed; iteration will be provided by the
@@ -36,7 +34,12 @@ func BuildTableMeta(schema column.Schema) (*TableMeta, error) {
 	var metas []column.Column
 	var offset uint32 = 0
 
+	seen := make(map[string]bool, len(schema))
 	for _, col := range schema {
+		if seen[col.Name] {
+			return nil, fmt.Errorf("duplicate column name %q", col.Name)
+		}
+		seen[col.Name] = true
 		switch col.Type {
 		case column.ColumnTypeInt:
 			metas = append(metas, column.Column{
@@ -48,6 +51,16 @@ func BuildTableMeta(schema column.Schema) (*TableMeta, error) {
 			})
 			offset += 4
 
+		case column.ColumnTypeInt32:
+			metas = append(metas, column.Column{
+				Name:      col.Name,
+				Type:      column.ColumnTypeInt32,
+				Offset:    offset,
+				ByteSize:  4,
+				MaxLength: 0,
+			})
+			offset += 4
+
 		case column.ColumnTypeText:
 			if col.MaxLength == 0 {
 				return nil, fmt.Errorf("TEXT column %q must have MaxLength>0", col.Name)
@@ -70,6 +83,9 @@ func BuildTableMeta(schema column.Schema) (*TableMeta, error) {
 	if totalSize == 0 {
 		return nil, errors.New("schema must have at least one column")
 	}
+	if err := checkRowFits(totalSize); err != nil {
+		return nil, err
+	}
 
 	return &TableMeta{
 		NumCols: len(schema),
@@ -78,24 +94,104 @@ func BuildTableMeta(schema column.Schema) (*TableMeta, error) {
 	}, nil
 }
 
-// OpenTable creates a Table backed by filename and computes NumRows = fileLength / PageSize.
-func OpenTable(filename string, schema column.Schema) (*Table, *pager.Pager, error) {
-	pg, err := pager.OpenPager(filename)
+// RowSizeBytes returns the number of bytes one serialized row takes.
+func (m *TableMeta) RowSizeBytes() uint32 {
+	return m.RowSize
+}
+
+// LeafCellSize returns the bytes one leaf cell takes: the key plus the row.
+func (m *TableMeta) LeafCellSize() uint32 {
+	return LeafCellSize(m.RowSize)
+}
+
+// RowsPerPage returns how many rows an uncompressed leaf holds before it
+// splits: as many cells as fit in the page, but at most maxCells.
+func (m *TableMeta) RowsPerPage() int {
+	return min(maxCells, int(LeafMaxCells(m.RowSize)))
+}
+
+// minLeafCells returns how many cells a non-root leaf needs not to count as
+// under-full: half of RowsPerPage, rounded down.
+func (m *TableMeta) minLeafCells() int {
+	return m.RowsPerPage() / 2
+}
+
+// checkRowFits rejects a row size for which not even a single cell fits in a
+// leaf page.
+func checkRowFits(rowSize uint32) error {
+	if LeafCellSize(rowSize) > LeafSpaceForCells() {
+		return fmt.Errorf("row size %d bytes too large: a leaf cell needs %d bytes but a page holds at most %d",
+			rowSize, LeafCellSize(rowSize), LeafSpaceForCells())
+	}
+	return nil
+}
+
+// OpenTable opens (or creates) the B-tree stored in filename and returns its
+// catalog entry together with the tree. RootPage is the root at open time;
+// row counts come from BTree.NumRows. Closing the tree closes the file.
+func OpenTable(filename string, schema column.Schema) (*Table, *BTree, error) {
+	meta, err := BuildTableMeta(schema)
 	if err != nil {
 		return nil, nil, err
 	}
-	meta, err := BuildTableMeta(schema)
+	pg, err := pager.OpenPager(filename)
 	if err != nil {
 		return nil, nil, err
 	}
-	numRows := uint32(pg.NumPages*pager.PageSize) / meta.RowSize
+	bt, err := NewBTree(pg, meta)
+	if err != nil {
+		pg.Close()
+		return nil, nil, fmt.Errorf("failed to open table: %w", err)
+	}
 	return &Table{
 		Name:     filename, // Assuming filename is the table name for now
 		Meta:     meta,
-		RootPage: 0, // Placeholder, will be updated by BTree
-		Pager:    pg,
-		NumRows:  numRows,
-	}, pg, nil
+		RootPage: bt.rootPage,
+	}, bt, nil
+}
+
+// OpenWithVerify opens filename like OpenTable and then runs a quick sanity
+// check on the tree: the root page must be in the file and the root, first
+// and last leaves must load cleanly. It is far cheaper than walking the
+// whole tree, and catches a damaged root at open time rather than at the
+// first query.
+func OpenWithVerify(filename string, schema column.Schema) (*Table, *BTree, error) {
+	tbl, bt, err := OpenTable(filename, schema)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := bt.quickCheck(); err != nil {
+		bt.bTreeMeta.Pager.Close()
+		return nil, nil, fmt.Errorf("failed to verify table: %w", err)
+	}
+	return tbl, bt, nil
+}
+
+// quickCheck loads the root and the nodes on the leftmost and rightmost
+// paths down to the first and last leaves.
+func (t *BTree) quickCheck() error {
+	if n := t.bTreeMeta.Pager.NumPages; t.rootPage == metaPageNum || int(t.rootPage) >= n {
+		return fmt.Errorf("root page %d out of bounds for a file of %d pages", t.rootPage, n)
+	}
+	for _, last := range []bool{false, true} {
+		pgno := t.rootPage
+		for {
+			node, err := t.loadNode(pgno)
+			if err != nil {
+				return fmt.Errorf("load page %d: %w", pgno, err)
+			}
+			if node.IsLeaf() {
+				break
+			}
+			in := node.(*InteriorNode)
+			if last {
+				pgno = in.child(in.numChildren() - 1)
+			} else {
+				pgno = in.child(0)
+			}
+		}
+	}
+	return nil
 }
 
 // All row-level operations (insert/search/scan) have moved to the B-tree layer.
@@ -2,8 +2,14 @@ package table
 
 import (
 	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
 	"os"
+	"path/filepath"
 	"reflect"
+	"slices"
+	"strings"
 	"testing"
 	"vqlite/column"
 	"vqlite/pager"
@@ -75,6 +81,106 @@ func TestSerializeDeserializeRow(t *testing.T) {
 	}
 }
 
+// trimTextOld is how DeserializeRow used to decode a TEXT field: convert the
+// whole padded field, then trim the NUL padding off the string.
+func trimTextOld(raw []byte) string {
+	return strings.TrimRight(string(raw), "\x00")
+}
+
+// TestDeserializeRow_TextMatchesOldTrim checks TEXT values decode exactly as
+// they did before the trimming moved ahead of the string conversion,
+// including values with NULs inside or at the end.
+func TestDeserializeRow_TextMatchesOldTrim(t *testing.T) {
+	meta, _ := BuildTableMeta(column.Schema{
+		{Name: "id", Type: column.ColumnTypeInt},
+		{Name: "text", Type: column.ColumnTypeText, MaxLength: 8},
+	})
+	for _, field := range []string{
+		"", "a", "hello", "12345678", "a\x00b", "\x00\x00ab", "ab\x00", "\x00", "a\x00\x00\x00\x00\x00\x00b",
+	} {
+		buf := make([]byte, meta.RowSize)
+		copy(buf[4:], field)
+		row, err := DeserializeRow(meta, buf)
+		if err != nil {
+			t.Fatalf("DeserializeRow(%q): %v", field, err)
+		}
+		if want := trimTextOld(buf[4:]); row[1] != want {
+			t.Errorf("field %q decoded as %q; want %q", field, row[1], want)
+		}
+	}
+}
+
+// BenchmarkDeserializeRow_Text compares the old TEXT decoding with the
+// current one over a table of wide, mostly empty TEXT columns.
+func BenchmarkDeserializeRow_Text(b *testing.B) {
+	schema := column.Schema{{Name: "id", Type: column.ColumnTypeInt}}
+	for i := 0; i < 8; i++ {
+		schema = append(schema, column.Column{Name: fmt.Sprint("t", i), Type: column.ColumnTypeText, MaxLength: 255})
+	}
+	meta, _ := BuildTableMeta(schema)
+	row := Row{uint32(1)}
+	for i := 0; i < 8; i++ {
+		row = append(row, fmt.Sprint("value-", i))
+	}
+	buf := make([]byte, meta.RowSize)
+	if err := SerializeRow(meta, row, buf); err != nil {
+		b.Fatalf("SerializeRow: %v", err)
+	}
+
+	b.Run("old", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			out := make(Row, meta.NumCols)
+			out[0] = binary.LittleEndian.Uint32(buf)
+			for j, c := range meta.Columns[1:] {
+				out[j+1] = trimTextOld(buf[c.Offset : c.Offset+c.ByteSize])
+			}
+		}
+	})
+	b.Run("new", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			DeserializeRow(meta, buf)
+		}
+	})
+}
+
+func TestSerializeDeserializeRow_Int32(t *testing.T) {
+	schema := column.Schema{
+		{Name: "id", Type: column.ColumnTypeInt},
+		{Name: "delta", Type: column.ColumnTypeInt32},
+	}
+	meta, err := BuildTableMeta(schema)
+	if err != nil {
+		t.Fatalf("BuildTableMeta: %v", err)
+	}
+	if meta.Columns[1].ByteSize != 4 || meta.RowSize != 8 {
+		t.Fatalf("int32 column ByteSize=%d RowSize=%d; want 4, 8", meta.Columns[1].ByteSize, meta.RowSize)
+	}
+
+	for _, v := range []int32{-1, math.MinInt32, math.MaxInt32, 0} {
+		orig := Row{uint32(1), v}
+		buf := make([]byte, meta.RowSize)
+		if err := SerializeRow(meta, orig, buf); err != nil {
+			t.Fatalf("SerializeRow(%d): %v", v, err)
+		}
+		if got := binary.LittleEndian.Uint32(buf[4:8]); got != uint32(v) {
+			t.Errorf("%d stored as 0x%x; want 0x%x", v, got, uint32(v))
+		}
+		row, err := DeserializeRow(meta, buf)
+		if err != nil {
+			t.Fatalf("DeserializeRow(%d): %v", v, err)
+		}
+		if !reflect.DeepEqual(orig, row) {
+			t.Errorf("Roundtrip mismatch: got %+v; want %+v", row, orig)
+		}
+	}
+
+	if err := SerializeRow(meta, Row{uint32(1), uint32(5)}, make([]byte, meta.RowSize)); err == nil {
+		t.Error("SerializeRow accepted a uint32 for an int32 column")
+	}
+}
+
 func TestInsertGetRow_FileBacked(t *testing.T) {
 	dbFile := newTempDB(t)
 	defer os.Remove(dbFile)
@@ -128,3 +234,721 @@ func TestInsertGetRow_FileBacked(t *testing.T) {
 		}
 	}
 }
+
+func TestRowEqualDiff(t *testing.T) {
+	schema := column.Schema{
+		{Name: "id", Type: column.ColumnTypeInt},
+		{Name: "username", Type: column.ColumnTypeText, MaxLength: 16},
+		{Name: "email", Type: column.ColumnTypeText, MaxLength: 32},
+	}
+	meta, _ := BuildTableMeta(schema)
+
+	a := Row{uint32(1), "alice", "alice@example.com"}
+	b := Row{uint32(1), "alice", "alice@example.org"}
+
+	if !a.Equal(Row{uint32(1), "alice", "alice@example.com"}) {
+		t.Errorf("Equal = false for identical rows")
+	}
+	if a.Equal(b) {
+		t.Errorf("Equal = true for rows differing in email")
+	}
+	if a.Equal(Row{uint32(1), "alice"}) {
+		t.Errorf("Equal = true for rows of different length")
+	}
+
+	if d := a.Diff(a, meta); d != "" {
+		t.Errorf("Diff of equal rows = %q; want empty", d)
+	}
+	want := `column "email": alice@example.com (string) != alice@example.org (string)`
+	if d := a.Diff(b, meta); d != want {
+		t.Errorf("Diff = %q; want %q", d, want)
+	}
+}
+
+func TestBuildTableMeta_RowTooLargeForPage(t *testing.T) {
+	schema := column.Schema{
+		{Name: "id", Type: column.ColumnTypeInt},
+		{Name: "blob", Type: column.ColumnTypeText, MaxLength: 5000},
+	}
+	if _, err := BuildTableMeta(schema); err == nil {
+		t.Fatalf("BuildTableMeta accepted a 5004-byte row")
+	} else if !strings.Contains(err.Error(), "5004") {
+		t.Errorf("error %q does not name the row size", err)
+	} else if limit := fmt.Sprint(LeafSpaceForCells()); !strings.Contains(err.Error(), limit) {
+		t.Errorf("error %q does not name the %s-byte limit", err, limit)
+	}
+
+	// a hand-built meta bypassing BuildTableMeta is rejected by NewBTree
+	pg, err := pager.OpenPager(newTempDB(t))
+	if err != nil {
+		t.Fatalf("OpenPager: %v", err)
+	}
+	defer os.Remove(pg.File.Name())
+	defer pg.Close()
+	if _, err := NewBTree(pg, &TableMeta{NumCols: 1, RowSize: 5004}); err == nil {
+		t.Errorf("NewBTree accepted a 5004-byte row")
+	}
+
+	// the largest row that still fits one cell is accepted
+	maxText := LeafSpaceForCells() - LeafNodeKeySize - 4
+	schema[1].MaxLength = maxText
+	if _, err := BuildTableMeta(schema); err != nil {
+		t.Errorf("BuildTableMeta rejected a row that fits: %v", err)
+	}
+}
+
+// TestBuildTableMeta_DuplicateColumnName checks a schema naming a column
+// twice is rejected with an error naming it.
+func TestBuildTableMeta_DuplicateColumnName(t *testing.T) {
+	schema := column.Schema{
+		{Name: "id", Type: column.ColumnTypeInt},
+		{Name: "name", Type: column.ColumnTypeText, MaxLength: 16},
+		{Name: "id", Type: column.ColumnTypeInt32},
+	}
+	_, err := BuildTableMeta(schema)
+	if err == nil {
+		t.Fatal("BuildTableMeta accepted two columns named id")
+	}
+	if want := `duplicate column name "id"`; err.Error() != want {
+		t.Errorf("error = %q; want %q", err, want)
+	}
+}
+
+// TestRowsPerPage_MatchesLeafCapacity fills a leaf until it splits and checks
+// that it held exactly RowsPerPage rows, for narrow and wide rows.
+func TestRowsPerPage_MatchesLeafCapacity(t *testing.T) {
+	for _, width := range []uint32{8, 300, 500, 1000} {
+		schema := column.Schema{
+			{Name: "id", Type: column.ColumnTypeInt},
+			{Name: "body", Type: column.ColumnTypeText, MaxLength: width},
+		}
+		meta, err := BuildTableMeta(schema)
+		if err != nil {
+			t.Fatalf("BuildTableMeta(%d): %v", width, err)
+		}
+		if meta.RowSizeBytes() != 4+width || meta.LeafCellSize() != 8+width {
+			t.Errorf("width %d: RowSizeBytes=%d LeafCellSize=%d", width, meta.RowSizeBytes(), meta.LeafCellSize())
+		}
+		if uint32(meta.RowsPerPage())*meta.LeafCellSize() > LeafSpaceForCells() {
+			t.Errorf("width %d: %d rows of %d bytes overflow a page", width, meta.RowsPerPage(), meta.LeafCellSize())
+		}
+
+		tp := newTempPager(t)
+		leaf, err := NewLeafNode(&BTreeMeta{Pager: tp.Pager, TableMeta: meta}, true)
+		if err != nil {
+			t.Fatalf("NewLeafNode: %v", err)
+		}
+		held := 0
+		for k := uint32(0); ; k++ {
+			if _, _, split := leaf.Insert(k, Row{k, "x"}); split {
+				break
+			}
+			held++
+		}
+		tp.cleanup()
+		if held != meta.RowsPerPage() {
+			t.Errorf("width %d: leaf held %d rows before splitting; RowsPerPage = %d", width, held, meta.RowsPerPage())
+		}
+	}
+}
+
+// TestCollation_NoCase sorts mixed-case keys and checks that NoCase keeps the
+// spellings of one word together while Binary separates them.
+func TestCollation_NoCase(t *testing.T) {
+	keys := []string{"banana", "Apple", "cherry", "apple", "Banana", "APPLE"}
+
+	sorted := slices.Clone(keys)
+	slices.SortStableFunc(sorted, NoCase.Compare)
+	want := []string{"Apple", "apple", "APPLE", "banana", "Banana", "cherry"}
+	if !reflect.DeepEqual(sorted, want) {
+		t.Fatalf("NoCase order = %v; want %v", sorted, want)
+	}
+
+	slices.SortStableFunc(sorted, Binary.Compare)
+	if sorted[0] != "APPLE" || sorted[len(sorted)-1] != "cherry" || sorted[2] != "Banana" {
+		t.Fatalf("Binary order = %v; want uppercase first", sorted)
+	}
+
+	// a NoCase lookup finds the key whatever case it was written in
+	for _, probe := range []string{"apple", "APPLE", "aPpLe"} {
+		i := slices.IndexFunc(want, func(k string) bool { return NoCase.Equal(k, probe) })
+		if i < 0 {
+			t.Errorf("NoCase lookup of %q found nothing", probe)
+		}
+	}
+	if Binary.Equal("Apple", "apple") {
+		t.Error("Binary treats Apple and apple as equal")
+	}
+}
+
+// TestCollation_NoCaseCompareMatchesEqual checks NoCase.Compare returns 0
+// exactly for the pairs NoCase.Equal accepts, Unicode folds such as the
+// Kelvin sign and the long s included, and is antisymmetric.
+func TestCollation_NoCaseCompareMatchesEqual(t *testing.T) {
+	words := []string{
+		"", "a", "A", "a_", "aB", "ab", "abc",
+		"kelvin", "KELVIN", "\u212aelvin", "Kelvin",
+		"sun", "\u017fun", "SUN", "straße", "STRASSE",
+		"\u00b5", "\u03bc", "\u039c", "zeta", "\xff", "\xfe",
+	}
+	for _, a := range words {
+		for _, b := range words {
+			c := NoCase.Compare(a, b)
+			if (c == 0) != NoCase.Equal(a, b) {
+				t.Errorf("NoCase.Compare(%q, %q) = %d but Equal = %v", a, b, c, NoCase.Equal(a, b))
+			}
+			if NoCase.Compare(b, a) != -c {
+				t.Errorf("NoCase.Compare(%q, %q) = %d, reversed %d", a, b, c, NoCase.Compare(b, a))
+			}
+		}
+	}
+	if NoCase.Compare("a_", "aB") >= 0 {
+		t.Error(`NoCase sorts "a_" after "aB"; want letters ordered as lower case`)
+	}
+	if NoCase.Compare("apple", "Banana") >= 0 {
+		t.Error(`NoCase sorts "apple" after "Banana"`)
+	}
+}
+
+// TestOpenTable_ReopensBTree inserts through a table opened with OpenTable,
+// closes it, and checks a second OpenTable sees the same root and rows.
+func TestOpenTable_ReopensBTree(t *testing.T) {
+	dbFile := newTempDB(t)
+	defer os.Remove(dbFile)
+	schema := column.Schema{
+		{Name: "id", Type: column.ColumnTypeInt},
+		{Name: "name", Type: column.ColumnTypeText, MaxLength: 16},
+	}
+
+	tbl, bt, err := OpenTable(dbFile, schema)
+	if err != nil {
+		t.Fatalf("OpenTable: %v", err)
+	}
+	for i := uint32(1); i <= 40; i++ {
+		if err := bt.Insert(i, Row{i, "row"}); err != nil {
+			t.Fatalf("insert %d: %v", i, err)
+		}
+	}
+	if tbl.RootPage != 1 {
+		t.Errorf("fresh table RootPage = %d; want 1", tbl.RootPage)
+	}
+	root := bt.rootPage
+	if err := bt.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	tbl, bt, err = OpenTable(dbFile, schema)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	defer bt.Close()
+	if tbl.RootPage != root {
+		t.Errorf("reopened RootPage = %d; want %d", tbl.RootPage, root)
+	}
+	if n, err := bt.NumRows(); err != nil || n != 40 {
+		t.Errorf("NumRows = %d, %v; want 40, nil", n, err)
+	}
+	rows, err := bt.AllRows()
+	if err != nil || len(rows) != 40 || rows[39]["id"] != uint32(40) {
+		t.Errorf("AllRows returned %d rows, err %v; want 40 ending at id 40", len(rows), err)
+	}
+}
+
+// TestBackup_CopyOpensIndependently backs up a populated tree, keeps writing
+// to the original, and checks the backup holds exactly the rows at backup
+// time when opened on its own.
+func TestBackup_CopyOpensIndependently(t *testing.T) {
+	dir := t.TempDir()
+	schema := column.Schema{
+		{Name: "id", Type: column.ColumnTypeInt},
+		{Name: "name", Type: column.ColumnTypeText, MaxLength: 16},
+	}
+	_, bt, err := OpenTable(filepath.Join(dir, "src.db"), schema)
+	if err != nil {
+		t.Fatalf("OpenTable: %v", err)
+	}
+	defer bt.Close()
+	bt.SetDeferredFlush(true)
+	for i := uint32(1); i <= 60; i++ {
+		if err := bt.Insert(i, Row{i, fmt.Sprintf("user%d", i)}); err != nil {
+			t.Fatalf("insert %d: %v", i, err)
+		}
+	}
+	for i := uint32(10); i < 20; i++ {
+		if _, err := bt.Delete(i); err != nil {
+			t.Fatalf("delete %d: %v", i, err)
+		}
+	}
+	want, err := bt.AllRows()
+	if err != nil {
+		t.Fatalf("AllRows: %v", err)
+	}
+
+	dst := filepath.Join(dir, "backup.db")
+	if err := bt.Backup(dst); err != nil {
+		t.Fatalf("Backup: %v", err)
+	}
+	if err := bt.Insert(100, Row{uint32(100), "later"}); err != nil {
+		t.Fatalf("insert after backup: %v", err)
+	}
+
+	_, copyTree, err := OpenTable(dst, schema)
+	if err != nil {
+		t.Fatalf("open backup: %v", err)
+	}
+	defer copyTree.Close()
+	got, err := copyTree.AllRows()
+	if err != nil {
+		t.Fatalf("backup AllRows: %v", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("backup holds %d rows; want the %d rows at backup time", len(got), len(want))
+	}
+	if n, _ := copyTree.NumRows(); n != uint32(len(want)) {
+		t.Errorf("backup NumRows = %d; want %d", n, len(want))
+	}
+}
+
+// TestKeyCodecs checks that the uint32 codec orders encodings exactly as the
+// tree compares keys, and that the uint64 codec sorts wide keys numerically.
+func TestKeyCodecs(t *testing.T) {
+	var k32 Uint32Keys
+	keys := []uint32{0, 1, 255, 256, 65535, 65536, 1 << 24, math.MaxUint32 - 1, math.MaxUint32}
+	for _, a := range keys {
+		if got := k32.Decode(k32.Encode(a)); got != a {
+			t.Errorf("Uint32Keys round trip of %d = %d", a, got)
+		}
+		for _, b := range keys {
+			if got, want := k32.Compare(k32.Encode(a), k32.Encode(b)), compareKeys(a, b); got != want {
+				t.Errorf("Uint32Keys.Compare(%d, %d) = %d; compareKeys = %d", a, b, got, want)
+			}
+		}
+	}
+
+	var k64 Uint64Keys
+	wide := []uint64{math.MaxUint64, 1 << 32, 0, math.MaxUint32, 1 << 40, 7}
+	enc := make([][]byte, len(wide))
+	for i, k := range wide {
+		enc[i] = k64.Encode(k)
+		if len(enc[i]) != k64.Size() {
+			t.Fatalf("Uint64Keys encoding of %d is %d bytes; want %d", k, len(enc[i]), k64.Size())
+		}
+	}
+	slices.SortFunc(enc, k64.Compare)
+	var got []uint64
+	for _, b := range enc {
+		got = append(got, k64.Decode(b))
+	}
+	want := []uint64{0, 7, math.MaxUint32, 1 << 32, 1 << 40, math.MaxUint64}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Uint64Keys order = %v; want %v", got, want)
+	}
+}
+
+// TestInspectFile_RecoversSchema stores the schema in a new database, fills
+// and closes it, then checks InspectFile decodes the same columns and stats
+// without being given the schema.
+func TestInspectFile_RecoversSchema(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "self.db")
+	schema := column.Schema{
+		{Name: "id", Type: column.ColumnTypeInt},
+		{Name: "username", Type: column.ColumnTypeText, MaxLength: 32},
+		{Name: "balance", Type: column.ColumnTypeInt32},
+	}
+	_, bt, err := OpenTable(path, schema)
+	if err != nil {
+		t.Fatalf("OpenTable: %v", err)
+	}
+	if err := bt.StoreSchema(); err != nil {
+		t.Fatalf("StoreSchema: %v", err)
+	}
+	for i := uint32(1); i <= 50; i++ {
+		if err := bt.Insert(i, Row{i, "user", int32(i) - 25}); err != nil {
+			t.Fatalf("insert %d: %v", i, err)
+		}
+	}
+	if err := bt.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	info, err := InspectFile(path)
+	if err != nil {
+		t.Fatalf("InspectFile: %v", err)
+	}
+	if info.Version != FormatVersion {
+		t.Errorf("Version = %d; want %d", info.Version, FormatVersion)
+	}
+	if len(info.Schema) != len(schema) {
+		t.Fatalf("schema has %d columns; want %d", len(info.Schema), len(schema))
+	}
+	for i, c := range schema {
+		got := info.Schema[i]
+		if got.Name != c.Name || got.Type != c.Type || got.MaxLength != c.MaxLength {
+			t.Errorf("column %d = %+v; want %+v", i, got, c)
+		}
+	}
+	if info.NumRows != 50 || info.Height < 2 {
+		t.Errorf("stats = %d rows, height %d; want 50 rows over several levels", info.NumRows, info.Height)
+	}
+
+	plain := filepath.Join(t.TempDir(), "plain.db")
+	_, bt, err = OpenTable(plain, schema)
+	if err != nil {
+		t.Fatalf("OpenTable: %v", err)
+	}
+	bt.Close()
+	if _, err := InspectFile(plain); !errors.Is(err, ErrNoSchema) {
+		t.Errorf("InspectFile without stored schema err = %v; want ErrNoSchema", err)
+	}
+}
+
+// TestOpenWithVerify_RejectsBadRoot points the meta root past the end of the
+// file and then at a page that holds no node. The verified open fails for
+// both, while a plain open of the second only fails once the tree is read.
+func TestOpenWithVerify_RejectsBadRoot(t *testing.T) {
+	dbFile := newTempDB(t)
+	defer os.Remove(dbFile)
+	schema := column.Schema{{Name: "id", Type: column.ColumnTypeInt}}
+	_, bt, err := OpenTable(dbFile, schema)
+	if err != nil {
+		t.Fatalf("OpenTable: %v", err)
+	}
+	for i := uint32(1); i <= 40; i++ {
+		if err := bt.Insert(i, Row{i}); err != nil {
+			t.Fatalf("insert %d: %v", i, err)
+		}
+	}
+	if err := bt.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if _, bt, err = OpenWithVerify(dbFile, schema); err != nil {
+		t.Fatalf("OpenWithVerify on a sound file: %v", err)
+	}
+	numPages := bt.bTreeMeta.Pager.NumPages
+	bt.Close()
+
+	setRoot := func(root uint32, junkPage bool) {
+		f, err := os.OpenFile(dbFile, os.O_RDWR, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		if junkPage {
+			if _, err := f.WriteAt([]byte{0x7}, int64(root)*pager.PageSize); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if _, err := f.WriteAt(binary.LittleEndian.AppendUint32(nil, root), metaRootOff); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	setRoot(uint32(numPages+5), false)
+	if _, _, err := OpenWithVerify(dbFile, schema); err == nil {
+		t.Error("OpenWithVerify with an out-of-range root succeeded")
+	}
+
+	setRoot(uint32(numPages-1), true)
+	_, bt, err = OpenTable(dbFile, schema)
+	if err != nil {
+		t.Fatalf("plain OpenTable with a junk root: %v; want the error deferred", err)
+	}
+	if _, _, _, err := bt.First(); err == nil {
+		t.Error("First on a junk root succeeded")
+	}
+	bt.bTreeMeta.Pager.Close()
+	if _, _, err := OpenWithVerify(dbFile, schema); err == nil {
+		t.Error("OpenWithVerify with a junk root succeeded")
+	}
+}
+
+// TestEmptyText_RoundTrips stores "" in a TEXT column next to non-empty
+// values and checks it comes back as "" from SerializeRow/DeserializeRow and
+// from the tree, in the plain and the compact text layout.
+func TestEmptyText_RoundTrips(t *testing.T) {
+	schema := column.Schema{
+		{Name: "id", Type: column.ColumnTypeInt},
+		{Name: "a", Type: column.ColumnTypeText, MaxLength: 8},
+		{Name: "b", Type: column.ColumnTypeText, MaxLength: 8},
+	}
+	meta, _ := BuildTableMeta(schema)
+	buf := make([]byte, meta.RowSize)
+	if err := SerializeRow(meta, Row{uint32(1), "", "x"}, buf); err != nil {
+		t.Fatalf("SerializeRow: %v", err)
+	}
+	if got, err := DeserializeRow(meta, buf); err != nil || !got.Equal(Row{uint32(1), "", "x"}) {
+		t.Errorf("DeserializeRow = %v, %v; want [1  x]", got, err)
+	}
+
+	for _, compact := range []bool{false, true} {
+		path := filepath.Join(t.TempDir(), "empty.db")
+		_, bt, err := OpenTable(path, schema)
+		if err != nil {
+			t.Fatalf("OpenTable: %v", err)
+		}
+		bt.SetCompactText(compact)
+		rows := []Row{{uint32(1), "", ""}, {uint32(2), "", "b"}, {uint32(3), "a", ""}}
+		for _, r := range rows {
+			if err := bt.Insert(r[0].(uint32), r); err != nil {
+				t.Fatalf("insert %v: %v", r, err)
+			}
+		}
+		if err := bt.Close(); err != nil {
+			t.Fatalf("Close: %v", err)
+		}
+		_, bt, err = OpenTable(path, schema)
+		if err != nil {
+			t.Fatalf("reopen: %v", err)
+		}
+		bt.SetCompactText(compact)
+		for _, r := range rows {
+			got, found, err := bt.Search(r[0].(uint32))
+			if err != nil || !found || !got.Equal(r) {
+				t.Errorf("compact=%v: Search(%v) = %q, %v, %v; want %q", compact, r[0], got, found, err, r)
+			}
+		}
+		bt.Close()
+	}
+}
+
+// TestParseSchema parses the REPL's demo schema, with and without extra
+// whitespace, and rejects malformed column definitions.
+func TestParseSchema(t *testing.T) {
+	want := column.Schema{
+		{Name: "id", Type: column.ColumnTypeInt},
+		{Name: "username", Type: column.ColumnTypeText, MaxLength: 32},
+		{Name: "email", Type: column.ColumnTypeText, MaxLength: 64},
+		{Name: "age", Type: column.ColumnTypeInt},
+		{Name: "delta", Type: column.ColumnTypeInt32},
+	}
+	for _, def := range []string{
+		"id INT, username TEXT(32), email TEXT(64), age INT, delta INT32",
+		"  id\tint,username text( 32 ) ,\temail Text(64),age INT ,delta int32 ",
+	} {
+		got, err := ParseSchema(def)
+		if err != nil {
+			t.Fatalf("ParseSchema(%q): %v", def, err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("ParseSchema(%q) = %+v; want %+v", def, got, want)
+		}
+		if _, err := BuildTableMeta(got); err != nil {
+			t.Errorf("BuildTableMeta(%q): %v", def, err)
+		}
+	}
+	for _, def := range []string{
+		"",
+		"id",
+		"id INT,",
+		"id FLOAT",
+		"name TEXT",
+		"name TEXT()",
+		"name TEXT(0)",
+		"name TEXT(-3)",
+		"name TEXT(abc)",
+		"name TEXT(12",
+	} {
+		if _, err := ParseSchema(def); err == nil {
+			t.Errorf("ParseSchema(%q) succeeded; want an error", def)
+		}
+	}
+}
+
+// TestUserVersion_SurvivesReopen checks a new file reports user version 0
+// and a version set before closing is read back after reopening.
+func TestUserVersion_SurvivesReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "version.db")
+	schema := column.Schema{{Name: "id", Type: column.ColumnTypeInt}}
+	_, bt, err := OpenTable(path, schema)
+	if err != nil {
+		t.Fatalf("OpenTable: %v", err)
+	}
+	if v, err := bt.GetUserVersion(); err != nil || v != 0 {
+		t.Errorf("GetUserVersion of a new file = %d, %v; want 0, nil", v, err)
+	}
+	if err := bt.Insert(1, Row{uint32(1)}); err != nil {
+		t.Fatalf("Insert: %v", err)
+	}
+	if err := bt.SetUserVersion(7); err != nil {
+		t.Fatalf("SetUserVersion: %v", err)
+	}
+	if err := bt.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	_, bt, err = OpenTable(path, schema)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	defer bt.Close()
+	if v, err := bt.GetUserVersion(); err != nil || v != 7 {
+		t.Errorf("GetUserVersion after reopen = %d, %v; want 7, nil", v, err)
+	}
+	if rows, err := bt.NumRows(); err != nil || rows != 1 {
+		t.Errorf("NumRows after reopen = %d, %v; want 1, nil", rows, err)
+	}
+}
+
+// TestSortRows_MultipleColumns sorts rows that tie on age and checks the
+// second key, email descending, orders each tie while rows equal on both
+// keep their input order.
+func TestSortRows_MultipleColumns(t *testing.T) {
+	schema := column.Schema{
+		{Name: "id", Type: column.ColumnTypeInt},
+		{Name: "age", Type: column.ColumnTypeInt32},
+		{Name: "email", Type: column.ColumnTypeText, MaxLength: 16},
+	}
+	rows := []Row{
+		{uint32(1), int32(30), "a@x"},
+		{uint32(2), int32(25), "b@x"},
+		{uint32(3), int32(30), "c@x"},
+		{uint32(4), int32(-5), "d@x"},
+		{uint32(5), int32(25), "b@x"},
+		{uint32(6), int32(30), "b@x"},
+	}
+	keys, err := ParseOrderBy("age asc, email DESC")
+	if err != nil {
+		t.Fatalf("ParseOrderBy: %v", err)
+	}
+	if err := SortRows(schema, rows, keys); err != nil {
+		t.Fatalf("SortRows: %v", err)
+	}
+	var ids []uint32
+	for _, r := range rows {
+		ids = append(ids, r[0].(uint32))
+	}
+	if want := []uint32{4, 2, 5, 3, 6, 1}; !slices.Equal(ids, want) {
+		t.Errorf("sorted ids = %v; want %v", ids, want)
+	}
+
+	for _, clause := range []string{"age sideways", "", "age asc desc"} {
+		if _, err := ParseOrderBy(clause); err == nil {
+			t.Errorf("ParseOrderBy(%q) succeeded; want an error", clause)
+		}
+	}
+	if err := SortRows(schema, rows, []SortKey{{Column: "name"}}); err == nil {
+		t.Error("SortRows on an unknown column succeeded; want an error")
+	}
+}
+
+// TestSortRows_NullOrdering sorts a mix of NULL (nil) and non-NULL ages in
+// both directions with each NullOrder and checks where the NULLs land; the
+// id column breaks ties so every ordering is exact.
+func TestSortRows_NullOrdering(t *testing.T) {
+	schema := column.Schema{
+		{Name: "id", Type: column.ColumnTypeInt},
+		{Name: "age", Type: column.ColumnTypeInt32},
+	}
+	input := []Row{
+		{uint32(1), int32(30)},
+		{uint32(2), nil},
+		{uint32(3), int32(-4)},
+		{uint32(4), nil},
+		{uint32(5), int32(12)},
+	}
+	for _, tc := range []struct {
+		clause string
+		want   []uint32
+	}{
+		{"age, id", []uint32{2, 4, 3, 5, 1}},
+		{"age desc, id", []uint32{1, 5, 3, 2, 4}},
+		{"age asc nulls first, id", []uint32{2, 4, 3, 5, 1}},
+		{"age asc nulls last, id", []uint32{3, 5, 1, 2, 4}},
+		{"age desc nulls first, id", []uint32{2, 4, 1, 5, 3}},
+		{"age DESC NULLS LAST, id desc", []uint32{1, 5, 3, 4, 2}},
+	} {
+		keys, err := ParseOrderBy(tc.clause)
+		if err != nil {
+			t.Fatalf("ParseOrderBy(%q): %v", tc.clause, err)
+		}
+		rows := slices.Clone(input)
+		if err := SortRows(schema, rows, keys); err != nil {
+			t.Fatalf("SortRows(%q): %v", tc.clause, err)
+		}
+		var ids []uint32
+		for _, r := range rows {
+			ids = append(ids, r[0].(uint32))
+		}
+		if !slices.Equal(ids, tc.want) {
+			t.Errorf("order by %s: ids = %v; want %v", tc.clause, ids, tc.want)
+		}
+	}
+	if _, err := ParseOrderBy("age nulls middle"); err == nil {
+		t.Error(`ParseOrderBy("age nulls middle") succeeded; want an error`)
+	}
+}
+
+// TestRecoverDatabase_RepairsAndValidates breaks a leaf's next pointer and
+// leaves a partial page at the end of the file, then checks RecoverDatabase
+// reports and repairs both so every row scans again. Recovering a file whose
+// leaf keys are out of order must report the tree invalid.
+func TestRecoverDatabase_RepairsAndValidates(t *testing.T) {
+	dbFile := newTempDB(t)
+	defer os.Remove(dbFile)
+	schema := column.Schema{{Name: "id", Type: column.ColumnTypeInt}}
+
+	_, bt, err := OpenTable(dbFile, schema)
+	if err != nil {
+		t.Fatalf("OpenTable: %v", err)
+	}
+	const n = 80
+	for i := uint32(0); i < n; i++ {
+		if err := bt.Insert(i, Row{i}); err != nil {
+			t.Fatalf("insert %d: %v", i, err)
+		}
+	}
+	leaves := func() []*LeafNode {
+		var out []*LeafNode
+		bt.WalkPages(func(_ uint32, node BTreeNode) error {
+			if leaf, ok := node.(*LeafNode); ok {
+				out = append(out, leaf)
+			}
+			return nil
+		})
+		return out
+	}
+	broken := leaves()[1]
+	broken.header.rightPointer = 0
+	if err := bt.serializeNode(broken); err != nil {
+		t.Fatalf("serializeNode: %v", err)
+	}
+	if err := bt.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	f, _ := os.OpenFile(dbFile, os.O_WRONLY|os.O_APPEND, 0600)
+	f.Write(make([]byte, pager.PageSize/3))
+	f.Close()
+
+	report, err := RecoverDatabase(dbFile, schema)
+	if err != nil {
+		t.Fatalf("RecoverDatabase: %v", err)
+	}
+	if report.TruncatedBytes != pager.PageSize/3 || report.RelinkedLeaves != 1 || !report.Valid {
+		t.Errorf("report = %+v; want %d bytes truncated, 1 leaf relinked, valid", report, pager.PageSize/3)
+	}
+
+	_, bt, err = OpenTable(dbFile, schema)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	rows, err := bt.AllRows()
+	if err != nil || len(rows) != n {
+		t.Errorf("AllRows after recovery returned %d rows, err %v; want %d", len(rows), err, n)
+	}
+
+	leaf := leaves()[0]
+	leaf.cells[0].Key = leaf.cells[1].Key + 1
+	if err := bt.serializeNode(leaf); err != nil {
+		t.Fatalf("serializeNode: %v", err)
+	}
+	if err := bt.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	report, err = RecoverDatabase(dbFile, schema)
+	if err != nil {
+		t.Fatalf("second RecoverDatabase: %v", err)
+	}
+	if report.Valid || !errors.Is(report.Problem, ErrKeysOutOfOrder) {
+		t.Errorf("report = %+v; want invalid with ErrKeysOutOfOrder", report)
+	}
+}
@@ -0,0 +1,119 @@
+package table
+
+import (
+	"fmt"
+	"slices"
+	"time"
+
+	"vqlite/column"
+)
+
+// SetDeleteExpired makes cursor scans delete the expired rows they skip
+// instead of leaving them in place. It only matters for a table whose
+// TableMeta names a TTLColumn.
+func (t *BTree) SetDeleteExpired(on bool) {
+	t.bTreeMeta.DeleteExpired = on
+}
+
+// ttlIndex returns the index of the table's TTL column, or -1 when the table
+// has none.
+func (m *TableMeta) ttlIndex() int {
+	if m.TTLColumn == "" {
+		return -1
+	}
+	return slices.IndexFunc(m.Columns, func(c column.Column) bool { return c.Name == m.TTLColumn })
+}
+
+// checkTTLColumn rejects a TTLColumn that is not an INT column of the table.
+func (m *TableMeta) checkTTLColumn() error {
+	if m.TTLColumn == "" {
+		return nil
+	}
+	i := m.ttlIndex()
+	if i < 0 {
+		return fmt.Errorf("TTL column %q not in schema", m.TTLColumn)
+	}
+	if m.Columns[i].Type != column.ColumnTypeInt {
+		return fmt.Errorf("TTL column %q must be INT", m.TTLColumn)
+	}
+	return nil
+}
+
+// expired reports whether row's TTL column holds a Unix time in seconds at
+// or before now. A zero expiry never expires.
+func (m *TableMeta) expired(row Row, now time.Time) bool {
+	i := m.ttlIndex()
+	if i < 0 {
+		return false
+	}
+	return expiredValue(row[i], now)
+}
+
+// expiredValue is expired for v, the value of a TTL column on its own.
+func expiredValue(v any, now time.Time) bool {
+	exp, ok := v.(uint32)
+	return ok && exp != 0 && int64(exp) <= now.Unix()
+}
+
+// lastLive returns the index of the last of the first end cells of n whose
+// row has not expired, or -1 if there is none.
+func (n *LeafNode) lastLive(end int) int {
+	meta, now := n.bTreeMeta.TableMeta, time.Now()
+	for i := end - 1; i >= 0; i-- {
+		if !meta.expired(n.cells[i].Value, now) {
+			return i
+		}
+	}
+	return -1
+}
+
+// skipExpired steps the cursor past its current cell if that row has
+// expired, deleting it from the leaf when DeleteExpired is set. It reports
+// whether it moved. A deletion bumps the tree's generation, as other
+// cursors may sit on the changed leaf and go stale, and a leaf it empties is
+// merged away by mergeLeafChildren, the cursor moving on to the slot of the
+// next key.
+func (c *Cursor) skipExpired() (bool, error) {
+	m := c.tree.bTreeMeta
+	if c.all || !m.TableMeta.expired(c.leaf.cells[c.idx].Value, time.Now()) {
+		return false, nil
+	}
+	if !m.DeleteExpired {
+		c.idx++
+		return true, nil
+	}
+	key := c.leaf.cells[c.idx].Key
+	c.tree.gen++
+	c.gen = c.tree.gen
+	c.leaf.cells = slices.Delete(c.leaf.cells, c.idx, c.idx+1)
+	c.leaf.header.numCells = uint32(len(c.leaf.cells))
+	if err := c.tree.serializeNode(c.leaf); err != nil {
+		return false, fmt.Errorf("delete expired row: %w", err)
+	}
+	if err := c.tree.leafChanged(c.leaf, key); err != nil {
+		return false, fmt.Errorf("delete expired row: %w", err)
+	}
+	if err := c.tree.addRows(-1); err != nil {
+		return false, err
+	}
+	if len(c.leaf.cells) > 0 {
+		return true, nil
+	}
+	if err := c.tree.mergeEmptyLeaf(key); err != nil {
+		return false, fmt.Errorf("delete expired row: %w", err)
+	}
+	return true, c.position(key)
+}
+
+// mergeEmptyLeaf merges the leaves under the parent of the leaf key leads
+// to, which has just been emptied, and persists the free list.
+func (t *BTree) mergeEmptyLeaf(key uint32) error {
+	path, _, err := t.interiorPath(key)
+	if err != nil || len(path) == 0 {
+		return err
+	}
+	if err := t.mergeLeafChildren(path[len(path)-1]); err != nil {
+		return err
+	}
+	return t.writeFreeList()
+}
@@ -0,0 +1,254 @@
+package table
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"vqlite/column"
+)
+
+// TestTTL_ScansSkipExpiredRows inserts rows expiring in the past, in the
+// future and never, and checks scans and Search return only live rows, then
+// that DeleteExpired removes the skipped rows for good.
+func TestTTL_ScansSkipExpiredRows(t *testing.T) {
+	tp := newTempPager(t)
+	defer tp.cleanup()
+	schema := column.Schema{
+		{Name: "id", Type: column.ColumnTypeInt},
+		{Name: "expires_at", Type: column.ColumnTypeInt},
+	}
+	meta, _ := BuildTableMeta(schema)
+	meta.TTLColumn = "expires_at"
+	bt, err := NewBTree(tp.Pager, meta)
+	if err != nil {
+		t.Fatalf("NewBTree: %v", err)
+	}
+
+	now := uint32(time.Now().Unix())
+	var live []uint32
+	for i := uint32(1); i <= 60; i++ {
+		exp := now - 3600 // expired
+		switch i % 3 {
+		case 1:
+			exp = now + 3600
+			live = append(live, i)
+		case 2:
+			exp = 0 // never expires
+			live = append(live, i)
+		}
+		if err := bt.Insert(i, Row{i, exp}); err != nil {
+			t.Fatalf("insert %d: %v", i, err)
+		}
+	}
+
+	scan := func() []uint32 {
+		var keys []uint32
+		if err := bt.ForEach(func(key uint32, _ Row) error {
+			keys = append(keys, key)
+			return nil
+		}); err != nil {
+			t.Fatalf("ForEach: %v", err)
+		}
+		return keys
+	}
+	if got := scan(); !reflect.DeepEqual(got, live) {
+		t.Fatalf("scan = %v; want %v", got, live)
+	}
+	if _, found, err := bt.Search(3); err != nil || found {
+		t.Errorf("Search(expired 3) found=%v err=%v; want not found", found, err)
+	}
+	if _, found, _ := bt.Search(4); !found {
+		t.Error("Search(live 4) not found")
+	}
+	if n, _ := bt.NumRows(); n != 60 {
+		t.Errorf("NumRows without lazy deletion = %d; want 60", n)
+	}
+
+	bt.SetDeleteExpired(true)
+	if got := scan(); !reflect.DeepEqual(got, live) {
+		t.Fatalf("deleting scan = %v; want %v", got, live)
+	}
+	if n, _ := bt.NumRows(); n != uint32(len(live)) {
+		t.Errorf("NumRows after lazy deletion = %d; want %d", n, len(live))
+	}
+	meta.TTLColumn = ""
+	if got := scan(); !reflect.DeepEqual(got, live) {
+		t.Errorf("scan without TTL = %v; expired rows should be gone", got)
+	}
+}
+
+// TestTTL_KeyCursorSkipsExpiredRows checks a key cursor, and the RawScan and
+// ScanColumns built on it, pass over expired rows of a table whose TTL
+// column is not the first, from the start and after a Seek.
+func TestTTL_KeyCursorSkipsExpiredRows(t *testing.T) {
+	tp := newTempPager(t)
+	defer tp.cleanup()
+	meta, _ := BuildTableMeta(column.Schema{
+		{Name: "id", Type: column.ColumnTypeInt},
+		{Name: "name", Type: column.ColumnTypeText, MaxLength: 8},
+		{Name: "expires_at", Type: column.ColumnTypeInt},
+	})
+	meta.TTLColumn = "expires_at"
+	bt, _ := NewBTree(tp.Pager, meta)
+	now := uint32(time.Now().Unix())
+	var live []uint32
+	for i := uint32(1); i <= 80; i++ {
+		exp := now - 60
+		if i%4 == 0 {
+			exp = now + 3600
+			live = append(live, i)
+		}
+		if err := bt.Insert(i, Row{i, "pet", exp}); err != nil {
+			t.Fatalf("insert %d: %v", i, err)
+		}
+	}
+
+	kc, err := bt.NewKeyCursor()
+	if err != nil {
+		t.Fatalf("NewKeyCursor: %v", err)
+	}
+	var keys []uint32
+	for kc.Valid() {
+		keys = append(keys, kc.Key())
+		if err := kc.Next(); err != nil {
+			t.Fatalf("Next: %v", err)
+		}
+	}
+	if !reflect.DeepEqual(keys, live) {
+		t.Errorf("key cursor = %v; want %v", keys, live)
+	}
+	if err := kc.Seek(41); err != nil || !kc.Valid() || kc.Key() != 44 {
+		t.Errorf("Seek(41) = valid %v, err %v; want key 44", kc.Valid(), err)
+	}
+
+	keys = nil
+	if err := bt.ScanColumns([]string{"name"}, func(key uint32, _ Row) bool {
+		keys = append(keys, key)
+		return true
+	}); err != nil {
+		t.Fatalf("ScanColumns: %v", err)
+	}
+	if !reflect.DeepEqual(keys, live) {
+		t.Errorf("ScanColumns keys = %v; want %v", keys, live)
+	}
+}
+
+// TestTTL_CountsAndNeighboursSkipExpiredRows expires the rows at both ends
+// of a multi-level TTL table and every third one between, and checks that
+// CountRange, KeyRange, Predecessor and Last agree with a cursor scan.
+func TestTTL_CountsAndNeighboursSkipExpiredRows(t *testing.T) {
+	tp := newTempPager(t)
+	defer tp.cleanup()
+	meta, _ := BuildTableMeta(column.Schema{
+		{Name: "id", Type: column.ColumnTypeInt},
+		{Name: "expires_at", Type: column.ColumnTypeInt},
+		{Name: "pad", Type: column.ColumnTypeText, MaxLength: 200},
+	})
+	meta.TTLColumn = "expires_at"
+	bt, _ := NewBTree(tp.Pager, meta)
+	now := uint32(time.Now().Unix())
+	live := 0
+	for i := uint32(1); i <= 240; i++ {
+		exp := uint32(0)
+		if i <= 80 || i > 200 || i%3 == 0 {
+			exp = now - 60
+		} else {
+			live++
+		}
+		if err := bt.Insert(i, Row{i, exp, "x"}); err != nil {
+			t.Fatalf("insert %d: %v", i, err)
+		}
+	}
+	if h, _ := bt.Height(); h < 2 {
+		t.Fatalf("height = %d; want a multi-level tree", h)
+	}
+
+	if n, err := bt.CountRange(0, ^uint32(0)); err != nil || n != live {
+		t.Errorf("CountRange(all) = %d, %v; want %d", n, err, live)
+	}
+	if n, err := bt.CountRange(81, 86); err != nil || n != 4 {
+		t.Errorf("CountRange(81, 86) = %d, %v; want 4", n, err)
+	}
+	lo, hi, count, ok, err := bt.KeyRange()
+	if err != nil || !ok || lo != 82 || hi != 200 || count != live {
+		t.Errorf("KeyRange = %d, %d, %d, %v, %v; want 82, 200, %d, true", lo, hi, count, ok, err, live)
+	}
+	for _, tc := range []struct {
+		key, want uint32
+		found     bool
+	}{
+		{240, 200, true},
+		{150, 149, true},
+		{83, 82, true},
+		{82, 0, false},
+	} {
+		k, _, found, err := bt.Predecessor(tc.key)
+		if err != nil || found != tc.found || k != tc.want {
+			t.Errorf("Predecessor(%d) = %d, %v, %v; want %d, %v", tc.key, k, found, err, tc.want, tc.found)
+		}
+	}
+	if k, _, found, err := bt.Last(); err != nil || !found || k != 200 {
+		t.Errorf("Last = %d, %v, %v; want 200", k, found, err)
+	}
+}
+
+// TestTTL_DeleteExpiredFreesLeavesAndStalesCursors expires a run of rows
+// several leaves long and checks a deleting scan frees the emptied leaves,
+// keeps the tree valid, and leaves a cursor opened before it stale.
+func TestTTL_DeleteExpiredFreesLeavesAndStalesCursors(t *testing.T) {
+	tp := newTempPager(t)
+	defer tp.cleanup()
+	meta, _ := BuildTableMeta(column.Schema{
+		{Name: "id", Type: column.ColumnTypeInt},
+		{Name: "expires_at", Type: column.ColumnTypeInt},
+		{Name: "pad", Type: column.ColumnTypeText, MaxLength: 200},
+	})
+	meta.TTLColumn = "expires_at"
+	bt, _ := NewBTree(tp.Pager, meta)
+	now := uint32(time.Now().Unix())
+	var live []uint32
+	for i := uint32(1); i <= 240; i++ {
+		exp := uint32(0)
+		if i > 40 && i <= 200 {
+			exp = now - 60
+		} else {
+			live = append(live, i)
+		}
+		if err := bt.Insert(i, Row{i, exp, "x"}); err != nil {
+			t.Fatalf("insert %d: %v", i, err)
+		}
+	}
+	other, err := bt.NewCursor()
+	if err != nil {
+		t.Fatalf("NewCursor: %v", err)
+	}
+	free := len(bt.bTreeMeta.freeList().freePages)
+
+	bt.SetDeleteExpired(true)
+	var keys []uint32
+	if err := bt.ForEach(func(key uint32, _ Row) error {
+		keys = append(keys, key)
+		return nil
+	}); err != nil {
+		t.Fatalf("ForEach: %v", err)
+	}
+	if !reflect.DeepEqual(keys, live) {
+		t.Errorf("deleting scan = %v; want %v", keys, live)
+	}
+	if n := len(bt.bTreeMeta.freeList().freePages); n <= free {
+		t.Errorf("free pages = %d, %d before; want emptied leaves freed", n, free)
+	}
+	if n, _ := bt.NumRows(); n != uint32(len(live)) {
+		t.Errorf("NumRows = %d; want %d", n, len(live))
+	}
+	if err := bt.Validate(); err != nil {
+		t.Errorf("Validate: %v", err)
+	}
+	if other.Valid() {
+		t.Error("cursor opened before the deleting scan is still valid")
+	}
+	if err := other.Next(); err != ErrCursorStale {
+		t.Errorf("Next on the earlier cursor err = %v; want ErrCursorStale", err)
+	}
+}
@@ -0,0 +1,43 @@
+package table
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrKeysOutOfOrder is returned when a leaf's cells are not sorted by key,
+// which breaks the binary searches of Search, Seek and Insert.
+var ErrKeysOutOfOrder = errors.New("leaf keys out of order")
+
+// SetVerifyOnLoad makes every leaf read from its page check that its keys
+// are in order, failing the load with ErrKeysOutOfOrder otherwise. It costs
+// a pass over the cells per load, so it is meant for debugging.
+func (t *BTree) SetVerifyOnLoad(on bool) {
+	t.bTreeMeta.VerifyOnLoad = on
+}
+
+// verifySorted checks the leaf's keys ascend, strictly unless the tree keeps
+// duplicates, and names the first pair that does not.
+func (n *LeafNode) verifySorted() error {
+	for i := 1; i < len(n.cells); i++ {
+		prev, cur := n.cells[i-1].Key, n.cells[i].Key
+		c := compareKeys(prev, cur)
+		if c > 0 || (c == 0 && !n.bTreeMeta.Duplicates) {
+			return fmt.Errorf("page %d: key %d at cell %d followed by %d: %w", n.Page(), prev, i-1, cur, ErrKeysOutOfOrder)
+		}
+	}
+	return nil
+}
+
+// Validate walks every node of the tree and checks each leaf's keys are in
+// order, returning the first problem found.
+func (t *BTree) Validate() error {
+	return t.WalkPages(func(_ uint32, node BTreeNode) error {
+		if leaf, ok := node.(*LeafNode); ok {
+			if err := leaf.verifySorted(); err != nil {
+				return fmt.Errorf("validate: %w", err)
+			}
+		}
+		return nil
+	})
+}